@@ -0,0 +1,46 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"golang.org/x/crypto/bcrypt"
+	"um6p.ma/finalproject/config"
+	"um6p.ma/finalproject/constants"
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/interfaces"
+	"um6p.ma/finalproject/models"
+)
+
+// EnsureAdmin creates the bootstrap admin account from the configuration, unless it already
+// exists. Public registration cannot create admins, so this is how the first one is made.
+func EnsureAdmin(ctx context.Context, users interfaces.UserStore, cfg config.Config) error {
+	if cfg.BootstrapAdminEmail == "" {
+		return nil
+	}
+	if _, err := users.GetUserByEmail(ctx, cfg.BootstrapAdminEmail); err == nil {
+		return nil
+	} else if !errorhandling.IsNotFoundError(err) {
+		return err
+	}
+
+	if cfg.BootstrapAdminPassword == "" {
+		return errors.New("BOOTSTRAP_ADMIN_PASSWORD must be set along with BOOTSTRAP_ADMIN_EMAIL")
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(cfg.BootstrapAdminPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	if _, err := users.CreateUser(ctx, models.User{
+		Name:     "Administrator",
+		Email:    cfg.BootstrapAdminEmail,
+		Password: string(hashedPassword),
+		Role:     constants.RoleAdmin,
+	}); err != nil {
+		return err
+	}
+	log.Printf("👤 Created the bootstrap admin %s", cfg.BootstrapAdminEmail)
+	return nil
+}
@@ -0,0 +1,20 @@
+package app
+
+import (
+	"fmt"
+
+	"um6p.ma/finalproject/config"
+	"um6p.ma/finalproject/mailer"
+)
+
+// NewMailer constructs the mailer selected in the configuration
+func NewMailer(cfg config.Config) (mailer.Mailer, error) {
+	switch cfg.Mailer {
+	case config.MailerOutbox:
+		return mailer.NewOutbox(cfg.MailOutboxDir, cfg.MailFrom)
+	case config.MailerSMTP:
+		return mailer.NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom), nil
+	default:
+		return nil, fmt.Errorf("unknown mailer %q (expected %q or %q)", cfg.Mailer, config.MailerOutbox, config.MailerSMTP)
+	}
+}
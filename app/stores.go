@@ -0,0 +1,186 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"gorm.io/gorm"
+	"um6p.ma/finalproject/config"
+	"um6p.ma/finalproject/database"
+	"um6p.ma/finalproject/gormstores"
+	"um6p.ma/finalproject/inmemorystores"
+	"um6p.ma/finalproject/interfaces"
+	"um6p.ma/finalproject/migrations"
+)
+
+// Stores groups the storage backends injected into the handlers and background jobs
+type Stores struct {
+	Books     interfaces.BookStore
+	Authors   interfaces.AuthorStore
+	Customers interfaces.CustomerStore
+	Orders    interfaces.OrderStore
+	Reports   interfaces.ReportStore
+	Users     interfaces.UserStore
+	Roles     interfaces.RoleStore
+
+	Invitations   interfaces.InvitationStore
+	RefreshTokens interfaces.RefreshTokenStore
+	Revocations   interfaces.TokenRevocationStore
+	AccountTokens interfaces.AccountTokenStore
+	TwoFactor     interfaces.TwoFactorStore
+	APIKeys       interfaces.APIKeyStore
+
+	LoginThrottles interfaces.LoginThrottleStore
+	SecurityEvents interfaces.SecurityEventStore
+
+	ImpersonationAudit interfaces.ImpersonationAuditStore
+}
+
+// NewStores constructs the stores for the storage backend selected in the configuration
+func NewStores(cfg config.Config) (*Stores, error) {
+	if cfg.Storage == config.StorageMemory {
+		return newInMemoryStores(cfg)
+	}
+
+	db, err := OpenDatabase(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureSchema(db, cfg); err != nil {
+		return nil, err
+	}
+	return newGormStores(db), nil
+}
+
+// OpenDatabase connects to the SQL database selected in the configuration
+func OpenDatabase(cfg config.Config) (*gorm.DB, error) {
+	switch cfg.Storage {
+	case config.StoragePostgres:
+		return database.ConnectDatabase()
+	case config.StorageSQLite:
+		return database.ConnectSQLite(cfg.SQLitePath)
+	case config.StorageMemory:
+		return nil, fmt.Errorf("storage backend %q has no database", cfg.Storage)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q (expected %q, %q or %q)",
+			cfg.Storage, config.StorageMemory, config.StoragePostgres, config.StorageSQLite)
+	}
+}
+
+// ensureSchema refuses to continue while migrations are pending, unless the configuration
+// asks for them to be applied. A fresh in-memory SQLite database is always migrated.
+func ensureSchema(db *gorm.DB, cfg config.Config) error {
+	ctx := context.Background()
+	migrator, err := migrations.New(db)
+	if err != nil {
+		return err
+	}
+
+	if cfg.MigrateOnStart || (cfg.Storage == config.StorageSQLite && cfg.SQLitePath == database.SQLiteInMemory) {
+		return migrator.Up(ctx)
+	}
+
+	pending, err := migrator.Pending(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%d pending migration(s), starting with %d (%s); run \"go run ./cmd/migrate up\" first",
+			len(pending), pending[0].Version, pending[0].Name)
+	}
+	return nil
+}
+
+// newGormStores builds stores on top of an open GORM connection
+func newGormStores(db *gorm.DB) *Stores {
+	return &Stores{
+		Books:     gormstores.NewGormBookStore(db),
+		Authors:   gormstores.NewGormAuthorStore(db),
+		Customers: gormstores.NewGormCustomerStore(db),
+		Orders:    gormstores.NewGormOrderStore(db),
+		Reports:   gormstores.NewGormReportStore(db),
+		Users:     gormstores.NewGormUserStore(db),
+		Roles:     gormstores.NewGormRoleStore(db),
+
+		Invitations:   gormstores.NewGormInvitationStore(db),
+		RefreshTokens: gormstores.NewGormRefreshTokenStore(db),
+		Revocations:   gormstores.NewGormTokenRevocationStore(db),
+		AccountTokens: gormstores.NewGormAccountTokenStore(db),
+		TwoFactor:     gormstores.NewGormTwoFactorStore(db),
+		APIKeys:       gormstores.NewGormAPIKeyStore(db),
+
+		LoginThrottles: gormstores.NewGormLoginThrottleStore(db),
+		SecurityEvents: gormstores.NewGormSecurityEventStore(db),
+
+		ImpersonationAudit: gormstores.NewGormImpersonationAuditStore(db),
+	}
+}
+
+// newInMemoryStores builds stores that keep everything in process memory. Books, authors,
+// customers and orders are journaled to the data directory, when one is configured.
+func newInMemoryStores(cfg config.Config) (*Stores, error) {
+	var opts []inmemorystores.Option
+	if cfg.DataDir != "" {
+		opts = append(opts,
+			inmemorystores.WithJournal(cfg.DataDir),
+			inmemorystores.WithSnapshotInterval(cfg.SnapshotInterval),
+			inmemorystores.WithBatchedWrites(cfg.FlushInterval),
+		)
+	}
+
+	bookStore, err := inmemorystores.NewInMemoryBookStore(opts...)
+	if err != nil {
+		return nil, err
+	}
+	authorStore, err := inmemorystores.NewInMemoryAuthorStore(opts...)
+	if err != nil {
+		return nil, err
+	}
+	customerStore, err := inmemorystores.NewInMemoryCustomerStore(opts...)
+	if err != nil {
+		return nil, err
+	}
+	orderStore, err := inmemorystores.NewInMemoryOrderStore(bookStore)
+	if err != nil {
+		return nil, err
+	}
+
+	userStore := inmemorystores.NewInMemoryUserStore()
+
+	return &Stores{
+		Books:     bookStore,
+		Authors:   authorStore,
+		Customers: customerStore,
+		Orders:    orderStore,
+		Reports:   inmemorystores.NewInMemoryReportStore(),
+		Users:     userStore,
+		Roles:     inmemorystores.NewInMemoryRoleStore(),
+
+		Invitations:   inmemorystores.NewInMemoryInvitationStore(userStore),
+		RefreshTokens: inmemorystores.NewInMemoryRefreshTokenStore(),
+		Revocations:   inmemorystores.NewInMemoryTokenRevocationStore(),
+		AccountTokens: inmemorystores.NewInMemoryAccountTokenStore(),
+		TwoFactor:     inmemorystores.NewInMemoryTwoFactorStore(userStore),
+		APIKeys:       inmemorystores.NewInMemoryAPIKeyStore(),
+
+		LoginThrottles: inmemorystores.NewInMemoryLoginThrottleStore(),
+		SecurityEvents: inmemorystores.NewInMemorySecurityEventStore(),
+
+		ImpersonationAudit: inmemorystores.NewInMemoryImpersonationAuditStore(),
+	}, nil
+}
+
+// Close releases the stores that hold resources, such as journals
+func (stores *Stores) Close() error {
+	var errs []error
+	for _, store := range []interface{}{stores.Books, stores.Authors, stores.Customers, stores.Orders, stores.Reports, stores.Users} {
+		if closer, ok := store.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"um6p.ma/finalproject/config"
+	internalhttp "um6p.ma/finalproject/internal/http"
+)
+
+const usage = `Usage: keygen [RS256|EdDSA]
+
+Creates a new JWT signing key in JWT_KEYS_DIR. The algorithm defaults to
+JWT_KEY_ALGORITHM. The newest key signs the tokens, while the older ones keep
+verifying the tokens they signed until they are deleted.`
+
+func main() {
+	if len(os.Args) > 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	cfg := config.Load()
+	if cfg.JWTKeysDir == "" {
+		log.Fatalf("❌ JWT_KEYS_DIR is not set\n\n%s", usage)
+	}
+
+	algorithm := cfg.JWTKeyAlgorithm
+	if len(os.Args) == 2 {
+		algorithm = os.Args[1]
+	}
+
+	kid, err := internalhttp.GenerateKey(cfg.JWTKeysDir, algorithm)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	fmt.Printf("✅ Created %s key %s in %s\n", algorithm, kid, cfg.JWTKeysDir)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"um6p.ma/finalproject/app"
+	"um6p.ma/finalproject/config"
+	"um6p.ma/finalproject/migrations"
+)
+
+const usage = `Usage: migrate <command>
+
+Commands:
+  up        Apply all pending migrations
+  down      Revert the most recently applied migration
+  status    List migrations and whether they are applied
+  to N      Migrate up or down to version N (0 reverts everything)
+
+The database is selected with the same STORAGE_BACKEND, SQLITE_PATH and DB_*
+variables as the server.`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	cfg := config.Load()
+	db, err := app.OpenDatabase(cfg)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
+	ctx := context.Background()
+	switch command := os.Args[1]; command {
+	case "up":
+		err = migrator.Up(ctx)
+	case "down":
+		err = migrator.Down(ctx)
+	case "to":
+		if len(os.Args) != 3 {
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(2)
+		}
+		version, convErr := strconv.Atoi(os.Args[2])
+		if convErr != nil {
+			log.Fatalf("❌ Invalid version %q", os.Args[2])
+		}
+		err = migrator.To(ctx, version)
+	case "status":
+		err = printStatus(ctx, migrator)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s\n", command, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	if os.Args[1] != "status" {
+		if err := printStatus(ctx, migrator); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+	}
+}
+
+// printStatus writes one line per known migration
+func printStatus(ctx context.Context, migrator *migrations.Migrator) error {
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, status := range statuses {
+		if status.Applied {
+			fmt.Printf("✅ %04d %-30s applied %s\n", status.Version, status.Name, status.AppliedAt.Format("2006-01-02 15:04:05"))
+		} else {
+			fmt.Printf("🔲 %04d %-30s pending\n", status.Version, status.Name)
+		}
+	}
+	return nil
+}
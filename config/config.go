@@ -0,0 +1,179 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// Storage backends
+const (
+	StorageMemory   = "memory"
+	StoragePostgres = "postgres"
+	StorageSQLite   = "sqlite"
+)
+
+// Mailers
+const (
+	MailerOutbox = "outbox"
+	MailerSMTP   = "smtp"
+)
+
+// Config holds the settings read at startup
+type Config struct {
+	Storage        string // Storage backend, one of the Storage* constants
+	SQLitePath     string // Database file for the SQLite backend, or ":memory:"
+	MigrateOnStart bool   // Apply pending migrations at startup instead of refusing to start
+	ServerAddr     string // Address the HTTP server listens on
+	PublicURL      string // Base URL of the API, used in the links sent to users
+	DataDir        string // Journal directory of the memory backend; empty keeps data in memory only
+
+	SnapshotInterval time.Duration // How often the memory backend also writes snapshots; 0 disables it
+	FlushInterval    time.Duration // Batch journal writes of the memory backend over this interval; 0 syncs every mutation
+
+	AccessTokenTTL  time.Duration // Lifetime of the JWT access tokens
+	RefreshTokenTTL time.Duration // Lifetime of the refresh tokens, renewed on every rotation
+
+	JWTKeysDir      string        // Directory of the PEM private keys signing the JWTs
+	JWTKeyAlgorithm string        // Algorithm of generated keys, RS256 or EdDSA
+	JWTKeyRotation  time.Duration // Generate a new signing key at this interval; 0 disables rotation
+
+	InvitationTTL          time.Duration // How long invitation links can be accepted
+	BootstrapAdminEmail    string        // Admin account created at startup if missing
+	BootstrapAdminPassword string
+
+	PasswordResetTTL     time.Duration // How long password reset links can be used
+	EmailVerificationTTL time.Duration // How long email verification links can be used
+
+	TOTPIssuer      string        // Issuer shown by authenticator apps next to the account
+	MFAChallengeTTL time.Duration // How long the second login step can be completed after the password was checked
+
+	LoginMaxFailures     int           // Failed logins of an email before it is locked
+	LoginIPMaxFailures   int           // Failed logins from an IP address before it is locked
+	LoginLockoutDuration time.Duration // How long a lock lasts, and how long failed logins are remembered
+	LoginBackoffBase     time.Duration // Wait after the first failed login of an email, doubled by every other failure
+	TrustProxy           bool          // Take the client IP from X-Forwarded-For, set by a reverse proxy
+	RateLimiting         bool          // Limit the request rate of each client
+	DebugErrors          bool          // Send the debug information of errors to clients, in development only
+
+	AuthzPolicyFile     string        // JSON file of the authorization policy; empty uses the built-in policy
+	AuthzReloadInterval time.Duration // How often the policy file is checked for changes; 0 disables reloading
+
+	ImpersonationTTL         time.Duration // How long the tokens of admins impersonating users last
+	ImpersonationAllowWrites bool          // Let admins ask for impersonation tokens that are not read-only
+
+	Mailer        string // How emails are delivered, one of the Mailer* constants
+	MailFrom      string // Sender of the emails
+	MailOutboxDir string // Directory the outbox mailer writes emails to
+	SMTPHost      string
+	SMTPPort      int
+	SMTPUsername  string // Optional; emails are sent without authentication when empty
+	SMTPPassword  string
+}
+
+// Load reads the configuration from the environment, after loading the .env file if present
+func Load() Config {
+	if err := godotenv.Load(); err != nil {
+		log.Printf("Warning: Error loading .env file: %v", err)
+	}
+
+	return Config{
+		Storage:        strings.ToLower(getEnv("STORAGE_BACKEND", StoragePostgres)),
+		SQLitePath:     getEnv("SQLITE_PATH", "mybiblio.db"),
+		MigrateOnStart: getEnvBool("MIGRATE_ON_START", false),
+		ServerAddr:     getEnv("SERVER_ADDR", ":8080"),
+		PublicURL:      strings.TrimSuffix(getEnv("PUBLIC_URL", "http://localhost:8080"), "/"),
+		DataDir:        os.Getenv("DATA_DIR"),
+
+		SnapshotInterval: getEnvDuration("SNAPSHOT_INTERVAL", 0),
+		FlushInterval:    getEnvDuration("JOURNAL_FLUSH_INTERVAL", 0),
+
+		AccessTokenTTL:  getEnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL: getEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+
+		JWTKeysDir:      os.Getenv("JWT_KEYS_DIR"),
+		JWTKeyAlgorithm: getEnv("JWT_KEY_ALGORITHM", "RS256"),
+		JWTKeyRotation:  getEnvDuration("JWT_KEY_ROTATION", 0),
+
+		InvitationTTL:          getEnvDuration("INVITATION_TTL", 7*24*time.Hour),
+		BootstrapAdminEmail:    os.Getenv("BOOTSTRAP_ADMIN_EMAIL"),
+		BootstrapAdminPassword: os.Getenv("BOOTSTRAP_ADMIN_PASSWORD"),
+
+		PasswordResetTTL:     getEnvDuration("PASSWORD_RESET_TTL", time.Hour),
+		EmailVerificationTTL: getEnvDuration("EMAIL_VERIFICATION_TTL", 48*time.Hour),
+
+		TOTPIssuer:      getEnv("TOTP_ISSUER", "MyBiblio"),
+		MFAChallengeTTL: getEnvDuration("MFA_CHALLENGE_TTL", 5*time.Minute),
+
+		LoginMaxFailures:     getEnvInt("LOGIN_MAX_FAILURES", 5),
+		LoginIPMaxFailures:   getEnvInt("LOGIN_IP_MAX_FAILURES", 50),
+		LoginLockoutDuration: getEnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+		LoginBackoffBase:     getEnvDuration("LOGIN_BACKOFF_BASE", time.Second),
+		TrustProxy:           getEnvBool("TRUST_PROXY", false),
+		RateLimiting:         getEnvBool("RATE_LIMITING", true),
+		DebugErrors:          getEnvBool("DEBUG_ERRORS", false),
+
+		AuthzPolicyFile:     getEnv("AUTHZ_POLICY_FILE", ""),
+		AuthzReloadInterval: getEnvDuration("AUTHZ_RELOAD_INTERVAL", 5*time.Second),
+
+		ImpersonationTTL:         getEnvDuration("IMPERSONATION_TTL", 15*time.Minute),
+		ImpersonationAllowWrites: getEnvBool("IMPERSONATION_ALLOW_WRITES", false),
+
+		Mailer:        strings.ToLower(getEnv("MAILER", MailerOutbox)),
+		MailFrom:      getEnv("MAIL_FROM", "MyBiblio <no-reply@mybiblio.com>"),
+		MailOutboxDir: getEnv("MAIL_OUTBOX_DIR", "outbox"),
+		SMTPHost:      getEnv("SMTP_HOST", "localhost"),
+		SMTPPort:      getEnvInt("SMTP_PORT", 587),
+		SMTPUsername:  os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:  os.Getenv("SMTP_PASSWORD"),
+	}
+}
+
+// SignedTokenLifetime returns how long the longest-lived token signed by the JWT keys stays
+// valid, which is how long the keys are kept after being replaced
+func (cfg Config) SignedTokenLifetime() time.Duration {
+	return max(cfg.AccessTokenTTL, cfg.ImpersonationTTL, cfg.MFAChallengeTTL,
+		cfg.PasswordResetTTL, cfg.EmailVerificationTTL)
+}
+
+// getEnv returns the value of an environment variable or a fallback when it is unset
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+// getEnvBool parses a boolean environment variable, returning fallback when it is unset or invalid
+func getEnvBool(key string, fallback bool) bool {
+	value, err := strconv.ParseBool(getEnv(key, strconv.FormatBool(fallback)))
+	if err != nil {
+		log.Printf("Warning: invalid boolean %s=%q, using %t", key, os.Getenv(key), fallback)
+		return fallback
+	}
+	return value
+}
+
+// getEnvInt parses an integer environment variable, returning fallback when it is unset or invalid
+func getEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(getEnv(key, strconv.Itoa(fallback)))
+	if err != nil {
+		log.Printf("Warning: invalid integer %s=%q, using %d", key, os.Getenv(key), fallback)
+		return fallback
+	}
+	return value
+}
+
+// getEnvDuration parses a duration such as "30s", returning fallback when it is unset or invalid
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(getEnv(key, fallback.String()))
+	if err != nil || value < 0 {
+		log.Printf("Warning: invalid duration %s=%q, using %s", key, os.Getenv(key), fallback)
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,11 @@
+package constants
+
+// Purposes of the account tokens mailed to users
+const (
+	TokenPurposePasswordReset     = "password_reset"
+	TokenPurposeEmailVerification = "email_verification"
+)
+
+// TokenPurposeLoginChallenge is the purpose of the tokens returned by the first login step of
+// users with two-factor authentication, which are exchanged for a session with their code
+const TokenPurposeLoginChallenge = "login_challenge"
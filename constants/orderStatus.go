@@ -0,0 +1,28 @@
+package constants
+
+// Order statuses
+const (
+	OrderStatusPending    = "pending"
+	OrderStatusProcessing = "processing"
+	OrderStatusShipped    = "shipped"
+	OrderStatusDelivered  = "delivered"
+	OrderStatusCancelled  = "cancelled"
+)
+
+// OrderTransitions maps each order status to the statuses it can move to.
+// Orders can only be cancelled before they are shipped.
+var OrderTransitions = map[string][]string{
+	OrderStatusPending:    {OrderStatusProcessing, OrderStatusCancelled},
+	OrderStatusProcessing: {OrderStatusShipped, OrderStatusCancelled},
+	OrderStatusShipped:    {OrderStatusDelivered},
+}
+
+// CanTransitionOrder checks if an order can move from one status to another
+func CanTransitionOrder(from, to string) bool {
+	for _, next := range OrderTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
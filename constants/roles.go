@@ -18,7 +18,8 @@ var RoleDescription = map[string]string{
 	RoleUser:     "Browse books and place orders",
 }
 
-// ValidRoles contains all valid role values
+// ValidRoles contains the built-in roles. Further roles can be created through the API,
+// but the built-in ones cannot be deleted.
 var ValidRoles = map[string]bool{
 	RoleAdmin:    true,
 	RoleManager:  true,
@@ -26,7 +27,23 @@ var ValidRoles = map[string]bool{
 	RoleUser:     true,
 }
 
-// GetAvailableRoles returns a list of all available roles
+// DefaultRolePermissions maps the built-in roles to the permissions they are seeded with
+var DefaultRolePermissions = map[string][]string{
+	RoleAdmin:    {"read:all", "write:all", "delete:all", "manage:users", "manage:roles", "manage:api_keys", "manage:customers", "read_sensitive:customers", "revoke:tokens", "process:orders", "generate:reports"},
+	RoleManager:  {"read:all", "write:books", "write:authors", "write:orders", "write:customers", "manage:customers", "read_sensitive:customers", "process:orders", "generate:reports"},
+	RoleEmployee: {"read:all", "write:orders", "write:customers", "process:orders"},
+	RoleUser:     {"read:books", "read:authors", "write:orders"},
+}
+
+// DefaultPermissionImplications lists the implication rules seeded with the roles, as pairs
+// of a permission and the permission it implies
+var DefaultPermissionImplications = [][2]string{
+	{"read:all", "read:*"},
+	{"write:all", "write:*"},
+	{"delete:all", "delete:*"},
+}
+
+// GetAvailableRoles returns a list of the built-in roles
 func GetAvailableRoles() []string {
 	roles := make([]string, 0, len(ValidRoles))
 	for role := range ValidRoles {
@@ -0,0 +1,9 @@
+package constants
+
+// Types of the security events
+const (
+	SecurityEventLoginLocked   = "login_locked"
+	SecurityEventLoginUnlocked = "login_unlocked"
+
+	SecurityEventImpersonationStarted = "impersonation_started"
+)
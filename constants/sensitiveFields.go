@@ -0,0 +1,14 @@
+package constants
+
+// Classes of sensitive fields, named by the `sensitive` tag of the model fields
+const (
+	SensitiveEmail   = "email"
+	SensitiveAddress = "address"
+)
+
+// SensitiveFieldPermissions maps each class of sensitive fields to the permission needed to see
+// them in full. Other callers, API keys included, see them masked, except on their own records.
+var SensitiveFieldPermissions = map[string]string{
+	SensitiveEmail:   "read_sensitive:customers",
+	SensitiveAddress: "read_sensitive:customers",
+}
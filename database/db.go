@@ -5,22 +5,14 @@ import (
 	"log"
 	"os"
 
-	"github.com/joho/godotenv"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
-	"um6p.ma/finalproject/models"
 )
 
-var DB *gorm.DB
-
-func ConnectDatabase() {
-	// Load environment variables from .env file
-	err := godotenv.Load()
-	if err != nil {
-		log.Printf("Warning: Error loading .env file: %v", err)
-	}
-
+// ConnectDatabase opens the PostgreSQL connection described by the DB_* environment variables.
+// The schema is managed by the migrations package.
+func ConnectDatabase() (*gorm.DB, error) {
 	// Log the DSN (without password)
 	logDSN := fmt.Sprintf(
 		"host=%s user=%s dbname=%s port=%s sslmode=%s",
@@ -44,34 +36,14 @@ func ConnectDatabase() {
 
 	// Connect to PostgreSQL with detailed logging
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
+		Logger:         logger.Default.LogMode(logger.Info),
+		TranslateError: true,
 	})
 	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
-	DB = db
 	log.Println("✅ Successfully connected to PostgreSQL!")
 
-	// AutoMigrate with error checking for each model
-	models := []interface{}{
-		&models.Author{},
-		&models.Book{},
-		&models.Customer{},
-		&models.Order{},
-		&models.OrderItem{},
-		&models.SalesReport{},
-		&models.BookSales{},
-		&models.User{},
-	}
-
-	for _, model := range models {
-		if err := DB.AutoMigrate(model); err != nil {
-			log.Printf("❌ Failed to migrate %T: %v", model, err)
-			continue
-		}
-		log.Printf("✅ Successfully migrated %T", model)
-	}
-
-	log.Println("✅ Database migration completed!")
+	return db, nil
 }
@@ -0,0 +1,41 @@
+package database
+
+import (
+	"fmt"
+	"log"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// SQLiteInMemory is the path that opens a private, throwaway in-memory SQLite database
+const SQLiteInMemory = ":memory:"
+
+// ConnectSQLite opens the SQLite database at path, or a fresh in-memory database when
+// path is SQLiteInMemory. The schema is managed by the migrations package.
+func ConnectSQLite(path string) (*gorm.DB, error) {
+	log.Printf("Attempting to open SQLite database: %s", path)
+
+	// Transactions take the write lock when they begin, since SQLite has no row locks: two
+	// transactions reading the same order before updating it would otherwise deadlock
+	db, err := gorm.Open(sqlite.Open(path+"?_foreign_keys=on&_txlock=immediate&_busy_timeout=5000"), &gorm.Config{
+		Logger:         logger.Default.LogMode(logger.Warn),
+		TranslateError: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite database: %w", err)
+	}
+
+	// Every connection to :memory: gets its own empty database, so keep a single one
+	if path == SQLiteInMemory {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure SQLite database: %w", err)
+		}
+		sqlDB.SetMaxOpenConns(1)
+	}
+
+	log.Println("✅ Successfully opened SQLite database!")
+	return db, nil
+}
@@ -4,16 +4,26 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
 )
 
+// debugErrors sends the debug information of errors to clients, rather than only logging it
+var debugErrors bool
+
+// SetDebug sets whether error responses include their debug information, which may reveal
+// internals such as database errors. It should only be enabled in development.
+func SetDebug(enabled bool) {
+	debugErrors = enabled
+}
+
 // ErrorResponse represents a structured error response
 type ErrorResponse struct {
 	StatusCode int         `json:"-"`                 // HTTP status code
 	Code       string      `json:"code"`              // Application-specific error code
 	Message    string      `json:"message"`           // User-friendly error message
 	Details    interface{} `json:"details,omitempty"` // Additional error details
-	Debug      string      `json:"debug,omitempty"`   // Debug information (only in development)
+	Debug      string      `json:"debug,omitempty"`   // Debug information (only sent in development, see SetDebug)
 }
 
 // Standard error codes
@@ -33,22 +43,67 @@ const (
 	ErrCodeMissingToken       = "MISSING_TOKEN"
 	ErrCodeWeakPassword       = "WEAK_PASSWORD"
 	ErrCodeInvalidRole        = "INVALID_ROLE"
+	ErrCodeConflict           = "CONFLICT"
+	ErrCodeAccountLocked      = "ACCOUNT_LOCKED"
+	ErrCodeTooManyAttempts    = "TOO_MANY_ATTEMPTS"
+	ErrCodeRateLimited        = "RATE_LIMITED"
+	ErrCodeReadOnly           = "READ_ONLY"
 )
 
 // Common application errors
 var (
-	ErrBookNotFound       = NewError(http.StatusNotFound, ErrCodeNotFound, "Book not found")
-	ErrCustomerNotFound   = NewError(http.StatusNotFound, ErrCodeNotFound, "Customer not found")
-	ErrAuthorNotFound     = NewError(http.StatusNotFound, ErrCodeNotFound, "Author not found")
-	ErrOrderNotFound      = NewError(http.StatusNotFound, ErrCodeNotFound, "Order not found")
-	ErrInsufficientStock  = NewError(http.StatusBadRequest, ErrCodeBadRequest, "Insufficient stock")
-	ErrInvalidInput       = NewError(http.StatusBadRequest, ErrCodeBadRequest, "Invalid input")
-	ErrInvalidCredentials = NewError(http.StatusUnauthorized, ErrCodeInvalidCredentials, "Invalid email or password")
-	ErrInvalidToken       = NewError(http.StatusUnauthorized, ErrCodeInvalidToken, "Invalid authentication token")
-	ErrExpiredToken       = NewError(http.StatusUnauthorized, ErrCodeExpiredToken, "Authentication token has expired")
-	ErrMissingToken       = NewError(http.StatusUnauthorized, ErrCodeMissingToken, "Authentication token is missing")
-	ErrWeakPassword       = NewError(http.StatusBadRequest, ErrCodeWeakPassword, "Password does not meet security requirements")
-	ErrInvalidRole        = NewError(http.StatusBadRequest, ErrCodeInvalidRole, "Invalid user role specified")
+	ErrBookNotFound         = NewError(http.StatusNotFound, ErrCodeNotFound, "Book not found")
+	ErrCustomerNotFound     = NewError(http.StatusNotFound, ErrCodeNotFound, "Customer not found")
+	ErrAuthorNotFound       = NewError(http.StatusNotFound, ErrCodeNotFound, "Author not found")
+	ErrOrderNotFound        = NewError(http.StatusNotFound, ErrCodeNotFound, "Order not found")
+	ErrUserNotFound         = NewError(http.StatusNotFound, ErrCodeNotFound, "User not found")
+	ErrRefreshTokenNotFound = NewError(http.StatusNotFound, ErrCodeNotFound, "Refresh token not found")
+	ErrInvitationNotFound   = NewError(http.StatusNotFound, ErrCodeNotFound, "Invitation not found")
+	ErrAccountTokenNotFound = NewError(http.StatusNotFound, ErrCodeNotFound, "Account token not found")
+	ErrRoleNotFound         = NewError(http.StatusNotFound, ErrCodeNotFound, "Role not found")
+	ErrImplicationNotFound  = NewError(http.StatusNotFound, ErrCodeNotFound, "Permission implication not found")
+	ErrAPIKeyNotFound       = NewError(http.StatusNotFound, ErrCodeNotFound, "API key not found")
+	ErrAddressNotFound      = NewError(http.StatusNotFound, ErrCodeNotFound, "Address not found")
+	ErrThrottleNotFound     = NewError(http.StatusNotFound, ErrCodeNotFound, "No failed logins recorded for this identifier")
+	ErrInvitationUsed       = NewError(http.StatusConflict, ErrCodeConflict, "Invitation was already accepted")
+	ErrAccountDisabled      = NewError(http.StatusForbidden, ErrCodeForbidden, "Account is disabled")
+	ErrAccountLocked        = NewError(http.StatusTooManyRequests, ErrCodeAccountLocked, "Too many failed logins; logging in is locked for a while")
+	ErrTooManyAttempts      = NewError(http.StatusTooManyRequests, ErrCodeTooManyAttempts, "Too many failed logins; wait before trying again")
+	ErrRateLimited          = NewError(http.StatusTooManyRequests, ErrCodeRateLimited, "Too many requests; slow down")
+	ErrInsufficientStock    = NewError(http.StatusBadRequest, ErrCodeBadRequest, "Insufficient stock")
+	ErrInvalidInput         = NewError(http.StatusBadRequest, ErrCodeBadRequest, "Invalid input")
+	ErrInvalidCredentials   = NewError(http.StatusUnauthorized, ErrCodeInvalidCredentials, "Invalid email or password")
+	ErrInvalidToken         = NewError(http.StatusUnauthorized, ErrCodeInvalidToken, "Invalid authentication token")
+	ErrExpiredToken         = NewError(http.StatusUnauthorized, ErrCodeExpiredToken, "Authentication token has expired")
+	ErrMissingToken         = NewError(http.StatusUnauthorized, ErrCodeMissingToken, "Authentication token is missing")
+	ErrRefreshTokenReused   = NewError(http.StatusUnauthorized, ErrCodeInvalidToken, "Refresh token was revoked or already used")
+	ErrTokenRevoked         = NewError(http.StatusUnauthorized, ErrCodeInvalidToken, "Authentication token has been revoked")
+	ErrInvalidAPIKey        = NewError(http.StatusUnauthorized, ErrCodeInvalidToken, "Invalid API key")
+	ErrExpiredAPIKey        = NewError(http.StatusUnauthorized, ErrCodeExpiredToken, "API key has expired")
+	ErrRevokedAPIKey        = NewError(http.StatusUnauthorized, ErrCodeInvalidToken, "API key has been revoked")
+	ErrAPIKeyNotAllowed     = NewError(http.StatusForbidden, ErrCodeForbidden, "This endpoint cannot be used with an API key")
+	ErrImpersonating        = NewError(http.StatusForbidden, ErrCodeForbidden, "This endpoint cannot be used while impersonating a user")
+	ErrImpersonationWrite   = NewError(http.StatusForbidden, ErrCodeReadOnly, "Changes are blocked while impersonating this user")
+	ErrAPIKeyAlreadyRevoked = NewError(http.StatusConflict, ErrCodeConflict, "API key was already revoked")
+	ErrInvalidLink          = NewError(http.StatusBadRequest, ErrCodeInvalidToken, "Link is invalid or has expired")
+	ErrLinkUsed             = NewError(http.StatusBadRequest, ErrCodeInvalidToken, "Link was already used or replaced by a newer one")
+	ErrEmailVerified        = NewError(http.StatusConflict, ErrCodeConflict, "Email address is already verified")
+	ErrEmailNotVerified     = NewError(http.StatusForbidden, ErrCodeForbidden, "Verify your email address first")
+	ErrCustomerLinked       = NewError(http.StatusConflict, ErrCodeConflict, "Customer is linked to another account")
+	ErrInvalidChallenge     = NewError(http.StatusUnauthorized, ErrCodeInvalidToken, "Login challenge is invalid or has expired")
+	ErrInvalidTwoFactorCode = NewError(http.StatusUnauthorized, ErrCodeInvalidCredentials, "Invalid or already used two-factor code")
+	ErrTwoFactorEnabled     = NewError(http.StatusConflict, ErrCodeConflict, "Two-factor authentication is already enabled")
+	ErrTwoFactorNotEnabled  = NewError(http.StatusConflict, ErrCodeConflict, "Two-factor authentication is not enabled")
+	ErrTwoFactorNotSetUp    = NewError(http.StatusConflict, ErrCodeConflict, "Two-factor authentication has not been set up")
+	ErrTwoFactorRequired    = NewError(http.StatusConflict, ErrCodeConflict, "Two-factor authentication is required for your role")
+	ErrWeakPassword         = NewError(http.StatusBadRequest, ErrCodeWeakPassword, "Password does not meet security requirements")
+	ErrInvalidRole          = NewError(http.StatusBadRequest, ErrCodeInvalidRole, "Invalid user role specified")
+	ErrBuiltInRole          = NewError(http.StatusConflict, ErrCodeConflict, "Built-in roles cannot be deleted")
+	ErrRoleInUse            = NewError(http.StatusConflict, ErrCodeConflict, "Role is still assigned to users or invitations")
+	ErrInvalidTransition    = NewError(http.StatusConflict, ErrCodeConflict, "Order cannot move to the requested status")
+	ErrOrderNotEditable     = NewError(http.StatusConflict, ErrCodeConflict, "Only pending orders can be modified")
+	ErrOrderNotDeletable    = NewError(http.StatusConflict, ErrCodeConflict, "Shipped and delivered orders cannot be deleted")
+	ErrOrderStatusChange    = NewError(http.StatusBadRequest, ErrCodeBadRequest, "Order status can only be changed through POST /orders/:id/transitions")
 )
 
 // Error implements the error interface
@@ -92,6 +147,14 @@ func HandleError(w http.ResponseWriter, err error) {
 		errResp = NewError(http.StatusInternalServerError, ErrCodeInternalServer, "Internal server error")
 	}
 
+	// Debug information stays in the logs, unless the server runs in development
+	if !debugErrors && errResp.Debug != "" {
+		if errResp.StatusCode >= http.StatusInternalServerError {
+			log.Printf("❌ %s: %s", errResp.Message, errResp.Debug)
+		}
+		errResp.Debug = ""
+	}
+
 	// Set status code and write response
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(errResp.StatusCode)
@@ -119,9 +182,34 @@ func NewNotFoundError(resource string, id interface{}) ErrorResponse {
 	)
 }
 
+// StockShortage describes an order item that the current stock cannot fulfil
+type StockShortage struct {
+	BookID    int
+	Requested int
+	Available int
+}
+
+// NewInsufficientStockError creates an insufficient stock error listing the items that are short
+func NewInsufficientStockError(shortages []StockShortage) ErrorResponse {
+	return ErrInsufficientStock.WithDetails(shortages)
+}
+
+// NewTotalPriceMismatchError creates an error for an order whose submitted total differs from
+// the total computed from the current book prices
+func NewTotalPriceMismatchError(submitted, computed float64) ErrorResponse {
+	return NewError(http.StatusBadRequest, ErrCodeBadRequest, "Total price does not match the order items").
+		WithDetails(map[string]float64{"Submitted": submitted, "Computed": computed})
+}
+
 // IsDuplicateKeyError checks if an error is a duplicate key error
 func IsDuplicateKeyError(err error) bool {
 	return errors.Is(err, ErrDuplicateKey)
 }
 
 var ErrDuplicateKey = errors.New("duplicate key error")
+
+// IsNotFoundError checks if an error is a not found error response
+func IsNotFoundError(err error) bool {
+	var errResp ErrorResponse
+	return errors.As(err, &errResp) && errResp.StatusCode == http.StatusNotFound
+}
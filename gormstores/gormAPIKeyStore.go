@@ -0,0 +1,95 @@
+package gormstores
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/models"
+)
+
+type GormAPIKeyStore struct {
+	db *gorm.DB
+}
+
+func NewGormAPIKeyStore(db *gorm.DB) *GormAPIKeyStore {
+	return &GormAPIKeyStore{db: db}
+}
+
+func (store *GormAPIKeyStore) ListAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	if err := store.withPermissions(ctx).Order("id").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (store *GormAPIKeyStore) GetAPIKey(ctx context.Context, id int) (models.APIKey, error) {
+	var key models.APIKey
+	if err := store.withPermissions(ctx).First(&key, id).Error; err != nil {
+		return models.APIKey{}, translateError(err, errorhandling.ErrAPIKeyNotFound)
+	}
+	return key, nil
+}
+
+func (store *GormAPIKeyStore) GetAPIKeyByHash(ctx context.Context, keyHash string) (models.APIKey, error) {
+	var key models.APIKey
+	if err := store.withPermissions(ctx).Where("key_hash = ?", keyHash).First(&key).Error; err != nil {
+		return models.APIKey{}, translateError(err, errorhandling.ErrAPIKeyNotFound)
+	}
+	return key, nil
+}
+
+func (store *GormAPIKeyStore) CreateAPIKey(ctx context.Context, key models.APIKey) (models.APIKey, error) {
+	key.ID = 0
+	for i := range key.Permissions {
+		key.Permissions[i].ID = 0
+	}
+	if err := store.db.WithContext(ctx).Create(&key).Error; err != nil {
+		return models.APIKey{}, translateError(err, errorhandling.ErrAPIKeyNotFound)
+	}
+	return key, nil
+}
+
+// TouchAPIKey records when a key was last used. The update never moves the time backwards.
+func (store *GormAPIKeyStore) TouchAPIKey(ctx context.Context, id int, usedAt time.Time) error {
+	return store.db.WithContext(ctx).Model(&models.APIKey{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", id, usedAt.UTC()).
+		Update("last_used_at", usedAt.UTC()).Error
+}
+
+// RevokeAPIKey marks a key as revoked. The key is kept, so that admins can still see it. The
+// update is conditional on the key not being revoked yet, and fails with
+// ErrAPIKeyAlreadyRevoked otherwise.
+func (store *GormAPIKeyStore) RevokeAPIKey(ctx context.Context, id int) (models.APIKey, error) {
+	var key models.APIKey
+	err := store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.APIKey{}).
+			Where("id = ? AND revoked_at IS NULL", id).
+			Update("revoked_at", time.Now().UTC())
+		if result.Error != nil {
+			return result.Error
+		}
+		if err := tx.Preload("Permissions", func(db *gorm.DB) *gorm.DB {
+			return db.Order("id")
+		}).First(&key, id).Error; err != nil {
+			return err
+		}
+		if result.RowsAffected == 0 {
+			return errorhandling.ErrAPIKeyAlreadyRevoked
+		}
+		return nil
+	})
+	if err != nil {
+		return models.APIKey{}, translateError(err, errorhandling.ErrAPIKeyNotFound)
+	}
+	return key, nil
+}
+
+// withPermissions returns a query loading keys with their permissions
+func (store *GormAPIKeyStore) withPermissions(ctx context.Context) *gorm.DB {
+	return store.db.WithContext(ctx).Preload("Permissions", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	})
+}
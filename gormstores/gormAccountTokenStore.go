@@ -0,0 +1,95 @@
+package gormstores
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/models"
+)
+
+type GormAccountTokenStore struct {
+	db *gorm.DB
+}
+
+func NewGormAccountTokenStore(db *gorm.DB) *GormAccountTokenStore {
+	return &GormAccountTokenStore{db: db}
+}
+
+// CreateAccountToken records a token, superseding the unused tokens of the same user and purpose
+func (store *GormAccountTokenStore) CreateAccountToken(ctx context.Context, token models.AccountToken) (models.AccountToken, error) {
+	token.ID = 0
+	token.ExpiresAt = token.ExpiresAt.UTC()
+	now := time.Now().UTC()
+	err := store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("expires_at <= ?", now).Delete(&models.AccountToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.AccountToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", token.UserID, token.Purpose).
+			Update("used_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Create(&token).Error
+	})
+	if err != nil {
+		return models.AccountToken{}, translateError(err, errorhandling.ErrAccountTokenNotFound)
+	}
+	return token, nil
+}
+
+func (store *GormAccountTokenStore) GetAccountToken(ctx context.Context, jti string) (models.AccountToken, error) {
+	var token models.AccountToken
+	if err := store.db.WithContext(ctx).Where("jti = ?", jti).First(&token).Error; err != nil {
+		return models.AccountToken{}, translateError(err, errorhandling.ErrAccountTokenNotFound)
+	}
+	return token, nil
+}
+
+// ConsumeAccountToken marks a token as used. Marking it is conditional on it being unused, so
+// that concurrent requests cannot both use it.
+func (store *GormAccountTokenStore) ConsumeAccountToken(ctx context.Context, jti string) (models.AccountToken, error) {
+	var token models.AccountToken
+	err := store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.AccountToken{}).
+			Where("jti = ? AND used_at IS NULL", jti).
+			Update("used_at", time.Now().UTC())
+		if result.Error != nil {
+			return result.Error
+		}
+		if err := tx.Where("jti = ?", jti).First(&token).Error; err != nil {
+			return err
+		}
+		if result.RowsAffected == 0 {
+			return errorhandling.ErrLinkUsed
+		}
+		return nil
+	})
+	if err != nil {
+		return models.AccountToken{}, translateError(err, errorhandling.ErrAccountTokenNotFound)
+	}
+	return token, nil
+}
+
+// RecordFailedAttempt counts a failed attempt at the code checked by a token. The token is
+// used up once maxAttempts attempts failed.
+func (store *GormAccountTokenStore) RecordFailedAttempt(ctx context.Context, jti string, maxAttempts int) (models.AccountToken, error) {
+	var token models.AccountToken
+	err := store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.AccountToken{}).Where("jti = ?", jti).
+			Update("attempts", gorm.Expr("attempts + 1")).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.AccountToken{}).
+			Where("jti = ? AND attempts >= ? AND used_at IS NULL", jti, maxAttempts).
+			Update("used_at", time.Now().UTC()).Error; err != nil {
+			return err
+		}
+		return tx.Where("jti = ?", jti).First(&token).Error
+	})
+	if err != nil {
+		return models.AccountToken{}, translateError(err, errorhandling.ErrAccountTokenNotFound)
+	}
+	return token, nil
+}
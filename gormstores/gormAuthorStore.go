@@ -0,0 +1,66 @@
+package gormstores
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/models"
+)
+
+type GormAuthorStore struct {
+	db *gorm.DB
+}
+
+func NewGormAuthorStore(db *gorm.DB) *GormAuthorStore {
+	return &GormAuthorStore{db: db}
+}
+
+func (store *GormAuthorStore) CreateAuthor(ctx context.Context, author models.Author) (models.Author, error) {
+	author.ID = 0
+	if err := store.db.WithContext(ctx).Create(&author).Error; err != nil {
+		return models.Author{}, translateError(err, errorhandling.ErrAuthorNotFound)
+	}
+	return author, nil
+}
+
+func (store *GormAuthorStore) GetAuthor(ctx context.Context, id int) (models.Author, error) {
+	var author models.Author
+	if err := store.db.WithContext(ctx).First(&author, id).Error; err != nil {
+		return models.Author{}, translateError(err, errorhandling.ErrAuthorNotFound)
+	}
+	return author, nil
+}
+
+func (store *GormAuthorStore) UpdateAuthor(ctx context.Context, id int, author models.Author) (models.Author, error) {
+	err := store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Select("id").First(&models.Author{}, id).Error; err != nil {
+			return err
+		}
+		author.ID = id
+		return tx.Save(&author).Error
+	})
+	if err != nil {
+		return models.Author{}, translateError(err, errorhandling.ErrAuthorNotFound)
+	}
+	return author, nil
+}
+
+func (store *GormAuthorStore) DeleteAuthor(ctx context.Context, id int) error {
+	result := store.db.WithContext(ctx).Delete(&models.Author{}, id)
+	if result.Error != nil {
+		return translateError(result.Error, errorhandling.ErrAuthorNotFound)
+	}
+	if result.RowsAffected == 0 {
+		return errorhandling.ErrAuthorNotFound
+	}
+	return nil
+}
+
+func (store *GormAuthorStore) GetAllAuthors(ctx context.Context) ([]models.Author, error) {
+	var authors []models.Author
+	if err := store.db.WithContext(ctx).Find(&authors).Error; err != nil {
+		return nil, err
+	}
+	return authors, nil
+}
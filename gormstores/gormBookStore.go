@@ -0,0 +1,108 @@
+package gormstores
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/models"
+)
+
+type GormBookStore struct {
+	db *gorm.DB
+}
+
+func NewGormBookStore(db *gorm.DB) *GormBookStore {
+	return &GormBookStore{db: db}
+}
+
+func (store *GormBookStore) CreateBook(ctx context.Context, book models.Book) (models.Book, error) {
+	book.ID = 0
+	if err := store.db.WithContext(ctx).Omit(clause.Associations).Create(&book).Error; err != nil {
+		return models.Book{}, translateError(err, errorhandling.ErrBookNotFound)
+	}
+	return book, nil
+}
+
+func (store *GormBookStore) GetBook(ctx context.Context, id int) (models.Book, error) {
+	var book models.Book
+	if err := store.db.WithContext(ctx).First(&book, id).Error; err != nil {
+		return models.Book{}, translateError(err, errorhandling.ErrBookNotFound)
+	}
+	return book, nil
+}
+
+func (store *GormBookStore) UpdateBook(ctx context.Context, id int, book models.Book) (models.Book, error) {
+	err := store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Select("id").First(&models.Book{}, id).Error; err != nil {
+			return err
+		}
+		book.ID = id
+		return tx.Omit(clause.Associations).Save(&book).Error
+	})
+	if err != nil {
+		return models.Book{}, translateError(err, errorhandling.ErrBookNotFound)
+	}
+	return book, nil
+}
+
+func (store *GormBookStore) DeleteBook(ctx context.Context, id int) error {
+	result := store.db.WithContext(ctx).Delete(&models.Book{}, id)
+	if result.Error != nil {
+		return translateError(result.Error, errorhandling.ErrBookNotFound)
+	}
+	if result.RowsAffected == 0 {
+		return errorhandling.ErrBookNotFound
+	}
+	return nil
+}
+
+func (store *GormBookStore) SearchBooks(ctx context.Context, criteria models.SearchCriteria) ([]models.Book, error) {
+	query := store.db.WithContext(ctx)
+
+	if len(criteria.Titles) > 0 {
+		query = query.Where(anyLike(store.db, "title", criteria.Titles))
+	}
+	if len(criteria.Authors) > 0 {
+		authorIDs := store.db.Model(&models.Author{}).Select("id").
+			Where(anyLike(store.db, "first_name", criteria.Authors)).
+			Or(anyLike(store.db, "last_name", criteria.Authors))
+		query = query.Where("author_id IN (?)", authorIDs)
+	}
+	if len(criteria.Genres) > 0 {
+		query = query.Where(anyLike(store.db, "genres", criteria.Genres))
+	}
+	if criteria.AuthorID > 0 {
+		query = query.Where("author_id = ?", criteria.AuthorID)
+	}
+	if criteria.MinPrice > 0 {
+		query = query.Where("price >= ?", criteria.MinPrice)
+	}
+	if criteria.MaxPrice > 0 {
+		query = query.Where("price <= ?", criteria.MaxPrice)
+	}
+
+	var books []models.Book
+	if err := query.Find(&books).Error; err != nil {
+		return nil, err
+	}
+	return books, nil
+}
+
+func (store *GormBookStore) GetAllBooks(ctx context.Context) ([]models.Book, error) {
+	var books []models.Book
+	if err := store.db.WithContext(ctx).Find(&books).Error; err != nil {
+		return nil, err
+	}
+	return books, nil
+}
+
+// anyLike builds a case-insensitive "column contains any of the terms" condition
+func anyLike(db *gorm.DB, column string, terms []string) *gorm.DB {
+	condition := db.Where(containsClause(column), containsPattern(terms[0]))
+	for _, term := range terms[1:] {
+		condition = condition.Or(containsClause(column), containsPattern(term))
+	}
+	return condition
+}
@@ -0,0 +1,196 @@
+package gormstores
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/models"
+)
+
+type GormCustomerStore struct {
+	db *gorm.DB
+}
+
+func NewGormCustomerStore(db *gorm.DB) *GormCustomerStore {
+	return &GormCustomerStore{db: db}
+}
+
+func (store *GormCustomerStore) CreateCustomer(ctx context.Context, customer models.Customer) (models.Customer, error) {
+	customer.ID = 0
+	customer.Addresses = nil
+	if err := store.db.WithContext(ctx).Omit(clause.Associations).Create(&customer).Error; err != nil {
+		return models.Customer{}, translateError(err, errorhandling.ErrCustomerNotFound)
+	}
+	return customer, nil
+}
+
+func (store *GormCustomerStore) GetCustomer(ctx context.Context, id int) (models.Customer, error) {
+	var customer models.Customer
+	if err := store.withAddresses(ctx).First(&customer, id).Error; err != nil {
+		return models.Customer{}, translateError(err, errorhandling.ErrCustomerNotFound)
+	}
+	return customer, nil
+}
+
+// UpdateCustomer replaces the details of a customer. Its link to a user and its address book
+// have their own methods.
+func (store *GormCustomerStore) UpdateCustomer(ctx context.Context, id int, customer models.Customer) (models.Customer, error) {
+	err := store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var existing models.Customer
+		if err := tx.Preload("Addresses", orderByID).First(&existing, id).Error; err != nil {
+			return err
+		}
+		customer.ID = id
+		customer.UserID = existing.UserID
+		customer.Addresses = existing.Addresses
+		customer.CreatedAt = existing.CreatedAt
+		return tx.Omit(clause.Associations).Save(&customer).Error
+	})
+	if err != nil {
+		return models.Customer{}, translateError(err, errorhandling.ErrCustomerNotFound)
+	}
+	return customer, nil
+}
+
+// DeleteCustomer deletes a customer along with its address book
+func (store *GormCustomerStore) DeleteCustomer(ctx context.Context, id int) error {
+	err := store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("customer_id = ?", id).Delete(&models.CustomerAddress{}).Error; err != nil {
+			return err
+		}
+		result := tx.Delete(&models.Customer{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errorhandling.ErrCustomerNotFound
+		}
+		return nil
+	})
+	return translateError(err, errorhandling.ErrCustomerNotFound)
+}
+
+func (store *GormCustomerStore) GetAllCustomers(ctx context.Context) ([]models.Customer, error) {
+	var customers []models.Customer
+	if err := store.withAddresses(ctx).Find(&customers).Error; err != nil {
+		return nil, err
+	}
+	return customers, nil
+}
+
+func (store *GormCustomerStore) GetCustomerByEmail(ctx context.Context, email string) (models.Customer, error) {
+	var customer models.Customer
+	if err := store.withAddresses(ctx).Where("email = ?", email).First(&customer).Error; err != nil {
+		return models.Customer{}, translateError(err, errorhandling.ErrCustomerNotFound)
+	}
+	return customer, nil
+}
+
+func (store *GormCustomerStore) GetCustomerByUserID(ctx context.Context, userID int) (models.Customer, error) {
+	var customer models.Customer
+	if err := store.withAddresses(ctx).Where("user_id = ?", userID).First(&customer).Error; err != nil {
+		return models.Customer{}, translateError(err, errorhandling.ErrCustomerNotFound)
+	}
+	return customer, nil
+}
+
+// LinkCustomer links a customer to a user account. A customer linked to another user, or a
+// user linked to another customer, cannot be linked.
+func (store *GormCustomerStore) LinkCustomer(ctx context.Context, id int, userID int) (models.Customer, error) {
+	var customer models.Customer
+	err := store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Customer{}).
+			Where("id = ? AND (user_id IS NULL OR user_id = ?)", id, userID).
+			Update("user_id", userID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if err := tx.Preload("Addresses", orderByID).First(&customer, id).Error; err != nil {
+			return err
+		}
+		if result.RowsAffected == 0 {
+			return errorhandling.ErrCustomerLinked
+		}
+		return nil
+	})
+	if err != nil {
+		return models.Customer{}, translateError(err, errorhandling.ErrCustomerNotFound)
+	}
+	return customer, nil
+}
+
+func (store *GormCustomerStore) AddCustomerAddress(ctx context.Context, customerID int, address models.CustomerAddress) (models.CustomerAddress, error) {
+	err := store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Select("id").First(&models.Customer{}, customerID).Error; err != nil {
+			return err
+		}
+		address.ID = 0
+		address.CustomerID = customerID
+		return tx.Create(&address).Error
+	})
+	if err != nil {
+		return models.CustomerAddress{}, translateError(err, errorhandling.ErrCustomerNotFound)
+	}
+	return address, nil
+}
+
+// UpdateCustomerAddress replaces an address of the address book of a customer
+func (store *GormCustomerStore) UpdateCustomerAddress(ctx context.Context, customerID int, address models.CustomerAddress) (models.CustomerAddress, error) {
+	address.CustomerID = customerID
+	result := store.db.WithContext(ctx).Model(&models.CustomerAddress{}).
+		Where("id = ? AND customer_id = ?", address.ID, customerID).
+		Select("*").Omit("id").
+		Updates(&address)
+	if result.Error != nil {
+		return models.CustomerAddress{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return models.CustomerAddress{}, errorhandling.ErrAddressNotFound
+	}
+	return address, nil
+}
+
+func (store *GormCustomerStore) DeleteCustomerAddress(ctx context.Context, customerID int, id int) error {
+	result := store.db.WithContext(ctx).Where("id = ? AND customer_id = ?", id, customerID).Delete(&models.CustomerAddress{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errorhandling.ErrAddressNotFound
+	}
+	return nil
+}
+
+func (store *GormCustomerStore) SearchCustomers(ctx context.Context, criteria models.CustomerSearchCriteria) ([]models.Customer, error) {
+	query := store.withAddresses(ctx)
+	if criteria.Email != "" {
+		query = query.Where(containsClause("email"), containsPattern(criteria.Email))
+	}
+	if criteria.Name != "" {
+		query = query.Where(containsClause("name"), containsPattern(criteria.Name))
+	}
+	if criteria.City != "" {
+		query = query.Where(containsClause("city"), containsPattern(criteria.City))
+	}
+	if criteria.UserID != 0 {
+		query = query.Where("user_id = ?", criteria.UserID)
+	}
+
+	var customers []models.Customer
+	if err := query.Find(&customers).Error; err != nil {
+		return nil, err
+	}
+	return customers, nil
+}
+
+// withAddresses returns a query loading customers with their address book
+func (store *GormCustomerStore) withAddresses(ctx context.Context) *gorm.DB {
+	return store.db.WithContext(ctx).Preload("Addresses", orderByID)
+}
+
+// orderByID orders preloaded associations by ID
+func orderByID(db *gorm.DB) *gorm.DB {
+	return db.Order("id")
+}
@@ -0,0 +1,36 @@
+package gormstores
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+	"um6p.ma/finalproject/errorhandling"
+)
+
+// translateError maps GORM errors onto the errors the handlers already understand,
+// so callers can treat every store implementation the same way
+func translateError(err error, notFound errorhandling.ErrorResponse) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return notFound
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return fmt.Errorf("%w: %v", errorhandling.ErrDuplicateKey, err)
+	default:
+		return err
+	}
+}
+
+// containsClause returns a case-insensitive substring match on column. ILIKE only exists
+// on PostgreSQL, so both sides are lowered and compared with LIKE, which every dialect supports.
+func containsClause(column string) string {
+	return "LOWER(" + column + ") LIKE ?"
+}
+
+// containsPattern returns the LIKE pattern matching values that contain term
+func containsPattern(term string) string {
+	return "%" + strings.ToLower(term) + "%"
+}
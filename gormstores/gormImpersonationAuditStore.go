@@ -0,0 +1,47 @@
+package gormstores
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+	"um6p.ma/finalproject/models"
+)
+
+type GormImpersonationAuditStore struct {
+	db *gorm.DB
+}
+
+func NewGormImpersonationAuditStore(db *gorm.DB) *GormImpersonationAuditStore {
+	return &GormImpersonationAuditStore{db: db}
+}
+
+func (store *GormImpersonationAuditStore) RecordImpersonatedRequest(ctx context.Context, request models.ImpersonatedRequest) (models.ImpersonatedRequest, error) {
+	request.ID = 0
+	if err := store.db.WithContext(ctx).Create(&request).Error; err != nil {
+		return models.ImpersonatedRequest{}, err
+	}
+	return request, nil
+}
+
+// ListImpersonatedRequests returns the requests matching a filter, most recent first
+func (store *GormImpersonationAuditStore) ListImpersonatedRequests(ctx context.Context, filter models.ImpersonatedRequestFilter) ([]models.ImpersonatedRequest, error) {
+	query := store.db.WithContext(ctx).Order("id DESC")
+	if filter.ImpersonatorID != 0 {
+		query = query.Where("impersonator_id = ?", filter.ImpersonatorID)
+	}
+	if filter.UserID != 0 {
+		query = query.Where("user_id = ?", filter.UserID)
+	}
+	if filter.TokenID != "" {
+		query = query.Where("token_id = ?", filter.TokenID)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var requests []models.ImpersonatedRequest
+	if err := query.Find(&requests).Error; err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
@@ -0,0 +1,86 @@
+package gormstores
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/models"
+)
+
+type GormInvitationStore struct {
+	db *gorm.DB
+}
+
+func NewGormInvitationStore(db *gorm.DB) *GormInvitationStore {
+	return &GormInvitationStore{db: db}
+}
+
+func (store *GormInvitationStore) CreateInvitation(ctx context.Context, invitation models.Invitation) (models.Invitation, error) {
+	invitation.ID = 0
+	if err := store.db.WithContext(ctx).Create(&invitation).Error; err != nil {
+		return models.Invitation{}, translateError(err, errorhandling.ErrInvitationNotFound)
+	}
+	return invitation, nil
+}
+
+func (store *GormInvitationStore) GetInvitationByHash(ctx context.Context, tokenHash string) (models.Invitation, error) {
+	var invitation models.Invitation
+	if err := store.db.WithContext(ctx).Where("token_hash = ?", tokenHash).First(&invitation).Error; err != nil {
+		return models.Invitation{}, translateError(err, errorhandling.ErrInvitationNotFound)
+	}
+	return invitation, nil
+}
+
+func (store *GormInvitationStore) ListInvitations(ctx context.Context) ([]models.Invitation, error) {
+	var invitations []models.Invitation
+	if err := store.db.WithContext(ctx).Order("id").Find(&invitations).Error; err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+func (store *GormInvitationStore) DeleteInvitation(ctx context.Context, id int) error {
+	result := store.db.WithContext(ctx).Delete(&models.Invitation{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errorhandling.ErrInvitationNotFound
+	}
+	return nil
+}
+
+// AcceptInvitation creates the user of an invitation and marks it as accepted. Marking it is
+// conditional on it not being accepted yet, so that concurrent requests cannot both use it.
+func (store *GormInvitationStore) AcceptInvitation(ctx context.Context, id int, user models.User) (models.User, error) {
+	user.ID = 0
+	err := store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Invitation{}).
+			Where("id = ? AND accepted_at IS NULL", id).
+			Update("accepted_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			var count int64
+			if err := tx.Model(&models.Invitation{}).Where("id = ?", id).Count(&count).Error; err != nil {
+				return err
+			}
+			if count == 0 {
+				return gorm.ErrRecordNotFound
+			}
+			return errorhandling.ErrInvitationUsed
+		}
+
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Invitation{}).Where("id = ?", id).Update("user_id", user.ID).Error
+	})
+	if err != nil {
+		return models.User{}, translateError(err, errorhandling.ErrInvitationNotFound)
+	}
+	return user, nil
+}
@@ -0,0 +1,105 @@
+package gormstores
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/models"
+)
+
+type GormLoginThrottleStore struct {
+	db *gorm.DB
+}
+
+func NewGormLoginThrottleStore(db *gorm.DB) *GormLoginThrottleStore {
+	return &GormLoginThrottleStore{db: db}
+}
+
+// GetLoginThrottle returns the failed logins of an identifier, which are none if it has no row
+func (store *GormLoginThrottleStore) GetLoginThrottle(ctx context.Context, identifier string) (models.LoginThrottle, error) {
+	var throttle models.LoginThrottle
+	err := store.db.WithContext(ctx).Where("identifier = ?", identifier).First(&throttle).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.LoginThrottle{Identifier: identifier}, nil
+	}
+	return throttle, err
+}
+
+// RecordLoginFailure counts a failed login of an identifier. The count is incremented in a
+// single UPDATE, so that concurrent failures on several instances are all counted, and starts
+// over when the previous failure is older than window. Rows whose failures and lock are over
+// are purged.
+func (store *GormLoginThrottleStore) RecordLoginFailure(ctx context.Context, identifier string, at time.Time, window time.Duration) (models.LoginThrottle, error) {
+	at = at.UTC()
+	cutoff := at.Add(-window)
+	var throttle models.LoginThrottle
+	err := store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("last_failure_at < ? AND (locked_until IS NULL OR locked_until < ?)", cutoff, at).
+			Delete(&models.LoginThrottle{}).Error; err != nil {
+			return err
+		}
+
+		result := tx.Model(&models.LoginThrottle{}).Where("identifier = ?", identifier).Updates(map[string]interface{}{
+			"failures":        gorm.Expr("CASE WHEN last_failure_at < ? THEN 1 ELSE failures + 1 END", cutoff),
+			"last_failure_at": at,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			if err := tx.Create(&models.LoginThrottle{
+				Identifier:    identifier,
+				Failures:      1,
+				LastFailureAt: at,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Where("identifier = ?", identifier).First(&throttle).Error
+	})
+	if err != nil {
+		return models.LoginThrottle{}, translateError(err, errorhandling.ErrThrottleNotFound)
+	}
+	return throttle, nil
+}
+
+// LockLogin refuses the logins of an identifier until the given time
+func (store *GormLoginThrottleStore) LockLogin(ctx context.Context, identifier string, until time.Time) error {
+	result := store.db.WithContext(ctx).Model(&models.LoginThrottle{}).
+		Where("identifier = ?", identifier).
+		Update("locked_until", until.UTC())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errorhandling.ErrThrottleNotFound
+	}
+	return nil
+}
+
+// ClearLoginThrottle forgets the failed logins and the lock of an identifier
+func (store *GormLoginThrottleStore) ClearLoginThrottle(ctx context.Context, identifier string) error {
+	result := store.db.WithContext(ctx).Where("identifier = ?", identifier).Delete(&models.LoginThrottle{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errorhandling.ErrThrottleNotFound
+	}
+	return nil
+}
+
+// ListLockedLogins returns the identifiers locked at the given time, soonest unlocked first
+func (store *GormLoginThrottleStore) ListLockedLogins(ctx context.Context, now time.Time) ([]models.LoginThrottle, error) {
+	var throttles []models.LoginThrottle
+	if err := store.db.WithContext(ctx).
+		Where("locked_until > ?", now.UTC()).
+		Order("locked_until, id").
+		Find(&throttles).Error; err != nil {
+		return nil, err
+	}
+	return throttles, nil
+}
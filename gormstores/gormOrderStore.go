@@ -0,0 +1,314 @@
+package gormstores
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"um6p.ma/finalproject/constants"
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/models"
+)
+
+type GormOrderStore struct {
+	db *gorm.DB
+}
+
+func NewGormOrderStore(db *gorm.DB) *GormOrderStore {
+	return &GormOrderStore{db: db}
+}
+
+func (store *GormOrderStore) CreateOrder(ctx context.Context, order models.Order) (models.Order, error) {
+	order.ID = 0
+	order.CreatedAt = time.Now()
+	order.Status = constants.OrderStatusPending
+	order.History = []models.OrderStatusChange{{
+		ToStatus:  constants.OrderStatusPending,
+		ChangedAt: order.CreatedAt,
+	}}
+
+	err := store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := priceOrder(tx, &order); err != nil {
+			return err
+		}
+		changes, err := models.StockChanges(nil, order.Items)
+		if err != nil {
+			return err
+		}
+		if err := reserveStock(tx, changes); err != nil {
+			return err
+		}
+		if err := tx.Omit(clause.Associations).Create(&order).Error; err != nil {
+			return err
+		}
+		if err := createOrderItems(tx, order.ID, order.Items); err != nil {
+			return err
+		}
+		order.History[0].OrderID = order.ID
+		return tx.Create(&order.History).Error
+	})
+	if err != nil {
+		return models.Order{}, translateError(err, errorhandling.ErrOrderNotFound)
+	}
+	return order, nil
+}
+
+func (store *GormOrderStore) GetOrder(ctx context.Context, id int) (models.Order, error) {
+	var order models.Order
+	if err := preloadOrders(store.db.WithContext(ctx)).First(&order, id).Error; err != nil {
+		return models.Order{}, translateError(err, errorhandling.ErrOrderNotFound)
+	}
+	return order, nil
+}
+
+func (store *GormOrderStore) UpdateOrder(ctx context.Context, id int, order models.Order) (models.Order, error) {
+	err := store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var existing models.Order
+		if err := preloadOrders(lockOrder(tx)).First(&existing, id).Error; err != nil {
+			return err
+		}
+		if order.Status != "" && order.Status != existing.Status {
+			return errorhandling.ErrOrderStatusChange
+		}
+		if existing.Status != constants.OrderStatusPending {
+			return errorhandling.ErrOrderNotEditable
+		}
+		if err := priceOrder(tx, &order); err != nil {
+			return err
+		}
+		changes, err := models.StockChanges(existing.Items, order.Items)
+		if err != nil {
+			return err
+		}
+		if err := reserveStock(tx, changes); err != nil {
+			return err
+		}
+
+		order.ID = id
+		order.CreatedAt = existing.CreatedAt
+		order.Status = existing.Status
+		order.History = existing.History
+		if err := tx.Omit(clause.Associations).Save(&order).Error; err != nil {
+			return err
+		}
+
+		// Items are replaced wholesale, mirroring the in-memory store
+		if err := tx.Where("order_id = ?", id).Delete(&models.OrderItem{}).Error; err != nil {
+			return err
+		}
+		return createOrderItems(tx, id, order.Items)
+	})
+	if err != nil {
+		return models.Order{}, translateError(err, errorhandling.ErrOrderNotFound)
+	}
+	return order, nil
+}
+
+// TransitionOrder moves an order to another status, returning the stock of cancelled orders
+func (store *GormOrderStore) TransitionOrder(ctx context.Context, id int, change models.OrderStatusChange) (models.Order, error) {
+	var order models.Order
+	err := store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := preloadOrders(lockOrder(tx)).First(&order, id).Error; err != nil {
+			return err
+		}
+		if !order.ApplyStatusChange(change) {
+			return errorhandling.ErrInvalidTransition
+		}
+
+		// Conditional on the previous status, so that concurrent transitions cannot both succeed
+		result := tx.Model(&models.Order{}).
+			Where("id = ? AND status = ?", id, change.FromStatus).
+			Update("status", change.ToStatus)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errorhandling.ErrInvalidTransition
+		}
+
+		if order.Status == constants.OrderStatusCancelled {
+			changes, err := models.StockChanges(order.Items, nil)
+			if err != nil {
+				return err
+			}
+			if err := reserveStock(tx, changes); err != nil {
+				return err
+			}
+		}
+		return tx.Create(&order.History[len(order.History)-1]).Error
+	})
+	if err != nil {
+		return models.Order{}, translateError(err, errorhandling.ErrOrderNotFound)
+	}
+	return order, nil
+}
+
+// DeleteOrder removes an order, returning the stock it still holds. Shipped and delivered
+// orders cannot be deleted.
+func (store *GormOrderStore) DeleteOrder(ctx context.Context, id int) error {
+	err := store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var existing models.Order
+		if err := lockOrder(tx).Preload("Items").First(&existing, id).Error; err != nil {
+			return err
+		}
+		if existing.Status == constants.OrderStatusShipped || existing.Status == constants.OrderStatusDelivered {
+			return errorhandling.ErrOrderNotDeletable
+		}
+		if existing.HoldsStock() {
+			changes, err := models.StockChanges(existing.Items, nil)
+			if err != nil {
+				return err
+			}
+			if err := reserveStock(tx, changes); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Where("order_id = ?", id).Delete(&models.OrderItem{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("order_id = ?", id).Delete(&models.OrderStatusChange{}).Error; err != nil {
+			return err
+		}
+		result := tx.Delete(&models.Order{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+	return translateError(err, errorhandling.ErrOrderNotFound)
+}
+
+func (store *GormOrderStore) GetAllOrders(ctx context.Context) ([]models.Order, error) {
+	var orders []models.Order
+	if err := preloadOrders(store.db.WithContext(ctx)).Find(&orders).Error; err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+func (store *GormOrderStore) GetOrdersInTimeRange(ctx context.Context, start, end time.Time) ([]models.Order, error) {
+	var orders []models.Order
+	if err := preloadOrders(store.db.WithContext(ctx)).
+		Where("created_at BETWEEN ? AND ?", start, end).
+		Find(&orders).Error; err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+func (store *GormOrderStore) GetOrdersByCustomer(ctx context.Context, customerID int) ([]models.Order, error) {
+	var orders []models.Order
+	if err := preloadOrders(store.db.WithContext(ctx)).
+		Where("customer_id = ?", customerID).
+		Find(&orders).Error; err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+// lockOrder locks the order row read within tx until the transaction ends, so that concurrent
+// changes of one order see each other's items and status instead of each applying its own
+// stock changes to the same snapshot. SQLite ignores it, as its transactions take the write
+// lock of the whole database when they begin.
+func lockOrder(tx *gorm.DB) *gorm.DB {
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"})
+}
+
+// preloadOrders loads the items and the status history along with orders
+func preloadOrders(db *gorm.DB) *gorm.DB {
+	return db.Preload("Items").Preload("History", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	})
+}
+
+// createOrderItems inserts the items of an order without touching the referenced books
+func createOrderItems(tx *gorm.DB, orderID int, items []models.OrderItem) error {
+	for i := range items {
+		items[i].ID = 0
+		items[i].OrderID = orderID
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	return tx.Omit(clause.Associations).Create(&items).Error
+}
+
+// priceOrder prices the order items at the current prices of their books. A total submitted
+// with the order must match the computed one.
+func priceOrder(tx *gorm.DB, order *models.Order) error {
+	if err := models.CheckItems(order.Items); err != nil {
+		return err
+	}
+
+	bookIDs := make([]int, 0, len(order.Items))
+	for _, item := range order.Items {
+		bookIDs = append(bookIDs, item.BookID)
+	}
+
+	var books []models.Book
+	if err := tx.Select("id", "price").Where("id IN ?", bookIDs).Find(&books).Error; err != nil {
+		return err
+	}
+	prices := make(map[int]float64, len(books))
+	for _, book := range books {
+		prices[book.ID] = book.Price
+	}
+	for _, bookID := range bookIDs {
+		if _, ok := prices[bookID]; !ok {
+			return errorhandling.ErrInvalidInput.WithDetails(fmt.Sprintf("Book with ID %d does not exist", bookID))
+		}
+	}
+
+	submitted := order.TotalPrice
+	if err := order.PriceItems(prices); err != nil {
+		return err
+	}
+	if submitted != 0 && models.RoundAmount(submitted) != order.TotalPrice {
+		return errorhandling.NewTotalPriceMismatchError(submitted, order.TotalPrice)
+	}
+	return nil
+}
+
+// reserveStock applies the stock changes of an order within tx. Each change is a conditional
+// UPDATE that only succeeds while enough stock remains, so that concurrent orders for the last
+// copies cannot both succeed. Changes are ordered by book ID, so concurrent transactions lock
+// the rows in the same order and cannot deadlock.
+func reserveStock(tx *gorm.DB, changes []models.StockChange) error {
+	var shortages []errorhandling.StockShortage
+	for _, change := range changes {
+		result := tx.Model(&models.Book{}).
+			Where("id = ? AND stock + ? >= 0", change.BookID, change.Delta).
+			Update("stock", gorm.Expr("stock + ?", change.Delta))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			continue
+		}
+
+		var book models.Book
+		if err := tx.Select("id", "stock").First(&book, change.BookID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errorhandling.ErrInvalidInput.WithDetails(fmt.Sprintf("Book with ID %d does not exist", change.BookID))
+			}
+			return err
+		}
+		shortages = append(shortages, errorhandling.StockShortage{
+			BookID:    change.BookID,
+			Requested: -change.Delta,
+			Available: book.Stock,
+		})
+	}
+
+	if len(shortages) > 0 {
+		return errorhandling.NewInsufficientStockError(shortages)
+	}
+	return nil
+}
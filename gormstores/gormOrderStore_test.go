@@ -0,0 +1,366 @@
+package gormstores
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"um6p.ma/finalproject/constants"
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/models"
+)
+
+// orderFixture is a migrated database holding a customer and two books, priced 10 and 25.50
+type orderFixture struct {
+	orders   *GormOrderStore
+	books    *GormBookStore
+	customer models.Customer
+	novel    models.Book // 5 copies
+	essay    models.Book // 2 copies
+}
+
+func newOrderFixture(t *testing.T) *orderFixture {
+	t.Helper()
+	return newOrderFixtureIn(t, newTestDB(t))
+}
+
+func newOrderFixtureIn(t *testing.T, db *gorm.DB) *orderFixture {
+	t.Helper()
+	ctx := context.Background()
+	fixture := &orderFixture{orders: NewGormOrderStore(db), books: NewGormBookStore(db)}
+
+	author, err := NewGormAuthorStore(db).CreateAuthor(ctx, models.Author{FirstName: "Jane", LastName: "Austen"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixture.customer, err = NewGormCustomerStore(db).CreateCustomer(ctx, models.Customer{
+		Name:  "Alice Doe",
+		Email: "alice@mybiblio.com",
+		Address: models.Address{
+			Street: "1 Main Street", City: "Ben Guerir", State: "Marrakech-Safi", PostalCode: "43150", Country: "Morocco",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	published := time.Date(1815, 12, 23, 0, 0, 0, 0, time.UTC)
+	fixture.novel, err = fixture.books.CreateBook(ctx, models.Book{
+		Title: "Emma", AuthorID: author.ID, Genres: "Fiction", PublishedAt: published, Price: 10, Stock: 5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixture.essay, err = fixture.books.CreateBook(ctx, models.Book{
+		Title: "Persuasion", AuthorID: author.ID, Genres: "Fiction", PublishedAt: published, Price: 25.50, Stock: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fixture
+}
+
+func (fixture *orderFixture) place(t *testing.T, items ...models.OrderItem) models.Order {
+	t.Helper()
+	order, err := fixture.orders.CreateOrder(context.Background(), models.Order{CustomerID: fixture.customer.ID, Items: items})
+	if err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	return order
+}
+
+// assertStock fails the test unless the books have the given stock, listed as book ID then stock
+func (fixture *orderFixture) assertStock(t *testing.T, stocks ...int) {
+	t.Helper()
+	for i := 0; i < len(stocks); i += 2 {
+		book, err := fixture.books.GetBook(context.Background(), stocks[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if book.Stock != stocks[i+1] {
+			t.Errorf("book %d: got stock %d, want %d", book.ID, book.Stock, stocks[i+1])
+		}
+	}
+}
+
+func item(bookID, quantity int) models.OrderItem {
+	return models.OrderItem{BookID: bookID, Quantity: quantity}
+}
+
+func TestCreateOrderPricesItemsAndReservesStock(t *testing.T) {
+	fixture := newOrderFixture(t)
+	order := fixture.place(t, item(fixture.novel.ID, 2), item(fixture.essay.ID, 1))
+
+	if order.Status != constants.OrderStatusPending || order.TotalPrice != 45.50 {
+		t.Errorf("got status %s and total %.2f, want %s and 45.50", order.Status, order.TotalPrice, constants.OrderStatusPending)
+	}
+	fixture.assertStock(t, fixture.novel.ID, 3, fixture.essay.ID, 1)
+
+	stored, err := fixture.orders.GetOrder(context.Background(), order.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Items) != 2 || stored.Items[1].UnitPrice != 25.50 || stored.Items[1].Subtotal != 25.50 {
+		t.Errorf("got items %+v, want the prices of the books", stored.Items)
+	}
+	if len(stored.History) != 1 || stored.History[0].ToStatus != constants.OrderStatusPending {
+		t.Errorf("got history %+v, want the initial status", stored.History)
+	}
+
+	// Later price changes do not rewrite placed orders
+	fixture.novel.Price = 12
+	if _, err := fixture.books.UpdateBook(context.Background(), fixture.novel.ID, fixture.novel); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ = fixture.orders.GetOrder(context.Background(), order.ID); stored.TotalPrice != 45.50 {
+		t.Errorf("after a price change: got total %.2f, want 45.50", stored.TotalPrice)
+	}
+}
+
+func TestCreateOrderReservesAllOrNothing(t *testing.T) {
+	fixture := newOrderFixture(t)
+
+	_, err := fixture.orders.CreateOrder(context.Background(), models.Order{
+		CustomerID: fixture.customer.ID,
+		Items:      []models.OrderItem{item(fixture.novel.ID, 2), item(fixture.essay.ID, 3)},
+	})
+	assertError(t, err, errorhandling.ErrInsufficientStock)
+	var response errorhandling.ErrorResponse
+	errors.As(err, &response)
+	shortages, _ := response.Details.([]errorhandling.StockShortage)
+	want := errorhandling.StockShortage{BookID: fixture.essay.ID, Requested: 3, Available: 2}
+	if len(shortages) != 1 || shortages[0] != want {
+		t.Errorf("got shortages %+v, want %+v", response.Details, want)
+	}
+
+	fixture.assertStock(t, fixture.novel.ID, 5, fixture.essay.ID, 2)
+	if orders, _ := fixture.orders.GetAllOrders(context.Background()); len(orders) != 0 {
+		t.Errorf("got %d orders, want none", len(orders))
+	}
+}
+
+func TestCreateOrderRejectsInvalidOrders(t *testing.T) {
+	fixture := newOrderFixture(t)
+
+	tests := []struct {
+		name  string
+		order models.Order
+		want  errorhandling.ErrorResponse
+	}{
+		{"no items", models.Order{}, errorhandling.ErrInvalidInput},
+		{"no quantity", models.Order{Items: []models.OrderItem{item(fixture.novel.ID, 0)}}, errorhandling.ErrInvalidInput},
+		{"unknown book", models.Order{Items: []models.OrderItem{item(999, 1)}}, errorhandling.ErrInvalidInput},
+		{"wrong total", models.Order{Items: []models.OrderItem{item(fixture.novel.ID, 1)}, TotalPrice: 9.99},
+			errorhandling.NewTotalPriceMismatchError(9.99, 10)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.order.CustomerID = fixture.customer.ID
+			_, err := fixture.orders.CreateOrder(context.Background(), tt.order)
+			assertError(t, err, tt.want)
+		})
+	}
+	fixture.assertStock(t, fixture.novel.ID, 5)
+}
+
+func TestUpdateOrderRebalancesStock(t *testing.T) {
+	fixture := newOrderFixture(t)
+	ctx := context.Background()
+	order := fixture.place(t, item(fixture.novel.ID, 2))
+
+	updated, err := fixture.orders.UpdateOrder(ctx, order.ID, models.Order{
+		CustomerID: fixture.customer.ID,
+		Items:      []models.OrderItem{item(fixture.novel.ID, 1), item(fixture.essay.ID, 2)},
+	})
+	if err != nil {
+		t.Fatalf("UpdateOrder: %v", err)
+	}
+	if updated.TotalPrice != 61 || updated.Status != constants.OrderStatusPending {
+		t.Errorf("got total %.2f and status %s, want 61.00 and pending", updated.TotalPrice, updated.Status)
+	}
+	fixture.assertStock(t, fixture.novel.ID, 4, fixture.essay.ID, 0)
+
+	// Asking for more than is left keeps the order as it was
+	_, err = fixture.orders.UpdateOrder(ctx, order.ID, models.Order{
+		CustomerID: fixture.customer.ID,
+		Items:      []models.OrderItem{item(fixture.novel.ID, 1), item(fixture.essay.ID, 3)},
+	})
+	assertError(t, err, errorhandling.ErrInsufficientStock)
+	fixture.assertStock(t, fixture.novel.ID, 4, fixture.essay.ID, 0)
+	if stored, _ := fixture.orders.GetOrder(ctx, order.ID); len(stored.Items) != 2 {
+		t.Errorf("got items %+v, want the items of the last update", stored.Items)
+	}
+
+	_, err = fixture.orders.UpdateOrder(ctx, order.ID, models.Order{
+		CustomerID: fixture.customer.ID,
+		Items:      []models.OrderItem{item(fixture.novel.ID, 1)},
+		Status:     constants.OrderStatusShipped,
+	})
+	assertError(t, err, errorhandling.ErrOrderStatusChange)
+
+	_, err = fixture.orders.UpdateOrder(ctx, 999, models.Order{Items: []models.OrderItem{item(fixture.novel.ID, 1)}})
+	if !errorhandling.IsNotFoundError(err) {
+		t.Errorf("unknown order: got %v, want a not found error", err)
+	}
+}
+
+func TestUpdateOrderOnlyEditsPendingOrders(t *testing.T) {
+	fixture := newOrderFixture(t)
+	ctx := context.Background()
+	order := fixture.place(t, item(fixture.novel.ID, 2))
+
+	if _, err := fixture.orders.TransitionOrder(ctx, order.ID, models.OrderStatusChange{
+		FromStatus: constants.OrderStatusPending, ToStatus: constants.OrderStatusProcessing,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := fixture.orders.UpdateOrder(ctx, order.ID, models.Order{
+		CustomerID: fixture.customer.ID,
+		Items:      []models.OrderItem{item(fixture.novel.ID, 1)},
+	})
+	assertError(t, err, errorhandling.ErrOrderNotEditable)
+	fixture.assertStock(t, fixture.novel.ID, 3)
+}
+
+func TestTransitionOrder(t *testing.T) {
+	fixture := newOrderFixture(t)
+	ctx := context.Background()
+	order := fixture.place(t, item(fixture.novel.ID, 2))
+
+	transition := func(from, to string) (models.Order, error) {
+		return fixture.orders.TransitionOrder(ctx, order.ID, models.OrderStatusChange{FromStatus: from, ToStatus: to, ChangedBy: 1})
+	}
+
+	_, err := transition(constants.OrderStatusPending, constants.OrderStatusDelivered)
+	assertError(t, err, errorhandling.ErrInvalidTransition)
+	// The status the caller saw must still be current
+	_, err = transition(constants.OrderStatusProcessing, constants.OrderStatusShipped)
+	assertError(t, err, errorhandling.ErrInvalidTransition)
+
+	for _, status := range []string{constants.OrderStatusProcessing, constants.OrderStatusShipped, constants.OrderStatusDelivered} {
+		updated, err := transition(order.Status, status)
+		if err != nil {
+			t.Fatalf("moving to %s: %v", status, err)
+		}
+		order = updated
+	}
+	_, err = transition(constants.OrderStatusDelivered, constants.OrderStatusCancelled)
+	assertError(t, err, errorhandling.ErrInvalidTransition)
+
+	stored, err := fixture.orders.GetOrder(ctx, order.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != constants.OrderStatusDelivered || len(stored.History) != 4 {
+		t.Fatalf("got status %s with %d changes, want delivered with 4", stored.Status, len(stored.History))
+	}
+	last := stored.History[3]
+	if last.FromStatus != constants.OrderStatusShipped || last.ToStatus != constants.OrderStatusDelivered || last.ChangedBy != 1 {
+		t.Errorf("got last change %+v", last)
+	}
+	// Delivered orders keep their stock
+	fixture.assertStock(t, fixture.novel.ID, 3)
+}
+
+func TestCancellingOrderReturnsStock(t *testing.T) {
+	fixture := newOrderFixture(t)
+	ctx := context.Background()
+	order := fixture.place(t, item(fixture.novel.ID, 2), item(fixture.essay.ID, 2))
+	fixture.assertStock(t, fixture.novel.ID, 3, fixture.essay.ID, 0)
+
+	cancelled, err := fixture.orders.TransitionOrder(ctx, order.ID, models.OrderStatusChange{
+		FromStatus: constants.OrderStatusPending, ToStatus: constants.OrderStatusCancelled,
+	})
+	if err != nil {
+		t.Fatalf("TransitionOrder: %v", err)
+	}
+	if cancelled.Status != constants.OrderStatusCancelled {
+		t.Errorf("got status %s, want %s", cancelled.Status, constants.OrderStatusCancelled)
+	}
+	fixture.assertStock(t, fixture.novel.ID, 5, fixture.essay.ID, 2)
+
+	// Deleting a cancelled order does not return its stock twice
+	if err := fixture.orders.DeleteOrder(ctx, order.ID); err != nil {
+		t.Fatalf("DeleteOrder: %v", err)
+	}
+	fixture.assertStock(t, fixture.novel.ID, 5, fixture.essay.ID, 2)
+}
+
+func TestDeleteOrderReturnsStock(t *testing.T) {
+	fixture := newOrderFixture(t)
+	ctx := context.Background()
+	order := fixture.place(t, item(fixture.novel.ID, 4))
+
+	if err := fixture.orders.DeleteOrder(ctx, order.ID); err != nil {
+		t.Fatalf("DeleteOrder: %v", err)
+	}
+	fixture.assertStock(t, fixture.novel.ID, 5)
+	if _, err := fixture.orders.GetOrder(ctx, order.ID); !errorhandling.IsNotFoundError(err) {
+		t.Errorf("deleted order: got %v, want a not found error", err)
+	}
+	if err := fixture.orders.DeleteOrder(ctx, order.ID); !errorhandling.IsNotFoundError(err) {
+		t.Errorf("deleting again: got %v, want a not found error", err)
+	}
+}
+
+func TestDeleteOrderKeepsShippedOrders(t *testing.T) {
+	fixture := newOrderFixture(t)
+	ctx := context.Background()
+	order := fixture.place(t, item(fixture.novel.ID, 2))
+	for _, status := range []string{constants.OrderStatusProcessing, constants.OrderStatusShipped} {
+		if _, err := fixture.orders.TransitionOrder(ctx, order.ID, models.OrderStatusChange{FromStatus: order.Status, ToStatus: status}); err != nil {
+			t.Fatal(err)
+		}
+		order.Status = status
+	}
+
+	assertError(t, fixture.orders.DeleteOrder(ctx, order.ID), errorhandling.ErrOrderNotDeletable)
+	fixture.assertStock(t, fixture.novel.ID, 3)
+	if _, err := fixture.orders.GetOrder(ctx, order.ID); err != nil {
+		t.Errorf("shipped order: got %v, want it kept", err)
+	}
+}
+
+func TestConcurrentUpdatesOfOneOrder(t *testing.T) {
+	fixture := newOrderFixtureIn(t, newFileTestDB(t))
+	ctx := context.Background()
+	order := fixture.place(t, item(fixture.novel.ID, 1))
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 4; i++ {
+		wg.Add(1)
+		go func(quantity int) {
+			defer wg.Done()
+			_, err := fixture.orders.UpdateOrder(ctx, order.ID, models.Order{
+				CustomerID: fixture.customer.ID,
+				Items:      []models.OrderItem{item(fixture.novel.ID, quantity)},
+			})
+			var response errorhandling.ErrorResponse
+			if err != nil && !(errors.As(err, &response) && response.Message == errorhandling.ErrOrderNotEditable.Message) {
+				t.Errorf("UpdateOrder: %v", err)
+			}
+		}(i)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if _, err := fixture.orders.TransitionOrder(ctx, order.ID, models.OrderStatusChange{
+			FromStatus: constants.OrderStatusPending, ToStatus: constants.OrderStatusCancelled,
+		}); err != nil {
+			t.Errorf("TransitionOrder: %v", err)
+		}
+	}()
+	wg.Wait()
+
+	// Whatever the interleaving, the stock matches the final order and the cancel holds
+	stored, err := fixture.orders.GetOrder(ctx, order.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != constants.OrderStatusCancelled {
+		t.Errorf("got status %s, want %s", stored.Status, constants.OrderStatusCancelled)
+	}
+	fixture.assertStock(t, fixture.novel.ID, 5)
+}
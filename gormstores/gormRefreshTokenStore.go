@@ -0,0 +1,77 @@
+package gormstores
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/models"
+)
+
+type GormRefreshTokenStore struct {
+	db *gorm.DB
+}
+
+func NewGormRefreshTokenStore(db *gorm.DB) *GormRefreshTokenStore {
+	return &GormRefreshTokenStore{db: db}
+}
+
+func (store *GormRefreshTokenStore) CreateRefreshToken(ctx context.Context, token models.RefreshToken) (models.RefreshToken, error) {
+	token.ID = 0
+	if err := store.db.WithContext(ctx).Create(&token).Error; err != nil {
+		return models.RefreshToken{}, translateError(err, errorhandling.ErrRefreshTokenNotFound)
+	}
+	return token, nil
+}
+
+func (store *GormRefreshTokenStore) GetRefreshTokenByHash(ctx context.Context, tokenHash string) (models.RefreshToken, error) {
+	var token models.RefreshToken
+	if err := store.db.WithContext(ctx).Where("token_hash = ?", tokenHash).First(&token).Error; err != nil {
+		return models.RefreshToken{}, translateError(err, errorhandling.ErrRefreshTokenNotFound)
+	}
+	return token, nil
+}
+
+// RotateRefreshToken revokes a token and creates its successor. The revocation is conditional
+// on the token not being revoked yet, so two concurrent rotations of one token cannot both
+// succeed: the loser gets ErrRefreshTokenReused.
+func (store *GormRefreshTokenStore) RotateRefreshToken(ctx context.Context, id int, next models.RefreshToken) (models.RefreshToken, error) {
+	next.ID = 0
+	err := store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.RefreshToken{}).
+			Where("id = ? AND revoked_at IS NULL", id).
+			Update("revoked_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			var count int64
+			if err := tx.Model(&models.RefreshToken{}).Where("id = ?", id).Count(&count).Error; err != nil {
+				return err
+			}
+			if count == 0 {
+				return gorm.ErrRecordNotFound
+			}
+			return errorhandling.ErrRefreshTokenReused
+		}
+
+		return tx.Create(&next).Error
+	})
+	if err != nil {
+		return models.RefreshToken{}, translateError(err, errorhandling.ErrRefreshTokenNotFound)
+	}
+	return next, nil
+}
+
+func (store *GormRefreshTokenStore) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	return store.db.WithContext(ctx).Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (store *GormRefreshTokenStore) RevokeUserRefreshTokens(ctx context.Context, userID int, createdBefore time.Time) error {
+	return store.db.WithContext(ctx).Model(&models.RefreshToken{}).
+		Where("user_id = ? AND created_at < ? AND revoked_at IS NULL", userID, createdBefore).
+		Update("revoked_at", time.Now()).Error
+}
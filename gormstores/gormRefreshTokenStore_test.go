@@ -0,0 +1,108 @@
+package gormstores
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"um6p.ma/finalproject/constants"
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/models"
+)
+
+func newRefreshTokenStore(t *testing.T) (*GormRefreshTokenStore, models.User) {
+	t.Helper()
+	db := newTestDB(t)
+	user, err := NewGormUserStore(db).CreateUser(context.Background(), models.User{
+		Name: "Alice Doe", Email: "alice@mybiblio.com", Password: "hash", Role: constants.RoleUser,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewGormRefreshTokenStore(db), user
+}
+
+func refreshToken(user models.User, hash, family string) models.RefreshToken {
+	return models.RefreshToken{
+		UserID:    user.ID,
+		TokenHash: hash,
+		FamilyID:  family,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+}
+
+func TestRotateRefreshTokenDetectsReuse(t *testing.T) {
+	store, user := newRefreshTokenStore(t)
+	ctx := context.Background()
+
+	first, err := store.CreateRefreshToken(ctx, refreshToken(user, "first", "family"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := store.RotateRefreshToken(ctx, first.ID, refreshToken(user, "second", "family"))
+	if err != nil {
+		t.Fatalf("rotating: %v", err)
+	}
+	if rotated, _ := store.GetRefreshTokenByHash(ctx, "first"); rotated.RevokedAt == nil {
+		t.Error("the rotated token was not revoked")
+	}
+
+	// Presenting the rotated token again is a reuse, and its successor is not created
+	_, err = store.RotateRefreshToken(ctx, first.ID, refreshToken(user, "stolen", "family"))
+	if !errors.Is(err, errorhandling.ErrRefreshTokenReused) {
+		t.Fatalf("rotating again: got %v, want ErrRefreshTokenReused", err)
+	}
+	if _, err := store.GetRefreshTokenByHash(ctx, "stolen"); err == nil {
+		t.Error("the successor of a reused token was created")
+	}
+
+	if err := store.RevokeRefreshTokenFamily(ctx, "family"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.RotateRefreshToken(ctx, second.ID, refreshToken(user, "third", "family")); !errors.Is(err, errorhandling.ErrRefreshTokenReused) {
+		t.Errorf("rotating a revoked family: got %v, want ErrRefreshTokenReused", err)
+	}
+
+	if _, err := store.RotateRefreshToken(ctx, 999, refreshToken(user, "unknown", "other")); !errorhandling.IsNotFoundError(err) {
+		t.Errorf("unknown token: got %v, want a not found error", err)
+	}
+}
+
+func TestConcurrentRotationsOfOneToken(t *testing.T) {
+	store, user := newRefreshTokenStore(t)
+	ctx := context.Background()
+	token, err := store.CreateRefreshToken(ctx, refreshToken(user, "token", "family"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	rotated, reused := 0, 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := store.RotateRefreshToken(ctx, token.ID, refreshToken(user, fmt.Sprintf("next-%d", i), "family"))
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				rotated++
+			case errors.Is(err, errorhandling.ErrRefreshTokenReused):
+				reused++
+			default:
+				t.Errorf("RotateRefreshToken: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if rotated != 1 || reused != 9 {
+		t.Errorf("got %d rotations and %d reuses, want 1 and 9", rotated, reused)
+	}
+}
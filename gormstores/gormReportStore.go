@@ -0,0 +1,48 @@
+package gormstores
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"um6p.ma/finalproject/models"
+)
+
+type GormReportStore struct {
+	db *gorm.DB
+}
+
+func NewGormReportStore(db *gorm.DB) *GormReportStore {
+	return &GormReportStore{db: db}
+}
+
+func (store *GormReportStore) CreateReport(ctx context.Context, report models.SalesReport) (models.SalesReport, error) {
+	report.ID = 0
+	err := store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).Create(&report).Error; err != nil {
+			return err
+		}
+		for i := range report.TopSellingBooks {
+			report.TopSellingBooks[i].ID = 0
+			report.TopSellingBooks[i].ReportID = report.ID
+		}
+		if len(report.TopSellingBooks) == 0 {
+			return nil
+		}
+		return tx.Omit(clause.Associations).Create(&report.TopSellingBooks).Error
+	})
+	if err != nil {
+		return models.SalesReport{}, err
+	}
+	return report, nil
+}
+
+func (store *GormReportStore) GetLatestReports(ctx context.Context, limit int) ([]models.SalesReport, error) {
+	var reports []models.SalesReport
+	if err := store.db.WithContext(ctx).Preload("TopSellingBooks.Book").
+		Order("timestamp DESC").Limit(limit).
+		Find(&reports).Error; err != nil {
+		return nil, err
+	}
+	return reports, nil
+}
@@ -0,0 +1,128 @@
+package gormstores
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/models"
+)
+
+type GormRoleStore struct {
+	db *gorm.DB
+}
+
+func NewGormRoleStore(db *gorm.DB) *GormRoleStore {
+	return &GormRoleStore{db: db}
+}
+
+func (store *GormRoleStore) ListRoles(ctx context.Context) ([]models.Role, error) {
+	var roles []models.Role
+	if err := store.withPermissions(ctx).Order("id").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (store *GormRoleStore) GetRole(ctx context.Context, name string) (models.Role, error) {
+	var role models.Role
+	if err := store.withPermissions(ctx).Where("name = ?", name).First(&role).Error; err != nil {
+		return models.Role{}, translateError(err, errorhandling.ErrRoleNotFound)
+	}
+	return role, nil
+}
+
+func (store *GormRoleStore) CreateRole(ctx context.Context, role models.Role) (models.Role, error) {
+	role.ID = 0
+	for i := range role.Permissions {
+		role.Permissions[i].ID = 0
+	}
+	if err := store.db.WithContext(ctx).Create(&role).Error; err != nil {
+		return models.Role{}, translateError(err, errorhandling.ErrRoleNotFound)
+	}
+	return role, nil
+}
+
+// UpdateRole replaces the description, permissions and two-factor requirement of a role. Its
+// name cannot change.
+func (store *GormRoleStore) UpdateRole(ctx context.Context, name string, role models.Role) (models.Role, error) {
+	var existing models.Role
+	err := store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("name = ?", name).First(&existing).Error; err != nil {
+			return err
+		}
+		existing.Description = role.Description
+		existing.RequireTwoFactor = role.RequireTwoFactor
+		if err := tx.Model(&existing).Select("description", "require_two_factor").Updates(&existing).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("role_id = ?", existing.ID).Delete(&models.RolePermission{}).Error; err != nil {
+			return err
+		}
+
+		existing.Permissions = make([]models.RolePermission, 0, len(role.Permissions))
+		for _, permission := range role.Permissions {
+			existing.Permissions = append(existing.Permissions, models.RolePermission{
+				RoleID:     existing.ID,
+				Permission: permission.Permission,
+			})
+		}
+		if len(existing.Permissions) == 0 {
+			return nil
+		}
+		return tx.Create(&existing.Permissions).Error
+	})
+	if err != nil {
+		return models.Role{}, translateError(err, errorhandling.ErrRoleNotFound)
+	}
+	return existing, nil
+}
+
+// DeleteRole deletes a role along with its permissions
+func (store *GormRoleStore) DeleteRole(ctx context.Context, name string) error {
+	err := store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var role models.Role
+		if err := tx.Where("name = ?", name).First(&role).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("role_id = ?", role.ID).Delete(&models.RolePermission{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&role).Error
+	})
+	return translateError(err, errorhandling.ErrRoleNotFound)
+}
+
+func (store *GormRoleStore) ListPermissionImplications(ctx context.Context) ([]models.PermissionImplication, error) {
+	var implications []models.PermissionImplication
+	if err := store.db.WithContext(ctx).Order("id").Find(&implications).Error; err != nil {
+		return nil, err
+	}
+	return implications, nil
+}
+
+func (store *GormRoleStore) CreatePermissionImplication(ctx context.Context, implication models.PermissionImplication) (models.PermissionImplication, error) {
+	implication.ID = 0
+	if err := store.db.WithContext(ctx).Create(&implication).Error; err != nil {
+		return models.PermissionImplication{}, translateError(err, errorhandling.ErrImplicationNotFound)
+	}
+	return implication, nil
+}
+
+func (store *GormRoleStore) DeletePermissionImplication(ctx context.Context, id int) error {
+	result := store.db.WithContext(ctx).Delete(&models.PermissionImplication{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errorhandling.ErrImplicationNotFound
+	}
+	return nil
+}
+
+// withPermissions returns a query loading roles with their permissions
+func (store *GormRoleStore) withPermissions(ctx context.Context) *gorm.DB {
+	return store.db.WithContext(ctx).Preload("Permissions", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	})
+}
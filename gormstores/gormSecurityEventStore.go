@@ -0,0 +1,44 @@
+package gormstores
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+	"um6p.ma/finalproject/models"
+)
+
+type GormSecurityEventStore struct {
+	db *gorm.DB
+}
+
+func NewGormSecurityEventStore(db *gorm.DB) *GormSecurityEventStore {
+	return &GormSecurityEventStore{db: db}
+}
+
+func (store *GormSecurityEventStore) RecordSecurityEvent(ctx context.Context, event models.SecurityEvent) (models.SecurityEvent, error) {
+	event.ID = 0
+	if err := store.db.WithContext(ctx).Create(&event).Error; err != nil {
+		return models.SecurityEvent{}, err
+	}
+	return event, nil
+}
+
+// ListSecurityEvents returns the events matching a filter, most recent first
+func (store *GormSecurityEventStore) ListSecurityEvents(ctx context.Context, filter models.SecurityEventFilter) ([]models.SecurityEvent, error) {
+	query := store.db.WithContext(ctx).Order("id DESC")
+	if filter.Type != "" {
+		query = query.Where("type = ?", filter.Type)
+	}
+	if filter.UserID != 0 {
+		query = query.Where("user_id = ?", filter.UserID)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var events []models.SecurityEvent
+	if err := query.Find(&events).Error; err != nil {
+		return nil, err
+	}
+	return events, nil
+}
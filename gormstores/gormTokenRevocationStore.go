@@ -0,0 +1,48 @@
+package gormstores
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"um6p.ma/finalproject/models"
+)
+
+type GormTokenRevocationStore struct {
+	db *gorm.DB
+}
+
+func NewGormTokenRevocationStore(db *gorm.DB) *GormTokenRevocationStore {
+	return &GormTokenRevocationStore{db: db}
+}
+
+// RevokeToken records a revocation, and drops the revocations whose tokens have expired
+func (store *GormTokenRevocationStore) RevokeToken(ctx context.Context, revocation models.TokenRevocation) error {
+	revocation.ID = 0
+	revocation.ExpiresAt = revocation.ExpiresAt.UTC()
+	if revocation.IssuedBefore != nil {
+		issuedBefore := revocation.IssuedBefore.UTC()
+		revocation.IssuedBefore = &issuedBefore
+	}
+
+	return store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("expires_at <= ?", time.Now().UTC()).Delete(&models.TokenRevocation{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&revocation).Error
+	})
+}
+
+func (store *GormTokenRevocationStore) IsTokenRevoked(ctx context.Context, jti string, userID int, issuedAt time.Time) (bool, error) {
+	var count int64
+	err := store.db.WithContext(ctx).Model(&models.TokenRevocation{}).
+		Where("expires_at > ?", time.Now().UTC()).
+		Where(store.db.
+			Where("jti = ? AND jti <> ''", jti).
+			Or("user_id = ? AND issued_before > ?", userID, issuedAt.UTC())).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,76 @@
+package gormstores
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/models"
+)
+
+type GormTwoFactorStore struct {
+	db *gorm.DB
+}
+
+func NewGormTwoFactorStore(db *gorm.DB) *GormTwoFactorStore {
+	return &GormTwoFactorStore{db: db}
+}
+
+// AcceptTOTPStep records the time step of a code used by a user. The update is conditional on
+// the step being later than the last one accepted, so that each code can only be used once,
+// even by concurrent requests.
+func (store *GormTwoFactorStore) AcceptTOTPStep(ctx context.Context, userID int, step int64) error {
+	result := store.db.WithContext(ctx).Model(&models.User{}).
+		Where("id = ? AND totp_last_step < ?", userID, step).
+		Update("totp_last_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errorhandling.ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// ReplaceRecoveryCodes replaces the recovery codes of a user, given by their hashes
+func (store *GormTwoFactorStore) ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string) error {
+	return store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		if len(codeHashes) == 0 {
+			return nil
+		}
+
+		codes := make([]models.RecoveryCode, 0, len(codeHashes))
+		for _, hash := range codeHashes {
+			codes = append(codes, models.RecoveryCode{UserID: userID, CodeHash: hash})
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+// UseRecoveryCode marks a recovery code of a user as used. It fails with
+// ErrInvalidTwoFactorCode if the user has no such unused code.
+func (store *GormTwoFactorStore) UseRecoveryCode(ctx context.Context, userID int, codeHash string) error {
+	result := store.db.WithContext(ctx).Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", time.Now().UTC())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errorhandling.ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// CountRecoveryCodes returns the number of unused recovery codes of a user
+func (store *GormTwoFactorStore) CountRecoveryCodes(ctx context.Context, userID int) (int, error) {
+	var count int64
+	err := store.db.WithContext(ctx).Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Count(&count).Error
+	return int(count), err
+}
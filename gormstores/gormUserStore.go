@@ -0,0 +1,99 @@
+package gormstores
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/models"
+)
+
+type GormUserStore struct {
+	db *gorm.DB
+}
+
+func NewGormUserStore(db *gorm.DB) *GormUserStore {
+	return &GormUserStore{db: db}
+}
+
+func (store *GormUserStore) CreateUser(ctx context.Context, user models.User) (models.User, error) {
+	user.ID = 0
+	if err := store.db.WithContext(ctx).Create(&user).Error; err != nil {
+		return models.User{}, translateError(err, errorhandling.ErrUserNotFound)
+	}
+	return user, nil
+}
+
+func (store *GormUserStore) GetUser(ctx context.Context, id int) (models.User, error) {
+	var user models.User
+	if err := store.db.WithContext(ctx).First(&user, id).Error; err != nil {
+		return models.User{}, translateError(err, errorhandling.ErrUserNotFound)
+	}
+	return user, nil
+}
+
+func (store *GormUserStore) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	var user models.User
+	if err := store.db.WithContext(ctx).Where("email = ?", email).First(&user).Error; err != nil {
+		return models.User{}, translateError(err, errorhandling.ErrUserNotFound)
+	}
+	return user, nil
+}
+
+func (store *GormUserStore) ListUsers(ctx context.Context) ([]models.User, error) {
+	var users []models.User
+	if err := store.db.WithContext(ctx).Order("id").Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (store *GormUserStore) UpdateUser(ctx context.Context, id int, user models.User) (models.User, error) {
+	err := store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var existing models.User
+		if err := tx.First(&existing, id).Error; err != nil {
+			return err
+		}
+		user.ID = id
+		user.CreatedAt = existing.CreatedAt
+		// Accepted TOTP steps only move forward, so that a stale copy of the user cannot let a code be replayed
+		user.TOTPLastStep = max(user.TOTPLastStep, existing.TOTPLastStep)
+		return tx.Save(&user).Error
+	})
+	if err != nil {
+		return models.User{}, translateError(err, errorhandling.ErrUserNotFound)
+	}
+	return user, nil
+}
+
+// DeleteUser deletes a user along with their refresh and account tokens and recovery codes,
+// and detaches the invitations they accepted
+func (store *GormUserStore) DeleteUser(ctx context.Context, id int) error {
+	err := store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", id).Delete(&models.RefreshToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", id).Delete(&models.AccountToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", id).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Invitation{}).Where("user_id = ?", id).Update("user_id", nil).Error; err != nil {
+			return err
+		}
+		// The customer record and its orders outlive the account
+		if err := tx.Model(&models.Customer{}).Where("user_id = ?", id).Update("user_id", nil).Error; err != nil {
+			return err
+		}
+		result := tx.Delete(&models.User{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+	return translateError(err, errorhandling.ErrUserNotFound)
+}
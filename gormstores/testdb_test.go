@@ -0,0 +1,57 @@
+package gormstores
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"gorm.io/gorm"
+	"um6p.ma/finalproject/database"
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/migrations"
+)
+
+// newTestDB opens a private in-memory SQLite database holding the migrated schema
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	return openTestDB(t, database.SQLiteInMemory)
+}
+
+// newFileTestDB opens a migrated SQLite database in a temporary file. Unlike the in-memory
+// database, it serves several connections at once, so transactions really run concurrently.
+func newFileTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	return openTestDB(t, filepath.Join(t.TempDir(), "test.db"))
+}
+
+func openTestDB(t *testing.T, path string) *gorm.DB {
+	t.Helper()
+	db, err := database.ConnectSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrator.Up(context.Background()); err != nil {
+		t.Fatalf("migrating the test database: %v", err)
+	}
+	return db
+}
+
+// assertError fails the test unless err is the application error want, whatever its details
+func assertError(t *testing.T, err error, want errorhandling.ErrorResponse) {
+	t.Helper()
+	var got errorhandling.ErrorResponse
+	if !errors.As(err, &got) || got.Code != want.Code || got.Message != want.Message {
+		t.Fatalf("got error %v, want %q", err, want.Message)
+	}
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/interfaces"
+	internalhttp "um6p.ma/finalproject/internal/http"
+	"um6p.ma/finalproject/models"
+	"um6p.ma/finalproject/validation"
+)
+
+// apiKeyPrefix starts every API key, so that leaked keys are easy to recognize
+const apiKeyPrefix = "mbk_"
+
+// APIKeyHandler serves the admin API managing the API keys of integrations
+type APIKeyHandler struct {
+	Store interfaces.APIKeyStore
+}
+
+type CreateAPIKeyInput struct {
+	Name        string     `json:"name" validate:"required,min=2,max=100"`
+	Permissions []string   `json:"permissions" validate:"required,min=1,dive,permission"`
+	ExpiresAt   *time.Time `json:"expires_at" validate:"omitempty,future_date"`
+}
+
+// ListAPIKeysHandler retrieves all API keys, without the keys themselves
+func (h *APIKeyHandler) ListAPIKeysHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	keys, err := h.Store.ListAPIKeys(r.Context())
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+
+	response := make([]map[string]interface{}, 0, len(keys))
+	for _, key := range keys {
+		response = append(response, apiKeyResponse(key))
+	}
+
+	internalhttp.WriteJSON(w, r, http.StatusOK, response)
+}
+
+// GetAPIKeyHandler retrieves an API key by ID, without the key itself
+func (h *APIKeyHandler) GetAPIKeyHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid ID format",
+		))
+		return
+	}
+
+	key, err := h.Store.GetAPIKey(r.Context(), id)
+	if err != nil {
+		handleAPIKeyStoreError(w, err, id)
+		return
+	}
+
+	internalhttp.WriteJSON(w, r, http.StatusOK, apiKeyResponse(key))
+}
+
+// CreateAPIKeyHandler creates an API key with a subset of the permissions of its creator. The
+// key is only returned in this response.
+func (h *APIKeyHandler) CreateAPIKeyHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := r.Context()
+	var input CreateAPIKeyInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid request body",
+		).WithDebug(err.Error()))
+		return
+	}
+	if errs := validation.Validate(input); len(errs) > 0 {
+		errorhandling.HandleError(w, errorhandling.NewValidationError(errs))
+		return
+	}
+
+	claims, ok := internalhttp.GetClaimsFromContext(ctx)
+	if !ok {
+		errorhandling.HandleError(w, errorhandling.ErrMissingToken)
+		return
+	}
+	granted, err := internalhttp.ResolvePermissions(ctx, claims.Role)
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+	for _, permission := range input.Permissions {
+		// Integrations do not administer the API, so keys cannot manage users, roles or keys
+		if permission == "*" || strings.HasPrefix(permission, "manage:") || strings.HasPrefix(permission, "*:") {
+			errorhandling.HandleError(w, errorhandling.ErrInvalidInput.
+				WithDetails("API keys cannot be granted "+permission))
+			return
+		}
+		if !internalhttp.HasPermission(granted, permission) {
+			errorhandling.HandleError(w, errorhandling.NewError(
+				http.StatusForbidden,
+				errorhandling.ErrCodeForbidden,
+				"You cannot grant a permission you do not hold: "+permission,
+			))
+			return
+		}
+	}
+
+	key, prefix, err := generateAPIKey()
+	if err != nil {
+		handleTokenError(w, err)
+		return
+	}
+	permissions := make([]models.APIKeyPermission, 0, len(input.Permissions))
+	for _, permission := range input.Permissions {
+		permissions = append(permissions, models.APIKeyPermission{Permission: permission})
+	}
+
+	apiKey, err := h.Store.CreateAPIKey(ctx, models.APIKey{
+		Name:        input.Name,
+		Prefix:      prefix,
+		KeyHash:     internalhttp.HashAPIKey(key),
+		Permissions: permissions,
+		CreatedBy:   claims.UserID,
+		ExpiresAt:   input.ExpiresAt,
+	})
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+
+	log.Printf("🔑 User %d created API key %d (%s) with permissions %v", claims.UserID, apiKey.ID, apiKey.Name, apiKey.PermissionNames())
+	response := apiKeyResponse(apiKey)
+	response["key"] = key
+
+	w.Header().Set("Cache-Control", "no-store")
+	internalhttp.WriteJSON(w, r, http.StatusCreated, response)
+}
+
+// RevokeAPIKeyHandler revokes an API key. The key stops working at once, but stays listed.
+func (h *APIKeyHandler) RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctx := r.Context()
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid ID format",
+		))
+		return
+	}
+	claims, ok := internalhttp.GetClaimsFromContext(ctx)
+	if !ok {
+		errorhandling.HandleError(w, errorhandling.ErrMissingToken)
+		return
+	}
+
+	if _, err := h.Store.RevokeAPIKey(ctx, id); err != nil {
+		handleAPIKeyStoreError(w, err, id)
+		return
+	}
+
+	log.Printf("🔒 User %d revoked API key %d", claims.UserID, id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// generateAPIKey returns a new API key and the prefix identifying it
+func generateAPIKey() (string, string, error) {
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", err
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	prefix := apiKeyPrefix + hex.EncodeToString(id)
+	return prefix + "_" + secret, prefix, nil
+}
+
+// apiKeyResponse is the representation of an API key, without the key itself
+func apiKeyResponse(key models.APIKey) map[string]interface{} {
+	status := "active"
+	if key.RevokedAt != nil {
+		status = "revoked"
+	} else if key.ExpiresAt != nil && time.Now().After(*key.ExpiresAt) {
+		status = "expired"
+	}
+
+	return map[string]interface{}{
+		"id":           key.ID,
+		"name":         key.Name,
+		"prefix":       key.Prefix,
+		"permissions":  key.PermissionNames(),
+		"status":       status,
+		"created_by":   key.CreatedBy,
+		"created_at":   key.CreatedAt,
+		"expires_at":   key.ExpiresAt,
+		"last_used_at": key.LastUsedAt,
+		"revoked_at":   key.RevokedAt,
+	}
+}
+
+// handleAPIKeyStoreError writes the response for an error returned by the API key store
+func handleAPIKeyStoreError(w http.ResponseWriter, err error, id int) {
+	switch {
+	case errorhandling.IsNotFoundError(err):
+		errorhandling.HandleError(w, errorhandling.NewNotFoundError("API key", id))
+	case errors.Is(err, errorhandling.ErrAPIKeyAlreadyRevoked):
+		errorhandling.HandleError(w, errorhandling.ErrAPIKeyAlreadyRevoked)
+	default:
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+	}
+}
@@ -0,0 +1,373 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/julienschmidt/httprouter"
+	"um6p.ma/finalproject/constants"
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/interfaces"
+	internalhttp "um6p.ma/finalproject/internal/http"
+	"um6p.ma/finalproject/mailer"
+	"um6p.ma/finalproject/models"
+	"um6p.ma/finalproject/validation"
+)
+
+// AccountHandler serves the password reset and email verification flows. The links mailed to
+// users carry tokens signed by the keyring, whose JTI is recorded so that each link works once.
+type AccountHandler struct {
+	Users                interfaces.UserStore
+	Tokens               interfaces.AccountTokenStore
+	Mailer               mailer.Mailer
+	Auth                 *AuthHandler // Signs the tokens and revokes the sessions of users resetting their password
+	PublicURL            string
+	PasswordResetTTL     time.Duration
+	EmailVerificationTTL time.Duration
+}
+
+type ForgotPasswordInput struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetPasswordInput struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,min=8,max=100,passwd"`
+}
+
+type VerifyEmailInput struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// accountClaims are the claims of the tokens mailed to users. Their audience is the purpose
+// of the token, which keeps them from being accepted as access tokens.
+type accountClaims struct {
+	Email string `json:"email"`
+	jwt.StandardClaims
+}
+
+// ForgotPassword mails a password reset link to the given address. The response is the same
+// whether or not an account exists for it, so that it cannot be used to discover accounts.
+func (h *AccountHandler) ForgotPassword(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := r.Context()
+	var input ForgotPasswordInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid request body",
+		).WithDebug(err.Error()))
+		return
+	}
+	if errs := validation.Validate(input); len(errs) > 0 {
+		errorhandling.HandleError(w, errorhandling.NewValidationError(errs))
+		return
+	}
+
+	user, err := h.Users.GetUserByEmail(ctx, input.Email)
+	if err != nil && !errorhandling.IsNotFoundError(err) {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+	if err == nil && !user.Disabled {
+		// Sent in the background, so that the response time does not reveal the account either
+		go func() {
+			if err := h.sendAccountEmail(context.WithoutCancel(ctx), user, constants.TokenPurposePasswordReset); err != nil {
+				log.Printf("❌ Failed to send the password reset email to %s: %v", user.Email, err)
+			}
+		}()
+	}
+
+	internalhttp.WriteJSON(w, r, http.StatusAccepted, map[string]string{
+		"message": "If an account exists for this email, a password reset link has been sent to it",
+	})
+}
+
+// ResetPassword sets a new password with the token of a password reset link. Every session of
+// the user is revoked, and their email counts as verified since they received the link.
+func (h *AccountHandler) ResetPassword(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := r.Context()
+	var input ResetPasswordInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid request body",
+		).WithDebug(err.Error()))
+		return
+	}
+	if errs := validation.Validate(input); len(errs) > 0 {
+		errorhandling.HandleError(w, errorhandling.NewValidationError(errs))
+		return
+	}
+
+	user, ok := h.redeemToken(w, r, input.Token, constants.TokenPurposePasswordReset, true)
+	if !ok {
+		return
+	}
+	if user.Disabled {
+		errorhandling.HandleError(w, errorhandling.ErrAccountDisabled)
+		return
+	}
+
+	hashedPassword, err := hashPassword(input.Password)
+	if err != nil {
+		handlePasswordError(w, err)
+		return
+	}
+	now := time.Now()
+	user.Password = hashedPassword
+	if user.EmailVerifiedAt == nil {
+		user.EmailVerifiedAt = &now
+	}
+	if _, err := h.Users.UpdateUser(ctx, user.ID, user); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+	if err := h.Auth.revokeUserTokens(ctx, user.ID, now, user.ID); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+
+	log.Printf("🔑 Password of user %d was reset", user.ID)
+	internalhttp.WriteJSON(w, r, http.StatusOK, map[string]string{
+		"message": "Password has been reset; please log in again",
+	})
+}
+
+// CheckPasswordResetLink serves the mailed password reset links. It tells whether the token
+// can still be used, without using it: the new password is posted to ResetPassword.
+func (h *AccountHandler) CheckPasswordResetLink(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if _, ok := h.redeemToken(w, r, r.URL.Query().Get("token"), constants.TokenPurposePasswordReset, false); !ok {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	internalhttp.WriteJSON(w, r, http.StatusOK, map[string]string{
+		"message": "This link is valid; post its token with a new password to /password/reset",
+	})
+}
+
+// VerifyEmailLink serves the mailed verification links, verifying the address of the user
+func (h *AccountHandler) VerifyEmailLink(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	h.verifyEmail(w, r, r.URL.Query().Get("token"))
+}
+
+// VerifyEmail marks the email address of a user as verified with the token of a verification link
+func (h *AccountHandler) VerifyEmail(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var input VerifyEmailInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid request body",
+		).WithDebug(err.Error()))
+		return
+	}
+	if errs := validation.Validate(input); len(errs) > 0 {
+		errorhandling.HandleError(w, errorhandling.NewValidationError(errs))
+		return
+	}
+
+	h.verifyEmail(w, r, input.Token)
+}
+
+// verifyEmail marks the email address of the user of a verification token as verified
+func (h *AccountHandler) verifyEmail(w http.ResponseWriter, r *http.Request, token string) {
+	user, ok := h.redeemToken(w, r, token, constants.TokenPurposeEmailVerification, true)
+	if !ok {
+		return
+	}
+	if user.EmailVerifiedAt == nil {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+		var err error
+		if user, err = h.Users.UpdateUser(r.Context(), user.ID, user); err != nil {
+			errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+			return
+		}
+	}
+	// The customer record holding the address can now be linked to the user
+	if _, err := customerOf(r.Context(), h.Auth.Customers, user); err != nil {
+		log.Printf("❌ Failed to link the customer record of %s: %v", user.Email, err)
+	}
+
+	internalhttp.WriteJSON(w, r, http.StatusOK, map[string]interface{}{
+		"message": "Email address verified",
+		"user":    userResponse(user),
+	})
+}
+
+// ResendVerificationEmail mails a new verification link to the authenticated user. Earlier
+// links stop working.
+func (h *AccountHandler) ResendVerificationEmail(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := r.Context()
+	claims, ok := internalhttp.GetClaimsFromContext(ctx)
+	if !ok {
+		errorhandling.HandleError(w, errorhandling.ErrMissingToken)
+		return
+	}
+
+	user, err := h.Users.GetUser(ctx, claims.UserID)
+	if err != nil {
+		handleUserStoreError(w, err, claims.UserID)
+		return
+	}
+	if user.EmailVerifiedAt != nil {
+		errorhandling.HandleError(w, errorhandling.ErrEmailVerified)
+		return
+	}
+
+	if err := h.sendAccountEmail(ctx, user, constants.TokenPurposeEmailVerification); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusInternalServerError,
+			errorhandling.ErrCodeInternalServer,
+			"Failed to send the verification email",
+		).WithDebug(err.Error()))
+		return
+	}
+
+	internalhttp.WriteJSON(w, r, http.StatusAccepted, map[string]string{
+		"message": "A verification link has been sent to " + user.Email,
+	})
+}
+
+// sendAccountEmail issues a token for the given purpose and mails its link to the user
+func (h *AccountHandler) sendAccountEmail(ctx context.Context, user models.User, purpose string) error {
+	var ttl time.Duration
+	var subject, path, body string
+	switch purpose {
+	case constants.TokenPurposePasswordReset:
+		ttl, subject, path = h.PasswordResetTTL, "Reset your MyBiblio password", "/password/reset"
+		body = "Hello %s,\n\n" +
+			"Someone asked to reset the password of your MyBiblio account. To choose a new\n" +
+			"password, open the link below within %s:\n\n%s\n\n" +
+			"If you did not ask for this, you can ignore this email and your password will not change.\n"
+	case constants.TokenPurposeEmailVerification:
+		ttl, subject, path = h.EmailVerificationTTL, "Verify your MyBiblio email address", "/email/verify"
+		body = "Hello %s,\n\n" +
+			"Please confirm that this is your email address by opening the link below within %s:\n\n%s\n\n" +
+			"If you did not create a MyBiblio account, you can ignore this email.\n"
+	default:
+		return fmt.Errorf("unknown account token purpose %q", purpose)
+	}
+
+	jti, err := randomToken(16)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+	token, err := h.Auth.Keys.Sign(jwt.MapClaims{
+		"jti":   jti,
+		"sub":   strconv.Itoa(user.ID),
+		"aud":   purpose,
+		"email": user.Email,
+		"iat":   now.Unix(),
+		"exp":   expiresAt.Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := h.Tokens.CreateAccountToken(ctx, models.AccountToken{
+		JTI:       jti,
+		UserID:    user.ID,
+		Purpose:   purpose,
+		ExpiresAt: expiresAt,
+	}); err != nil {
+		return err
+	}
+
+	link := h.PublicURL + path + "?token=" + url.QueryEscape(token)
+	return h.Mailer.Send(ctx, mailer.Message{
+		To:      (&mail.Address{Name: user.Name, Address: user.Email}).String(),
+		Subject: subject,
+		Body:    fmt.Sprintf(body, user.Name, formatTTL(ttl), link),
+	})
+}
+
+// redeemToken verifies a mailed token for the given purpose and marks it as used, unless
+// consume is false. It returns the user of the token, or writes an error response and
+// returns false.
+func (h *AccountHandler) redeemToken(w http.ResponseWriter, r *http.Request, token, purpose string, consume bool) (models.User, bool) {
+	ctx := r.Context()
+	if token == "" {
+		errorhandling.HandleError(w, errorhandling.ErrInvalidLink.WithDebug("No token"))
+		return models.User{}, false
+	}
+	claims := &accountClaims{}
+	if err := h.Auth.Keys.Verify(token, claims); err != nil {
+		errorhandling.HandleError(w, errorhandling.ErrInvalidLink.WithDebug(err.Error()))
+		return models.User{}, false
+	}
+	if !claims.VerifyAudience(purpose, true) {
+		errorhandling.HandleError(w, errorhandling.ErrInvalidLink.WithDebug("Token has another purpose"))
+		return models.User{}, false
+	}
+
+	var err error
+	if consume {
+		_, err = h.Tokens.ConsumeAccountToken(ctx, claims.Id)
+	} else if stored, getErr := h.Tokens.GetAccountToken(ctx, claims.Id); getErr != nil {
+		err = getErr
+	} else if stored.UsedAt != nil {
+		err = errorhandling.ErrLinkUsed
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, errorhandling.ErrLinkUsed):
+			errorhandling.HandleError(w, errorhandling.ErrLinkUsed)
+		case errorhandling.IsNotFoundError(err):
+			errorhandling.HandleError(w, errorhandling.ErrInvalidLink)
+		default:
+			errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		}
+		return models.User{}, false
+	}
+
+	userID, _ := strconv.Atoi(claims.Subject)
+	user, err := h.Users.GetUser(ctx, userID)
+	if err != nil {
+		if errorhandling.IsNotFoundError(err) {
+			errorhandling.HandleError(w, errorhandling.ErrInvalidLink)
+			return models.User{}, false
+		}
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return models.User{}, false
+	}
+	// Links are bound to the address they were sent to
+	if user.Email != claims.Email {
+		errorhandling.HandleError(w, errorhandling.ErrInvalidLink.WithDebug("Email address changed"))
+		return models.User{}, false
+	}
+
+	return user, true
+}
+
+// formatTTL describes the lifetime of a link in words
+func formatTTL(ttl time.Duration) string {
+	plural := func(n int64, unit string) string {
+		if n == 1 {
+			return "1 " + unit
+		}
+		return fmt.Sprintf("%d %ss", n, unit)
+	}
+
+	switch {
+	case ttl >= 24*time.Hour && ttl%(24*time.Hour) == 0:
+		return plural(int64(ttl/(24*time.Hour)), "day")
+	case ttl >= time.Hour && ttl%time.Hour == 0:
+		return plural(int64(ttl/time.Hour), "hour")
+	default:
+		return plural(int64(ttl.Round(time.Minute)/time.Minute), "minute")
+	}
+}
@@ -1,29 +1,53 @@
 package handlers
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/julienschmidt/httprouter"
 	"golang.org/x/crypto/bcrypt"
-	"gorm.io/gorm"
 	"um6p.ma/finalproject/constants"
-	"um6p.ma/finalproject/database"
 	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/interfaces"
 	internalhttp "um6p.ma/finalproject/internal/http"
+	"um6p.ma/finalproject/internal/loginguard"
 	"um6p.ma/finalproject/models"
 	"um6p.ma/finalproject/validation"
 )
 
+type AuthHandler struct {
+	Store            interfaces.UserStore
+	Customers        interfaces.CustomerStore // Holds the customer records linked to users
+	Keys             *internalhttp.Keyring
+	Tokens           interfaces.RefreshTokenStore
+	Invitations      interfaces.InvitationStore
+	Revocations      interfaces.TokenRevocationStore
+	Accounts         *AccountHandler   // Mails the verification link of registered users
+	TwoFactor        *TwoFactorHandler // Challenges the users with two-factor authentication for their code
+	Guard            *loginguard.Guard // Throttles failed logins
+	AccessTokenTTL   time.Duration
+	RefreshTokenTTL  time.Duration
+	ImpersonationTTL time.Duration // Impersonation tokens are access tokens too, and may outlive the others
+}
+
 type RegisterInput struct {
 	Name     string `json:"name" validate:"required,min=2,max=100"`
 	Email    string `json:"email" validate:"required,email,custom_email"`
 	Password string `json:"password" validate:"required,min=8,max=100,passwd"`
-	Role     string `json:"role" validate:"required,oneof=admin manager employee user"`
+	Role     string `json:"role" validate:"omitempty,oneof=user"` // Other roles are only granted by admins
 }
 
 type LoginInput struct {
@@ -31,8 +55,25 @@ type LoginInput struct {
 	Password string `json:"password" validate:"required"`
 }
 
+type AcceptInvitationInput struct {
+	Name     string `json:"name" validate:"required,min=2,max=100"`
+	Email    string `json:"email" validate:"required,email,custom_email"`
+	Password string `json:"password" validate:"required,min=8,max=100,passwd"`
+}
+
+type RefreshTokenInput struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+type RevokeTokensInput struct {
+	JTI          string     `json:"jti" validate:"required_without=UserID"`
+	UserID       int        `json:"user_id" validate:"required_without=JTI"`
+	IssuedBefore *time.Time `json:"issued_before"`
+}
+
 // RegisterUser handles user registration
-func RegisterUser(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+func (h *AuthHandler) RegisterUser(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := r.Context()
 	var input RegisterInput
 
 	// Decode JSON request body
@@ -52,17 +93,15 @@ func RegisterUser(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	}
 
 	// Check if user already exists
-	var existingUser models.User
-	result := database.DB.Where("email = ?", input.Email).First(&existingUser)
-	if result.Error == nil {
+	if _, err := h.Store.GetUserByEmail(ctx, input.Email); err == nil {
 		errorhandling.HandleError(w, errorhandling.NewError(
 			http.StatusConflict,
 			errorhandling.ErrCodeDuplicateEntry,
 			"Email already registered",
 		))
 		return
-	} else if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
-		errorhandling.HandleError(w, errorhandling.NewDatabaseError(result.Error))
+	} else if !errorhandling.IsNotFoundError(err) {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
 		return
 	}
 
@@ -82,12 +121,13 @@ func RegisterUser(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		Name:     input.Name,
 		Email:    input.Email,
 		Password: string(hashedPassword),
-		Role:     input.Role,
+		Role:     constants.RoleUser,
 	}
 
-	// Save user in the database
-	if err := database.DB.Create(&user).Error; err != nil {
-		if strings.Contains(err.Error(), "unique constraint") || strings.Contains(err.Error(), "Duplicate entry") {
+	// Save user in the store
+	user, err = h.Store.CreateUser(ctx, user)
+	if err != nil {
+		if errorhandling.IsDuplicateKeyError(err) {
 			errorhandling.HandleError(w, errorhandling.NewError(
 				http.StatusConflict,
 				errorhandling.ErrCodeDuplicateEntry,
@@ -99,24 +139,35 @@ func RegisterUser(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		return
 	}
 
+	// Shoppers get their customer record right away, unless one already holds their email
+	// address, which is only linked once they verify it
+	if _, err := customerOf(ctx, h.Customers, user); err != nil && !errors.Is(err, errorhandling.ErrEmailNotVerified) {
+		log.Printf("❌ Failed to create the customer record of %s: %v", user.Email, err)
+	}
+
+	// The account is usable right away; a failure to mail the link is only logged, since the
+	// user can ask for another one
+	if err := h.Accounts.sendAccountEmail(ctx, user, constants.TokenPurposeEmailVerification); err != nil {
+		log.Printf("❌ Failed to send the verification email to %s: %v", user.Email, err)
+	}
+
 	// Return success response
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"message": "User registered successfully",
+	internalhttp.WriteJSON(w, r, http.StatusCreated, map[string]interface{}{
+		"message": "User registered successfully; check your email to verify your address",
 		"user": map[string]interface{}{
-			"id":    user.ID,
-			"name":  user.Name,
-			"email": user.Email,
-			"role":  user.Role,
+			"id":             user.ID,
+			"name":           user.Name,
+			"email":          user.Email,
+			"role":           user.Role,
+			"email_verified": false,
 		},
 	})
 }
 
 // LoginUser handles user authentication and token generation
-func LoginUser(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+func (h *AuthHandler) LoginUser(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := r.Context()
 	var input LoginInput
-	var user models.User
 
 	// Decode and validate request body
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
@@ -134,9 +185,16 @@ func LoginUser(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		return
 	}
 
-	// Find user in database
-	if err := database.DB.Where("email = ?", input.Email).First(&user).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
+	// Refuse throttled attempts before spending a password check on them
+	if !h.checkLoginGuard(w, r, input.Email) {
+		return
+	}
+
+	// Find user in the store
+	user, err := h.Store.GetUserByEmail(ctx, input.Email)
+	if err != nil {
+		if errorhandling.IsNotFoundError(err) {
+			h.failLogin(r, input.Email, nil)
 			errorhandling.HandleError(w, errorhandling.ErrInvalidCredentials)
 			return
 		}
@@ -146,78 +204,549 @@ func LoginUser(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 
 	// Check password
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)); err != nil {
+		h.failLogin(r, input.Email, &user.ID)
 		errorhandling.HandleError(w, errorhandling.ErrInvalidCredentials)
 		return
 	}
+	if user.Disabled {
+		errorhandling.HandleError(w, errorhandling.ErrAccountDisabled)
+		return
+	}
 
-	// Create JWT Token with enhanced claims
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id":     user.ID,
-		"email":       user.Email,
-		"name":        user.Name,
-		"role":        user.Role,
-		"permissions": getPermissionsForRole(user.Role),
-		"iat":         time.Now().Unix(),
-		"exp":         time.Now().Add(time.Hour * 24).Unix(), // Expires in 24h
-	})
+	// Users with two-factor authentication get a challenge to complete with their code
+	challenge, enroll, err := h.TwoFactor.requiresChallenge(ctx, user)
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+	if challenge {
+		h.TwoFactor.writeChallenge(w, r, user, enroll)
+		return
+	}
+
+	refreshToken, ok := h.startSession(w, r, user)
+	if !ok {
+		return
+	}
+	h.succeedLogin(r, user.Email)
+	h.writeTokens(w, r, user, refreshToken, nil)
+}
 
-	tokenString, err := token.SignedString(internalhttp.GetJWTSecret())
+// startSession starts a new session of a user, with its own refresh token family. It returns
+// the refresh token, or writes an error response and returns false.
+func (h *AuthHandler) startSession(w http.ResponseWriter, r *http.Request, user models.User) (string, bool) {
+	familyID, err := randomToken(16)
+	if err != nil {
+		handleTokenError(w, err)
+		return "", false
+	}
+	refreshToken, stored, err := h.newRefreshToken(user.ID, familyID)
 	if err != nil {
+		handleTokenError(w, err)
+		return "", false
+	}
+	if _, err := h.Tokens.CreateRefreshToken(r.Context(), stored); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return "", false
+	}
+	return refreshToken, true
+}
+
+// RefreshToken exchanges a refresh token for a new access token and a new refresh token.
+// Presenting a refresh token that was already rotated revokes its whole session.
+func (h *AuthHandler) RefreshToken(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := r.Context()
+	var input RefreshTokenInput
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		errorhandling.HandleError(w, errorhandling.NewError(
-			http.StatusInternalServerError,
-			errorhandling.ErrCodeInternalServer,
-			"Failed to generate authentication token",
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid request format",
 		).WithDebug(err.Error()))
 		return
 	}
+	if errs := validation.Validate(input); len(errs) > 0 {
+		errorhandling.HandleError(w, errorhandling.NewValidationError(errs))
+		return
+	}
 
-	// Return JWT Token with user info
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"token": tokenString,
-		"user": map[string]interface{}{
-			"id":    user.ID,
-			"name":  user.Name,
-			"email": user.Email,
-			"role":  user.Role,
-		},
-		"permissions": getPermissionsForRole(user.Role),
+	current, err := h.Tokens.GetRefreshTokenByHash(ctx, hashRefreshToken(input.RefreshToken))
+	if err != nil {
+		if errorhandling.IsNotFoundError(err) {
+			errorhandling.HandleError(w, errorhandling.ErrInvalidToken)
+			return
+		}
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+	if current.RevokedAt != nil {
+		h.revokeReusedFamily(w, r, current)
+		return
+	}
+	if time.Now().After(current.ExpiresAt) {
+		errorhandling.HandleError(w, errorhandling.ErrExpiredToken)
+		return
+	}
+
+	user, err := h.Store.GetUser(ctx, current.UserID)
+	if err != nil {
+		if errorhandling.IsNotFoundError(err) {
+			errorhandling.HandleError(w, errorhandling.ErrInvalidToken)
+			return
+		}
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+	if user.Disabled {
+		errorhandling.HandleError(w, errorhandling.ErrAccountDisabled)
+		return
+	}
+
+	refreshToken, next, err := h.newRefreshToken(user.ID, current.FamilyID)
+	if err != nil {
+		handleTokenError(w, err)
+		return
+	}
+	if _, err := h.Tokens.RotateRefreshToken(ctx, current.ID, next); err != nil {
+		// Another request rotated the same token first
+		if errors.Is(err, errorhandling.ErrRefreshTokenReused) {
+			h.revokeReusedFamily(w, r, current)
+			return
+		}
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+
+	h.writeTokens(w, r, user, refreshToken, nil)
+}
+
+// Logout ends the session of the given refresh token
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := r.Context()
+	var input RefreshTokenInput
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid request format",
+		).WithDebug(err.Error()))
+		return
+	}
+	if errs := validation.Validate(input); len(errs) > 0 {
+		errorhandling.HandleError(w, errorhandling.NewValidationError(errs))
+		return
+	}
+
+	claims, ok := internalhttp.GetClaimsFromContext(ctx)
+	if !ok {
+		errorhandling.HandleError(w, errorhandling.ErrMissingToken)
+		return
+	}
+
+	token, err := h.Tokens.GetRefreshTokenByHash(ctx, hashRefreshToken(input.RefreshToken))
+	if err != nil {
+		if errorhandling.IsNotFoundError(err) {
+			errorhandling.HandleError(w, errorhandling.ErrInvalidToken)
+			return
+		}
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+	// Users can only end their own sessions
+	if token.UserID != claims.UserID {
+		errorhandling.HandleError(w, errorhandling.ErrInvalidToken)
+		return
+	}
+
+	if err := h.Tokens.RevokeRefreshTokenFamily(ctx, token.FamilyID); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+
+	// The access token used to log out is revoked as well
+	if err := h.Revocations.RevokeToken(ctx, models.TokenRevocation{
+		JTI:       claims.Id,
+		UserID:    claims.UserID,
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+		RevokedBy: claims.UserID,
+	}); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LogoutAll ends every session of the authenticated user
+func (h *AuthHandler) LogoutAll(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := r.Context()
+	claims, ok := internalhttp.GetClaimsFromContext(ctx)
+	if !ok {
+		errorhandling.HandleError(w, errorhandling.ErrMissingToken)
+		return
+	}
+
+	if err := h.revokeUserTokens(ctx, claims.UserID, time.Now(), claims.UserID); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetInvitation describes the invitation behind a signup link, so that the signup form can be prefilled
+func (h *AuthHandler) GetInvitation(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	invitation, ok := h.pendingInvitation(w, r, ps.ByName("token"))
+	if !ok {
+		return
+	}
+
+	internalhttp.WriteJSON(w, r, http.StatusOK, map[string]interface{}{
+		"email":      invitation.Email,
+		"role":       invitation.Role,
+		"expires_at": invitation.ExpiresAt,
 	})
 }
 
-// getPermissionsForRole returns a list of permissions based on the user's role
-func getPermissionsForRole(role string) []string {
-	switch role {
-	case constants.RoleAdmin:
-		return []string{
-			"read:all",
-			"write:all",
-			"delete:all",
-			"manage:users",
-			"manage:roles",
-			"generate:reports",
+// AcceptInvitation signs up through an invitation, with the role it was issued for
+func (h *AuthHandler) AcceptInvitation(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var input AcceptInvitationInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid request format",
+		).WithDebug(err.Error()))
+		return
+	}
+	if errs := validation.Validate(input); len(errs) > 0 {
+		errorhandling.HandleError(w, errorhandling.NewValidationError(errs))
+		return
+	}
+
+	invitation, ok := h.pendingInvitation(w, r, ps.ByName("token"))
+	if !ok {
+		return
+	}
+	if invitation.Email != "" && !strings.EqualFold(invitation.Email, input.Email) {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusForbidden,
+			errorhandling.ErrCodeForbidden,
+			"This invitation was issued for another email address",
+		))
+		return
+	}
+
+	hashedPassword, err := hashPassword(input.Password)
+	if err != nil {
+		handlePasswordError(w, err)
+		return
+	}
+
+	user, err := h.Invitations.AcceptInvitation(r.Context(), invitation.ID, models.User{
+		Name:     input.Name,
+		Email:    input.Email,
+		Password: hashedPassword,
+		Role:     invitation.Role,
+	})
+	if err != nil {
+		var errResp errorhandling.ErrorResponse
+		if errors.As(err, &errResp) {
+			errorhandling.HandleError(w, errResp)
+			return
+		}
+		handleUserStoreError(w, err, 0)
+		return
+	}
+
+	internalhttp.WriteJSON(w, r, http.StatusCreated, map[string]interface{}{
+		"message": "User registered successfully",
+		"user":    userResponse(user),
+	})
+}
+
+// pendingInvitation looks up the invitation of a signup token, writing an error response
+// unless it can still be accepted
+func (h *AuthHandler) pendingInvitation(w http.ResponseWriter, r *http.Request, token string) (models.Invitation, bool) {
+	invitation, err := h.Invitations.GetInvitationByHash(r.Context(), hashRefreshToken(token))
+	if err != nil {
+		if errorhandling.IsNotFoundError(err) {
+			errorhandling.HandleError(w, errorhandling.ErrInvitationNotFound)
+			return models.Invitation{}, false
+		}
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return models.Invitation{}, false
+	}
+	if invitation.AcceptedAt != nil {
+		errorhandling.HandleError(w, errorhandling.ErrInvitationUsed)
+		return models.Invitation{}, false
+	}
+	if time.Now().After(invitation.ExpiresAt) {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusGone,
+			errorhandling.ErrCodeExpiredToken,
+			"Invitation has expired",
+		))
+		return models.Invitation{}, false
+	}
+	return invitation, true
+}
+
+// RevokeTokens revokes a single access token by its JTI, or every token issued to a user
+// before a given time, which defaults to now
+func (h *AuthHandler) RevokeTokens(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := r.Context()
+	var input RevokeTokensInput
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid request format",
+		).WithDebug(err.Error()))
+		return
+	}
+	if errs := validation.Validate(input); len(errs) > 0 {
+		errorhandling.HandleError(w, errorhandling.NewValidationError(errs))
+		return
+	}
+
+	claims, ok := internalhttp.GetClaimsFromContext(ctx)
+	if !ok {
+		errorhandling.HandleError(w, errorhandling.ErrMissingToken)
+		return
+	}
+
+	now := time.Now()
+	if input.JTI != "" {
+		// Access tokens never outlive their TTL, so the revocation can be dropped after it
+		if err := h.Revocations.RevokeToken(ctx, models.TokenRevocation{
+			JTI:       input.JTI,
+			ExpiresAt: now.Add(h.accessTokenLifetime()),
+			RevokedBy: claims.UserID,
+		}); err != nil {
+			errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+			return
 		}
-	case constants.RoleManager:
-		return []string{
-			"read:all",
-			"write:books",
-			"write:authors",
-			"write:orders",
-			"generate:reports",
+	}
+
+	if input.UserID != 0 {
+		issuedBefore := now
+		if input.IssuedBefore != nil {
+			if input.IssuedBefore.After(now) {
+				errorhandling.HandleError(w, errorhandling.ErrInvalidInput.
+					WithDetails("issued_before cannot be in the future"))
+				return
+			}
+			issuedBefore = *input.IssuedBefore
 		}
-	case constants.RoleEmployee:
-		return []string{
-			"read:all",
-			"write:orders",
-			"write:customers",
+		if _, err := h.Store.GetUser(ctx, input.UserID); err != nil {
+			if errorhandling.IsNotFoundError(err) {
+				errorhandling.HandleError(w, errorhandling.NewNotFoundError("User", input.UserID))
+				return
+			}
+			errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+			return
 		}
-	case constants.RoleUser:
-		return []string{
-			"read:books",
-			"read:authors",
-			"write:orders",
+		if err := h.revokeUserTokens(ctx, input.UserID, issuedBefore, claims.UserID); err != nil {
+			errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+			return
 		}
-	default:
-		return []string{"read:books"}
 	}
+
+	log.Printf("🔒 User %d revoked tokens (jti: %q, user: %d)", claims.UserID, input.JTI, input.UserID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeUserTokens revokes the refresh tokens and the access tokens issued to a user before the given time
+func (h *AuthHandler) revokeUserTokens(ctx context.Context, userID int, issuedBefore time.Time, revokedBy int) error {
+	if err := h.Tokens.RevokeUserRefreshTokens(ctx, userID, issuedBefore); err != nil {
+		return err
+	}
+	return h.Revocations.RevokeToken(ctx, models.TokenRevocation{
+		UserID:       userID,
+		IssuedBefore: &issuedBefore,
+		ExpiresAt:    issuedBefore.Add(h.accessTokenLifetime()),
+		RevokedBy:    revokedBy,
+	})
+}
+
+// accessTokenLifetime returns how long the longest-lived access tokens, impersonation tokens
+// included, stay valid
+func (h *AuthHandler) accessTokenLifetime() time.Duration {
+	return max(h.AccessTokenTTL, h.ImpersonationTTL)
+}
+
+// revokeReusedFamily revokes the session of a refresh token that was presented again after
+// its rotation, since either its owner or whoever stole it holds the newer token
+func (h *AuthHandler) revokeReusedFamily(w http.ResponseWriter, r *http.Request, token models.RefreshToken) {
+	log.Printf("⚠️ Revoked refresh token presented for user %d, revoking its session", token.UserID)
+	if err := h.Tokens.RevokeRefreshTokenFamily(r.Context(), token.FamilyID); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+	errorhandling.HandleError(w, errorhandling.ErrRefreshTokenReused)
+}
+
+// signAccessToken creates the short-lived JWT access token of a user. Its random JTI lets
+// the token be revoked on its own.
+func (h *AuthHandler) signAccessToken(user models.User) (string, error) {
+	token, _, err := h.signToken(user, h.AccessTokenTTL, nil)
+	return token, err
+}
+
+// signToken creates an access token of a user lasting the given time, with any extra claims.
+// It returns the token along with its JTI.
+func (h *AuthHandler) signToken(user models.User, ttl time.Duration, extra jwt.MapClaims) (string, string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"jti":     jti,
+		"user_id": user.ID,
+		"email":   user.Email,
+		"name":    user.Name,
+		"role":    user.Role,
+		"iat":     now.Unix(),
+		"exp":     now.Add(ttl).Unix(),
+	}
+	for name, value := range extra {
+		claims[name] = value
+	}
+	token, err := h.Keys.Sign(claims)
+	return token, jti, err
+}
+
+// newRefreshToken generates an opaque refresh token in the given family. Only its hash is stored.
+func (h *AuthHandler) newRefreshToken(userID int, familyID string) (string, models.RefreshToken, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", models.RefreshToken{}, err
+	}
+	return token, models.RefreshToken{
+		UserID:    userID,
+		TokenHash: hashRefreshToken(token),
+		FamilyID:  familyID,
+		ExpiresAt: time.Now().Add(h.RefreshTokenTTL),
+	}, nil
+}
+
+// writeTokens signs a new access token and writes it along with the refresh token and any
+// extra fields. The permissions of the user are listed for clients, but are not part of the token.
+func (h *AuthHandler) writeTokens(w http.ResponseWriter, r *http.Request, user models.User, refreshToken string, extra map[string]interface{}) {
+	permissions, err := internalhttp.ResolvePermissions(r.Context(), user.Role)
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+	accessToken, err := h.signAccessToken(user)
+	if err != nil {
+		handleTokenError(w, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"token":         accessToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(h.AccessTokenTTL.Seconds()),
+		"refresh_token": refreshToken,
+		"user": map[string]interface{}{
+			"id":                 user.ID,
+			"name":               user.Name,
+			"email":              user.Email,
+			"role":               user.Role,
+			"email_verified":     user.EmailVerifiedAt != nil,
+			"two_factor_enabled": user.TwoFactorEnabled(),
+		},
+		"permissions": permissions,
+	}
+	for key, value := range extra {
+		response[key] = value
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	internalhttp.WriteJSON(w, r, http.StatusOK, response)
+}
+
+// GetJWKS publishes the public keys verifying the access tokens
+func (h *AuthHandler) GetJWKS(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	internalhttp.WriteJSON(w, r, http.StatusOK, h.Keys.JWKS())
+}
+
+// checkLoginGuard refuses the login of an email while it or the client IP is throttled. It
+// writes an error response with a Retry-After header and returns false when refused.
+func (h *AuthHandler) checkLoginGuard(w http.ResponseWriter, r *http.Request, email string) bool {
+	if h.Guard == nil {
+		return true
+	}
+	verdict, err := h.Guard.Check(r.Context(), email, internalhttp.ClientIP(r))
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return false
+	}
+	if verdict.Allowed() {
+		return true
+	}
+
+	seconds := int(math.Ceil(verdict.RetryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	if verdict.Locked {
+		errorhandling.HandleError(w, errorhandling.ErrAccountLocked.
+			WithDetails(fmt.Sprintf("Try again in %d seconds, or ask an admin to unlock it", seconds)))
+	} else {
+		errorhandling.HandleError(w, errorhandling.ErrTooManyAttempts.
+			WithDetails(fmt.Sprintf("Try again in %d seconds", seconds)))
+	}
+	return false
+}
+
+// failLogin counts a failed login of an email. The failure is only logged when it cannot be
+// counted, since the login fails either way.
+func (h *AuthHandler) failLogin(r *http.Request, email string, userID *int) {
+	if h.Guard == nil {
+		return
+	}
+	if err := h.Guard.Fail(r.Context(), email, internalhttp.ClientIP(r), userID); err != nil {
+		log.Printf("❌ Failed to count the failed login of %s: %v", email, err)
+	}
+}
+
+// succeedLogin forgets the failed logins of an email once its user logged in
+func (h *AuthHandler) succeedLogin(r *http.Request, email string) {
+	if h.Guard == nil {
+		return
+	}
+	if err := h.Guard.Succeed(r.Context(), email); err != nil {
+		log.Printf("❌ Failed to clear the failed logins of %s: %v", email, err)
+	}
+}
+
+// handleTokenError writes the response for a failure to generate a token
+func handleTokenError(w http.ResponseWriter, err error) {
+	errorhandling.HandleError(w, errorhandling.NewError(
+		http.StatusInternalServerError,
+		errorhandling.ErrCodeInternalServer,
+		"Failed to generate authentication token",
+	).WithDebug(err.Error()))
+}
+
+// randomToken returns n random bytes encoded as URL-safe base64
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashRefreshToken returns the hash under which a refresh token is stored
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
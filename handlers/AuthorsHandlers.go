@@ -6,18 +6,19 @@ import (
 	"strconv"
 
 	"github.com/julienschmidt/httprouter"
-	"um6p.ma/finalproject/database"
 	"um6p.ma/finalproject/errorhandling"
 	"um6p.ma/finalproject/interfaces"
+	internalhttp "um6p.ma/finalproject/internal/http"
 	"um6p.ma/finalproject/models"
 	"um6p.ma/finalproject/validation"
 )
 
 type AuthorHandler struct {
 	Store interfaces.AuthorStore
+	Books interfaces.BookStore
 }
 
-// GetAuthorByIDHandler retrieves an author by ID from the database
+// GetAuthorByIDHandler retrieves an author by ID from the store
 func (h *AuthorHandler) GetAuthorByIDHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	ctx := r.Context()
 	id, err := strconv.Atoi(ps.ByName("id"))
@@ -30,17 +31,20 @@ func (h *AuthorHandler) GetAuthorByIDHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	var author models.Author
-	if err := database.DB.WithContext(ctx).First(&author, id).Error; err != nil {
-		errorhandling.HandleError(w, errorhandling.NewNotFoundError("Author", id))
+	author, err := h.Store.GetAuthor(ctx, id)
+	if err != nil {
+		if errorhandling.IsNotFoundError(err) {
+			errorhandling.HandleError(w, errorhandling.NewNotFoundError("Author", id))
+			return
+		}
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(author)
+	internalhttp.WriteJSON(w, r, http.StatusOK, author)
 }
 
-// CreateAuthorHandler adds a new author to the database
+// CreateAuthorHandler adds a new author to the store
 func (h *AuthorHandler) CreateAuthorHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	ctx := r.Context()
 	var newAuthor models.Author
@@ -59,18 +63,17 @@ func (h *AuthorHandler) CreateAuthorHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// Insert into the database
-	if err := database.DB.WithContext(ctx).Create(&newAuthor).Error; err != nil {
+	// Insert into the store
+	createdAuthor, err := h.Store.CreateAuthor(ctx, newAuthor)
+	if err != nil {
 		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(newAuthor)
+	internalhttp.WriteJSON(w, r, http.StatusCreated, createdAuthor)
 }
 
-// UpdateAuthorHandler modifies an existing author in the database
+// UpdateAuthorHandler modifies an existing author in the store
 func (h *AuthorHandler) UpdateAuthorHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	ctx := r.Context()
 	id, err := strconv.Atoi(ps.ByName("id"))
@@ -99,24 +102,21 @@ func (h *AuthorHandler) UpdateAuthorHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// Check if author exists
-	var existingAuthor models.Author
-	if err := database.DB.WithContext(ctx).First(&existingAuthor, id).Error; err != nil {
-		errorhandling.HandleError(w, errorhandling.NewNotFoundError("Author", id))
-		return
-	}
-
-	// Update in the database
-	if err := database.DB.WithContext(ctx).Model(&models.Author{}).Where("id = ?", id).Updates(updatedAuthor).Error; err != nil {
+	// Update in the store
+	author, err := h.Store.UpdateAuthor(ctx, id, updatedAuthor)
+	if err != nil {
+		if errorhandling.IsNotFoundError(err) {
+			errorhandling.HandleError(w, errorhandling.NewNotFoundError("Author", id))
+			return
+		}
 		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(updatedAuthor)
+	internalhttp.WriteJSON(w, r, http.StatusOK, author)
 }
 
-// DeleteAuthorHandler removes an author from the database
+// DeleteAuthorHandler removes an author from the store
 func (h *AuthorHandler) DeleteAuthorHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	ctx := r.Context()
 	id, err := strconv.Atoi(ps.ByName("id"))
@@ -130,13 +130,13 @@ func (h *AuthorHandler) DeleteAuthorHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 	// Check if author exists and has no associated books
-	var bookCount int64
-	if err := database.DB.WithContext(ctx).Model(&models.Book{}).Where("author_id = ?", id).Count(&bookCount).Error; err != nil {
+	books, err := h.Books.SearchBooks(ctx, models.SearchCriteria{AuthorID: id})
+	if err != nil {
 		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
 		return
 	}
 
-	if bookCount > 0 {
+	if bookCount := len(books); bookCount > 0 {
 		errorhandling.HandleError(w, errorhandling.NewError(
 			http.StatusConflict,
 			errorhandling.ErrCodeBadRequest,
@@ -147,8 +147,12 @@ func (h *AuthorHandler) DeleteAuthorHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// Delete from the database
-	if err := database.DB.WithContext(ctx).Delete(&models.Author{}, id).Error; err != nil {
+	// Delete from the store
+	if err := h.Store.DeleteAuthor(ctx, id); err != nil {
+		if errorhandling.IsNotFoundError(err) {
+			errorhandling.HandleError(w, errorhandling.NewNotFoundError("Author", id))
+			return
+		}
 		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
 		return
 	}
@@ -156,16 +160,14 @@ func (h *AuthorHandler) DeleteAuthorHandler(w http.ResponseWriter, r *http.Reque
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// ListAuthorsHandler retrieves all authors from the database
+// ListAuthorsHandler retrieves all authors from the store
 func (h *AuthorHandler) ListAuthorsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	ctx := r.Context()
-	var authors []models.Author
-
-	if err := database.DB.WithContext(ctx).Find(&authors).Error; err != nil {
+	authors, err := h.Store.GetAllAuthors(ctx)
+	if err != nil {
 		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(authors)
+	internalhttp.WriteJSON(w, r, http.StatusOK, authors)
 }
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/interfaces"
+	"um6p.ma/finalproject/internal/authz"
+	internalhttp "um6p.ma/finalproject/internal/http"
+	"um6p.ma/finalproject/validation"
+)
+
+// AuthzHandler serves the admin API inspecting the authorization policy
+type AuthzHandler struct {
+	Users      interfaces.UserStore
+	Authorizer *internalhttp.Authorizer
+	Policy     *authz.Engine
+}
+
+type ExplainInput struct {
+	Method string `json:"method" validate:"required,oneof=GET POST PUT PATCH DELETE"`
+	Path   string `json:"path" validate:"required,startswith=/,max=500"`
+	UserID int    `json:"user_id" validate:"omitempty,min=1"` // The caller when omitted
+}
+
+// ExplainHandler reports which rule of the policy allows or denies the request of a user to a
+// route, along with the attributes the rules were tested against
+func (h *AuthzHandler) ExplainHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := r.Context()
+	var input ExplainInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid request body",
+		).WithDebug(err.Error()))
+		return
+	}
+	input.Method = strings.ToUpper(input.Method)
+	if errs := validation.Validate(input); len(errs) > 0 {
+		errorhandling.HandleError(w, errorhandling.NewValidationError(errs))
+		return
+	}
+
+	claims, ok := internalhttp.GetClaimsFromContext(ctx)
+	if !ok {
+		errorhandling.HandleError(w, errorhandling.ErrMissingToken)
+		return
+	}
+	if input.UserID != 0 && input.UserID != claims.UserID {
+		user, err := h.Users.GetUser(ctx, input.UserID)
+		if err != nil {
+			handleUserStoreError(w, err, input.UserID)
+			return
+		}
+		claims = &internalhttp.Claims{UserID: user.ID, Email: user.Email, Name: user.Name, Role: user.Role}
+	}
+
+	explanation, err := h.Authorizer.Explain(ctx, claims, input.Method, input.Path, internalhttp.ClientIP(r))
+	if err != nil {
+		var errResp errorhandling.ErrorResponse
+		if errors.As(err, &errResp) {
+			errorhandling.HandleError(w, errResp)
+			return
+		}
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+
+	internalhttp.WriteJSON(w, r, http.StatusOK, explanation)
+}
+
+// GetPolicyHandler retrieves the policy in force, along with the file it was loaded from
+func (h *AuthzHandler) GetPolicyHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	policy, source, loadedAt := h.Policy.Policy()
+
+	internalhttp.WriteJSON(w, r, http.StatusOK, map[string]interface{}{
+		"source":    source,
+		"loaded_at": loadedAt,
+		"policy":    policy,
+	})
+}
@@ -6,9 +6,9 @@ import (
 	"strconv"
 
 	"github.com/julienschmidt/httprouter"
-	"um6p.ma/finalproject/database"
 	"um6p.ma/finalproject/errorhandling"
 	"um6p.ma/finalproject/interfaces"
+	internalhttp "um6p.ma/finalproject/internal/http"
 	"um6p.ma/finalproject/models"
 	"um6p.ma/finalproject/validation"
 )
@@ -17,7 +17,7 @@ type BookHandler struct {
 	Store interfaces.BookStore
 }
 
-// GetBookByIDHandler retrieves a book by ID from the database
+// GetBookByIDHandler retrieves a book by ID from the store
 func (h *BookHandler) GetBookByIDHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	ctx := r.Context()
 	id, err := strconv.Atoi(ps.ByName("id"))
@@ -30,17 +30,20 @@ func (h *BookHandler) GetBookByIDHandler(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	var book models.Book
-	if err := database.DB.WithContext(ctx).First(&book, id).Error; err != nil {
-		errorhandling.HandleError(w, errorhandling.NewNotFoundError("Book", id))
+	book, err := h.Store.GetBook(ctx, id)
+	if err != nil {
+		if errorhandling.IsNotFoundError(err) {
+			errorhandling.HandleError(w, errorhandling.NewNotFoundError("Book", id))
+			return
+		}
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(book)
+	internalhttp.WriteJSON(w, r, http.StatusOK, book)
 }
 
-// CreateBookHandler adds a new book to the database
+// CreateBookHandler adds a new book to the store
 func (h *BookHandler) CreateBookHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	ctx := r.Context()
 	var newBook models.Book
@@ -60,8 +63,9 @@ func (h *BookHandler) CreateBookHandler(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	// Insert into the database
-	if err := database.DB.WithContext(ctx).Create(&newBook).Error; err != nil {
+	// Insert into the store
+	createdBook, err := h.Store.CreateBook(ctx, newBook)
+	if err != nil {
 		if errorhandling.IsDuplicateKeyError(err) {
 			errorhandling.HandleError(w, errorhandling.NewError(
 				http.StatusConflict,
@@ -74,12 +78,10 @@ func (h *BookHandler) CreateBookHandler(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(newBook)
+	internalhttp.WriteJSON(w, r, http.StatusCreated, createdBook)
 }
 
-// UpdateBookHandler modifies an existing book in the database
+// UpdateBookHandler modifies an existing book in the store
 func (h *BookHandler) UpdateBookHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	ctx := r.Context()
 	id, err := strconv.Atoi(ps.ByName("id"))
@@ -108,9 +110,10 @@ func (h *BookHandler) UpdateBookHandler(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	// Update in the database
-	if err := database.DB.WithContext(ctx).Model(&models.Book{}).Where("id = ?", id).Updates(updatedBook).Error; err != nil {
-		if err := database.DB.WithContext(ctx).First(&models.Book{}, id).Error; err != nil {
+	// Update in the store
+	book, err := h.Store.UpdateBook(ctx, id, updatedBook)
+	if err != nil {
+		if errorhandling.IsNotFoundError(err) {
 			errorhandling.HandleError(w, errorhandling.NewNotFoundError("Book", id))
 			return
 		}
@@ -118,11 +121,10 @@ func (h *BookHandler) UpdateBookHandler(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(updatedBook)
+	internalhttp.WriteJSON(w, r, http.StatusOK, book)
 }
 
-// DeleteBookHandler removes a book from the database
+// DeleteBookHandler removes a book from the store
 func (h *BookHandler) DeleteBookHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	ctx := r.Context()
 	id, err := strconv.Atoi(ps.ByName("id"))
@@ -135,8 +137,8 @@ func (h *BookHandler) DeleteBookHandler(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	if err := database.DB.WithContext(ctx).Delete(&models.Book{}, id).Error; err != nil {
-		if err := database.DB.WithContext(ctx).First(&models.Book{}, id).Error; err != nil {
+	if err := h.Store.DeleteBook(ctx, id); err != nil {
+		if errorhandling.IsNotFoundError(err) {
 			errorhandling.HandleError(w, errorhandling.NewNotFoundError("Book", id))
 			return
 		}
@@ -147,37 +149,58 @@ func (h *BookHandler) DeleteBookHandler(w http.ResponseWriter, r *http.Request,
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// SearchBooksHandler searches for books in the database
+// SearchBooksHandler searches for books in the store
 func (h *BookHandler) SearchBooksHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	ctx := r.Context()
 	query := r.URL.Query()
 
-	var books []models.Book
-	dbQuery := database.DB.WithContext(ctx)
+	var criteria models.SearchCriteria
+	var err error
 
 	// Apply filters if provided
 	if title := query.Get("title"); title != "" {
-		dbQuery = dbQuery.Where("title ILIKE ?", "%"+title+"%")
-	}
-	if authorID := query.Get("author_id"); authorID != "" {
-		dbQuery = dbQuery.Where("author_id = ?", authorID)
+		criteria.Titles = []string{title}
 	}
 	if genre := query.Get("genre"); genre != "" {
-		dbQuery = dbQuery.Where("genres ILIKE ?", "%"+genre+"%")
+		criteria.Genres = []string{genre}
+	}
+	if authorID := query.Get("author_id"); authorID != "" {
+		if criteria.AuthorID, err = strconv.Atoi(authorID); err != nil {
+			errorhandling.HandleError(w, errorhandling.NewError(
+				http.StatusBadRequest,
+				errorhandling.ErrCodeInvalidInput,
+				"Invalid author_id format",
+			))
+			return
+		}
 	}
 	if minPrice := query.Get("min_price"); minPrice != "" {
-		dbQuery = dbQuery.Where("price >= ?", minPrice)
+		if criteria.MinPrice, err = strconv.ParseFloat(minPrice, 64); err != nil {
+			errorhandling.HandleError(w, errorhandling.NewError(
+				http.StatusBadRequest,
+				errorhandling.ErrCodeInvalidInput,
+				"Invalid min_price format",
+			))
+			return
+		}
 	}
 	if maxPrice := query.Get("max_price"); maxPrice != "" {
-		dbQuery = dbQuery.Where("price <= ?", maxPrice)
+		if criteria.MaxPrice, err = strconv.ParseFloat(maxPrice, 64); err != nil {
+			errorhandling.HandleError(w, errorhandling.NewError(
+				http.StatusBadRequest,
+				errorhandling.ErrCodeInvalidInput,
+				"Invalid max_price format",
+			))
+			return
+		}
 	}
 
 	// Execute query
-	if err := dbQuery.Find(&books).Error; err != nil {
+	books, err := h.Store.SearchBooks(ctx, criteria)
+	if err != nil {
 		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(books)
+	internalhttp.WriteJSON(w, r, http.StatusOK, books)
 }
@@ -6,18 +6,19 @@ import (
 	"strconv"
 
 	"github.com/julienschmidt/httprouter"
-	"um6p.ma/finalproject/database"
 	"um6p.ma/finalproject/errorhandling"
 	"um6p.ma/finalproject/interfaces"
+	internalhttp "um6p.ma/finalproject/internal/http"
 	"um6p.ma/finalproject/models"
 	"um6p.ma/finalproject/validation"
 )
 
 type CustomerHandler struct {
-	Store interfaces.CustomerStore
+	Store  interfaces.CustomerStore
+	Orders interfaces.OrderStore
 }
 
-// GetCustomerByIDHandler retrieves a customer by ID from the database
+// GetCustomerByIDHandler retrieves a customer by ID from the store
 func (h *CustomerHandler) GetCustomerByIDHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	ctx := r.Context()
 	id, err := strconv.Atoi(ps.ByName("id"))
@@ -30,17 +31,20 @@ func (h *CustomerHandler) GetCustomerByIDHandler(w http.ResponseWriter, r *http.
 		return
 	}
 
-	var customer models.Customer
-	if err := database.DB.WithContext(ctx).First(&customer, id).Error; err != nil {
-		errorhandling.HandleError(w, errorhandling.NewNotFoundError("Customer", id))
+	customer, err := h.Store.GetCustomer(ctx, id)
+	if err != nil {
+		if errorhandling.IsNotFoundError(err) {
+			errorhandling.HandleError(w, errorhandling.NewNotFoundError("Customer", id))
+			return
+		}
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(customer)
+	internalhttp.WriteJSON(w, r, http.StatusOK, customer)
 }
 
-// CreateCustomerHandler adds a new customer to the database
+// CreateCustomerHandler adds a new customer to the store
 func (h *CustomerHandler) CreateCustomerHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	ctx := r.Context()
 	var newCustomer models.Customer
@@ -60,28 +64,29 @@ func (h *CustomerHandler) CreateCustomerHandler(w http.ResponseWriter, r *http.R
 	}
 
 	// Check if email is unique
-	var existingCustomer models.Customer
-	if err := database.DB.WithContext(ctx).Where("email = ?", newCustomer.Email).First(&existingCustomer).Error; err == nil {
+	if _, err := h.Store.GetCustomerByEmail(ctx, newCustomer.Email); err == nil {
 		errorhandling.HandleError(w, errorhandling.NewError(
 			http.StatusConflict,
 			errorhandling.ErrCodeDuplicateEntry,
 			"Email already registered",
 		))
 		return
+	} else if !errorhandling.IsNotFoundError(err) {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
 	}
 
-	// Insert into the database
-	if err := database.DB.WithContext(ctx).Create(&newCustomer).Error; err != nil {
+	// Insert into the store
+	createdCustomer, err := h.Store.CreateCustomer(ctx, newCustomer)
+	if err != nil {
 		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(newCustomer)
+	internalhttp.WriteJSON(w, r, http.StatusCreated, createdCustomer)
 }
 
-// UpdateCustomerHandler modifies an existing customer in the database
+// UpdateCustomerHandler modifies an existing customer in the store
 func (h *CustomerHandler) UpdateCustomerHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	ctx := r.Context()
 	id, err := strconv.Atoi(ps.ByName("id"))
@@ -111,36 +116,42 @@ func (h *CustomerHandler) UpdateCustomerHandler(w http.ResponseWriter, r *http.R
 	}
 
 	// Check if customer exists
-	var existingCustomer models.Customer
-	if err := database.DB.WithContext(ctx).First(&existingCustomer, id).Error; err != nil {
-		errorhandling.HandleError(w, errorhandling.NewNotFoundError("Customer", id))
+	existingCustomer, err := h.Store.GetCustomer(ctx, id)
+	if err != nil {
+		if errorhandling.IsNotFoundError(err) {
+			errorhandling.HandleError(w, errorhandling.NewNotFoundError("Customer", id))
+			return
+		}
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
 		return
 	}
 
 	// Check if new email is unique (if changed)
 	if updatedCustomer.Email != existingCustomer.Email {
-		var emailExists models.Customer
-		if err := database.DB.WithContext(ctx).Where("email = ? AND id != ?", updatedCustomer.Email, id).First(&emailExists).Error; err == nil {
+		if emailOwner, err := h.Store.GetCustomerByEmail(ctx, updatedCustomer.Email); err == nil && emailOwner.ID != id {
 			errorhandling.HandleError(w, errorhandling.NewError(
 				http.StatusConflict,
 				errorhandling.ErrCodeDuplicateEntry,
 				"Email already registered",
 			))
 			return
+		} else if err != nil && !errorhandling.IsNotFoundError(err) {
+			errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+			return
 		}
 	}
 
-	// Update in the database
-	if err := database.DB.WithContext(ctx).Model(&existingCustomer).Updates(updatedCustomer).Error; err != nil {
+	// Update in the store
+	customer, err := h.Store.UpdateCustomer(ctx, id, updatedCustomer)
+	if err != nil {
 		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(updatedCustomer)
+	internalhttp.WriteJSON(w, r, http.StatusOK, customer)
 }
 
-// DeleteCustomerHandler removes a customer from the database
+// DeleteCustomerHandler removes a customer from the store
 func (h *CustomerHandler) DeleteCustomerHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	ctx := r.Context()
 	id, err := strconv.Atoi(ps.ByName("id"))
@@ -154,13 +165,13 @@ func (h *CustomerHandler) DeleteCustomerHandler(w http.ResponseWriter, r *http.R
 	}
 
 	// Check if customer has any orders
-	var orderCount int64
-	if err := database.DB.WithContext(ctx).Model(&models.Order{}).Where("customer_id = ?", id).Count(&orderCount).Error; err != nil {
+	orders, err := h.Orders.GetOrdersByCustomer(ctx, id)
+	if err != nil {
 		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
 		return
 	}
 
-	if orderCount > 0 {
+	if orderCount := len(orders); orderCount > 0 {
 		errorhandling.HandleError(w, errorhandling.NewError(
 			http.StatusConflict,
 			errorhandling.ErrCodeBadRequest,
@@ -171,8 +182,12 @@ func (h *CustomerHandler) DeleteCustomerHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	// Delete from the database
-	if err := database.DB.WithContext(ctx).Delete(&models.Customer{}, id).Error; err != nil {
+	// Delete from the store
+	if err := h.Store.DeleteCustomer(ctx, id); err != nil {
+		if errorhandling.IsNotFoundError(err) {
+			errorhandling.HandleError(w, errorhandling.NewNotFoundError("Customer", id))
+			return
+		}
 		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
 		return
 	}
@@ -180,29 +195,25 @@ func (h *CustomerHandler) DeleteCustomerHandler(w http.ResponseWriter, r *http.R
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// ListCustomersHandler retrieves all customers from the database
+// ListCustomersHandler retrieves the customers from the store. Callers restricted to their own
+// records find only the customer linked to them.
 func (h *CustomerHandler) ListCustomersHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	ctx := r.Context()
-	var customers []models.Customer
-
-	query := database.DB.WithContext(ctx)
-
 	// Handle query parameters for filtering
-	if email := r.URL.Query().Get("email"); email != "" {
-		query = query.Where("email LIKE ?", "%"+email+"%")
+	criteria := models.CustomerSearchCriteria{
+		Email: r.URL.Query().Get("email"),
+		Name:  r.URL.Query().Get("name"),
+		City:  r.URL.Query().Get("city"),
 	}
-	if name := r.URL.Query().Get("name"); name != "" {
-		query = query.Where("name LIKE ?", "%"+name+"%")
-	}
-	if city := r.URL.Query().Get("city"); city != "" {
-		query = query.Where("address_city LIKE ?", "%"+city+"%")
+	if userID, scoped := internalhttp.OwnerScope(ctx); scoped {
+		criteria.UserID = userID
 	}
 
-	if err := query.Find(&customers).Error; err != nil {
+	customers, err := h.Store.SearchCustomers(ctx, criteria)
+	if err != nil {
 		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(customers)
+	internalhttp.WriteJSON(w, r, http.StatusOK, customers)
 }
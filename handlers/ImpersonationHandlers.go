@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/julienschmidt/httprouter"
+	"um6p.ma/finalproject/constants"
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/interfaces"
+	internalhttp "um6p.ma/finalproject/internal/http"
+	"um6p.ma/finalproject/models"
+	"um6p.ma/finalproject/validation"
+)
+
+// maxImpersonatedRequests bounds the number of audited requests listed at once
+const maxImpersonatedRequests = 500
+
+// ImpersonationHandler serves the admin API letting support staff act as another user
+type ImpersonationHandler struct {
+	Users       interfaces.UserStore
+	Events      interfaces.SecurityEventStore
+	Audit       interfaces.ImpersonationAuditStore
+	Auth        *AuthHandler
+	TTL         time.Duration
+	AllowWrites bool // Tokens may be asked for without blocking writes
+}
+
+type ImpersonateInput struct {
+	Reason      string `json:"reason" validate:"required,min=3,max=500"`
+	AllowWrites bool   `json:"allow_writes"`
+}
+
+// ImpersonateUserHandler issues a short-lived access token acting as a user, on behalf of the
+// authenticated admin. The token cannot be refreshed, and is read-only unless writes were
+// asked for and are allowed by the configuration.
+func (h *ImpersonationHandler) ImpersonateUserHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctx := r.Context()
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid ID format",
+		))
+		return
+	}
+
+	var input ImpersonateInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid request body",
+		).WithDebug(err.Error()))
+		return
+	}
+	if errs := validation.Validate(input); len(errs) > 0 {
+		errorhandling.HandleError(w, errorhandling.NewValidationError(errs))
+		return
+	}
+	if input.AllowWrites && !h.AllowWrites {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusForbidden,
+			errorhandling.ErrCodeForbidden,
+			"Impersonation tokens are read-only on this server",
+		))
+		return
+	}
+
+	claims, ok := internalhttp.GetClaimsFromContext(ctx)
+	if !ok {
+		errorhandling.HandleError(w, errorhandling.ErrMissingToken)
+		return
+	}
+	if id == claims.UserID {
+		errorhandling.HandleError(w, errorhandling.ErrInvalidInput.WithDetails("You cannot impersonate yourself"))
+		return
+	}
+
+	user, err := h.Users.GetUser(ctx, id)
+	if err != nil {
+		handleUserStoreError(w, err, id)
+		return
+	}
+	// Acting as another user manager, whatever their role, would hide who did what
+	permissions, err := internalhttp.ResolvePermissions(ctx, user.Role)
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+	if internalhttp.HasPermission(permissions, "manage:users") {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusForbidden,
+			errorhandling.ErrCodeForbidden,
+			"Users holding manage:users cannot be impersonated",
+		))
+		return
+	}
+	if user.Disabled {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusConflict,
+			errorhandling.ErrCodeConflict,
+			"Disabled users cannot be impersonated",
+		))
+		return
+	}
+
+	readOnly := !input.AllowWrites
+	token, jti, err := h.Auth.signToken(user, h.TTL, jwt.MapClaims{
+		"impersonator_id": claims.UserID,
+		"read_only":       readOnly,
+	})
+	if err != nil {
+		handleTokenError(w, err)
+		return
+	}
+
+	if _, err := h.Events.RecordSecurityEvent(ctx, models.SecurityEvent{
+		Type:    constants.SecurityEventImpersonationStarted,
+		UserID:  &user.ID,
+		ActorID: &claims.UserID,
+		IP:      internalhttp.ClientIP(r),
+		Details: fmt.Sprintf("token %s, read-only: %t, reason: %s", jti, readOnly, input.Reason),
+	}); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+	log.Printf("🔑 Admin %d is impersonating user %d (read-only: %t): %s", claims.UserID, user.ID, readOnly, input.Reason)
+
+	w.Header().Set("Cache-Control", "no-store")
+	internalhttp.WriteJSON(w, r, http.StatusOK, map[string]interface{}{
+		"token":           token,
+		"token_type":      "Bearer",
+		"token_id":        jti,
+		"expires_in":      int(h.TTL.Seconds()),
+		"impersonator_id": claims.UserID,
+		"read_only":       readOnly,
+		"user":            userResponse(user),
+	})
+}
+
+// ListImpersonatedRequestsHandler retrieves the most recent requests made with impersonation
+// tokens, optionally filtered by admin, by user or by token
+func (h *ImpersonationHandler) ListImpersonatedRequestsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	query := r.URL.Query()
+	filter := models.ImpersonatedRequestFilter{TokenID: query.Get("token_id"), Limit: 100}
+	for name, target := range map[string]*int{"impersonator_id": &filter.ImpersonatorID, "user_id": &filter.UserID} {
+		if value := query.Get(name); value != "" {
+			id, err := strconv.Atoi(value)
+			if err != nil {
+				errorhandling.HandleError(w, errorhandling.ErrInvalidInput.WithDetails(name+" must be a number"))
+				return
+			}
+			*target = id
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxImpersonatedRequests {
+			errorhandling.HandleError(w, errorhandling.ErrInvalidInput.
+				WithDetails("limit must be between 1 and "+strconv.Itoa(maxImpersonatedRequests)))
+			return
+		}
+		filter.Limit = limit
+	}
+
+	requests, err := h.Audit.ListImpersonatedRequests(r.Context(), filter)
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+
+	response := make([]map[string]interface{}, 0, len(requests))
+	for _, request := range requests {
+		response = append(response, map[string]interface{}{
+			"id":              request.ID,
+			"impersonator_id": request.ImpersonatorID,
+			"user_id":         request.UserID,
+			"token_id":        request.TokenID,
+			"method":          request.Method,
+			"path":            request.Path,
+			"status":          request.Status,
+			"ip":              request.IP,
+			"created_at":      request.CreatedAt,
+		})
+	}
+
+	internalhttp.WriteJSON(w, r, http.StatusOK, response)
+}
@@ -0,0 +1,355 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/interfaces"
+	internalhttp "um6p.ma/finalproject/internal/http"
+	"um6p.ma/finalproject/models"
+	"um6p.ma/finalproject/validation"
+)
+
+// MeHandler serves the self-service API of the authenticated user. Every query is scoped to
+// the customer record linked to the user, so shoppers never deal with IDs of their own.
+type MeHandler struct {
+	Users     interfaces.UserStore
+	Customers interfaces.CustomerStore
+	Orders    interfaces.OrderStore
+}
+
+type UpdateMeInput struct {
+	Name    *string         `json:"name" validate:"omitempty,min=2,max=100"`
+	Address *models.Address `json:"address"`
+}
+
+// GetMe retrieves the account and the customer record of the authenticated user
+func (h *MeHandler) GetMe(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user, customer, ok := h.current(w, r)
+	if !ok {
+		return
+	}
+
+	internalhttp.WriteJSON(w, r, http.StatusOK, meResponse(user, customer))
+}
+
+// UpdateMe changes the name and the main address of the authenticated user
+func (h *MeHandler) UpdateMe(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := r.Context()
+	var input UpdateMeInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid request body",
+		).WithDebug(err.Error()))
+		return
+	}
+	if errs := validation.Validate(input); len(errs) > 0 {
+		errorhandling.HandleError(w, errorhandling.NewValidationError(errs))
+		return
+	}
+
+	user, customer, ok := h.current(w, r)
+	if !ok {
+		return
+	}
+
+	if input.Name != nil && *input.Name != user.Name {
+		user.Name = *input.Name
+		updated, err := h.Users.UpdateUser(ctx, user.ID, user)
+		if err != nil {
+			handleUserStoreError(w, err, user.ID)
+			return
+		}
+		user = updated
+		customer.Name = user.Name
+	}
+	if input.Address != nil {
+		customer.Address = *input.Address
+	}
+	customer, err := h.Customers.UpdateCustomer(ctx, customer.ID, customer)
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+
+	internalhttp.WriteJSON(w, r, http.StatusOK, meResponse(user, customer))
+}
+
+// ListMyOrders retrieves the orders of the authenticated user
+func (h *MeHandler) ListMyOrders(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	_, customer, ok := h.current(w, r)
+	if !ok {
+		return
+	}
+
+	orders, err := h.Orders.GetOrdersByCustomer(r.Context(), customer.ID)
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+	if orders == nil {
+		orders = []models.Order{}
+	}
+
+	internalhttp.WriteJSON(w, r, http.StatusOK, orders)
+}
+
+// GetMyOrder retrieves an order of the authenticated user. The orders of others are not
+// found, rather than forbidden, so that their IDs reveal nothing.
+func (h *MeHandler) GetMyOrder(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid ID format",
+		))
+		return
+	}
+	_, customer, ok := h.current(w, r)
+	if !ok {
+		return
+	}
+
+	order, err := h.Orders.GetOrder(r.Context(), id)
+	if err != nil {
+		handleOrderStoreError(w, err, id)
+		return
+	}
+	if order.CustomerID != customer.ID {
+		errorhandling.HandleError(w, errorhandling.NewNotFoundError("Order", id))
+		return
+	}
+
+	internalhttp.WriteJSON(w, r, http.StatusOK, order)
+}
+
+// CreateMyOrder places an order for the authenticated user
+func (h *MeHandler) CreateMyOrder(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var newOrder models.Order
+	if err := json.NewDecoder(r.Body).Decode(&newOrder); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid request body",
+		).WithDebug(err.Error()))
+		return
+	}
+	_, customer, ok := h.current(w, r)
+	if !ok {
+		return
+	}
+
+	newOrder.CustomerID = customer.ID
+	if errs := validation.Validate(newOrder); len(errs) > 0 {
+		errorhandling.HandleError(w, errorhandling.NewValidationError(errs))
+		return
+	}
+	createdOrder, err := h.Orders.CreateOrder(r.Context(), newOrder)
+	if err != nil {
+		handleOrderStoreError(w, err, 0)
+		return
+	}
+
+	internalhttp.WriteJSON(w, r, http.StatusCreated, createdOrder)
+}
+
+// ListMyAddresses retrieves the address book of the authenticated user
+func (h *MeHandler) ListMyAddresses(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	_, customer, ok := h.current(w, r)
+	if !ok {
+		return
+	}
+
+	addresses := customer.Addresses
+	if addresses == nil {
+		addresses = []models.CustomerAddress{}
+	}
+
+	internalhttp.WriteJSON(w, r, http.StatusOK, addresses)
+}
+
+// CreateMyAddress adds an address to the address book of the authenticated user
+func (h *MeHandler) CreateMyAddress(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	address, ok := decodeAddress(w, r)
+	if !ok {
+		return
+	}
+	_, customer, ok := h.current(w, r)
+	if !ok {
+		return
+	}
+
+	address, err := h.Customers.AddCustomerAddress(r.Context(), customer.ID, address)
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+
+	internalhttp.WriteJSON(w, r, http.StatusCreated, address)
+}
+
+// UpdateMyAddress replaces an address of the address book of the authenticated user
+func (h *MeHandler) UpdateMyAddress(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid ID format",
+		))
+		return
+	}
+	address, ok := decodeAddress(w, r)
+	if !ok {
+		return
+	}
+	_, customer, ok := h.current(w, r)
+	if !ok {
+		return
+	}
+
+	address.ID = id
+	address, err = h.Customers.UpdateCustomerAddress(r.Context(), customer.ID, address)
+	if err != nil {
+		handleAddressStoreError(w, err, id)
+		return
+	}
+
+	internalhttp.WriteJSON(w, r, http.StatusOK, address)
+}
+
+// DeleteMyAddress removes an address from the address book of the authenticated user
+func (h *MeHandler) DeleteMyAddress(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid ID format",
+		))
+		return
+	}
+	_, customer, ok := h.current(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.Customers.DeleteCustomerAddress(r.Context(), customer.ID, id); err != nil {
+		handleAddressStoreError(w, err, id)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// current returns the authenticated user and their customer record, or writes an error
+// response and returns false
+func (h *MeHandler) current(w http.ResponseWriter, r *http.Request) (models.User, models.Customer, bool) {
+	ctx := r.Context()
+	claims, ok := internalhttp.GetClaimsFromContext(ctx)
+	if !ok {
+		errorhandling.HandleError(w, errorhandling.ErrMissingToken)
+		return models.User{}, models.Customer{}, false
+	}
+
+	user, err := h.Users.GetUser(ctx, claims.UserID)
+	if err != nil {
+		handleUserStoreError(w, err, claims.UserID)
+		return models.User{}, models.Customer{}, false
+	}
+	customer, err := customerOf(ctx, h.Customers, user)
+	if err != nil {
+		handleCustomerLinkError(w, err)
+		return models.User{}, models.Customer{}, false
+	}
+	return user, customer, true
+}
+
+// customerOf returns the customer record linked to a user. Users without one get the customer
+// record holding their email address, once they verified it, or a new one.
+func customerOf(ctx context.Context, customers interfaces.CustomerStore, user models.User) (models.Customer, error) {
+	customer, err := customers.GetCustomerByUserID(ctx, user.ID)
+	if !errorhandling.IsNotFoundError(err) {
+		return customer, err
+	}
+
+	customer, err = customers.GetCustomerByEmail(ctx, user.Email)
+	switch {
+	case err == nil:
+		// Anyone can register with an address, so only its owner gets the orders placed with it
+		if customer.UserID == nil && user.EmailVerifiedAt == nil {
+			return models.Customer{}, errorhandling.ErrEmailNotVerified
+		}
+		return customers.LinkCustomer(ctx, customer.ID, user.ID)
+	case !errorhandling.IsNotFoundError(err):
+		return models.Customer{}, err
+	}
+
+	customer, err = customers.CreateCustomer(ctx, models.Customer{
+		UserID: &user.ID,
+		Name:   user.Name,
+		Email:  user.Email,
+	})
+	if errorhandling.IsDuplicateKeyError(err) {
+		// Another request created it first
+		return customers.GetCustomerByUserID(ctx, user.ID)
+	}
+	return customer, err
+}
+
+// decodeAddress decodes and validates an address book entry, or writes an error response and
+// returns false
+func decodeAddress(w http.ResponseWriter, r *http.Request) (models.CustomerAddress, bool) {
+	var address models.CustomerAddress
+	if err := json.NewDecoder(r.Body).Decode(&address); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid request body",
+		).WithDebug(err.Error()))
+		return models.CustomerAddress{}, false
+	}
+	if errs := validation.Validate(address); len(errs) > 0 {
+		errorhandling.HandleError(w, errorhandling.NewValidationError(errs))
+		return models.CustomerAddress{}, false
+	}
+	return address, true
+}
+
+// meResponse is the representation of the account and the customer record of a user
+func meResponse(user models.User, customer models.Customer) map[string]interface{} {
+	return map[string]interface{}{
+		"user":     userResponse(user),
+		"customer": customer,
+	}
+}
+
+// handleCustomerLinkError writes the response for an error finding the customer of a user
+func handleCustomerLinkError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errorhandling.ErrEmailNotVerified):
+		errorhandling.HandleError(w, errorhandling.ErrEmailNotVerified.
+			WithDetails("A customer record already uses your email address; verify it to access its orders"))
+	case errors.Is(err, errorhandling.ErrCustomerLinked):
+		errorhandling.HandleError(w, errorhandling.ErrCustomerLinked)
+	default:
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+	}
+}
+
+// handleAddressStoreError writes the response for an error returned by the address methods
+func handleAddressStoreError(w http.ResponseWriter, err error, id int) {
+	if errorhandling.IsNotFoundError(err) {
+		errorhandling.HandleError(w, errorhandling.NewNotFoundError("Address", id))
+		return
+	}
+	errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+}
@@ -1,128 +1,279 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
-	"um6p.ma/finalproject/database"
-	"um6p.ma/finalproject/inmemorystores"
+	"um6p.ma/finalproject/constants"
+	"um6p.ma/finalproject/errorhandling"
 	"um6p.ma/finalproject/interfaces"
+	internalhttp "um6p.ma/finalproject/internal/http"
 	"um6p.ma/finalproject/models"
+	"um6p.ma/finalproject/validation"
 )
 
 type OrderHandler struct {
-	Store       interfaces.OrderStore
-	ReportStore *inmemorystores.ReportStore
+	Store     interfaces.OrderStore
+	Customers interfaces.CustomerStore
+	Users     interfaces.UserStore
 }
 
-// GetOrderByIDHandler retrieves an order by ID from the database
+type OrderTransitionInput struct {
+	Status string `json:"status" validate:"required,valid_status"`
+}
+
+// GetOrderByIDHandler retrieves an order by ID from the store
 func (h *OrderHandler) GetOrderByIDHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	ctx := r.Context()
 	id, err := strconv.Atoi(ps.ByName("id"))
 	if err != nil {
-		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid ID format",
+		))
 		return
 	}
 
-	var order models.Order
-	if err := database.DB.WithContext(ctx).Preload("Items").First(&order, id).Error; err != nil {
-		http.Error(w, "Order not found", http.StatusNotFound)
+	order, err := h.Store.GetOrder(ctx, id)
+	if err != nil {
+		handleOrderStoreError(w, err, id)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(order)
+	internalhttp.WriteJSON(w, r, http.StatusOK, order)
 }
 
-// CreateOrderHandler adds a new order to the database
+// CreateOrderHandler adds a new order to the store. Callers restricted to their own records
+// place it for the customer linked to them, whatever customer the body names.
 func (h *OrderHandler) CreateOrderHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	ctx := r.Context()
 	var newOrder models.Order
 	if err := json.NewDecoder(r.Body).Decode(&newOrder); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid request body",
+		).WithDebug(err.Error()))
+		return
+	}
+	if userID, scoped := internalhttp.OwnerScope(ctx); scoped {
+		user, err := h.Users.GetUser(ctx, userID)
+		if err != nil {
+			handleUserStoreError(w, err, userID)
+			return
+		}
+		customer, err := customerOf(ctx, h.Customers, user)
+		if err != nil {
+			handleCustomerLinkError(w, err)
+			return
+		}
+		newOrder.CustomerID = customer.ID
+	}
+	if errs := validation.Validate(newOrder); len(errs) > 0 {
+		errorhandling.HandleError(w, errorhandling.NewValidationError(errs))
 		return
 	}
 
-	// Insert into the database
-	if err := database.DB.WithContext(ctx).Create(&newOrder).Error; err != nil {
-		http.Error(w, "Failed to create order", http.StatusInternalServerError)
+	// Insert into the store
+	createdOrder, err := h.Store.CreateOrder(ctx, newOrder)
+	if err != nil {
+		handleOrderStoreError(w, err, 0)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(newOrder)
+	internalhttp.WriteJSON(w, r, http.StatusCreated, createdOrder)
 }
 
-// UpdateOrderHandler modifies an existing order in the database
+// UpdateOrderHandler modifies an existing order in the store. Callers restricted to their own
+// records cannot move the order to another customer.
 func (h *OrderHandler) UpdateOrderHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	ctx := r.Context()
 	id, err := strconv.Atoi(ps.ByName("id"))
 	if err != nil {
-		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid ID format",
+		))
 		return
 	}
 
 	var updatedOrder models.Order
 	if err := json.NewDecoder(r.Body).Decode(&updatedOrder); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid request body",
+		).WithDebug(err.Error()))
+		return
+	}
+	if _, scoped := internalhttp.OwnerScope(ctx); scoped {
+		existing, err := h.Store.GetOrder(ctx, id)
+		if err != nil {
+			handleOrderStoreError(w, err, id)
+			return
+		}
+		updatedOrder.CustomerID = existing.CustomerID
+	}
+	if errs := validation.Validate(updatedOrder); len(errs) > 0 {
+		errorhandling.HandleError(w, errorhandling.NewValidationError(errs))
 		return
 	}
 
-	// Update in the database
-	if err := database.DB.WithContext(ctx).Model(&models.Order{}).Where("id = ?", id).Updates(updatedOrder).Error; err != nil {
-		http.Error(w, "Order not found or update failed", http.StatusNotFound)
+	// Update in the store
+	order, err := h.Store.UpdateOrder(ctx, id, updatedOrder)
+	if err != nil {
+		handleOrderStoreError(w, err, id)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(updatedOrder)
+	internalhttp.WriteJSON(w, r, http.StatusOK, order)
 }
 
-// DeleteOrderHandler removes an order from the database
+// DeleteOrderHandler removes an order from the store
 func (h *OrderHandler) DeleteOrderHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	ctx := r.Context()
 	id, err := strconv.Atoi(ps.ByName("id"))
 	if err != nil {
-		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid ID format",
+		))
 		return
 	}
 
-	// Delete from the database
-	if err := database.DB.WithContext(ctx).Delete(&models.Order{}, id).Error; err != nil {
-		http.Error(w, "Order not found or delete failed", http.StatusNotFound)
+	// Delete from the store
+	if err := h.Store.DeleteOrder(ctx, id); err != nil {
+		handleOrderStoreError(w, err, id)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// GetAllOrdersHandler retrieves all orders from the database
-func (h *OrderHandler) GetAllOrdersHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+// TransitionOrderHandler moves an order to another status of its lifecycle
+func (h *OrderHandler) TransitionOrderHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	ctx := r.Context()
-	var orders []models.Order
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid ID format",
+		))
+		return
+	}
+
+	var input OrderTransitionInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid request body",
+		).WithDebug(err.Error()))
+		return
+	}
+	if errs := validation.Validate(input); len(errs) > 0 {
+		errorhandling.HandleError(w, errorhandling.NewValidationError(errs))
+		return
+	}
+
+	claims, ok := internalhttp.GetClaimsFromContext(ctx)
+	if !ok {
+		errorhandling.HandleError(w, errorhandling.ErrMissingToken)
+		return
+	}
+
+	order, err := h.Store.GetOrder(ctx, id)
+	if err != nil {
+		handleOrderStoreError(w, err, id)
+		return
+	}
 
-	if err := database.DB.WithContext(ctx).Preload("Items").Find(&orders).Error; err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	change := models.OrderStatusChange{
+		FromStatus: order.Status,
+		ToStatus:   strings.ToLower(input.Status),
+		ChangedBy:  claims.UserID,
+		ChangedAt:  time.Now(),
+	}
+	// Callers the policy restricts to their own orders only cancel them
+	_, owned := internalhttp.OwnerScope(ctx)
+	if owned && !canCancelOwnOrder(order, change.ToStatus) {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusForbidden,
+			errorhandling.ErrCodeForbidden,
+			fmt.Sprintf("You cannot move your orders to %s", change.ToStatus),
+		))
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(orders)
+	order, err = h.Store.TransitionOrder(ctx, id, change)
+	if err != nil {
+		handleOrderStoreError(w, err, id)
+		return
+	}
+
+	internalhttp.WriteJSON(w, r, http.StatusOK, order)
+}
+
+// canCancelOwnOrder checks that the owner of an order cancels it while it is still pending
+func canCancelOwnOrder(order models.Order, status string) bool {
+	return status == constants.OrderStatusCancelled && order.Status == constants.OrderStatusPending
 }
 
-// GetSalesReportHandler returns the latest sales reports
-func (h *OrderHandler) GetSalesReportHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+// GetAllOrdersHandler retrieves the orders from the store. Callers restricted to their own
+// records find only the orders of the customer linked to them.
+func (h *OrderHandler) GetAllOrdersHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	ctx := r.Context()
-	var reports []models.SalesReport
 
-	if err := database.DB.WithContext(ctx).Order("timestamp DESC").Limit(10).Find(&reports).Error; err != nil {
-		http.Error(w, "Failed to fetch sales reports", http.StatusInternalServerError)
+	var orders []models.Order
+	var err error
+	if userID, scoped := internalhttp.OwnerScope(ctx); scoped {
+		orders, err = h.ownOrders(ctx, userID)
+	} else {
+		orders, err = h.Store.GetAllOrders(ctx)
+	}
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+
+	internalhttp.WriteJSON(w, r, http.StatusOK, orders)
+}
+
+// ownOrders returns the orders of the customer linked to a user, if any
+func (h *OrderHandler) ownOrders(ctx context.Context, userID int) ([]models.Order, error) {
+	customer, err := h.Customers.GetCustomerByUserID(ctx, userID)
+	if errorhandling.IsNotFoundError(err) {
+		return []models.Order{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return h.Store.GetOrdersByCustomer(ctx, customer.ID)
+}
+
+// handleOrderStoreError writes the response for an error returned by the order store.
+// Application errors such as insufficient stock are passed through unchanged.
+func handleOrderStoreError(w http.ResponseWriter, err error, id int) {
+	if id != 0 && errorhandling.IsNotFoundError(err) {
+		errorhandling.HandleError(w, errorhandling.NewNotFoundError("Order", id))
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(reports)
+	var errResp errorhandling.ErrorResponse
+	if errors.As(err, &errResp) {
+		errorhandling.HandleError(w, errResp)
+		return
+	}
+	errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
 }
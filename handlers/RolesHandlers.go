@@ -0,0 +1,371 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"um6p.ma/finalproject/constants"
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/interfaces"
+	internalhttp "um6p.ma/finalproject/internal/http"
+	"um6p.ma/finalproject/models"
+	"um6p.ma/finalproject/validation"
+)
+
+// RoleHandler serves the admin API managing roles, their permissions and the implication rules
+type RoleHandler struct {
+	Store       interfaces.RoleStore
+	Users       interfaces.UserStore       // Checked before deleting a role
+	Invitations interfaces.InvitationStore // Checked before deleting a role
+}
+
+type CreateRoleInput struct {
+	Name             string   `json:"name" validate:"required,role_name"`
+	Description      string   `json:"description" validate:"max=200"`
+	Permissions      []string `json:"permissions" validate:"dive,permission"`
+	RequireTwoFactor bool     `json:"require_two_factor"`
+}
+
+type UpdateRoleInput struct {
+	Description      string   `json:"description" validate:"max=200"`
+	Permissions      []string `json:"permissions" validate:"required,dive,permission"`
+	RequireTwoFactor bool     `json:"require_two_factor"`
+}
+
+type PermissionImplicationInput struct {
+	Permission string `json:"permission" validate:"required,permission"`
+	Implies    string `json:"implies" validate:"required,permission"`
+}
+
+// ListRolesHandler retrieves all roles with their permissions
+func (h *RoleHandler) ListRolesHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	roles, err := h.Store.ListRoles(r.Context())
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+
+	response := make([]map[string]interface{}, 0, len(roles))
+	for _, role := range roles {
+		response = append(response, roleResponse(role))
+	}
+
+	internalhttp.WriteJSON(w, r, http.StatusOK, response)
+}
+
+// GetRoleHandler retrieves a role by name, along with the permissions it is effectively
+// granted once the implication rules apply
+func (h *RoleHandler) GetRoleHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctx := r.Context()
+	role, err := h.Store.GetRole(ctx, ps.ByName("name"))
+	if err != nil {
+		handleRoleStoreError(w, err, ps.ByName("name"))
+		return
+	}
+	implications, err := h.Store.ListPermissionImplications(ctx)
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+
+	response := roleResponse(role)
+	response["effective_permissions"] = internalhttp.ExpandPermissions(role.PermissionNames(), implications)
+
+	internalhttp.WriteJSON(w, r, http.StatusOK, response)
+}
+
+// CreateRoleHandler creates a role with the given permissions
+func (h *RoleHandler) CreateRoleHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var input CreateRoleInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid request body",
+		).WithDebug(err.Error()))
+		return
+	}
+	if errs := validation.Validate(input); len(errs) > 0 {
+		errorhandling.HandleError(w, errorhandling.NewValidationError(errs))
+		return
+	}
+
+	role, err := h.Store.CreateRole(r.Context(), models.Role{
+		Name:             input.Name,
+		Description:      input.Description,
+		RequireTwoFactor: input.RequireTwoFactor,
+		Permissions:      rolePermissions(input.Permissions),
+	})
+	if err != nil {
+		handleRoleStoreError(w, err, "")
+		return
+	}
+	internalhttp.InvalidatePermissions()
+
+	internalhttp.WriteJSON(w, r, http.StatusCreated, roleResponse(role))
+}
+
+// UpdateRoleHandler replaces the description and permissions of a role. The change applies to
+// the users of the role on their next request.
+func (h *RoleHandler) UpdateRoleHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctx := r.Context()
+	name := ps.ByName("name")
+
+	var input UpdateRoleInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid request body",
+		).WithDebug(err.Error()))
+		return
+	}
+	if errs := validation.Validate(input); len(errs) > 0 {
+		errorhandling.HandleError(w, errorhandling.NewValidationError(errs))
+		return
+	}
+
+	claims, ok := internalhttp.GetClaimsFromContext(ctx)
+	if !ok {
+		errorhandling.HandleError(w, errorhandling.ErrMissingToken)
+		return
+	}
+	// Admins cannot lock themselves out of the roles API
+	if name == claims.Role {
+		implications, err := h.Store.ListPermissionImplications(ctx)
+		if err != nil {
+			errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+			return
+		}
+		if !internalhttp.HasPermission(internalhttp.ExpandPermissions(input.Permissions, implications), "manage:roles") {
+			errorhandling.HandleError(w, errorhandling.NewError(
+				http.StatusConflict,
+				errorhandling.ErrCodeConflict,
+				"You cannot remove manage:roles from your own role",
+			))
+			return
+		}
+	}
+
+	role, err := h.Store.UpdateRole(ctx, name, models.Role{
+		Description:      input.Description,
+		RequireTwoFactor: input.RequireTwoFactor,
+		Permissions:      rolePermissions(input.Permissions),
+	})
+	if err != nil {
+		handleRoleStoreError(w, err, name)
+		return
+	}
+	internalhttp.InvalidatePermissions()
+
+	internalhttp.WriteJSON(w, r, http.StatusOK, roleResponse(role))
+}
+
+// DeleteRoleHandler deletes a role that no user or pending invitation refers to. The built-in
+// roles cannot be deleted.
+func (h *RoleHandler) DeleteRoleHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctx := r.Context()
+	name := ps.ByName("name")
+
+	if constants.IsValidRole(name) {
+		errorhandling.HandleError(w, errorhandling.ErrBuiltInRole)
+		return
+	}
+	inUse, err := h.roleInUse(ctx, name)
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+	if inUse {
+		errorhandling.HandleError(w, errorhandling.ErrRoleInUse)
+		return
+	}
+
+	if err := h.Store.DeleteRole(ctx, name); err != nil {
+		handleRoleStoreError(w, err, name)
+		return
+	}
+	internalhttp.InvalidatePermissions()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListPermissionImplicationsHandler retrieves all implication rules
+func (h *RoleHandler) ListPermissionImplicationsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	implications, err := h.Store.ListPermissionImplications(r.Context())
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+
+	response := make([]map[string]interface{}, 0, len(implications))
+	for _, implication := range implications {
+		response = append(response, implicationResponse(implication))
+	}
+
+	internalhttp.WriteJSON(w, r, http.StatusOK, response)
+}
+
+// CreatePermissionImplicationHandler adds an implication rule
+func (h *RoleHandler) CreatePermissionImplicationHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var input PermissionImplicationInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid request body",
+		).WithDebug(err.Error()))
+		return
+	}
+	if errs := validation.Validate(input); len(errs) > 0 {
+		errorhandling.HandleError(w, errorhandling.NewValidationError(errs))
+		return
+	}
+	if input.Permission == input.Implies {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"A permission cannot imply itself",
+		))
+		return
+	}
+
+	implication, err := h.Store.CreatePermissionImplication(r.Context(), models.PermissionImplication{
+		Permission: input.Permission,
+		Implies:    input.Implies,
+	})
+	if err != nil {
+		if errorhandling.IsDuplicateKeyError(err) {
+			errorhandling.HandleError(w, errorhandling.NewError(
+				http.StatusConflict,
+				errorhandling.ErrCodeDuplicateEntry,
+				"Implication rule already exists",
+			))
+			return
+		}
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+	internalhttp.InvalidatePermissions()
+
+	internalhttp.WriteJSON(w, r, http.StatusCreated, implicationResponse(implication))
+}
+
+// DeletePermissionImplicationHandler removes an implication rule
+func (h *RoleHandler) DeletePermissionImplicationHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid ID format",
+		))
+		return
+	}
+
+	if err := h.Store.DeletePermissionImplication(r.Context(), id); err != nil {
+		if errorhandling.IsNotFoundError(err) {
+			errorhandling.HandleError(w, errorhandling.NewNotFoundError("Permission implication", id))
+			return
+		}
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+	internalhttp.InvalidatePermissions()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// roleInUse checks if a user or a pending invitation refers to a role
+func (h *RoleHandler) roleInUse(ctx context.Context, name string) (bool, error) {
+	users, err := h.Users.ListUsers(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, user := range users {
+		if user.Role == name {
+			return true, nil
+		}
+	}
+
+	invitations, err := h.Invitations.ListInvitations(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, invitation := range invitations {
+		if invitation.Role == name && invitation.AcceptedAt == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// rolePermissions builds the permissions of a role from their names, dropping duplicates
+func rolePermissions(names []string) []models.RolePermission {
+	permissions := make([]models.RolePermission, 0, len(names))
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			permissions = append(permissions, models.RolePermission{Permission: name})
+		}
+	}
+	return permissions
+}
+
+// roleResponse is the representation of a role
+func roleResponse(role models.Role) map[string]interface{} {
+	return map[string]interface{}{
+		"name":               role.Name,
+		"description":        role.Description,
+		"permissions":        role.PermissionNames(),
+		"require_two_factor": role.RequireTwoFactor,
+		"built_in":           constants.IsValidRole(role.Name),
+		"created_at":         role.CreatedAt,
+	}
+}
+
+// implicationResponse is the representation of an implication rule
+func implicationResponse(implication models.PermissionImplication) map[string]interface{} {
+	return map[string]interface{}{
+		"id":         implication.ID,
+		"permission": implication.Permission,
+		"implies":    implication.Implies,
+	}
+}
+
+// handleRoleStoreError writes the response for an error returned by the role store
+func handleRoleStoreError(w http.ResponseWriter, err error, name string) {
+	switch {
+	case name != "" && errorhandling.IsNotFoundError(err):
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusNotFound,
+			errorhandling.ErrCodeNotFound,
+			"Role "+name+" not found",
+		))
+	case errorhandling.IsDuplicateKeyError(err):
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusConflict,
+			errorhandling.ErrCodeDuplicateEntry,
+			"Role already exists",
+		))
+	default:
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+	}
+}
+
+// validateRole writes an error response and returns false when a role does not exist
+func validateRole(ctx context.Context, w http.ResponseWriter, store interfaces.RoleStore, name string) bool {
+	if _, err := store.GetRole(ctx, name); err != nil {
+		if errorhandling.IsNotFoundError(err) {
+			errorhandling.HandleError(w, errorhandling.ErrInvalidRole.WithDetails(map[string]string{"role": name}))
+			return false
+		}
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return false
+	}
+	return true
+}
@@ -2,34 +2,56 @@ package handlers
 
 import (
 	"context"
-	"encoding/json"
 	"log"
 	"net/http"
 	"sort"
 	"time"
 
 	"github.com/julienschmidt/httprouter"
-	"um6p.ma/finalproject/database"
+	"um6p.ma/finalproject/app"
+	"um6p.ma/finalproject/constants"
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/interfaces"
+	internalhttp "um6p.ma/finalproject/internal/http"
 	"um6p.ma/finalproject/models"
 )
 
-// GenerateSalesReport generates a report based on database data
-func GenerateSalesReport(ctx context.Context) (models.SalesReport, error) {
+type SalesReportHandler struct {
+	Store  interfaces.ReportStore
+	Orders interfaces.OrderStore
+	Books  interfaces.BookStore
+}
+
+// NewSalesReportHandler creates a SalesReportHandler reading from and writing to the given stores
+func NewSalesReportHandler(stores *app.Stores) *SalesReportHandler {
+	return &SalesReportHandler{
+		Store:  stores.Reports,
+		Orders: stores.Orders,
+		Books:  stores.Books,
+	}
+}
+
+// GenerateSalesReport generates a report based on the orders of the last 24 hours
+func (h *SalesReportHandler) GenerateSalesReport(ctx context.Context) (models.SalesReport, error) {
 	startTime := time.Now().Add(-24 * time.Hour)
 	endTime := time.Now()
 
 	// Fetch all orders from the last 24 hours
-	var orders []models.Order
-	if err := database.DB.Preload("Items").Where("created_at BETWEEN ? AND ?", startTime, endTime).Find(&orders).Error; err != nil {
+	orders, err := h.Orders.GetOrdersInTimeRange(ctx, startTime, endTime)
+	if err != nil {
 		return models.SalesReport{}, err
 	}
 
 	totalRevenue := 0.0
-	totalOrders := len(orders)
+	totalOrders := 0
 	bookSalesMap := make(map[int]int)
 
-	// Calculate total revenue and book sales
+	// Calculate total revenue and book sales, leaving out cancelled orders
 	for _, order := range orders {
+		if order.Status == constants.OrderStatusCancelled {
+			continue
+		}
+		totalOrders++
 		totalRevenue += order.TotalPrice
 		for _, item := range order.Items {
 			bookSalesMap[item.BookID] += item.Quantity
@@ -37,7 +59,7 @@ func GenerateSalesReport(ctx context.Context) (models.SalesReport, error) {
 	}
 
 	// Get top-selling books
-	topSellingBooks, err := calculateTopSellingBooks(bookSalesMap)
+	topSellingBooks, err := h.calculateTopSellingBooks(ctx, bookSalesMap)
 	if err != nil {
 		return models.SalesReport{}, err
 	}
@@ -50,8 +72,9 @@ func GenerateSalesReport(ctx context.Context) (models.SalesReport, error) {
 		TopSellingBooks: topSellingBooks,
 	}
 
-	// Store report in the database
-	if err := database.DB.Create(&report).Error; err != nil {
+	// Store the report
+	report, err = h.Store.CreateReport(ctx, report)
+	if err != nil {
 		return models.SalesReport{}, err
 	}
 
@@ -60,12 +83,12 @@ func GenerateSalesReport(ctx context.Context) (models.SalesReport, error) {
 }
 
 // calculateTopSellingBooks fetches book details for top-selling books
-func calculateTopSellingBooks(bookSalesMap map[int]int) ([]models.BookSales, error) {
+func (h *SalesReportHandler) calculateTopSellingBooks(ctx context.Context, bookSalesMap map[int]int) ([]models.BookSales, error) {
 	var bookSales []models.BookSales
 
 	for bookID, quantity := range bookSalesMap {
-		var book models.Book
-		if err := database.DB.First(&book, bookID).Error; err != nil {
+		book, err := h.Books.GetBook(ctx, bookID)
+		if err != nil {
 			log.Printf("⚠️ Skipping book ID %d due to error: %v", bookID, err)
 			continue
 		}
@@ -90,19 +113,18 @@ func calculateTopSellingBooks(bookSalesMap map[int]int) ([]models.BookSales, err
 }
 
 // GetSalesReportHandler returns the latest reports
-func GetSalesReportHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	var reports []models.SalesReport
-	if err := database.DB.Order("timestamp DESC").Limit(10).Find(&reports).Error; err != nil {
-		http.Error(w, "Failed to fetch sales reports", http.StatusInternalServerError)
+func (h *SalesReportHandler) GetSalesReportHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	reports, err := h.Store.GetLatestReports(r.Context(), 10)
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(reports)
+	internalhttp.WriteJSON(w, r, http.StatusOK, reports)
 }
 
 // StartSalesReportGeneration runs report generation every 24 hours
-func StartSalesReportGeneration(ctx context.Context) {
+func (h *SalesReportHandler) StartSalesReportGeneration(ctx context.Context) {
 	ticker := time.NewTicker(24 * time.Hour)
 	defer ticker.Stop()
 
@@ -112,7 +134,7 @@ func StartSalesReportGeneration(ctx context.Context) {
 			log.Println("🛑 Sales report generation stopped.")
 			return
 		case <-ticker.C:
-			_, err := GenerateSalesReport(ctx)
+			_, err := h.GenerateSalesReport(ctx)
 			if err != nil {
 				log.Printf("❌ Error generating sales report: %v", err)
 			}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/interfaces"
+	internalhttp "um6p.ma/finalproject/internal/http"
+	"um6p.ma/finalproject/internal/loginguard"
+	"um6p.ma/finalproject/models"
+)
+
+// maxSecurityEvents bounds the number of security events listed at once
+const maxSecurityEvents = 500
+
+// SecurityHandler serves the admin API reviewing login lockouts and security events
+type SecurityHandler struct {
+	Users  interfaces.UserStore
+	Guard  *loginguard.Guard
+	Events interfaces.SecurityEventStore
+}
+
+// ListLockoutsHandler retrieves the emails and IP addresses whose logins are locked
+func (h *SecurityHandler) ListLockoutsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	throttles, err := h.Guard.Throttles.ListLockedLogins(r.Context(), time.Now())
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+
+	response := make([]map[string]interface{}, 0, len(throttles))
+	for _, throttle := range throttles {
+		response = append(response, map[string]interface{}{
+			"identifier":      throttle.Identifier,
+			"failures":        throttle.Failures,
+			"last_failure_at": throttle.LastFailureAt,
+			"locked_until":    throttle.LockedUntil,
+		})
+	}
+
+	internalhttp.WriteJSON(w, r, http.StatusOK, response)
+}
+
+// UnlockHandler lifts the lock of an identifier, such as "email:jane@example.com" or
+// "ip:203.0.113.7", and forgets its failed logins
+func (h *SecurityHandler) UnlockHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctx := r.Context()
+	identifier := ps.ByName("identifier")
+
+	var userID *int
+	if email, ok := strings.CutPrefix(identifier, "email:"); ok {
+		user, err := h.Users.GetUserByEmail(ctx, email)
+		if err == nil {
+			userID = &user.ID
+		} else if !errorhandling.IsNotFoundError(err) {
+			errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+			return
+		}
+	}
+	h.unlock(w, r, identifier, userID)
+}
+
+// UnlockUserHandler lifts the lock of the email of a user and forgets its failed logins
+func (h *SecurityHandler) UnlockUserHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid ID format",
+		))
+		return
+	}
+
+	user, err := h.Users.GetUser(r.Context(), id)
+	if err != nil {
+		handleUserStoreError(w, err, id)
+		return
+	}
+	h.unlock(w, r, loginguard.EmailKey(user.Email), &user.ID)
+}
+
+// ListSecurityEventsHandler retrieves the most recent security events, optionally filtered
+// by type and user
+func (h *SecurityHandler) ListSecurityEventsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	query := r.URL.Query()
+	filter := models.SecurityEventFilter{Type: query.Get("type"), Limit: 100}
+	if value := query.Get("user_id"); value != "" {
+		userID, err := strconv.Atoi(value)
+		if err != nil {
+			errorhandling.HandleError(w, errorhandling.ErrInvalidInput.WithDetails("user_id must be a number"))
+			return
+		}
+		filter.UserID = userID
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxSecurityEvents {
+			errorhandling.HandleError(w, errorhandling.ErrInvalidInput.
+				WithDetails("limit must be between 1 and "+strconv.Itoa(maxSecurityEvents)))
+			return
+		}
+		filter.Limit = limit
+	}
+
+	events, err := h.Events.ListSecurityEvents(r.Context(), filter)
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+
+	response := make([]map[string]interface{}, 0, len(events))
+	for _, event := range events {
+		response = append(response, map[string]interface{}{
+			"id":         event.ID,
+			"type":       event.Type,
+			"user_id":    event.UserID,
+			"actor_id":   event.ActorID,
+			"identifier": event.Identifier,
+			"ip":         event.IP,
+			"details":    event.Details,
+			"created_at": event.CreatedAt,
+		})
+	}
+
+	internalhttp.WriteJSON(w, r, http.StatusOK, response)
+}
+
+// unlock lifts the lock of an identifier on behalf of the authenticated admin
+func (h *SecurityHandler) unlock(w http.ResponseWriter, r *http.Request, identifier string, userID *int) {
+	claims, ok := internalhttp.GetClaimsFromContext(r.Context())
+	if !ok {
+		errorhandling.HandleError(w, errorhandling.ErrMissingToken)
+		return
+	}
+
+	if err := h.Guard.Unlock(r.Context(), identifier, claims.UserID, userID); err != nil {
+		if errors.Is(err, errorhandling.ErrThrottleNotFound) {
+			errorhandling.HandleError(w, errorhandling.ErrThrottleNotFound)
+			return
+		}
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
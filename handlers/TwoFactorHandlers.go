@@ -0,0 +1,682 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/crypto/bcrypt"
+	"um6p.ma/finalproject/constants"
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/interfaces"
+	internalhttp "um6p.ma/finalproject/internal/http"
+	"um6p.ma/finalproject/internal/totp"
+	"um6p.ma/finalproject/models"
+	"um6p.ma/finalproject/validation"
+)
+
+const (
+	// maxChallengeAttempts is the number of wrong codes after which a login challenge stops working
+	maxChallengeAttempts = 5
+	// recoveryCodeCount is the number of recovery codes issued at once
+	recoveryCodeCount = 10
+	// totpSkew is the number of time steps a code is accepted before or after its own
+	totpSkew = 1
+)
+
+// TwoFactorHandler serves TOTP enrollment and the second step of logging in. Once a user
+// enabled two-factor authentication, or when their role requires it, LoginUser returns a
+// challenge token instead of a session, which is exchanged for one along with a code.
+type TwoFactorHandler struct {
+	Users        interfaces.UserStore
+	Store        interfaces.TwoFactorStore
+	Roles        interfaces.RoleStore         // Tells which roles require two-factor authentication
+	Challenges   interfaces.AccountTokenStore // Records the login challenges, so that each works once
+	Auth         *AuthHandler                 // Signs the challenges and starts the sessions
+	Issuer       string
+	ChallengeTTL time.Duration
+}
+
+type TwoFactorLoginInput struct {
+	ChallengeToken string `json:"challenge_token" validate:"required"`
+	Code           string `json:"code" validate:"required,max=20"` // TOTP or recovery code
+}
+
+type TwoFactorSetupInput struct {
+	ChallengeToken string `json:"challenge_token" validate:"required"`
+}
+
+type TwoFactorCodeInput struct {
+	Code string `json:"code" validate:"required,max=20"`
+}
+
+type DisableTwoFactorInput struct {
+	Password string `json:"password" validate:"required"`
+	Code     string `json:"code" validate:"required,max=20"` // TOTP or recovery code
+}
+
+// challengeClaims are the claims of login challenges. Their audience keeps them from being
+// accepted as access tokens or account links.
+type challengeClaims struct {
+	jwt.StandardClaims
+}
+
+// CompleteLogin exchanges a login challenge and a TOTP or recovery code for a session. Users
+// enrolling because their role requires it confirm their new secret this way, and receive
+// their recovery codes along with the session.
+func (h *TwoFactorHandler) CompleteLogin(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := r.Context()
+	var input TwoFactorLoginInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid request format",
+		).WithDebug(err.Error()))
+		return
+	}
+	if errs := validation.Validate(input); len(errs) > 0 {
+		errorhandling.HandleError(w, errorhandling.NewValidationError(errs))
+		return
+	}
+
+	user, jti, ok := h.pendingChallenge(w, r, input.ChallengeToken)
+	if !ok {
+		return
+	}
+	// Wrong codes count as failed logins, so that guessing them also locks the account
+	if !h.Auth.checkLoginGuard(w, r, user.Email) {
+		return
+	}
+
+	var recoveryCodes []string
+	if user.TwoFactorEnabled() {
+		err := h.verifyCode(ctx, user, input.Code)
+		if errors.Is(err, errorhandling.ErrInvalidTwoFactorCode) {
+			h.failChallenge(w, r, user, jti)
+			return
+		}
+		if err != nil {
+			errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+			return
+		}
+	} else {
+		if user.TOTPSecret == "" {
+			errorhandling.HandleError(w, errorhandling.ErrTwoFactorNotSetUp.
+				WithDetails("Set up two-factor authentication with POST /login/2fa/setup first"))
+			return
+		}
+		err := h.acceptTOTP(ctx, user, input.Code)
+		if errors.Is(err, errorhandling.ErrInvalidTwoFactorCode) {
+			h.failChallenge(w, r, user, jti)
+			return
+		}
+		if err != nil {
+			errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+			return
+		}
+		if user, recoveryCodes, err = h.enable(ctx, user); err != nil {
+			errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+			return
+		}
+	}
+
+	if _, err := h.Challenges.ConsumeAccountToken(ctx, jti); err != nil {
+		// Another request completed the same challenge first
+		if errors.Is(err, errorhandling.ErrLinkUsed) || errorhandling.IsNotFoundError(err) {
+			errorhandling.HandleError(w, errorhandling.ErrInvalidChallenge)
+			return
+		}
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+
+	refreshToken, ok := h.Auth.startSession(w, r, user)
+	if !ok {
+		return
+	}
+	h.Auth.succeedLogin(r, user.Email)
+	var extra map[string]interface{}
+	if recoveryCodes != nil {
+		extra = map[string]interface{}{"recovery_codes": recoveryCodes}
+	}
+	h.Auth.writeTokens(w, r, user, refreshToken, extra)
+}
+
+// SetupLogin generates the TOTP secret of a user who must enroll before logging in, because
+// their role requires two-factor authentication
+func (h *TwoFactorHandler) SetupLogin(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var input TwoFactorSetupInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid request format",
+		).WithDebug(err.Error()))
+		return
+	}
+	if errs := validation.Validate(input); len(errs) > 0 {
+		errorhandling.HandleError(w, errorhandling.NewValidationError(errs))
+		return
+	}
+
+	user, _, ok := h.pendingChallenge(w, r, input.ChallengeToken)
+	if !ok {
+		return
+	}
+	h.writeSetup(w, r, user)
+}
+
+// GetStatus describes the two-factor authentication of the authenticated user
+func (h *TwoFactorHandler) GetStatus(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := r.Context()
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	required, err := h.roleRequiresTwoFactor(ctx, user.Role)
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+	remaining := 0
+	if user.TwoFactorEnabled() {
+		if remaining, err = h.Store.CountRecoveryCodes(ctx, user.ID); err != nil {
+			errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+			return
+		}
+	}
+
+	internalhttp.WriteJSON(w, r, http.StatusOK, map[string]interface{}{
+		"enabled":                  user.TwoFactorEnabled(),
+		"enabled_at":               user.TOTPEnabledAt,
+		"required":                 required,
+		"recovery_codes_remaining": remaining,
+	})
+}
+
+// Setup generates a new TOTP secret for the authenticated user. Two-factor authentication is
+// only enabled once a code of the secret is confirmed with Enable.
+func (h *TwoFactorHandler) Setup(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	h.writeSetup(w, r, user)
+}
+
+// Enable turns on two-factor authentication for the authenticated user, once they confirm the
+// secret from Setup with a code. The recovery codes are only shown in this response.
+func (h *TwoFactorHandler) Enable(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := r.Context()
+	var input TwoFactorCodeInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid request format",
+		).WithDebug(err.Error()))
+		return
+	}
+	if errs := validation.Validate(input); len(errs) > 0 {
+		errorhandling.HandleError(w, errorhandling.NewValidationError(errs))
+		return
+	}
+
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	if user.TwoFactorEnabled() {
+		errorhandling.HandleError(w, errorhandling.ErrTwoFactorEnabled)
+		return
+	}
+	if user.TOTPSecret == "" {
+		errorhandling.HandleError(w, errorhandling.ErrTwoFactorNotSetUp)
+		return
+	}
+	if err := h.acceptTOTP(ctx, user, input.Code); err != nil {
+		handleTwoFactorError(w, err)
+		return
+	}
+
+	user, recoveryCodes, err := h.enable(ctx, user)
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	internalhttp.WriteJSON(w, r, http.StatusOK, map[string]interface{}{
+		"message":        "Two-factor authentication enabled; store the recovery codes in a safe place",
+		"recovery_codes": recoveryCodes,
+		"user":           userResponse(user),
+	})
+}
+
+// Disable turns off two-factor authentication for the authenticated user, who confirms it with
+// their password and a code. Users whose role requires it cannot turn it off.
+func (h *TwoFactorHandler) Disable(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := r.Context()
+	var input DisableTwoFactorInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid request format",
+		).WithDebug(err.Error()))
+		return
+	}
+	if errs := validation.Validate(input); len(errs) > 0 {
+		errorhandling.HandleError(w, errorhandling.NewValidationError(errs))
+		return
+	}
+
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	if !user.TwoFactorEnabled() {
+		errorhandling.HandleError(w, errorhandling.ErrTwoFactorNotEnabled)
+		return
+	}
+	required, err := h.roleRequiresTwoFactor(ctx, user.Role)
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+	if required {
+		errorhandling.HandleError(w, errorhandling.ErrTwoFactorRequired)
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)); err != nil {
+		errorhandling.HandleError(w, errorhandling.ErrInvalidCredentials)
+		return
+	}
+	if err := h.verifyCode(ctx, user, input.Code); err != nil {
+		handleTwoFactorError(w, err)
+		return
+	}
+
+	if user, err = h.disable(ctx, user); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+
+	log.Printf("🔑 User %d disabled two-factor authentication", user.ID)
+	internalhttp.WriteJSON(w, r, http.StatusOK, map[string]interface{}{
+		"message": "Two-factor authentication disabled",
+		"user":    userResponse(user),
+	})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the authenticated user, who confirms
+// it with a code. Earlier recovery codes stop working.
+func (h *TwoFactorHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := r.Context()
+	var input TwoFactorCodeInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid request format",
+		).WithDebug(err.Error()))
+		return
+	}
+	if errs := validation.Validate(input); len(errs) > 0 {
+		errorhandling.HandleError(w, errorhandling.NewValidationError(errs))
+		return
+	}
+
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	if !user.TwoFactorEnabled() {
+		errorhandling.HandleError(w, errorhandling.ErrTwoFactorNotEnabled)
+		return
+	}
+	if err := h.acceptTOTP(ctx, user, input.Code); err != nil {
+		handleTwoFactorError(w, err)
+		return
+	}
+
+	recoveryCodes, err := h.issueRecoveryCodes(ctx, user.ID)
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	internalhttp.WriteJSON(w, r, http.StatusOK, map[string]interface{}{
+		"recovery_codes": recoveryCodes,
+	})
+}
+
+// ResetTwoFactorHandler turns off two-factor authentication for a user who lost their device.
+// If their role requires it, they enroll again at their next login.
+func (h *TwoFactorHandler) ResetTwoFactorHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctx := r.Context()
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid ID format",
+		))
+		return
+	}
+	claims, ok := internalhttp.GetClaimsFromContext(ctx)
+	if !ok {
+		errorhandling.HandleError(w, errorhandling.ErrMissingToken)
+		return
+	}
+
+	user, err := h.Users.GetUser(ctx, id)
+	if err != nil {
+		handleUserStoreError(w, err, id)
+		return
+	}
+	if user.TOTPSecret == "" && !user.TwoFactorEnabled() {
+		errorhandling.HandleError(w, errorhandling.ErrTwoFactorNotEnabled)
+		return
+	}
+	if _, err := h.disable(ctx, user); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+
+	log.Printf("🔑 User %d reset the two-factor authentication of user %d", claims.UserID, id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requiresChallenge reports whether logging a user in takes a second step, and whether the
+// user must enroll during that step because their role requires two-factor authentication
+func (h *TwoFactorHandler) requiresChallenge(ctx context.Context, user models.User) (challenge, enroll bool, err error) {
+	if user.TwoFactorEnabled() {
+		return true, false, nil
+	}
+	required, err := h.roleRequiresTwoFactor(ctx, user.Role)
+	return required, required, err
+}
+
+// writeChallenge answers the first login step of a user with a challenge token
+func (h *TwoFactorHandler) writeChallenge(w http.ResponseWriter, r *http.Request, user models.User, enroll bool) {
+	jti, err := randomToken(16)
+	if err != nil {
+		handleTokenError(w, err)
+		return
+	}
+	now := time.Now()
+	expiresAt := now.Add(h.ChallengeTTL)
+	token, err := h.Auth.Keys.Sign(jwt.MapClaims{
+		"jti": jti,
+		"sub": strconv.Itoa(user.ID),
+		"aud": constants.TokenPurposeLoginChallenge,
+		"iat": now.Unix(),
+		"exp": expiresAt.Unix(),
+	})
+	if err != nil {
+		handleTokenError(w, err)
+		return
+	}
+	// Starting another login supersedes the pending challenge of the user
+	if _, err := h.Challenges.CreateAccountToken(r.Context(), models.AccountToken{
+		JTI:       jti,
+		UserID:    user.ID,
+		Purpose:   constants.TokenPurposeLoginChallenge,
+		ExpiresAt: expiresAt,
+	}); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	internalhttp.WriteJSON(w, r, http.StatusOK, map[string]interface{}{
+		"mfa_required":        true,
+		"enrollment_required": enroll,
+		"challenge_token":     token,
+		"expires_in":          int(h.ChallengeTTL.Seconds()),
+	})
+}
+
+// pendingChallenge verifies a login challenge that was neither completed nor failed too many
+// times. It returns the user and the JTI of the challenge, or writes an error response and
+// returns false.
+func (h *TwoFactorHandler) pendingChallenge(w http.ResponseWriter, r *http.Request, token string) (models.User, string, bool) {
+	ctx := r.Context()
+	claims := &challengeClaims{}
+	if err := h.Auth.Keys.Verify(token, claims); err != nil {
+		errorhandling.HandleError(w, errorhandling.ErrInvalidChallenge.WithDebug(err.Error()))
+		return models.User{}, "", false
+	}
+	if !claims.VerifyAudience(constants.TokenPurposeLoginChallenge, true) {
+		errorhandling.HandleError(w, errorhandling.ErrInvalidChallenge.WithDebug("Token has another purpose"))
+		return models.User{}, "", false
+	}
+
+	challenge, err := h.Challenges.GetAccountToken(ctx, claims.Id)
+	if err != nil {
+		if errorhandling.IsNotFoundError(err) {
+			errorhandling.HandleError(w, errorhandling.ErrInvalidChallenge)
+			return models.User{}, "", false
+		}
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return models.User{}, "", false
+	}
+	if challenge.UsedAt != nil || time.Now().After(challenge.ExpiresAt) {
+		errorhandling.HandleError(w, errorhandling.ErrInvalidChallenge)
+		return models.User{}, "", false
+	}
+
+	user, err := h.Users.GetUser(ctx, challenge.UserID)
+	if err != nil {
+		if errorhandling.IsNotFoundError(err) {
+			errorhandling.HandleError(w, errorhandling.ErrInvalidChallenge)
+			return models.User{}, "", false
+		}
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return models.User{}, "", false
+	}
+	if user.Disabled {
+		errorhandling.HandleError(w, errorhandling.ErrAccountDisabled)
+		return models.User{}, "", false
+	}
+
+	return user, challenge.JTI, true
+}
+
+// failChallenge counts a wrong code against a login challenge, which stops working after
+// maxChallengeAttempts of them, and as a failed login of its user
+func (h *TwoFactorHandler) failChallenge(w http.ResponseWriter, r *http.Request, user models.User, jti string) {
+	h.Auth.failLogin(r, user.Email, &user.ID)
+	challenge, err := h.Challenges.RecordFailedAttempt(r.Context(), jti, maxChallengeAttempts)
+	if err != nil && !errorhandling.IsNotFoundError(err) {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+	if challenge.Attempts >= maxChallengeAttempts {
+		log.Printf("⚠️ Login challenge of user %d failed %d times, log in again", challenge.UserID, challenge.Attempts)
+		errorhandling.HandleError(w, errorhandling.ErrInvalidTwoFactorCode.
+			WithDetails("Too many wrong codes; log in again"))
+		return
+	}
+	errorhandling.HandleError(w, errorhandling.ErrInvalidTwoFactorCode.
+		WithDetails(strconv.Itoa(maxChallengeAttempts-challenge.Attempts)+" attempts left"))
+}
+
+// writeSetup generates and saves a new TOTP secret for a user, and writes it along with its
+// provisioning URI. The secret replaces any earlier one that was not confirmed yet.
+func (h *TwoFactorHandler) writeSetup(w http.ResponseWriter, r *http.Request, user models.User) {
+	if user.TwoFactorEnabled() {
+		errorhandling.HandleError(w, errorhandling.ErrTwoFactorEnabled)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusInternalServerError,
+			errorhandling.ErrCodeInternalServer,
+			"Failed to generate the secret",
+		).WithDebug(err.Error()))
+		return
+	}
+	user.TOTPSecret = secret
+	if _, err := h.Users.UpdateUser(r.Context(), user.ID, user); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	internalhttp.WriteJSON(w, r, http.StatusOK, map[string]interface{}{
+		"secret":           secret,
+		"provisioning_uri": totp.ProvisioningURI(h.Issuer, user.Email, secret),
+		"digits":           totp.Digits,
+		"period":           int(totp.Period / time.Second),
+	})
+}
+
+// verifyCode checks a TOTP code or a recovery code of a user, using it up. It returns
+// ErrInvalidTwoFactorCode for wrong or reused codes.
+func (h *TwoFactorHandler) verifyCode(ctx context.Context, user models.User, code string) error {
+	code = normalizeCode(code)
+	if len(code) == totp.Digits && isDigits(code) {
+		return h.acceptTOTP(ctx, user, code)
+	}
+
+	if err := h.Store.UseRecoveryCode(ctx, user.ID, hashRefreshToken(code)); err != nil {
+		return err
+	}
+	log.Printf("🔑 User %d used a recovery code", user.ID)
+	return nil
+}
+
+// acceptTOTP checks a TOTP code against the secret of a user, and records its time step so
+// that it cannot be used again
+func (h *TwoFactorHandler) acceptTOTP(ctx context.Context, user models.User, code string) error {
+	step, ok := totp.Validate(user.TOTPSecret, normalizeCode(code), time.Now(), totpSkew)
+	if !ok {
+		return errorhandling.ErrInvalidTwoFactorCode
+	}
+	return h.Store.AcceptTOTPStep(ctx, user.ID, step)
+}
+
+// enable turns on two-factor authentication for a user with a confirmed secret, and issues
+// their recovery codes
+func (h *TwoFactorHandler) enable(ctx context.Context, user models.User) (models.User, []string, error) {
+	// Reloaded, since accepting the code moved the last TOTP step of the user
+	user, err := h.Users.GetUser(ctx, user.ID)
+	if err != nil {
+		return models.User{}, nil, err
+	}
+	now := time.Now()
+	user.TOTPEnabledAt = &now
+	if user, err = h.Users.UpdateUser(ctx, user.ID, user); err != nil {
+		return models.User{}, nil, err
+	}
+
+	recoveryCodes, err := h.issueRecoveryCodes(ctx, user.ID)
+	if err != nil {
+		return models.User{}, nil, err
+	}
+
+	log.Printf("🔑 User %d enabled two-factor authentication", user.ID)
+	return user, recoveryCodes, nil
+}
+
+// disable turns off two-factor authentication for a user, dropping their secret and recovery codes
+func (h *TwoFactorHandler) disable(ctx context.Context, user models.User) (models.User, error) {
+	user.TOTPSecret = ""
+	user.TOTPEnabledAt = nil
+	user, err := h.Users.UpdateUser(ctx, user.ID, user)
+	if err != nil {
+		return models.User{}, err
+	}
+	if err := h.Store.ReplaceRecoveryCodes(ctx, user.ID, nil); err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+// issueRecoveryCodes generates new recovery codes for a user, replacing the earlier ones.
+// Only their hashes are stored.
+func (h *TwoFactorHandler) issueRecoveryCodes(ctx context.Context, userID int) ([]string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := hex.EncodeToString(b)
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, hashRefreshToken(code))
+	}
+
+	if err := h.Store.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// roleRequiresTwoFactor reports whether the users of a role must use two-factor authentication
+func (h *TwoFactorHandler) roleRequiresTwoFactor(ctx context.Context, name string) (bool, error) {
+	role, err := h.Roles.GetRole(ctx, name)
+	if err != nil {
+		if errorhandling.IsNotFoundError(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return role.RequireTwoFactor, nil
+}
+
+// currentUser loads the authenticated user, or writes an error response and returns false
+func (h *TwoFactorHandler) currentUser(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	claims, ok := internalhttp.GetClaimsFromContext(r.Context())
+	if !ok {
+		errorhandling.HandleError(w, errorhandling.ErrMissingToken)
+		return models.User{}, false
+	}
+	user, err := h.Users.GetUser(r.Context(), claims.UserID)
+	if err != nil {
+		handleUserStoreError(w, err, claims.UserID)
+		return models.User{}, false
+	}
+	return user, true
+}
+
+// handleTwoFactorError writes the response for a failure to verify a code
+func handleTwoFactorError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errorhandling.ErrInvalidTwoFactorCode) {
+		errorhandling.HandleError(w, errorhandling.ErrInvalidTwoFactorCode)
+		return
+	}
+	errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+}
+
+// normalizeCode drops the separators users may type in codes, and lowercases recovery codes
+func normalizeCode(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
+
+// isDigits reports whether s only contains ASCII digits
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
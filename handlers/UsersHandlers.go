@@ -0,0 +1,397 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/crypto/bcrypt"
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/interfaces"
+	internalhttp "um6p.ma/finalproject/internal/http"
+	"um6p.ma/finalproject/models"
+	"um6p.ma/finalproject/validation"
+)
+
+// UserHandler serves the admin API managing user accounts and invitations
+type UserHandler struct {
+	Store         interfaces.UserStore
+	Invitations   interfaces.InvitationStore
+	Roles         interfaces.RoleStore // Roles assigned to users and invitations must exist
+	Auth          *AuthHandler         // Revokes the sessions of updated and deleted users
+	InvitationTTL time.Duration
+	PublicURL     string
+}
+
+type CreateUserInput struct {
+	Name     string `json:"name" validate:"required,min=2,max=100"`
+	Email    string `json:"email" validate:"required,email,custom_email"`
+	Password string `json:"password" validate:"required,min=8,max=100,passwd"`
+	Role     string `json:"role" validate:"required,role_name"`
+}
+
+type UpdateUserInput struct {
+	Role     *string `json:"role" validate:"omitempty,role_name"`
+	Disabled *bool   `json:"disabled"`
+}
+
+type InvitationInput struct {
+	Email string `json:"email" validate:"omitempty,email,custom_email"`
+	Role  string `json:"role" validate:"required,role_name"`
+}
+
+// ListUsersHandler retrieves all user accounts
+func (h *UserHandler) ListUsersHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	users, err := h.Store.ListUsers(r.Context())
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+
+	response := make([]map[string]interface{}, 0, len(users))
+	for _, user := range users {
+		response = append(response, userResponse(user))
+	}
+
+	internalhttp.WriteJSON(w, r, http.StatusOK, response)
+}
+
+// GetUserHandler retrieves a user account by ID
+func (h *UserHandler) GetUserHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid ID format",
+		))
+		return
+	}
+
+	user, err := h.Store.GetUser(r.Context(), id)
+	if err != nil {
+		handleUserStoreError(w, err, id)
+		return
+	}
+
+	internalhttp.WriteJSON(w, r, http.StatusOK, userResponse(user))
+}
+
+// CreateUserHandler creates a user account with any role
+func (h *UserHandler) CreateUserHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var input CreateUserInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid request body",
+		).WithDebug(err.Error()))
+		return
+	}
+	if errs := validation.Validate(input); len(errs) > 0 {
+		errorhandling.HandleError(w, errorhandling.NewValidationError(errs))
+		return
+	}
+	if !validateRole(r.Context(), w, h.Roles, input.Role) {
+		return
+	}
+
+	hashedPassword, err := hashPassword(input.Password)
+	if err != nil {
+		handlePasswordError(w, err)
+		return
+	}
+
+	user, err := h.Store.CreateUser(r.Context(), models.User{
+		Name:     input.Name,
+		Email:    input.Email,
+		Password: hashedPassword,
+		Role:     input.Role,
+	})
+	if err != nil {
+		handleUserStoreError(w, err, 0)
+		return
+	}
+
+	internalhttp.WriteJSON(w, r, http.StatusCreated, userResponse(user))
+}
+
+// UpdateUserHandler changes the role of a user account or disables it. The sessions of the
+// user are revoked, so that their tokens cannot outlive the change.
+func (h *UserHandler) UpdateUserHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctx := r.Context()
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid ID format",
+		))
+		return
+	}
+
+	var input UpdateUserInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid request body",
+		).WithDebug(err.Error()))
+		return
+	}
+	if errs := validation.Validate(input); len(errs) > 0 {
+		errorhandling.HandleError(w, errorhandling.NewValidationError(errs))
+		return
+	}
+
+	claims, ok := internalhttp.GetClaimsFromContext(ctx)
+	if !ok {
+		errorhandling.HandleError(w, errorhandling.ErrMissingToken)
+		return
+	}
+	// Admins cannot lock themselves out
+	if id == claims.UserID {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusConflict,
+			errorhandling.ErrCodeConflict,
+			"You cannot change the role of or disable your own account",
+		))
+		return
+	}
+
+	user, err := h.Store.GetUser(ctx, id)
+	if err != nil {
+		handleUserStoreError(w, err, id)
+		return
+	}
+	changed := false
+	if input.Role != nil && *input.Role != user.Role {
+		if !validateRole(ctx, w, h.Roles, *input.Role) {
+			return
+		}
+		user.Role = *input.Role
+		changed = true
+	}
+	if input.Disabled != nil && *input.Disabled != user.Disabled {
+		user.Disabled = *input.Disabled
+		changed = true
+	}
+
+	if changed {
+		user, err = h.Store.UpdateUser(ctx, id, user)
+		if err != nil {
+			handleUserStoreError(w, err, id)
+			return
+		}
+		if err := h.Auth.revokeUserTokens(ctx, id, time.Now(), claims.UserID); err != nil {
+			errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+			return
+		}
+	}
+
+	internalhttp.WriteJSON(w, r, http.StatusOK, userResponse(user))
+}
+
+// DeleteUserHandler deletes a user account and revokes its sessions
+func (h *UserHandler) DeleteUserHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctx := r.Context()
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid ID format",
+		))
+		return
+	}
+
+	claims, ok := internalhttp.GetClaimsFromContext(ctx)
+	if !ok {
+		errorhandling.HandleError(w, errorhandling.ErrMissingToken)
+		return
+	}
+	if id == claims.UserID {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusConflict,
+			errorhandling.ErrCodeConflict,
+			"You cannot delete your own account",
+		))
+		return
+	}
+
+	if _, err := h.Store.GetUser(ctx, id); err != nil {
+		handleUserStoreError(w, err, id)
+		return
+	}
+	if err := h.Auth.revokeUserTokens(ctx, id, time.Now(), claims.UserID); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+	if err := h.Store.DeleteUser(ctx, id); err != nil {
+		handleUserStoreError(w, err, id)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateInvitationHandler issues a one-time signup link bound to a role
+func (h *UserHandler) CreateInvitationHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := r.Context()
+	var input InvitationInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid request body",
+		).WithDebug(err.Error()))
+		return
+	}
+	if errs := validation.Validate(input); len(errs) > 0 {
+		errorhandling.HandleError(w, errorhandling.NewValidationError(errs))
+		return
+	}
+	if !validateRole(ctx, w, h.Roles, input.Role) {
+		return
+	}
+
+	claims, ok := internalhttp.GetClaimsFromContext(ctx)
+	if !ok {
+		errorhandling.HandleError(w, errorhandling.ErrMissingToken)
+		return
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		handleTokenError(w, err)
+		return
+	}
+	invitation, err := h.Invitations.CreateInvitation(ctx, models.Invitation{
+		TokenHash: hashRefreshToken(token),
+		Email:     input.Email,
+		Role:      input.Role,
+		InvitedBy: claims.UserID,
+		ExpiresAt: time.Now().Add(h.InvitationTTL),
+	})
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+
+	// The token is only returned here; the store keeps its hash
+	response := invitationResponse(invitation)
+	response["token"] = token
+	response["signup_url"] = h.PublicURL + "/signup/" + token
+
+	internalhttp.WriteJSON(w, r, http.StatusCreated, response)
+}
+
+// ListInvitationsHandler retrieves all invitations
+func (h *UserHandler) ListInvitationsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	invitations, err := h.Invitations.ListInvitations(r.Context())
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+
+	response := make([]map[string]interface{}, 0, len(invitations))
+	for _, invitation := range invitations {
+		response = append(response, invitationResponse(invitation))
+	}
+
+	internalhttp.WriteJSON(w, r, http.StatusOK, response)
+}
+
+// DeleteInvitationHandler revokes an invitation
+func (h *UserHandler) DeleteInvitationHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusBadRequest,
+			errorhandling.ErrCodeInvalidInput,
+			"Invalid ID format",
+		))
+		return
+	}
+
+	if err := h.Invitations.DeleteInvitation(r.Context(), id); err != nil {
+		if errorhandling.IsNotFoundError(err) {
+			errorhandling.HandleError(w, errorhandling.NewNotFoundError("Invitation", id))
+			return
+		}
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// userResponse is the representation of a user account, without its password
+func userResponse(user models.User) map[string]interface{} {
+	return map[string]interface{}{
+		"id":         user.ID,
+		"name":       user.Name,
+		"email":      user.Email,
+		"role":       user.Role,
+		"disabled":   user.Disabled,
+		"created_at": user.CreatedAt,
+
+		"email_verified_at":  user.EmailVerifiedAt,
+		"two_factor_enabled": user.TwoFactorEnabled(),
+	}
+}
+
+// invitationResponse is the representation of an invitation, without its token
+func invitationResponse(invitation models.Invitation) map[string]interface{} {
+	status := "pending"
+	if invitation.AcceptedAt != nil {
+		status = "accepted"
+	} else if time.Now().After(invitation.ExpiresAt) {
+		status = "expired"
+	}
+
+	return map[string]interface{}{
+		"id":          invitation.ID,
+		"email":       invitation.Email,
+		"role":        invitation.Role,
+		"status":      status,
+		"invited_by":  invitation.InvitedBy,
+		"created_at":  invitation.CreatedAt,
+		"expires_at":  invitation.ExpiresAt,
+		"accepted_at": invitation.AcceptedAt,
+		"user_id":     invitation.UserID,
+	}
+}
+
+// hashPassword hashes a password for storage
+func hashPassword(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hashed), err
+}
+
+// handlePasswordError writes the response for a failure to hash a password
+func handlePasswordError(w http.ResponseWriter, err error) {
+	errorhandling.HandleError(w, errorhandling.NewError(
+		http.StatusInternalServerError,
+		errorhandling.ErrCodeInternalServer,
+		"Failed to process password",
+	).WithDebug(err.Error()))
+}
+
+// handleUserStoreError writes the response for an error returned by the user store
+func handleUserStoreError(w http.ResponseWriter, err error, id int) {
+	switch {
+	case id != 0 && errorhandling.IsNotFoundError(err):
+		errorhandling.HandleError(w, errorhandling.NewNotFoundError("User", id))
+	case errorhandling.IsDuplicateKeyError(err):
+		errorhandling.HandleError(w, errorhandling.NewError(
+			http.StatusConflict,
+			errorhandling.ErrCodeDuplicateEntry,
+			"Email already registered",
+		))
+	default:
+		errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+	}
+}
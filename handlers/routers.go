@@ -3,25 +3,107 @@ package handlers
 import (
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
-	"um6p.ma/finalproject/constants"
+	"um6p.ma/finalproject/app"
+	"um6p.ma/finalproject/config"
 	"um6p.ma/finalproject/errorhandling"
-	"um6p.ma/finalproject/inmemorystores"
+	"um6p.ma/finalproject/internal/authz"
 	httputil "um6p.ma/finalproject/internal/http"
+	"um6p.ma/finalproject/internal/loginguard"
+	"um6p.ma/finalproject/mailer"
 )
 
-// SetupRouter initializes and returns the router
-func SetupRouter() *httprouter.Router {
-	bookStore := inmemorystores.NewInMemoryBookStore()
-	authorStore := inmemorystores.NewInMemoryAuthorStore()
-	customerStore := inmemorystores.NewInMemoryCustomerStore()
-	orderStore := inmemorystores.NewInMemoryOrderStore(bookStore)
+// SetupRouter initializes and returns the router, injecting the given stores into the handlers
+func SetupRouter(stores *app.Stores, keyring *httputil.Keyring, policy *authz.Engine, mail mailer.Mailer, cfg config.Config) *httprouter.Router {
+	accountHandler := AccountHandler{
+		Users:                stores.Users,
+		Tokens:               stores.AccountTokens,
+		Mailer:               mail,
+		PublicURL:            cfg.PublicURL,
+		PasswordResetTTL:     cfg.PasswordResetTTL,
+		EmailVerificationTTL: cfg.EmailVerificationTTL,
+	}
+	guard := loginguard.New(stores.LoginThrottles, stores.SecurityEvents, loginguard.Policy{
+		MaxFailures:     cfg.LoginMaxFailures,
+		IPMaxFailures:   cfg.LoginIPMaxFailures,
+		LockoutDuration: cfg.LoginLockoutDuration,
+		BackoffBase:     cfg.LoginBackoffBase,
+	})
+	authHandler := AuthHandler{
+		Store:            stores.Users,
+		Customers:        stores.Customers,
+		Keys:             keyring,
+		Tokens:           stores.RefreshTokens,
+		Invitations:      stores.Invitations,
+		Revocations:      stores.Revocations,
+		Accounts:         &accountHandler,
+		Guard:            guard,
+		AccessTokenTTL:   cfg.AccessTokenTTL,
+		RefreshTokenTTL:  cfg.RefreshTokenTTL,
+		ImpersonationTTL: cfg.ImpersonationTTL,
+	}
+	accountHandler.Auth = &authHandler
+	twoFactorHandler := TwoFactorHandler{
+		Users:        stores.Users,
+		Store:        stores.TwoFactor,
+		Roles:        stores.Roles,
+		Challenges:   stores.AccountTokens,
+		Auth:         &authHandler,
+		Issuer:       cfg.TOTPIssuer,
+		ChallengeTTL: cfg.MFAChallengeTTL,
+	}
+	authHandler.TwoFactor = &twoFactorHandler
+	userHandler := UserHandler{
+		Store:         stores.Users,
+		Invitations:   stores.Invitations,
+		Roles:         stores.Roles,
+		Auth:          &authHandler,
+		InvitationTTL: cfg.InvitationTTL,
+		PublicURL:     cfg.PublicURL,
+	}
+	roleHandler := RoleHandler{Store: stores.Roles, Users: stores.Users, Invitations: stores.Invitations}
+	apiKeyHandler := APIKeyHandler{Store: stores.APIKeys}
+	securityHandler := SecurityHandler{Users: stores.Users, Guard: guard, Events: stores.SecurityEvents}
+	impersonationHandler := ImpersonationHandler{
+		Users:       stores.Users,
+		Events:      stores.SecurityEvents,
+		Audit:       stores.ImpersonationAudit,
+		Auth:        &authHandler,
+		TTL:         cfg.ImpersonationTTL,
+		AllowWrites: cfg.ImpersonationAllowWrites,
+	}
+	bookHandler := BookHandler{Store: stores.Books}
+	authorHandler := AuthorHandler{Store: stores.Authors, Books: stores.Books}
+	customerHandler := CustomerHandler{Store: stores.Customers, Orders: stores.Orders}
+	orderHandler := OrderHandler{Store: stores.Orders, Customers: stores.Customers, Users: stores.Users}
+	meHandler := MeHandler{Users: stores.Users, Customers: stores.Customers, Orders: stores.Orders}
+	salesReportHandler := NewSalesReportHandler(stores)
+
+	httputil.SetKeyring(keyring)
+	httputil.SetTokenRevocationStore(stores.Revocations)
+	httputil.SetRoleStore(stores.Roles)
+	httputil.SetAPIKeyStore(stores.APIKeys)
+	httputil.SetTrustProxy(cfg.TrustProxy)
+	httputil.SetImpersonationAuditStore(stores.ImpersonationAudit)
+	errorhandling.SetDebug(cfg.DebugErrors)
 
-	bookHandler := BookHandler{Store: bookStore}
-	authorHandler := AuthorHandler{Store: authorStore}
-	customerHandler := CustomerHandler{Store: customerStore}
-	orderHandler := OrderHandler{Store: orderStore}
+	// Request budgets of each client, shared by the routes limited by the same budget
+	limiter := httputil.NewRateLimiter(cfg.RateLimiting)
+	standard := limiter.Budget("standard", 120, time.Minute)
+	search := limiter.Budget("search", 30, time.Minute)   // Filtered listings, which scan whole tables
+	auth := limiter.Budget("auth", 10, time.Minute)       // Password and link checks, by unauthenticated clients
+	reports := limiter.Budget("reports", 10, time.Minute) // Sales reports aggregate every order
+
+	// Catalog, customer, order and report routes are decided by the authorization policy, which
+	// tests the attributes of the resources loaded here
+	authorizer := httputil.NewAuthorizer(policy, map[string]httputil.ResourceLoader{
+		"book":     httputil.BookResource(stores.Books),
+		"customer": httputil.CustomerResource(stores.Customers),
+		"order":    httputil.OrderResource(stores.Orders, stores.Customers),
+	})
+	authzHandler := AuthzHandler{Users: stores.Users, Authorizer: authorizer, Policy: policy}
 
 	router := httprouter.New()
 
@@ -35,39 +117,112 @@ func SetupRouter() *httprouter.Router {
 	}
 
 	// Public routes (no authentication required)
-	router.POST("/login", LoginUser)
-	router.POST("/register", RegisterUser)
+	router.POST("/login", auth.Limit(authHandler.LoginUser))
+	router.POST("/login/2fa", auth.Limit(twoFactorHandler.CompleteLogin))
+	router.POST("/login/2fa/setup", auth.Limit(twoFactorHandler.SetupLogin))
+	router.POST("/register", auth.Limit(authHandler.RegisterUser))
+	router.POST("/token/refresh", auth.Limit(authHandler.RefreshToken))
+	router.GET("/.well-known/jwks.json", standard.Limit(authHandler.GetJWKS))
+	router.GET("/signup/:token", standard.Limit(authHandler.GetInvitation))
+	router.POST("/signup/:token", auth.Limit(authHandler.AcceptInvitation))
+	router.POST("/password/forgot", auth.Limit(accountHandler.ForgotPassword))
+	router.GET("/password/reset", auth.Limit(accountHandler.CheckPasswordResetLink))
+	router.POST("/password/reset", auth.Limit(accountHandler.ResetPassword))
+	router.GET("/email/verify", auth.Limit(accountHandler.VerifyEmailLink))
+	router.POST("/email/verify", auth.Limit(accountHandler.VerifyEmail))
+
+	// Session routes
+	router.POST("/logout", standard.Limit(httputil.WrapWithAuth(authHandler.Logout)))
+	router.POST("/logout-all", standard.Limit(httputil.WrapWithOwnCredentials(authHandler.LogoutAll)))
+	router.POST("/email/verify/resend", auth.Limit(httputil.WrapWithAuth(accountHandler.ResendVerificationEmail)))
+	router.POST("/tokens/revoke", standard.Limit(httputil.Wrap(authHandler.RevokeTokens, "revoke:tokens")))
+
+	// Two-factor authentication routes
+	router.GET("/2fa", standard.Limit(httputil.WrapWithAuth(twoFactorHandler.GetStatus)))
+	router.POST("/2fa/setup", standard.Limit(httputil.WrapWithOwnCredentials(twoFactorHandler.Setup)))
+	router.POST("/2fa/enable", standard.Limit(httputil.WrapWithOwnCredentials(twoFactorHandler.Enable)))
+	router.POST("/2fa/disable", standard.Limit(httputil.WrapWithOwnCredentials(twoFactorHandler.Disable)))
+	router.POST("/2fa/recovery-codes", standard.Limit(httputil.WrapWithOwnCredentials(twoFactorHandler.RegenerateRecoveryCodes)))
+
+	// Self-service routes, scoped to the customer record of the authenticated user
+	router.GET("/me", standard.Limit(httputil.WrapWithAuth(meHandler.GetMe)))
+	router.PATCH("/me", standard.Limit(httputil.WrapWithAuth(meHandler.UpdateMe)))
+	router.GET("/me/orders", standard.Limit(httputil.WrapWithAuth(meHandler.ListMyOrders)))
+	router.GET("/me/orders/:id", standard.Limit(httputil.WrapWithAuth(meHandler.GetMyOrder)))
+	router.POST("/me/orders", standard.Limit(httputil.WrapWithMiddleware(meHandler.CreateMyOrder, httputil.RequireAuth, httputil.RequireUser, httputil.RequirePermission("write:orders"), httputil.ScopeToCaller)))
+	router.GET("/me/addresses", standard.Limit(httputil.WrapWithAuth(meHandler.ListMyAddresses)))
+	router.POST("/me/addresses", standard.Limit(httputil.WrapWithAuth(meHandler.CreateMyAddress)))
+	router.PUT("/me/addresses/:id", standard.Limit(httputil.WrapWithAuth(meHandler.UpdateMyAddress)))
+	router.DELETE("/me/addresses/:id", standard.Limit(httputil.WrapWithAuth(meHandler.DeleteMyAddress)))
+
+	// Users routes - Admin only
+	router.GET("/users", standard.Limit(httputil.Wrap(userHandler.ListUsersHandler, "manage:users")))
+	router.GET("/users/:id", standard.Limit(httputil.Wrap(userHandler.GetUserHandler, "manage:users")))
+	router.POST("/users", standard.Limit(httputil.Wrap(userHandler.CreateUserHandler, "manage:users")))
+	router.PATCH("/users/:id", standard.Limit(httputil.Wrap(userHandler.UpdateUserHandler, "manage:users")))
+	router.DELETE("/users/:id", standard.Limit(httputil.Wrap(userHandler.DeleteUserHandler, "manage:users")))
+	router.DELETE("/users/:id/2fa", standard.Limit(httputil.Wrap(twoFactorHandler.ResetTwoFactorHandler, "manage:users")))
+	router.POST("/users/:id/unlock", standard.Limit(httputil.Wrap(securityHandler.UnlockUserHandler, "manage:users")))
+	router.POST("/users/:id/impersonate", auth.Limit(httputil.WrapWithMiddleware(impersonationHandler.ImpersonateUserHandler, httputil.RequireAuth, httputil.RequireUser, httputil.RequireOwnCredentials, httputil.RequirePermission("manage:users"))))
+	router.GET("/impersonated-requests", standard.Limit(httputil.Wrap(impersonationHandler.ListImpersonatedRequestsHandler, "manage:users")))
+	router.GET("/login-lockouts", standard.Limit(httputil.Wrap(securityHandler.ListLockoutsHandler, "manage:users")))
+	router.DELETE("/login-lockouts/:identifier", standard.Limit(httputil.Wrap(securityHandler.UnlockHandler, "manage:users")))
+	router.GET("/security-events", standard.Limit(httputil.Wrap(securityHandler.ListSecurityEventsHandler, "manage:users")))
+	router.GET("/invitations", standard.Limit(httputil.Wrap(userHandler.ListInvitationsHandler, "manage:users")))
+	router.POST("/invitations", standard.Limit(httputil.Wrap(userHandler.CreateInvitationHandler, "manage:users")))
+	router.DELETE("/invitations/:id", standard.Limit(httputil.Wrap(userHandler.DeleteInvitationHandler, "manage:users")))
+
+	// Roles routes - Admin only
+	router.GET("/roles", standard.Limit(httputil.Wrap(roleHandler.ListRolesHandler, "manage:roles")))
+	router.GET("/roles/:name", standard.Limit(httputil.Wrap(roleHandler.GetRoleHandler, "manage:roles")))
+	router.POST("/roles", standard.Limit(httputil.Wrap(roleHandler.CreateRoleHandler, "manage:roles")))
+	router.PUT("/roles/:name", standard.Limit(httputil.Wrap(roleHandler.UpdateRoleHandler, "manage:roles")))
+	router.DELETE("/roles/:name", standard.Limit(httputil.Wrap(roleHandler.DeleteRoleHandler, "manage:roles")))
+	router.GET("/permission-implications", standard.Limit(httputil.Wrap(roleHandler.ListPermissionImplicationsHandler, "manage:roles")))
+	router.POST("/permission-implications", standard.Limit(httputil.Wrap(roleHandler.CreatePermissionImplicationHandler, "manage:roles")))
+	router.DELETE("/permission-implications/:id", standard.Limit(httputil.Wrap(roleHandler.DeletePermissionImplicationHandler, "manage:roles")))
+
+	// API keys routes - Admin only
+	router.GET("/api-keys", standard.Limit(httputil.Wrap(apiKeyHandler.ListAPIKeysHandler, "manage:api_keys")))
+	router.GET("/api-keys/:id", standard.Limit(httputil.Wrap(apiKeyHandler.GetAPIKeyHandler, "manage:api_keys")))
+	router.POST("/api-keys", standard.Limit(httputil.Wrap(apiKeyHandler.CreateAPIKeyHandler, "manage:api_keys")))
+	router.DELETE("/api-keys/:id", standard.Limit(httputil.Wrap(apiKeyHandler.RevokeAPIKeyHandler, "manage:api_keys")))
+
+	// Authorization policy routes - Admin only
+	router.POST("/authz/explain", standard.Limit(httputil.Wrap(authzHandler.ExplainHandler, "manage:roles")))
+	router.GET("/authz/policy", standard.Limit(httputil.Wrap(authzHandler.GetPolicyHandler, "manage:roles")))
 
 	// Books routes
-	router.GET("/books/:id", httputil.Wrap(bookHandler.GetBookByIDHandler, "read:books"))
-	router.GET("/books", httputil.Wrap(bookHandler.SearchBooksHandler, "read:books"))
-	router.POST("/books", httputil.WrapWithRoles(bookHandler.CreateBookHandler, constants.RoleAdmin, constants.RoleManager))
-	router.PUT("/books/:id", httputil.WrapWithRoles(bookHandler.UpdateBookHandler, constants.RoleAdmin, constants.RoleManager))
-	router.DELETE("/books/:id", httputil.WrapWithRole(bookHandler.DeleteBookHandler, constants.RoleAdmin))
+	router.GET("/books/:id", standard.Limit(authorizer.Wrap(http.MethodGet, "/books/:id", "books:read", "book", bookHandler.GetBookByIDHandler)))
+	router.GET("/books", search.Limit(authorizer.Wrap(http.MethodGet, "/books", "books:list", "book", bookHandler.SearchBooksHandler)))
+	router.POST("/books", standard.Limit(authorizer.Wrap(http.MethodPost, "/books", "books:create", "book", bookHandler.CreateBookHandler)))
+	router.PUT("/books/:id", standard.Limit(authorizer.Wrap(http.MethodPut, "/books/:id", "books:update", "book", bookHandler.UpdateBookHandler)))
+	router.DELETE("/books/:id", standard.Limit(authorizer.Wrap(http.MethodDelete, "/books/:id", "books:delete", "book", bookHandler.DeleteBookHandler)))
 
 	// Authors routes
-	router.GET("/authors/:id", httputil.Wrap(authorHandler.GetAuthorByIDHandler, "read:authors"))
-	router.GET("/authors", httputil.Wrap(authorHandler.ListAuthorsHandler, "read:authors"))
-	router.POST("/authors", httputil.WrapWithRoles(authorHandler.CreateAuthorHandler, constants.RoleAdmin, constants.RoleManager))
-	router.PUT("/authors/:id", httputil.WrapWithRoles(authorHandler.UpdateAuthorHandler, constants.RoleAdmin, constants.RoleManager))
-	router.DELETE("/authors/:id", httputil.WrapWithRole(authorHandler.DeleteAuthorHandler, constants.RoleAdmin))
+	router.GET("/authors/:id", standard.Limit(authorizer.Wrap(http.MethodGet, "/authors/:id", "authors:read", "author", authorHandler.GetAuthorByIDHandler)))
+	router.GET("/authors", search.Limit(authorizer.Wrap(http.MethodGet, "/authors", "authors:list", "author", authorHandler.ListAuthorsHandler)))
+	router.POST("/authors", standard.Limit(authorizer.Wrap(http.MethodPost, "/authors", "authors:create", "author", authorHandler.CreateAuthorHandler)))
+	router.PUT("/authors/:id", standard.Limit(authorizer.Wrap(http.MethodPut, "/authors/:id", "authors:update", "author", authorHandler.UpdateAuthorHandler)))
+	router.DELETE("/authors/:id", standard.Limit(authorizer.Wrap(http.MethodDelete, "/authors/:id", "authors:delete", "author", authorHandler.DeleteAuthorHandler)))
 
 	// Customers routes
-	router.GET("/customers/:id", httputil.WrapWithRoles(customerHandler.GetCustomerByIDHandler, constants.RoleAdmin, constants.RoleManager, constants.RoleEmployee))
-	router.GET("/customers", httputil.WrapWithRoles(customerHandler.ListCustomersHandler, constants.RoleAdmin, constants.RoleManager, constants.RoleEmployee))
-	router.POST("/customers", httputil.WrapWithRoles(customerHandler.CreateCustomerHandler, constants.RoleAdmin, constants.RoleManager, constants.RoleEmployee))
-	router.PUT("/customers/:id", httputil.WrapWithRoles(customerHandler.UpdateCustomerHandler, constants.RoleAdmin, constants.RoleManager))
-	router.DELETE("/customers/:id", httputil.WrapWithRole(customerHandler.DeleteCustomerHandler, constants.RoleAdmin))
-
-	// Orders routes - More granular control
-	router.GET("/orders", httputil.WrapWithRoles(orderHandler.GetAllOrdersHandler, constants.RoleAdmin, constants.RoleManager, constants.RoleEmployee))
-	router.GET("/orders/:id", httputil.Wrap(orderHandler.GetOrderByIDHandler, "read:orders"))
-	router.POST("/orders", httputil.Wrap(orderHandler.CreateOrderHandler, "write:orders"))
-	router.PUT("/orders/:id", httputil.WrapWithOwnerOrAdmin(orderHandler.UpdateOrderHandler, httputil.ExtractOrderOwnerID))
-	router.DELETE("/orders/:id", httputil.WrapWithOwnerOrAdmin(orderHandler.DeleteOrderHandler, httputil.ExtractOrderOwnerID))
-
-	// Sales reports - Admin and Manager only
-	router.GET("/sales-reports", httputil.WrapWithRoles(GetSalesReportHandler, constants.RoleAdmin, constants.RoleManager))
+	router.GET("/customers/:id", standard.Limit(authorizer.Wrap(http.MethodGet, "/customers/:id", "customers:read", "customer", customerHandler.GetCustomerByIDHandler)))
+	router.GET("/customers", search.Limit(authorizer.Wrap(http.MethodGet, "/customers", "customers:list", "customer", customerHandler.ListCustomersHandler)))
+	router.POST("/customers", standard.Limit(authorizer.Wrap(http.MethodPost, "/customers", "customers:create", "customer", customerHandler.CreateCustomerHandler)))
+	router.PUT("/customers/:id", standard.Limit(authorizer.Wrap(http.MethodPut, "/customers/:id", "customers:update", "customer", customerHandler.UpdateCustomerHandler)))
+	router.DELETE("/customers/:id", standard.Limit(authorizer.Wrap(http.MethodDelete, "/customers/:id", "customers:delete", "customer", customerHandler.DeleteCustomerHandler)))
+
+	// Orders routes
+	router.GET("/orders", search.Limit(authorizer.Wrap(http.MethodGet, "/orders", "orders:list", "order", orderHandler.GetAllOrdersHandler)))
+	router.GET("/orders/:id", standard.Limit(authorizer.Wrap(http.MethodGet, "/orders/:id", "orders:read", "order", orderHandler.GetOrderByIDHandler)))
+	router.POST("/orders", standard.Limit(authorizer.Wrap(http.MethodPost, "/orders", "orders:create", "order", orderHandler.CreateOrderHandler)))
+	router.PUT("/orders/:id", standard.Limit(authorizer.Wrap(http.MethodPut, "/orders/:id", "orders:update", "order", orderHandler.UpdateOrderHandler)))
+	router.DELETE("/orders/:id", standard.Limit(authorizer.Wrap(http.MethodDelete, "/orders/:id", "orders:delete", "order", orderHandler.DeleteOrderHandler)))
+	router.POST("/orders/:id/transitions", standard.Limit(authorizer.Wrap(http.MethodPost, "/orders/:id/transitions", "orders:transition", "order", orderHandler.TransitionOrderHandler)))
+
+	// Sales reports
+	router.GET("/sales-reports", reports.Limit(authorizer.Wrap(http.MethodGet, "/sales-reports", "reports:read", "report", salesReportHandler.GetSalesReportHandler)))
 
 	return router
 }
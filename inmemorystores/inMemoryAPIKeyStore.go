@@ -0,0 +1,176 @@
+package inmemorystores
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/models"
+)
+
+type InMemoryAPIKeyStore struct {
+	mu     sync.RWMutex
+	keys   map[int]models.APIKey
+	byHash map[string]int // Key IDs by key hash, looked up on every request authenticated by a key
+	nextID int
+
+	nextPermissionID int
+}
+
+func NewInMemoryAPIKeyStore() *InMemoryAPIKeyStore {
+	return &InMemoryAPIKeyStore{
+		keys:   make(map[int]models.APIKey),
+		byHash: make(map[string]int),
+		nextID: 1,
+
+		nextPermissionID: 1,
+	}
+}
+
+func (store *InMemoryAPIKeyStore) ListAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	keys := make([]models.APIKey, 0, len(store.keys))
+	for _, key := range store.keys {
+		keys = append(keys, copyAPIKey(key))
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+
+	return keys, nil
+}
+
+func (store *InMemoryAPIKeyStore) GetAPIKey(ctx context.Context, id int) (models.APIKey, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	select {
+	case <-ctx.Done():
+		return models.APIKey{}, ctx.Err()
+	default:
+	}
+
+	key, exists := store.keys[id]
+	if !exists {
+		return models.APIKey{}, errorhandling.ErrAPIKeyNotFound
+	}
+	return copyAPIKey(key), nil
+}
+
+func (store *InMemoryAPIKeyStore) GetAPIKeyByHash(ctx context.Context, keyHash string) (models.APIKey, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	select {
+	case <-ctx.Done():
+		return models.APIKey{}, ctx.Err()
+	default:
+	}
+
+	id, exists := store.byHash[keyHash]
+	if !exists {
+		return models.APIKey{}, errorhandling.ErrAPIKeyNotFound
+	}
+	return copyAPIKey(store.keys[id]), nil
+}
+
+func (store *InMemoryAPIKeyStore) CreateAPIKey(ctx context.Context, key models.APIKey) (models.APIKey, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return models.APIKey{}, ctx.Err()
+	default:
+	}
+
+	if _, exists := store.byHash[key.KeyHash]; exists {
+		return models.APIKey{}, fmt.Errorf("%w: API key", errorhandling.ErrDuplicateKey)
+	}
+
+	key.ID = store.nextID
+	key.CreatedAt = time.Now()
+	store.nextID++
+	permissions := make([]models.APIKeyPermission, 0, len(key.Permissions))
+	seen := make(map[string]bool)
+	for _, permission := range key.Permissions {
+		if seen[permission.Permission] {
+			continue
+		}
+		seen[permission.Permission] = true
+		permissions = append(permissions, models.APIKeyPermission{
+			ID:         store.nextPermissionID,
+			APIKeyID:   key.ID,
+			Permission: permission.Permission,
+		})
+		store.nextPermissionID++
+	}
+	key.Permissions = permissions
+	store.keys[key.ID] = key
+	store.byHash[key.KeyHash] = key.ID
+
+	return copyAPIKey(key), nil
+}
+
+// TouchAPIKey records when a key was last used
+func (store *InMemoryAPIKeyStore) TouchAPIKey(ctx context.Context, id int, usedAt time.Time) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	key, exists := store.keys[id]
+	if !exists {
+		return errorhandling.ErrAPIKeyNotFound
+	}
+	if key.LastUsedAt == nil || key.LastUsedAt.Before(usedAt) {
+		key.LastUsedAt = &usedAt
+		store.keys[id] = key
+	}
+	return nil
+}
+
+// RevokeAPIKey marks a key as revoked. The key is kept, so that admins can still see it. It
+// fails with ErrAPIKeyAlreadyRevoked if the key was already revoked.
+func (store *InMemoryAPIKeyStore) RevokeAPIKey(ctx context.Context, id int) (models.APIKey, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return models.APIKey{}, ctx.Err()
+	default:
+	}
+
+	key, exists := store.keys[id]
+	if !exists {
+		return models.APIKey{}, errorhandling.ErrAPIKeyNotFound
+	}
+	if key.RevokedAt != nil {
+		return models.APIKey{}, errorhandling.ErrAPIKeyAlreadyRevoked
+	}
+	now := time.Now()
+	key.RevokedAt = &now
+	store.keys[id] = key
+
+	return copyAPIKey(key), nil
+}
+
+// copyAPIKey returns a copy of a key that does not share its permissions
+func copyAPIKey(key models.APIKey) models.APIKey {
+	key.Permissions = append([]models.APIKeyPermission(nil), key.Permissions...)
+	return key
+}
@@ -0,0 +1,128 @@
+package inmemorystores
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/models"
+)
+
+type InMemoryAccountTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]models.AccountToken // Tokens by JTI
+	nextID int
+}
+
+func NewInMemoryAccountTokenStore() *InMemoryAccountTokenStore {
+	return &InMemoryAccountTokenStore{
+		tokens: make(map[string]models.AccountToken),
+		nextID: 1,
+	}
+}
+
+// CreateAccountToken records a token, superseding the unused tokens of the same user and purpose
+func (store *InMemoryAccountTokenStore) CreateAccountToken(ctx context.Context, token models.AccountToken) (models.AccountToken, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return models.AccountToken{}, ctx.Err()
+	default:
+	}
+
+	now := time.Now()
+	for jti, existing := range store.tokens {
+		if !now.Before(existing.ExpiresAt) {
+			delete(store.tokens, jti)
+			continue
+		}
+		if existing.UserID == token.UserID && existing.Purpose == token.Purpose && existing.UsedAt == nil {
+			existing.UsedAt = &now
+			store.tokens[jti] = existing
+		}
+	}
+	if _, exists := store.tokens[token.JTI]; exists {
+		return models.AccountToken{}, fmt.Errorf("%w: account token %s", errorhandling.ErrDuplicateKey, token.JTI)
+	}
+
+	token.ID = store.nextID
+	token.CreatedAt = now
+	store.nextID++
+	store.tokens[token.JTI] = token
+
+	return token, nil
+}
+
+func (store *InMemoryAccountTokenStore) GetAccountToken(ctx context.Context, jti string) (models.AccountToken, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return models.AccountToken{}, ctx.Err()
+	default:
+	}
+
+	token, exists := store.tokens[jti]
+	if !exists {
+		return models.AccountToken{}, errorhandling.ErrAccountTokenNotFound
+	}
+	return token, nil
+}
+
+// ConsumeAccountToken marks a token as used. It fails with ErrLinkUsed if the token was
+// already used or superseded.
+func (store *InMemoryAccountTokenStore) ConsumeAccountToken(ctx context.Context, jti string) (models.AccountToken, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return models.AccountToken{}, ctx.Err()
+	default:
+	}
+
+	token, exists := store.tokens[jti]
+	if !exists {
+		return models.AccountToken{}, errorhandling.ErrAccountTokenNotFound
+	}
+	if token.UsedAt != nil {
+		return models.AccountToken{}, errorhandling.ErrLinkUsed
+	}
+
+	now := time.Now()
+	token.UsedAt = &now
+	store.tokens[jti] = token
+
+	return token, nil
+}
+
+// RecordFailedAttempt counts a failed attempt at the code checked by a token. The token is
+// used up once maxAttempts attempts failed.
+func (store *InMemoryAccountTokenStore) RecordFailedAttempt(ctx context.Context, jti string, maxAttempts int) (models.AccountToken, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return models.AccountToken{}, ctx.Err()
+	default:
+	}
+
+	token, exists := store.tokens[jti]
+	if !exists {
+		return models.AccountToken{}, errorhandling.ErrAccountTokenNotFound
+	}
+	token.Attempts++
+	if token.Attempts >= maxAttempts && token.UsedAt == nil {
+		now := time.Now()
+		token.UsedAt = &now
+	}
+	store.tokens[jti] = token
+
+	return token, nil
+}
@@ -3,7 +3,10 @@ package inmemorystores
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"log"
 	"os"
+	"sort"
 	"sync"
 
 	"um6p.ma/finalproject/errorhandling"
@@ -14,35 +17,47 @@ type InMemoryAuthorStore struct {
 	mu      sync.RWMutex
 	authors map[int]models.Author
 	nextID  int
+	journal *journal
 }
 
-func NewInMemoryAuthorStore() *InMemoryAuthorStore {
-	return &InMemoryAuthorStore{
+func NewInMemoryAuthorStore(opts ...Option) (*InMemoryAuthorStore, error) {
+	store := &InMemoryAuthorStore{
 		authors: make(map[int]models.Author),
 		nextID:  1,
 	}
+
+	journal, err := openStoreJournal("authors", opts)
+	if err != nil || journal == nil {
+		return store, err
+	}
+	if err := journal.recover(store.loadAuthors, store.applyEntry); err != nil {
+		journal.close()
+		return nil, err
+	}
+	store.journal = journal
+	journal.start(store.writeSnapshot)
+
+	return store, nil
 }
 
 func (store *InMemoryAuthorStore) CreateAuthor(ctx context.Context, author models.Author) (models.Author, error) {
 	store.mu.Lock()
+	defer store.mu.Unlock()
 
 	select {
 	case <-ctx.Done():
-		store.mu.Unlock()
 		return models.Author{}, ctx.Err()
 	default:
 	}
 
 	author.ID = store.nextID
-	store.nextID++
-	store.authors[author.ID] = author
-
-	store.mu.Unlock()
-
-	if err := store.SaveAuthorsToJSON("./database/authors.json"); err != nil {
+	if err := store.record(opPut, author.ID, author); err != nil {
 		return models.Author{}, err
 	}
 
+	store.nextID++
+	store.authors[author.ID] = author
+
 	return author, nil
 }
 
@@ -66,10 +81,10 @@ func (store *InMemoryAuthorStore) GetAuthor(ctx context.Context, id int) (models
 
 func (store *InMemoryAuthorStore) UpdateAuthor(ctx context.Context, id int, author models.Author) (models.Author, error) {
 	store.mu.Lock()
+	defer store.mu.Unlock()
 
 	select {
 	case <-ctx.Done():
-		store.mu.Unlock()
 		return models.Author{}, ctx.Err()
 	default:
 	}
@@ -80,23 +95,21 @@ func (store *InMemoryAuthorStore) UpdateAuthor(ctx context.Context, id int, auth
 	}
 
 	author.ID = id
-	store.authors[id] = author
-
-	store.mu.Unlock()
-
-	if err := store.SaveAuthorsToJSON("./database/authors.json"); err != nil {
+	if err := store.record(opPut, id, author); err != nil {
 		return models.Author{}, err
 	}
 
+	store.authors[id] = author
+
 	return author, nil
 }
 
 func (store *InMemoryAuthorStore) DeleteAuthor(ctx context.Context, id int) error {
 	store.mu.Lock()
+	defer store.mu.Unlock()
 
 	select {
 	case <-ctx.Done():
-		store.mu.Unlock()
 		return ctx.Err()
 	default:
 	}
@@ -106,14 +119,12 @@ func (store *InMemoryAuthorStore) DeleteAuthor(ctx context.Context, id int) erro
 		return errorhandling.ErrAuthorNotFound
 	}
 
-	delete(store.authors, id)
-
-	store.mu.Unlock()
-
-	if err := store.SaveAuthorsToJSON("./database/authors.json"); err != nil {
+	if err := store.record(opDelete, id, nil); err != nil {
 		return err
 	}
 
+	delete(store.authors, id)
+
 	return nil
 }
 
@@ -136,14 +147,17 @@ func (store *InMemoryAuthorStore) GetAllAuthors(ctx context.Context) ([]models.A
 }
 
 func (store *InMemoryAuthorStore) LoadAuthorsFromJSON(filePath string) error {
-	file, err := os.Open(filePath)
+	data, err := os.ReadFile(filePath)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
+	return store.loadAuthors(data)
+}
+
+func (store *InMemoryAuthorStore) loadAuthors(data []byte) error {
 	var authors []models.Author
-	if err := json.NewDecoder(file).Decode(&authors); err != nil {
+	if err := json.Unmarshal(data, &authors); err != nil {
 		return err
 	}
 
@@ -160,19 +174,92 @@ func (store *InMemoryAuthorStore) LoadAuthorsFromJSON(filePath string) error {
 	return nil
 }
 
+// SaveAuthorsToJSON atomically writes every author, ordered by ID, to filePath
 func (store *InMemoryAuthorStore) SaveAuthorsToJSON(filePath string) error {
 	store.mu.RLock()
 	defer store.mu.RUnlock()
 
-	var authors []models.Author
-	for _, author := range store.authors {
-		authors = append(authors, author)
+	data, err := json.MarshalIndent(store.snapshot(), "", "  ")
+	if err != nil {
+		return err
 	}
 
-	data, err := json.MarshalIndent(authors, "", "  ")
-	if err != nil {
+	return writeFileAtomic(filePath, data, 0600)
+}
+
+// Close compacts the journal into a snapshot and releases it
+func (store *InMemoryAuthorStore) Close() error {
+	if store.journal == nil {
+		return nil
+	}
+	store.journal.stopBackground()
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if err := store.journal.compact(store.snapshot()); err != nil {
 		return err
 	}
+	return store.journal.close()
+}
+
+// record writes a mutation to the journal, if any, before it is applied.
+// The caller must hold the write lock.
+func (store *InMemoryAuthorStore) record(op string, id int, author interface{}) error {
+	if store.journal == nil {
+		return nil
+	}
+	// Compact before appending: the mutation is not applied yet, so a snapshot taken after
+	// appending would miss it while truncating its entry
+	if store.journal.needsCompaction() {
+		if err := store.journal.compact(store.snapshot()); err != nil {
+			log.Printf("⚠️ Failed to compact authors journal: %v", err)
+		}
+	}
+	return store.journal.append(op, id, author)
+}
+
+// applyEntry replays a journal entry during recovery
+func (store *InMemoryAuthorStore) applyEntry(entry journalEntry) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	switch entry.Op {
+	case opPut:
+		var author models.Author
+		if err := json.Unmarshal(entry.Data, &author); err != nil {
+			return err
+		}
+		store.authors[entry.ID] = author
+	case opDelete:
+		delete(store.authors, entry.ID)
+	default:
+		return fmt.Errorf("unknown journal operation %q", entry.Op)
+	}
+
+	if entry.ID >= store.nextID {
+		store.nextID = entry.ID + 1
+	}
+	return nil
+}
 
-	return os.WriteFile(filePath, data, 0644)
+// writeSnapshot compacts the journal into a snapshot of the current authors
+func (store *InMemoryAuthorStore) writeSnapshot() error {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	return store.journal.compact(store.snapshot())
+}
+
+// snapshot returns every author, ordered by ID so that saved files diff cleanly.
+// The caller must hold the lock.
+func (store *InMemoryAuthorStore) snapshot() []models.Author {
+	authors := make([]models.Author, 0, len(store.authors))
+	for _, author := range store.authors {
+		authors = append(authors, author)
+	}
+	sort.Slice(authors, func(i, j int) bool {
+		return authors[i].ID < authors[j].ID
+	})
+	return authors
 }
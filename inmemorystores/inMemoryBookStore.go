@@ -3,25 +3,51 @@ package inmemorystores
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"log"
 	"os"
-	"strings"
+	"sort"
 	"sync"
 
 	"um6p.ma/finalproject/errorhandling"
 	"um6p.ma/finalproject/models"
 )
 
+// Collections of the books journal, which the orders share so that an order and the stock it
+// takes are journaled in a single entry
+const (
+	booksCollection  = "books"
+	ordersCollection = "orders"
+)
+
 type InMemoryBookStore struct {
-	mu     sync.RWMutex
-	books  map[int]models.Book
-	nextID int
+	mu      sync.RWMutex
+	books   map[int]models.Book
+	nextID  int
+	journal *journal
+
+	orders   *InMemoryOrderStore // Journaled with the books, once attached
+	orderLog []journalEntry      // Recovered order entries, until the order store is attached
 }
 
-func NewInMemoryBookStore() *InMemoryBookStore {
-	return &InMemoryBookStore{
+func NewInMemoryBookStore(opts ...Option) (*InMemoryBookStore, error) {
+	store := &InMemoryBookStore{
 		books:  make(map[int]models.Book),
 		nextID: 1,
 	}
+
+	journal, err := openStoreJournal(booksCollection, opts)
+	if err != nil || journal == nil {
+		return store, err
+	}
+	if err := journal.recover(store.loadBooks, store.applyEntry); err != nil {
+		journal.close()
+		return nil, err
+	}
+	store.journal = journal
+	journal.start(store.writeSnapshot)
+
+	return store, nil
 }
 
 func (store *InMemoryBookStore) GetAllBooks(ctx context.Context) ([]models.Book, error) {
@@ -42,17 +68,16 @@ func (store *InMemoryBookStore) GetAllBooks(ctx context.Context) ([]models.Book,
 
 func (store *InMemoryBookStore) CreateBook(ctx context.Context, book models.Book) (models.Book, error) {
 	store.mu.Lock()
+	defer store.mu.Unlock()
 
 	book.ID = store.nextID
-	store.nextID++
-	store.books[book.ID] = book
-
-	store.mu.Unlock()
-
-	if err := store.SaveBooksToJSON("./database/books.json"); err != nil {
+	if err := store.record(opPut, book.ID, book); err != nil {
 		return models.Book{}, err
 	}
 
+	store.nextID++
+	store.books[book.ID] = book
+
 	return book, nil
 }
 
@@ -74,6 +99,7 @@ func (store *InMemoryBookStore) GetBook(ctx context.Context, id int) (models.Boo
 
 func (store *InMemoryBookStore) UpdateBook(ctx context.Context, id int, book models.Book) (models.Book, error) {
 	store.mu.Lock()
+	defer store.mu.Unlock()
 
 	_, exists := store.books[id]
 	if !exists {
@@ -81,33 +107,30 @@ func (store *InMemoryBookStore) UpdateBook(ctx context.Context, id int, book mod
 	}
 
 	book.ID = id
-	store.books[id] = book
-
-	store.mu.Unlock()
-
-	if err := store.SaveBooksToJSON("./database/books.json"); err != nil {
+	if err := store.record(opPut, id, book); err != nil {
 		return models.Book{}, err
 	}
 
+	store.books[id] = book
+
 	return book, nil
 }
 
 func (store *InMemoryBookStore) DeleteBook(ctx context.Context, id int) error {
 	store.mu.Lock()
+	defer store.mu.Unlock()
 
 	_, exists := store.books[id]
 	if !exists {
 		return errorhandling.ErrBookNotFound
 	}
 
-	delete(store.books, id)
-
-	store.mu.Unlock()
-
-	if err := store.SaveBooksToJSON("./database/books.json"); err != nil {
+	if err := store.record(opDelete, id, nil); err != nil {
 		return err
 	}
 
+	delete(store.books, id)
+
 	return nil
 }
 
@@ -123,48 +146,61 @@ func (store *InMemoryBookStore) SearchBooks(ctx context.Context, criteria models
 		default:
 		}
 
-		if len(criteria.Titles) > 0 {
-			for _, title := range criteria.Titles {
-				if book.Title == title {
-					results = append(results, book)
-				}
-			}
-		} else if len(criteria.Authors) > 0 {
-			for _, author := range criteria.Authors {
-				if book.Author.FirstName == author || book.Author.LastName == author {
-					results = append(results, book)
-				}
-			}
-		} else if len(criteria.Genres) > 0 {
-			for _, genre := range criteria.Genres {
-				bookGenres := strings.Split(book.Genres, ", ")
-
-				for _, bookGenre := range bookGenres {
-					if genre == bookGenre {
-						results = append(results, book)
-						break
-					}
-				}
-			}
-		} else if criteria.MinPrice >= 0 && criteria.MaxPrice > 0 {
-			if book.Price >= criteria.MinPrice && book.Price <= criteria.MaxPrice {
-				results = append(results, book)
-			}
+		if matchesCriteria(book, criteria) {
+			results = append(results, book)
 		}
 	}
 
 	return results, nil
 }
 
+// matchesCriteria reports whether a book satisfies every filter set in the criteria
+func matchesCriteria(book models.Book, criteria models.SearchCriteria) bool {
+	if len(criteria.Titles) > 0 && !containsAny(book.Title, criteria.Titles) {
+		return false
+	}
+	if len(criteria.Authors) > 0 &&
+		!containsAny(book.Author.FirstName, criteria.Authors) &&
+		!containsAny(book.Author.LastName, criteria.Authors) {
+		return false
+	}
+	if len(criteria.Genres) > 0 && !containsAny(book.Genres, criteria.Genres) {
+		return false
+	}
+	if criteria.AuthorID > 0 && book.AuthorID != criteria.AuthorID {
+		return false
+	}
+	if criteria.MinPrice > 0 && book.Price < criteria.MinPrice {
+		return false
+	}
+	if criteria.MaxPrice > 0 && book.Price > criteria.MaxPrice {
+		return false
+	}
+	return true
+}
+
+// containsAny reports whether value contains any of the terms, ignoring case
+func containsAny(value string, terms []string) bool {
+	for _, term := range terms {
+		if containsFold(value, term) {
+			return true
+		}
+	}
+	return false
+}
+
 func (store *InMemoryBookStore) LoadBooksFromJSON(filePath string) error {
-	file, err := os.Open(filePath)
+	data, err := os.ReadFile(filePath)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
+	return store.loadBooks(data)
+}
+
+func (store *InMemoryBookStore) loadBooks(data []byte) error {
 	var books []models.Book
-	if err := json.NewDecoder(file).Decode(&books); err != nil {
+	if err := json.Unmarshal(data, &books); err != nil {
 		return err
 	}
 
@@ -181,19 +217,159 @@ func (store *InMemoryBookStore) LoadBooksFromJSON(filePath string) error {
 	return nil
 }
 
+// SaveBooksToJSON atomically writes every book, ordered by ID, to filePath
 func (store *InMemoryBookStore) SaveBooksToJSON(filePath string) error {
 	store.mu.RLock()
 	defer store.mu.RUnlock()
 
-	var books []models.Book
-	for _, book := range store.books {
-		books = append(books, book)
+	data, err := json.MarshalIndent(store.snapshot(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filePath, data, 0600)
+}
+
+// Close compacts the journal into a snapshot and releases it
+func (store *InMemoryBookStore) Close() error {
+	if store.journal == nil {
+		return nil
 	}
+	store.journal.stopBackground()
 
-	data, err := json.MarshalIndent(books, "", "  ")
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if err := store.compact(store.attachedOrders); err != nil {
+		return err
+	}
+	return store.journal.close()
+}
+
+// record writes a mutation to the journal, if any, before it is applied.
+// The caller must hold the write lock.
+func (store *InMemoryBookStore) record(op string, id int, book interface{}) error {
+	if store.journal == nil {
+		return nil
+	}
+	entry, err := newJournalEntry(op, id, book)
 	if err != nil {
 		return err
 	}
+	return store.write(entry, store.attachedOrders)
+}
 
-	return os.WriteFile(filePath, data, 0644)
+// write appends an entry to the journal, compacting it first once it has grown past the
+// threshold. orders returns the current orders for the snapshot. The caller must hold the
+// write lock.
+func (store *InMemoryBookStore) write(entry journalEntry, orders func() []models.Order) error {
+	// Compact before appending: the mutation is not applied yet, so a snapshot taken after
+	// appending would miss it while truncating its entry
+	if store.journal.needsCompaction() {
+		if err := store.compact(orders); err != nil {
+			log.Printf("⚠️ Failed to compact books journal: %v", err)
+		}
+	}
+	return store.journal.write(entry)
+}
+
+// compact replaces the snapshots of the books and of the attached orders and empties the
+// journal. The caller must hold the lock.
+func (store *InMemoryBookStore) compact(orders func() []models.Order) error {
+	collections := map[string]interface{}{booksCollection: store.snapshot()}
+	if store.orders != nil {
+		collections[ordersCollection] = orders()
+	} else if len(store.orderLog) > 0 {
+		// The recovered order entries stay in the log until the order store replays them
+		return nil
+	}
+	return store.journal.compactAll(collections)
+}
+
+// attachedOrders returns a snapshot of the attached orders. The caller must hold the book lock
+// and not the order lock, which is always taken after the book lock.
+func (store *InMemoryBookStore) attachedOrders() []models.Order {
+	store.orders.mu.RLock()
+	defer store.orders.mu.RUnlock()
+
+	return store.orders.snapshot()
+}
+
+// attachOrders journals the orders with the books from now on, after loading their snapshot
+// and replaying their recovered entries
+func (store *InMemoryBookStore) attachOrders(orders *InMemoryOrderStore) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if store.journal != nil {
+		data, err := store.journal.readSnapshot(ordersCollection)
+		if err != nil {
+			return err
+		}
+		if data != nil {
+			if err := orders.loadOrders(data); err != nil {
+				return fmt.Errorf("failed to load the orders snapshot: %w", err)
+			}
+		}
+		for _, entry := range store.orderLog {
+			if err := orders.applyEntry(entry); err != nil {
+				return fmt.Errorf("failed to replay an order entry: %w", err)
+			}
+		}
+		store.orderLog = nil
+	}
+
+	store.orders = orders
+	return nil
+}
+
+// applyEntry replays a journal entry during recovery. Order entries are kept for the order
+// store.
+func (store *InMemoryBookStore) applyEntry(entry journalEntry) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if entry.Collection == ordersCollection {
+		store.orderLog = append(store.orderLog, entry)
+		return nil
+	}
+
+	switch entry.Op {
+	case opPut:
+		var book models.Book
+		if err := json.Unmarshal(entry.Data, &book); err != nil {
+			return err
+		}
+		store.books[entry.ID] = book
+	case opDelete:
+		delete(store.books, entry.ID)
+	default:
+		return fmt.Errorf("unknown journal operation %q", entry.Op)
+	}
+
+	if entry.ID >= store.nextID {
+		store.nextID = entry.ID + 1
+	}
+	return nil
+}
+
+// writeSnapshot compacts the journal into snapshots of the current books and orders
+func (store *InMemoryBookStore) writeSnapshot() error {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	return store.compact(store.attachedOrders)
+}
+
+// snapshot returns every book, ordered by ID so that saved files diff cleanly.
+// The caller must hold the lock.
+func (store *InMemoryBookStore) snapshot() []models.Book {
+	books := make([]models.Book, 0, len(store.books))
+	for _, book := range store.books {
+		books = append(books, book)
+	}
+	sort.Slice(books, func(i, j int) bool {
+		return books[i].ID < books[j].ID
+	})
+	return books
 }
@@ -3,24 +3,45 @@ package inmemorystores
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"log"
 	"os"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"um6p.ma/finalproject/errorhandling"
 	"um6p.ma/finalproject/models"
 )
 
 type InMemoryCustomerStore struct {
-	mu        sync.RWMutex
-	customers map[int]models.Customer
-	nextID    int
+	mu            sync.RWMutex
+	customers     map[int]models.Customer
+	nextID        int
+	nextAddressID int
+	journal       *journal
 }
 
-func NewInMemoryCustomerStore() *InMemoryCustomerStore {
-	return &InMemoryCustomerStore{
-		customers: make(map[int]models.Customer),
-		nextID:    1,
+func NewInMemoryCustomerStore(opts ...Option) (*InMemoryCustomerStore, error) {
+	store := &InMemoryCustomerStore{
+		customers:     make(map[int]models.Customer),
+		nextID:        1,
+		nextAddressID: 1,
 	}
+
+	journal, err := openStoreJournal("customers", opts)
+	if err != nil || journal == nil {
+		return store, err
+	}
+	if err := journal.recover(store.loadCustomers, store.applyEntry); err != nil {
+		journal.close()
+		return nil, err
+	}
+	store.journal = journal
+	journal.start(store.writeSnapshot)
+
+	return store, nil
 }
 
 func (store *InMemoryCustomerStore) GetAllCustomers(ctx context.Context) ([]models.Customer, error) {
@@ -41,22 +62,28 @@ func (store *InMemoryCustomerStore) GetAllCustomers(ctx context.Context) ([]mode
 
 func (store *InMemoryCustomerStore) CreateCustomer(ctx context.Context, customer models.Customer) (models.Customer, error) {
 	store.mu.Lock()
+	defer store.mu.Unlock()
 
 	select {
 	case <-ctx.Done():
-		store.mu.Unlock()
 		return models.Customer{}, ctx.Err()
 	default:
-		customer.ID = store.nextID
-		store.nextID++
-		store.customers[customer.ID] = customer
-
-		store.mu.Unlock()
+		if customer.UserID != nil {
+			if _, linked := store.findByUserID(*customer.UserID); linked {
+				return models.Customer{}, fmt.Errorf("%w: customer of user %d", errorhandling.ErrDuplicateKey, *customer.UserID)
+			}
+		}
 
-		if err := store.SaveCustomersToJSON("./database/customers.json"); err != nil {
+		customer.ID = store.nextID
+		customer.CreatedAt = time.Now()
+		customer.Addresses = nil
+		if err := store.record(opPut, customer.ID, customer); err != nil {
 			return models.Customer{}, err
 		}
 
+		store.nextID++
+		store.customers[customer.ID] = customer
+
 		return customer, nil
 	}
 }
@@ -79,36 +106,38 @@ func (store *InMemoryCustomerStore) GetCustomer(ctx context.Context, id int) (mo
 
 func (store *InMemoryCustomerStore) UpdateCustomer(ctx context.Context, id int, customer models.Customer) (models.Customer, error) {
 	store.mu.Lock()
+	defer store.mu.Unlock()
 
 	select {
 	case <-ctx.Done():
-		store.mu.Unlock()
 		return models.Customer{}, ctx.Err()
 	default:
-		_, exists := store.customers[id]
+		existing, exists := store.customers[id]
 		if !exists {
 			return models.Customer{}, errorhandling.ErrCustomerNotFound
 		}
 
+		// The link and the address book have their own methods
 		customer.ID = id
-		store.customers[id] = customer
-
-		store.mu.Unlock()
-
-		if err := store.SaveCustomersToJSON("./database/customers.json"); err != nil {
+		customer.UserID = existing.UserID
+		customer.Addresses = existing.Addresses
+		customer.CreatedAt = existing.CreatedAt
+		if err := store.record(opPut, id, customer); err != nil {
 			return models.Customer{}, err
 		}
 
+		store.customers[id] = customer
+
 		return customer, nil
 	}
 }
 
 func (store *InMemoryCustomerStore) DeleteCustomer(ctx context.Context, id int) error {
 	store.mu.Lock()
+	defer store.mu.Unlock()
 
 	select {
 	case <-ctx.Done():
-		store.mu.Unlock()
 		return ctx.Err()
 	default:
 		_, exists := store.customers[id]
@@ -116,26 +145,202 @@ func (store *InMemoryCustomerStore) DeleteCustomer(ctx context.Context, id int)
 			return errorhandling.ErrCustomerNotFound
 		}
 
+		if err := store.record(opDelete, id, nil); err != nil {
+			return err
+		}
+
 		delete(store.customers, id)
-		store.mu.Unlock()
 
-		if err := store.SaveCustomersToJSON("./database/customers.json"); err != nil {
+		return nil
+	}
+}
+
+func (store *InMemoryCustomerStore) GetCustomerByEmail(ctx context.Context, email string) (models.Customer, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	select {
+	case <-ctx.Done():
+		return models.Customer{}, ctx.Err()
+	default:
+		for _, customer := range store.customers {
+			if customer.Email == email {
+				return customer, nil
+			}
+		}
+		return models.Customer{}, errorhandling.ErrCustomerNotFound
+	}
+}
+
+func (store *InMemoryCustomerStore) GetCustomerByUserID(ctx context.Context, userID int) (models.Customer, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	select {
+	case <-ctx.Done():
+		return models.Customer{}, ctx.Err()
+	default:
+		customer, exists := store.findByUserID(userID)
+		if !exists {
+			return models.Customer{}, errorhandling.ErrCustomerNotFound
+		}
+		return customer, nil
+	}
+}
+
+// LinkCustomer links a customer to a user account. A customer linked to another user, or a
+// user linked to another customer, cannot be linked.
+func (store *InMemoryCustomerStore) LinkCustomer(ctx context.Context, id int, userID int) (models.Customer, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return models.Customer{}, ctx.Err()
+	default:
+		customer, exists := store.customers[id]
+		if !exists {
+			return models.Customer{}, errorhandling.ErrCustomerNotFound
+		}
+		if customer.UserID != nil {
+			if *customer.UserID == userID {
+				return customer, nil
+			}
+			return models.Customer{}, errorhandling.ErrCustomerLinked
+		}
+		if _, linked := store.findByUserID(userID); linked {
+			return models.Customer{}, fmt.Errorf("%w: customer of user %d", errorhandling.ErrDuplicateKey, userID)
+		}
+
+		customer.UserID = &userID
+		if err := store.record(opPut, id, customer); err != nil {
+			return models.Customer{}, err
+		}
+		store.customers[id] = customer
+
+		return customer, nil
+	}
+}
+
+func (store *InMemoryCustomerStore) AddCustomerAddress(ctx context.Context, customerID int, address models.CustomerAddress) (models.CustomerAddress, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return models.CustomerAddress{}, ctx.Err()
+	default:
+		customer, exists := store.customers[customerID]
+		if !exists {
+			return models.CustomerAddress{}, errorhandling.ErrCustomerNotFound
+		}
+
+		address.ID = store.nextAddressID
+		address.CustomerID = customerID
+		customer.Addresses = append(append([]models.CustomerAddress(nil), customer.Addresses...), address)
+		if err := store.record(opPut, customerID, customer); err != nil {
+			return models.CustomerAddress{}, err
+		}
+		store.nextAddressID++
+		store.customers[customerID] = customer
+
+		return address, nil
+	}
+}
+
+// UpdateCustomerAddress replaces an address of the address book of a customer
+func (store *InMemoryCustomerStore) UpdateCustomerAddress(ctx context.Context, customerID int, address models.CustomerAddress) (models.CustomerAddress, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return models.CustomerAddress{}, ctx.Err()
+	default:
+		customer, exists := store.customers[customerID]
+		if !exists {
+			return models.CustomerAddress{}, errorhandling.ErrAddressNotFound
+		}
+		index := addressIndex(customer.Addresses, address.ID)
+		if index < 0 {
+			return models.CustomerAddress{}, errorhandling.ErrAddressNotFound
+		}
+
+		address.CustomerID = customerID
+		customer.Addresses = append([]models.CustomerAddress(nil), customer.Addresses...)
+		customer.Addresses[index] = address
+		if err := store.record(opPut, customerID, customer); err != nil {
+			return models.CustomerAddress{}, err
+		}
+		store.customers[customerID] = customer
+
+		return address, nil
+	}
+}
+
+func (store *InMemoryCustomerStore) DeleteCustomerAddress(ctx context.Context, customerID int, id int) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		customer, exists := store.customers[customerID]
+		if !exists {
+			return errorhandling.ErrAddressNotFound
+		}
+		index := addressIndex(customer.Addresses, id)
+		if index < 0 {
+			return errorhandling.ErrAddressNotFound
+		}
+
+		addresses := make([]models.CustomerAddress, 0, len(customer.Addresses)-1)
+		addresses = append(addresses, customer.Addresses[:index]...)
+		customer.Addresses = append(addresses, customer.Addresses[index+1:]...)
+		if err := store.record(opPut, customerID, customer); err != nil {
 			return err
 		}
+		store.customers[customerID] = customer
 
 		return nil
 	}
 }
 
+func (store *InMemoryCustomerStore) SearchCustomers(ctx context.Context, criteria models.CustomerSearchCriteria) ([]models.Customer, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+		var customers []models.Customer
+		for _, customer := range store.customers {
+			if !containsFold(customer.Email, criteria.Email) ||
+				!containsFold(customer.Name, criteria.Name) ||
+				!containsFold(customer.Address.City, criteria.City) ||
+				(criteria.UserID != 0 && (customer.UserID == nil || *customer.UserID != criteria.UserID)) {
+				continue
+			}
+			customers = append(customers, customer)
+		}
+		return customers, nil
+	}
+}
+
 func (store *InMemoryCustomerStore) LoadCustomersFromJSON(filePath string) error {
-	file, err := os.Open(filePath)
+	data, err := os.ReadFile(filePath)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
+	return store.loadCustomers(data)
+}
+
+func (store *InMemoryCustomerStore) loadCustomers(data []byte) error {
 	var customers []models.Customer
-	if err := json.NewDecoder(file).Decode(&customers); err != nil {
+	if err := json.Unmarshal(data, &customers); err != nil {
 		return err
 	}
 
@@ -143,28 +348,142 @@ func (store *InMemoryCustomerStore) LoadCustomersFromJSON(filePath string) error
 	defer store.mu.Unlock()
 
 	for _, customer := range customers {
+		customer.UserID = nil // See applyEntry
 		store.customers[customer.ID] = customer
 		if customer.ID >= store.nextID {
 			store.nextID = customer.ID + 1
 		}
+		store.reserveAddressIDs(customer)
 	}
 
 	return nil
 }
 
+// SaveCustomersToJSON atomically writes every customer, ordered by ID, to filePath
 func (store *InMemoryCustomerStore) SaveCustomersToJSON(filePath string) error {
 	store.mu.RLock()
 	defer store.mu.RUnlock()
 
-	var customers []models.Customer
+	data, err := json.MarshalIndent(store.snapshot(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filePath, data, 0600)
+}
+
+// Close compacts the journal into a snapshot and releases it
+func (store *InMemoryCustomerStore) Close() error {
+	if store.journal == nil {
+		return nil
+	}
+	store.journal.stopBackground()
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if err := store.journal.compact(store.snapshot()); err != nil {
+		return err
+	}
+	return store.journal.close()
+}
+
+// record writes a mutation to the journal, if any, before it is applied.
+// The caller must hold the write lock.
+func (store *InMemoryCustomerStore) record(op string, id int, customer interface{}) error {
+	if store.journal == nil {
+		return nil
+	}
+	// Compact before appending: the mutation is not applied yet, so a snapshot taken after
+	// appending would miss it while truncating its entry
+	if store.journal.needsCompaction() {
+		if err := store.journal.compact(store.snapshot()); err != nil {
+			log.Printf("⚠️ Failed to compact customers journal: %v", err)
+		}
+	}
+	return store.journal.append(op, id, customer)
+}
+
+// applyEntry replays a journal entry during recovery
+func (store *InMemoryCustomerStore) applyEntry(entry journalEntry) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	switch entry.Op {
+	case opPut:
+		var customer models.Customer
+		if err := json.Unmarshal(entry.Data, &customer); err != nil {
+			return err
+		}
+		// Users are not persisted, so their IDs are reused after a restart; customers are linked
+		// again once their user verifies the same email address
+		customer.UserID = nil
+		store.customers[entry.ID] = customer
+		store.reserveAddressIDs(customer)
+	case opDelete:
+		delete(store.customers, entry.ID)
+	default:
+		return fmt.Errorf("unknown journal operation %q", entry.Op)
+	}
+
+	if entry.ID >= store.nextID {
+		store.nextID = entry.ID + 1
+	}
+	return nil
+}
+
+// writeSnapshot compacts the journal into a snapshot of the current customers
+func (store *InMemoryCustomerStore) writeSnapshot() error {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	return store.journal.compact(store.snapshot())
+}
+
+// snapshot returns every customer, ordered by ID so that saved files diff cleanly.
+// The caller must hold the lock.
+func (store *InMemoryCustomerStore) snapshot() []models.Customer {
+	customers := make([]models.Customer, 0, len(store.customers))
 	for _, customer := range store.customers {
 		customers = append(customers, customer)
 	}
+	sort.Slice(customers, func(i, j int) bool {
+		return customers[i].ID < customers[j].ID
+	})
+	return customers
+}
 
-	data, err := json.MarshalIndent(customers, "", "  ")
-	if err != nil {
-		return err
+// findByUserID returns the customer linked to a user. The caller must hold the lock.
+func (store *InMemoryCustomerStore) findByUserID(userID int) (models.Customer, bool) {
+	for _, customer := range store.customers {
+		if customer.UserID != nil && *customer.UserID == userID {
+			return customer, true
+		}
+	}
+	return models.Customer{}, false
+}
+
+// reserveAddressIDs keeps new addresses from reusing the IDs of those of a recovered customer.
+// The caller must hold the write lock.
+func (store *InMemoryCustomerStore) reserveAddressIDs(customer models.Customer) {
+	for _, address := range customer.Addresses {
+		if address.ID >= store.nextAddressID {
+			store.nextAddressID = address.ID + 1
+		}
 	}
+}
+
+// addressIndex returns the index of the address with the given ID, or -1
+func addressIndex(addresses []models.CustomerAddress, id int) int {
+	for i, address := range addresses {
+		if address.ID == id {
+			return i
+		}
+	}
+	return -1
+}
 
-	return os.WriteFile(filePath, data, 0644)
+// containsFold reports whether substr is within s, ignoring case
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
 }
@@ -0,0 +1,69 @@
+package inmemorystores
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"um6p.ma/finalproject/models"
+)
+
+type InMemoryImpersonationAuditStore struct {
+	mu       sync.RWMutex
+	requests []models.ImpersonatedRequest // Ordered by ID
+	nextID   int
+}
+
+func NewInMemoryImpersonationAuditStore() *InMemoryImpersonationAuditStore {
+	return &InMemoryImpersonationAuditStore{nextID: 1}
+}
+
+func (store *InMemoryImpersonationAuditStore) RecordImpersonatedRequest(ctx context.Context, request models.ImpersonatedRequest) (models.ImpersonatedRequest, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return models.ImpersonatedRequest{}, ctx.Err()
+	default:
+	}
+
+	request.ID = store.nextID
+	request.CreatedAt = time.Now()
+	store.nextID++
+	store.requests = append(store.requests, request)
+
+	return request, nil
+}
+
+// ListImpersonatedRequests returns the requests matching a filter, most recent first
+func (store *InMemoryImpersonationAuditStore) ListImpersonatedRequests(ctx context.Context, filter models.ImpersonatedRequestFilter) ([]models.ImpersonatedRequest, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	requests := make([]models.ImpersonatedRequest, 0)
+	for i := len(store.requests) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(requests) >= filter.Limit {
+			break
+		}
+		request := store.requests[i]
+		if filter.ImpersonatorID != 0 && request.ImpersonatorID != filter.ImpersonatorID {
+			continue
+		}
+		if filter.UserID != 0 && request.UserID != filter.UserID {
+			continue
+		}
+		if filter.TokenID != "" && request.TokenID != filter.TokenID {
+			continue
+		}
+		requests = append(requests, request)
+	}
+
+	return requests, nil
+}
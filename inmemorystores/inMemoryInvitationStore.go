@@ -0,0 +1,141 @@
+package inmemorystores
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/models"
+)
+
+type InMemoryInvitationStore struct {
+	mu          sync.RWMutex
+	invitations map[int]models.Invitation
+	nextID      int
+	users       *InMemoryUserStore
+}
+
+// NewInMemoryInvitationStore creates an invitation store creating the accepting users in users
+func NewInMemoryInvitationStore(users *InMemoryUserStore) *InMemoryInvitationStore {
+	return &InMemoryInvitationStore{
+		invitations: make(map[int]models.Invitation),
+		nextID:      1,
+		users:       users,
+	}
+}
+
+func (store *InMemoryInvitationStore) CreateInvitation(ctx context.Context, invitation models.Invitation) (models.Invitation, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return models.Invitation{}, ctx.Err()
+	default:
+	}
+
+	for _, existing := range store.invitations {
+		if existing.TokenHash == invitation.TokenHash {
+			return models.Invitation{}, fmt.Errorf("%w: invitation", errorhandling.ErrDuplicateKey)
+		}
+	}
+
+	invitation.ID = store.nextID
+	invitation.CreatedAt = time.Now()
+	store.nextID++
+	store.invitations[invitation.ID] = invitation
+
+	return invitation, nil
+}
+
+func (store *InMemoryInvitationStore) GetInvitationByHash(ctx context.Context, tokenHash string) (models.Invitation, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	select {
+	case <-ctx.Done():
+		return models.Invitation{}, ctx.Err()
+	default:
+	}
+
+	for _, invitation := range store.invitations {
+		if invitation.TokenHash == tokenHash {
+			return invitation, nil
+		}
+	}
+
+	return models.Invitation{}, errorhandling.ErrInvitationNotFound
+}
+
+func (store *InMemoryInvitationStore) ListInvitations(ctx context.Context) ([]models.Invitation, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	invitations := make([]models.Invitation, 0, len(store.invitations))
+	for _, invitation := range store.invitations {
+		invitations = append(invitations, invitation)
+	}
+	sort.Slice(invitations, func(i, j int) bool { return invitations[i].ID < invitations[j].ID })
+
+	return invitations, nil
+}
+
+func (store *InMemoryInvitationStore) DeleteInvitation(ctx context.Context, id int) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	if _, exists := store.invitations[id]; !exists {
+		return errorhandling.ErrInvitationNotFound
+	}
+	delete(store.invitations, id)
+
+	return nil
+}
+
+// AcceptInvitation creates the user of an invitation and marks it as accepted. An invitation
+// can only be accepted once.
+func (store *InMemoryInvitationStore) AcceptInvitation(ctx context.Context, id int, user models.User) (models.User, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return models.User{}, ctx.Err()
+	default:
+	}
+
+	invitation, exists := store.invitations[id]
+	if !exists {
+		return models.User{}, errorhandling.ErrInvitationNotFound
+	}
+	if invitation.AcceptedAt != nil {
+		return models.User{}, errorhandling.ErrInvitationUsed
+	}
+
+	user, err := store.users.CreateUser(ctx, user)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	now := time.Now()
+	invitation.AcceptedAt = &now
+	invitation.UserID = &user.ID
+	store.invitations[id] = invitation
+
+	return user, nil
+}
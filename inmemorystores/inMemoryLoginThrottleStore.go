@@ -0,0 +1,139 @@
+package inmemorystores
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/models"
+)
+
+type InMemoryLoginThrottleStore struct {
+	mu        sync.Mutex
+	throttles map[string]models.LoginThrottle // Throttles by identifier
+	nextID    int
+}
+
+func NewInMemoryLoginThrottleStore() *InMemoryLoginThrottleStore {
+	return &InMemoryLoginThrottleStore{
+		throttles: make(map[string]models.LoginThrottle),
+		nextID:    1,
+	}
+}
+
+// GetLoginThrottle returns the failed logins of an identifier, which are none if it has no entry
+func (store *InMemoryLoginThrottleStore) GetLoginThrottle(ctx context.Context, identifier string) (models.LoginThrottle, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return models.LoginThrottle{}, ctx.Err()
+	default:
+	}
+
+	if throttle, exists := store.throttles[identifier]; exists {
+		return throttle, nil
+	}
+	return models.LoginThrottle{Identifier: identifier}, nil
+}
+
+// RecordLoginFailure counts a failed login of an identifier. The count starts over when the
+// previous failure is older than window. Entries whose failures and lock are over are purged.
+func (store *InMemoryLoginThrottleStore) RecordLoginFailure(ctx context.Context, identifier string, at time.Time, window time.Duration) (models.LoginThrottle, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return models.LoginThrottle{}, ctx.Err()
+	default:
+	}
+
+	cutoff := at.Add(-window)
+	for key, existing := range store.throttles {
+		if existing.LastFailureAt.Before(cutoff) && (existing.LockedUntil == nil || existing.LockedUntil.Before(at)) {
+			delete(store.throttles, key)
+		}
+	}
+
+	throttle, exists := store.throttles[identifier]
+	if !exists {
+		throttle = models.LoginThrottle{ID: store.nextID, Identifier: identifier}
+		store.nextID++
+	}
+	throttle.Failures++
+	throttle.LastFailureAt = at
+	store.throttles[identifier] = throttle
+
+	return throttle, nil
+}
+
+// LockLogin refuses the logins of an identifier until the given time
+func (store *InMemoryLoginThrottleStore) LockLogin(ctx context.Context, identifier string, until time.Time) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	throttle, exists := store.throttles[identifier]
+	if !exists {
+		return errorhandling.ErrThrottleNotFound
+	}
+	throttle.LockedUntil = &until
+	store.throttles[identifier] = throttle
+
+	return nil
+}
+
+// ClearLoginThrottle forgets the failed logins and the lock of an identifier
+func (store *InMemoryLoginThrottleStore) ClearLoginThrottle(ctx context.Context, identifier string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	if _, exists := store.throttles[identifier]; !exists {
+		return errorhandling.ErrThrottleNotFound
+	}
+	delete(store.throttles, identifier)
+
+	return nil
+}
+
+// ListLockedLogins returns the identifiers locked at the given time, soonest unlocked first
+func (store *InMemoryLoginThrottleStore) ListLockedLogins(ctx context.Context, now time.Time) ([]models.LoginThrottle, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	locked := make([]models.LoginThrottle, 0)
+	for _, throttle := range store.throttles {
+		if throttle.LockedUntil != nil && throttle.LockedUntil.After(now) {
+			locked = append(locked, throttle)
+		}
+	}
+	sort.Slice(locked, func(i, j int) bool {
+		if !locked[i].LockedUntil.Equal(*locked[j].LockedUntil) {
+			return locked[i].LockedUntil.Before(*locked[j].LockedUntil)
+		}
+		return locked[i].ID < locked[j].ID
+	})
+
+	return locked, nil
+}
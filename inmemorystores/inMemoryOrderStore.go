@@ -3,16 +3,20 @@ package inmemorystores
 import (
 	"context"
 	"encoding/json"
-	"errors"
-	"log"
+	"fmt"
 	"os"
+	"sort"
 	"sync"
 	"time"
 
+	"um6p.ma/finalproject/constants"
 	"um6p.ma/finalproject/errorhandling"
 	"um6p.ma/finalproject/models"
 )
 
+// InMemoryOrderStore keeps orders next to the books they take stock from. Orders are
+// journaled in the journal of the book store, if it has one, so that an order and its stock
+// changes are written as one entry. Writes lock the book store first, then the order store.
 type InMemoryOrderStore struct {
 	mu        sync.RWMutex
 	orders    map[int]models.Order
@@ -20,53 +24,56 @@ type InMemoryOrderStore struct {
 	bookStore *InMemoryBookStore
 }
 
-func NewInMemoryOrderStore(bookStore *InMemoryBookStore) *InMemoryOrderStore {
-	return &InMemoryOrderStore{
+// NewInMemoryOrderStore returns an order store reserving stock from bookStore, recovering the
+// orders journaled with the books
+func NewInMemoryOrderStore(bookStore *InMemoryBookStore) (*InMemoryOrderStore, error) {
+	store := &InMemoryOrderStore{
 		orders:    make(map[int]models.Order),
 		nextID:    1,
 		bookStore: bookStore,
 	}
+	if err := bookStore.attachOrders(store); err != nil {
+		return nil, err
+	}
+
+	return store, nil
 }
 
 func (store *InMemoryOrderStore) CreateOrder(ctx context.Context, order models.Order) (models.Order, error) {
-	store.mu.Lock()
+	unlock := store.lock()
+	defer unlock()
 
 	select {
 	case <-ctx.Done():
-		store.mu.Unlock()
 		return models.Order{}, ctx.Err()
 	default:
 	}
 
-	for _, item := range order.Items {
-		book, err := store.bookStore.GetBook(ctx, item.Book.ID)
-		if err != nil {
-			store.mu.Unlock()
-			return models.Order{}, errors.New("book does not exist")
-		}
-		if book.Stock < item.Quantity {
-			store.mu.Unlock()
-			return models.Order{}, errors.New("insufficient stock for book")
-		}
-		book.Stock -= item.Quantity
-		_, err = store.bookStore.UpdateBook(ctx, book.ID, book)
-		if err != nil {
-			store.mu.Unlock()
-			log.Printf("you re here")
-			return models.Order{}, err
-		}
-	}
-
 	order.ID = store.nextID
-	store.nextID++
-	store.orders[order.ID] = order
-
-	store.mu.Unlock()
-
-	if err := store.SaveOrdersToJSON("./database/orders.json"); err != nil {
+	order.CreatedAt = time.Now()
+	for i := range order.Items {
+		order.Items[i].OrderID = order.ID
+	}
+	order.Status = constants.OrderStatusPending
+	order.History = []models.OrderStatusChange{{
+		OrderID:   order.ID,
+		ToStatus:  constants.OrderStatusPending,
+		ChangedAt: order.CreatedAt,
+	}}
+
+	if err := store.bookStore.priceOrder(&order); err != nil {
+		return models.Order{}, err
+	}
+	changes, err := models.StockChanges(nil, order.Items)
+	if err != nil {
+		return models.Order{}, err
+	}
+	if err := store.saveWithStock(&order, changes); err != nil {
 		return models.Order{}, err
 	}
 
+	store.nextID++
+
 	return order, nil
 }
 
@@ -89,92 +96,155 @@ func (store *InMemoryOrderStore) GetOrder(ctx context.Context, id int) (models.O
 }
 
 func (store *InMemoryOrderStore) UpdateOrder(ctx context.Context, id int, order models.Order) (models.Order, error) {
-	store.mu.Lock()
+	unlock := store.lock()
+	defer unlock()
 
 	select {
 	case <-ctx.Done():
-		store.mu.Unlock()
 		return models.Order{}, ctx.Err()
 	default:
 	}
 
 	existingOrder, exists := store.orders[id]
 	if !exists {
-		store.mu.Unlock()
 		return models.Order{}, errorhandling.ErrOrderNotFound
 	}
+	if order.Status != "" && order.Status != existingOrder.Status {
+		return models.Order{}, errorhandling.ErrOrderStatusChange
+	}
+	if existingOrder.Status != constants.OrderStatusPending {
+		return models.Order{}, errorhandling.ErrOrderNotEditable
+	}
 
-	for _, item := range existingOrder.Items {
-		book, err := store.bookStore.GetBook(ctx, item.Book.ID)
-		if err != nil {
-			store.mu.Unlock()
-			return models.Order{}, errors.New("book does not exist during stock restoration")
-		}
-		book.Stock += item.Quantity
-		_, err = store.bookStore.UpdateBook(ctx, book.ID, book)
-		if err != nil {
-			store.mu.Unlock()
-			return models.Order{}, err
-		}
+	order.ID = id
+	order.CreatedAt = existingOrder.CreatedAt
+	order.Status = existingOrder.Status
+	order.History = existingOrder.History
+	for i := range order.Items {
+		order.Items[i].OrderID = id
 	}
 
-	for _, item := range order.Items {
-		book, err := store.bookStore.GetBook(ctx, item.Book.ID)
-		if err != nil {
-			store.mu.Unlock()
-			return models.Order{}, errors.New("book does not exist during stock validation")
-		}
-		if book.Stock < item.Quantity {
-			store.mu.Unlock()
-			return models.Order{}, errorhandling.ErrInsufficientStock
-		}
-		book.Stock -= item.Quantity
-		_, err = store.bookStore.UpdateBook(ctx, book.ID, book)
-		if err != nil {
-			store.mu.Unlock()
-			return models.Order{}, err
-		}
+	if err := store.bookStore.priceOrder(&order); err != nil {
+		return models.Order{}, err
+	}
+	changes, err := models.StockChanges(existingOrder.Items, order.Items)
+	if err != nil {
+		return models.Order{}, err
+	}
+	if err := store.saveWithStock(&order, changes); err != nil {
+		return models.Order{}, err
 	}
 
-	order.ID = id
-	store.orders[id] = order
+	return order, nil
+}
 
-	store.mu.Unlock()
+// TransitionOrder moves an order to another status, returning the stock of cancelled orders
+func (store *InMemoryOrderStore) TransitionOrder(ctx context.Context, id int, change models.OrderStatusChange) (models.Order, error) {
+	unlock := store.lock()
+	defer unlock()
 
-	if err := store.SaveOrdersToJSON("./database/orders.json"); err != nil {
+	select {
+	case <-ctx.Done():
+		return models.Order{}, ctx.Err()
+	default:
+	}
+
+	existingOrder, exists := store.orders[id]
+	if !exists {
+		return models.Order{}, errorhandling.ErrOrderNotFound
+	}
+
+	// Copy the history so that a failed save leaves the stored order untouched
+	order := existingOrder
+	order.History = append([]models.OrderStatusChange(nil), existingOrder.History...)
+	if !order.ApplyStatusChange(change) {
+		return models.Order{}, errorhandling.ErrInvalidTransition
+	}
+
+	var changes []models.StockChange
+	if order.Status == constants.OrderStatusCancelled {
+		var err error
+		if changes, err = models.StockChanges(order.Items, nil); err != nil {
+			return models.Order{}, err
+		}
+	}
+	if err := store.saveWithStock(&order, changes); err != nil {
 		return models.Order{}, err
 	}
 
 	return order, nil
 }
 
+// saveWithStock stores the order as a unit of work with the given stock changes: the order and
+// the updated books are journaled in one entry, then applied in memory, all or none. The
+// caller must hold the write locks.
+func (store *InMemoryOrderStore) saveWithStock(order *models.Order, changes []models.StockChange) error {
+	books, err := store.bookStore.reserveStock(changes)
+	if err != nil {
+		return err
+	}
+	if err := store.record(opPut, order.ID, order, books); err != nil {
+		return err
+	}
+
+	store.bookStore.storeStock(books)
+	store.orders[order.ID] = *order
+	return nil
+}
+
+// DeleteOrder removes an order, returning the stock it still holds. Shipped and delivered
+// orders cannot be deleted. The stock and the deletion are applied as a unit of work, like
+// saveWithStock.
 func (store *InMemoryOrderStore) DeleteOrder(ctx context.Context, id int) error {
-	store.mu.Lock()
+	unlock := store.lock()
+	defer unlock()
 
 	select {
 	case <-ctx.Done():
-		store.mu.Unlock()
 		return ctx.Err()
 	default:
 	}
 
-	_, exists := store.orders[id]
+	existingOrder, exists := store.orders[id]
 	if !exists {
-		store.mu.Unlock()
 		return errorhandling.ErrOrderNotFound
 	}
 
-	delete(store.orders, id)
-
-	store.mu.Unlock()
+	if existingOrder.Status == constants.OrderStatusShipped || existingOrder.Status == constants.OrderStatusDelivered {
+		return errorhandling.ErrOrderNotDeletable
+	}
 
-	if err := store.SaveOrdersToJSON("./database/orders.json"); err != nil {
+	var changes []models.StockChange
+	if existingOrder.HoldsStock() {
+		var err error
+		if changes, err = models.StockChanges(existingOrder.Items, nil); err != nil {
+			return err
+		}
+	}
+	books, err := store.bookStore.reserveStock(changes)
+	if err != nil {
+		return err
+	}
+	if err := store.record(opDelete, id, nil, books); err != nil {
 		return err
 	}
 
+	store.bookStore.storeStock(books)
+	delete(store.orders, id)
 	return nil
 }
 
+// lock takes the write locks of the book store and of the order store, in that order, and
+// returns the function releasing them
+func (store *InMemoryOrderStore) lock() func() {
+	store.bookStore.mu.Lock()
+	store.mu.Lock()
+	return func() {
+		store.mu.Unlock()
+		store.bookStore.mu.Unlock()
+	}
+}
+
 func (store *InMemoryOrderStore) GetAllOrders(ctx context.Context) ([]models.Order, error) {
 	store.mu.RLock()
 	defer store.mu.RUnlock()
@@ -194,14 +264,17 @@ func (store *InMemoryOrderStore) GetAllOrders(ctx context.Context) ([]models.Ord
 }
 
 func (store *InMemoryOrderStore) LoadOrdersFromJSON(filePath string) error {
-	file, err := os.Open(filePath)
+	data, err := os.ReadFile(filePath)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
+	return store.loadOrders(data)
+}
+
+func (store *InMemoryOrderStore) loadOrders(data []byte) error {
 	var orders []models.Order
-	if err := json.NewDecoder(file).Decode(&orders); err != nil {
+	if err := json.Unmarshal(data, &orders); err != nil {
 		return err
 	}
 
@@ -218,21 +291,67 @@ func (store *InMemoryOrderStore) LoadOrdersFromJSON(filePath string) error {
 	return nil
 }
 
+// SaveOrdersToJSON atomically writes every order, ordered by ID, to filePath
 func (store *InMemoryOrderStore) SaveOrdersToJSON(filePath string) error {
 	store.mu.RLock()
 	defer store.mu.RUnlock()
 
-	var orders []models.Order
-	for _, order := range store.orders {
-		orders = append(orders, order)
+	data, err := json.MarshalIndent(store.snapshot(), "", "  ")
+	if err != nil {
+		return err
 	}
 
-	data, err := json.MarshalIndent(orders, "", "  ")
+	return writeFileAtomic(filePath, data, 0600)
+}
+
+// record writes a mutation of an order and the books whose stock it changes to the journal,
+// if any, before they are applied. The caller must hold the write locks.
+func (store *InMemoryOrderStore) record(op string, id int, order interface{}, books []models.Book) error {
+	if store.bookStore.journal == nil {
+		return nil
+	}
+	entry, err := newJournalEntry(op, id, order)
 	if err != nil {
 		return err
 	}
+	return store.bookStore.recordWithStock(entry, books)
+}
+
+// applyEntry replays a journal entry during recovery
+func (store *InMemoryOrderStore) applyEntry(entry journalEntry) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	switch entry.Op {
+	case opPut:
+		var order models.Order
+		if err := json.Unmarshal(entry.Data, &order); err != nil {
+			return err
+		}
+		store.orders[entry.ID] = order
+	case opDelete:
+		delete(store.orders, entry.ID)
+	default:
+		return fmt.Errorf("unknown journal operation %q", entry.Op)
+	}
+
+	if entry.ID >= store.nextID {
+		store.nextID = entry.ID + 1
+	}
+	return nil
+}
 
-	return os.WriteFile(filePath, data, 0644)
+// snapshot returns every order, ordered by ID so that saved files diff cleanly.
+// The caller must hold the lock.
+func (store *InMemoryOrderStore) snapshot() []models.Order {
+	orders := make([]models.Order, 0, len(store.orders))
+	for _, order := range store.orders {
+		orders = append(orders, order)
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		return orders[i].ID < orders[j].ID
+	})
+	return orders
 }
 
 func (store *InMemoryOrderStore) GetOrdersInTimeRange(ctx context.Context, start, end time.Time) ([]models.Order, error) {
@@ -254,3 +373,23 @@ func (store *InMemoryOrderStore) GetOrdersInTimeRange(ctx context.Context, start
 
 	return ordersInRange, nil
 }
+
+func (store *InMemoryOrderStore) GetOrdersByCustomer(ctx context.Context, customerID int) ([]models.Order, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	var customerOrders []models.Order
+	for _, order := range store.orders {
+		if order.CustomerID == customerID {
+			customerOrders = append(customerOrders, order)
+		}
+	}
+
+	return customerOrders, nil
+}
@@ -0,0 +1,195 @@
+package inmemorystores
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"um6p.ma/finalproject/constants"
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/models"
+)
+
+// newStores returns a book store holding books with the given stock, priced 10 each, and an
+// order store reserving from it
+func newStores(t *testing.T, stocks ...int) (*InMemoryBookStore, *InMemoryOrderStore) {
+	t.Helper()
+	books, err := NewInMemoryBookStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stock := range stocks {
+		if _, err := books.CreateBook(context.Background(), models.Book{Title: "Emma", Price: 10, Stock: stock}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	orders, err := NewInMemoryOrderStore(books)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return books, orders
+}
+
+func assertStock(t *testing.T, books *InMemoryBookStore, stocks ...int) {
+	t.Helper()
+	for i, want := range stocks {
+		book, err := books.GetBook(context.Background(), i+1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if book.Stock != want {
+			t.Errorf("book %d: got stock %d, want %d", book.ID, book.Stock, want)
+		}
+	}
+}
+
+func orderOf(quantities ...int) models.Order {
+	var order models.Order
+	for i, quantity := range quantities {
+		if quantity > 0 {
+			order.Items = append(order.Items, models.OrderItem{BookID: i + 1, Quantity: quantity})
+		}
+	}
+	return order
+}
+
+func TestConcurrentOrdersCannotOversell(t *testing.T) {
+	books, orders := newStores(t, 5)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	placed, refused := 0, 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := orders.CreateOrder(context.Background(), orderOf(1))
+			mu.Lock()
+			defer mu.Unlock()
+			var response errorhandling.ErrorResponse
+			switch {
+			case err == nil:
+				placed++
+			case errors.As(err, &response) && response.Message == errorhandling.ErrInsufficientStock.Message:
+				refused++
+			default:
+				t.Errorf("CreateOrder: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if placed != 5 || refused != 15 {
+		t.Errorf("got %d orders placed and %d refused, want 5 and 15", placed, refused)
+	}
+	assertStock(t, books, 0)
+}
+
+func TestOrderReservesAllOrNothing(t *testing.T) {
+	books, orders := newStores(t, 5, 1)
+
+	_, err := orders.CreateOrder(context.Background(), orderOf(2, 2))
+	var response errorhandling.ErrorResponse
+	if !errors.As(err, &response) || response.Message != errorhandling.ErrInsufficientStock.Message {
+		t.Fatalf("got %v, want insufficient stock", err)
+	}
+	shortages, _ := response.Details.([]errorhandling.StockShortage)
+	if want := (errorhandling.StockShortage{BookID: 2, Requested: 2, Available: 1}); len(shortages) != 1 || shortages[0] != want {
+		t.Errorf("got shortages %+v, want %+v", response.Details, want)
+	}
+	assertStock(t, books, 5, 1)
+	if all, _ := orders.GetAllOrders(context.Background()); len(all) != 0 {
+		t.Errorf("got %d orders, want none", len(all))
+	}
+}
+
+func TestOrderStockFollowsTheOrder(t *testing.T) {
+	books, orders := newStores(t, 5, 3)
+	ctx := context.Background()
+
+	order, err := orders.CreateOrder(ctx, orderOf(2, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.TotalPrice != 30 {
+		t.Errorf("got total %.2f, want 30.00", order.TotalPrice)
+	}
+	assertStock(t, books, 3, 2)
+
+	if _, err := orders.UpdateOrder(ctx, order.ID, orderOf(1, 3)); err != nil {
+		t.Fatal(err)
+	}
+	assertStock(t, books, 4, 0)
+
+	if _, err := orders.TransitionOrder(ctx, order.ID, models.OrderStatusChange{
+		FromStatus: constants.OrderStatusPending, ToStatus: constants.OrderStatusCancelled,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	assertStock(t, books, 5, 3)
+
+	// The stock of a cancelled order was already returned
+	if err := orders.DeleteOrder(ctx, order.ID); err != nil {
+		t.Fatal(err)
+	}
+	assertStock(t, books, 5, 3)
+
+	order, err = orders.CreateOrder(ctx, orderOf(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := orders.DeleteOrder(ctx, order.ID); err != nil {
+		t.Fatal(err)
+	}
+	assertStock(t, books, 5, 3)
+}
+
+func TestFailedJournalWriteReturnsStock(t *testing.T) {
+	dir := t.TempDir()
+	books, err := NewInMemoryBookStore(WithJournal(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer books.Close()
+	if _, err := books.CreateBook(context.Background(), models.Book{Title: "Emma", Price: 10, Stock: 5}); err != nil {
+		t.Fatal(err)
+	}
+	orders, err := NewInMemoryOrderStore(books)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Writes to the journal fail once its file is closed
+	books.journal.file.Close()
+	if _, err := orders.CreateOrder(context.Background(), orderOf(2)); err == nil {
+		t.Fatal("CreateOrder: got no error")
+	}
+	assertStock(t, books, 5)
+	if all, _ := orders.GetAllOrders(context.Background()); len(all) != 0 {
+		t.Errorf("got %d orders, want none", len(all))
+	}
+}
+
+func TestShippedOrdersCannotBeDeleted(t *testing.T) {
+	books, orders := newStores(t, 5)
+	ctx := context.Background()
+	order, err := orders.CreateOrder(ctx, orderOf(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range []string{constants.OrderStatusProcessing, constants.OrderStatusShipped} {
+		if order, err = orders.TransitionOrder(ctx, order.ID, models.OrderStatusChange{FromStatus: order.Status, ToStatus: status}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var response errorhandling.ErrorResponse
+	if err := orders.DeleteOrder(ctx, order.ID); !errors.As(err, &response) || response.Message != errorhandling.ErrOrderNotDeletable.Message {
+		t.Fatalf("got %v, want %v", err, errorhandling.ErrOrderNotDeletable)
+	}
+	assertStock(t, books, 3)
+	if _, err := orders.GetOrder(ctx, order.ID); err != nil {
+		t.Errorf("shipped order: got %v, want it kept", err)
+	}
+}
@@ -0,0 +1,133 @@
+package inmemorystores
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/models"
+)
+
+type InMemoryRefreshTokenStore struct {
+	mu     sync.RWMutex
+	tokens map[int]models.RefreshToken
+	nextID int
+}
+
+func NewInMemoryRefreshTokenStore() *InMemoryRefreshTokenStore {
+	return &InMemoryRefreshTokenStore{
+		tokens: make(map[int]models.RefreshToken),
+		nextID: 1,
+	}
+}
+
+func (store *InMemoryRefreshTokenStore) CreateRefreshToken(ctx context.Context, token models.RefreshToken) (models.RefreshToken, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return models.RefreshToken{}, ctx.Err()
+	default:
+	}
+
+	return store.create(token)
+}
+
+func (store *InMemoryRefreshTokenStore) GetRefreshTokenByHash(ctx context.Context, tokenHash string) (models.RefreshToken, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	select {
+	case <-ctx.Done():
+		return models.RefreshToken{}, ctx.Err()
+	default:
+	}
+
+	for _, token := range store.tokens {
+		if token.TokenHash == tokenHash {
+			return token, nil
+		}
+	}
+
+	return models.RefreshToken{}, errorhandling.ErrRefreshTokenNotFound
+}
+
+// RotateRefreshToken revokes a token and creates its successor. It fails with
+// ErrRefreshTokenReused if the token was already revoked, by an earlier rotation or a logout.
+func (store *InMemoryRefreshTokenStore) RotateRefreshToken(ctx context.Context, id int, next models.RefreshToken) (models.RefreshToken, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return models.RefreshToken{}, ctx.Err()
+	default:
+	}
+
+	token, exists := store.tokens[id]
+	if !exists {
+		return models.RefreshToken{}, errorhandling.ErrRefreshTokenNotFound
+	}
+	if token.RevokedAt != nil {
+		return models.RefreshToken{}, errorhandling.ErrRefreshTokenReused
+	}
+
+	now := time.Now()
+	token.RevokedAt = &now
+	store.tokens[id] = token
+
+	return store.create(next)
+}
+
+func (store *InMemoryRefreshTokenStore) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	return store.revokeWhere(ctx, func(token models.RefreshToken) bool {
+		return token.FamilyID == familyID
+	})
+}
+
+func (store *InMemoryRefreshTokenStore) RevokeUserRefreshTokens(ctx context.Context, userID int, createdBefore time.Time) error {
+	return store.revokeWhere(ctx, func(token models.RefreshToken) bool {
+		return token.UserID == userID && token.CreatedAt.Before(createdBefore)
+	})
+}
+
+// revokeWhere revokes every token matching the filter that is not revoked yet
+func (store *InMemoryRefreshTokenStore) revokeWhere(ctx context.Context, matches func(models.RefreshToken) bool) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	now := time.Now()
+	for id, token := range store.tokens {
+		if token.RevokedAt == nil && matches(token) {
+			token.RevokedAt = &now
+			store.tokens[id] = token
+		}
+	}
+
+	return nil
+}
+
+// create stores a new token. The caller must hold the write lock.
+func (store *InMemoryRefreshTokenStore) create(token models.RefreshToken) (models.RefreshToken, error) {
+	for _, existing := range store.tokens {
+		if existing.TokenHash == token.TokenHash {
+			return models.RefreshToken{}, fmt.Errorf("%w: refresh token", errorhandling.ErrDuplicateKey)
+		}
+	}
+
+	token.ID = store.nextID
+	token.CreatedAt = time.Now()
+	store.nextID++
+	store.tokens[token.ID] = token
+
+	return token, nil
+}
@@ -1,24 +1,72 @@
 package inmemorystores
 
 import (
+	"context"
 	"sync"
 
 	"um6p.ma/finalproject/models"
 )
 
 type ReportStore struct {
-	mu     sync.RWMutex
-	report models.SalesReport
+	mu      sync.RWMutex
+	reports []models.SalesReport
+	nextID  int
+}
+
+func NewInMemoryReportStore() *ReportStore {
+	return &ReportStore{nextID: 1}
 }
 
 func (rs *ReportStore) StoreReport(report models.SalesReport) {
 	rs.mu.Lock()
 	defer rs.mu.Unlock()
-	rs.report = report
+
+	if rs.nextID == 0 {
+		rs.nextID = 1
+	}
+	report.ID = rs.nextID
+	rs.nextID++
+	for i := range report.TopSellingBooks {
+		report.TopSellingBooks[i].ReportID = report.ID
+	}
+	rs.reports = append(rs.reports, report)
 }
 
 func (rs *ReportStore) GetLatestReport() models.SalesReport {
 	rs.mu.RLock()
 	defer rs.mu.RUnlock()
-	return rs.report
+
+	if len(rs.reports) == 0 {
+		return models.SalesReport{}
+	}
+	return rs.reports[len(rs.reports)-1]
+}
+
+func (rs *ReportStore) CreateReport(ctx context.Context, report models.SalesReport) (models.SalesReport, error) {
+	select {
+	case <-ctx.Done():
+		return models.SalesReport{}, ctx.Err()
+	default:
+	}
+
+	rs.StoreReport(report)
+	return rs.GetLatestReport(), nil
+}
+
+func (rs *ReportStore) GetLatestReports(ctx context.Context, limit int) ([]models.SalesReport, error) {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	var reports []models.SalesReport
+	for i := len(rs.reports) - 1; i >= 0 && len(reports) < limit; i-- {
+		reports = append(reports, rs.reports[i])
+	}
+
+	return reports, nil
 }
@@ -0,0 +1,252 @@
+package inmemorystores
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"um6p.ma/finalproject/constants"
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/models"
+)
+
+type InMemoryRoleStore struct {
+	mu               sync.RWMutex
+	roles            map[string]models.Role
+	implications     map[int]models.PermissionImplication
+	nextID           int
+	nextPermissionID int
+	nextRuleID       int
+}
+
+// NewInMemoryRoleStore creates a role store seeded with the built-in roles and implication rules
+func NewInMemoryRoleStore() *InMemoryRoleStore {
+	store := &InMemoryRoleStore{
+		roles:            make(map[string]models.Role),
+		implications:     make(map[int]models.PermissionImplication),
+		nextID:           1,
+		nextPermissionID: 1,
+		nextRuleID:       1,
+	}
+
+	for _, name := range []string{constants.RoleAdmin, constants.RoleManager, constants.RoleEmployee, constants.RoleUser} {
+		store.createRole(models.Role{
+			Name:        name,
+			Description: constants.GetRoleDescription(name),
+			Permissions: permissionsOf(constants.DefaultRolePermissions[name]),
+		})
+	}
+	for _, rule := range constants.DefaultPermissionImplications {
+		store.implications[store.nextRuleID] = models.PermissionImplication{
+			ID:         store.nextRuleID,
+			Permission: rule[0],
+			Implies:    rule[1],
+		}
+		store.nextRuleID++
+	}
+
+	return store
+}
+
+func (store *InMemoryRoleStore) ListRoles(ctx context.Context) ([]models.Role, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	roles := make([]models.Role, 0, len(store.roles))
+	for _, role := range store.roles {
+		roles = append(roles, copyRole(role))
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].ID < roles[j].ID })
+
+	return roles, nil
+}
+
+func (store *InMemoryRoleStore) GetRole(ctx context.Context, name string) (models.Role, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	select {
+	case <-ctx.Done():
+		return models.Role{}, ctx.Err()
+	default:
+	}
+
+	role, exists := store.roles[name]
+	if !exists {
+		return models.Role{}, errorhandling.ErrRoleNotFound
+	}
+	return copyRole(role), nil
+}
+
+func (store *InMemoryRoleStore) CreateRole(ctx context.Context, role models.Role) (models.Role, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return models.Role{}, ctx.Err()
+	default:
+	}
+
+	if _, exists := store.roles[role.Name]; exists {
+		return models.Role{}, fmt.Errorf("%w: role %s", errorhandling.ErrDuplicateKey, role.Name)
+	}
+
+	return copyRole(store.createRole(role)), nil
+}
+
+// UpdateRole replaces the description, permissions and two-factor requirement of a role. Its
+// name cannot change.
+func (store *InMemoryRoleStore) UpdateRole(ctx context.Context, name string, role models.Role) (models.Role, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return models.Role{}, ctx.Err()
+	default:
+	}
+
+	existing, exists := store.roles[name]
+	if !exists {
+		return models.Role{}, errorhandling.ErrRoleNotFound
+	}
+
+	existing.Description = role.Description
+	existing.RequireTwoFactor = role.RequireTwoFactor
+	existing.Permissions = store.assignPermissions(existing.ID, role.Permissions)
+	store.roles[name] = existing
+
+	return copyRole(existing), nil
+}
+
+func (store *InMemoryRoleStore) DeleteRole(ctx context.Context, name string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	if _, exists := store.roles[name]; !exists {
+		return errorhandling.ErrRoleNotFound
+	}
+	delete(store.roles, name)
+
+	return nil
+}
+
+func (store *InMemoryRoleStore) ListPermissionImplications(ctx context.Context) ([]models.PermissionImplication, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	implications := make([]models.PermissionImplication, 0, len(store.implications))
+	for _, implication := range store.implications {
+		implications = append(implications, implication)
+	}
+	sort.Slice(implications, func(i, j int) bool { return implications[i].ID < implications[j].ID })
+
+	return implications, nil
+}
+
+func (store *InMemoryRoleStore) CreatePermissionImplication(ctx context.Context, implication models.PermissionImplication) (models.PermissionImplication, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return models.PermissionImplication{}, ctx.Err()
+	default:
+	}
+
+	for _, existing := range store.implications {
+		if existing.Permission == implication.Permission && existing.Implies == implication.Implies {
+			return models.PermissionImplication{}, fmt.Errorf("%w: %s implies %s", errorhandling.ErrDuplicateKey, implication.Permission, implication.Implies)
+		}
+	}
+
+	implication.ID = store.nextRuleID
+	store.nextRuleID++
+	store.implications[implication.ID] = implication
+
+	return implication, nil
+}
+
+func (store *InMemoryRoleStore) DeletePermissionImplication(ctx context.Context, id int) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	if _, exists := store.implications[id]; !exists {
+		return errorhandling.ErrImplicationNotFound
+	}
+	delete(store.implications, id)
+
+	return nil
+}
+
+// createRole stores a new role. The caller must hold the write lock.
+func (store *InMemoryRoleStore) createRole(role models.Role) models.Role {
+	role.ID = store.nextID
+	role.CreatedAt = time.Now()
+	store.nextID++
+	role.Permissions = store.assignPermissions(role.ID, role.Permissions)
+	store.roles[role.Name] = role
+	return role
+}
+
+// assignPermissions numbers the permissions of a role, dropping duplicates. The caller must
+// hold the write lock.
+func (store *InMemoryRoleStore) assignPermissions(roleID int, permissions []models.RolePermission) []models.RolePermission {
+	assigned := make([]models.RolePermission, 0, len(permissions))
+	seen := make(map[string]bool)
+	for _, permission := range permissions {
+		if seen[permission.Permission] {
+			continue
+		}
+		seen[permission.Permission] = true
+		assigned = append(assigned, models.RolePermission{
+			ID:         store.nextPermissionID,
+			RoleID:     roleID,
+			Permission: permission.Permission,
+		})
+		store.nextPermissionID++
+	}
+	return assigned
+}
+
+// copyRole copies a role, so that callers cannot modify the stored permissions
+func copyRole(role models.Role) models.Role {
+	role.Permissions = append([]models.RolePermission(nil), role.Permissions...)
+	return role
+}
+
+// permissionsOf builds the permissions of a role from their names
+func permissionsOf(names []string) []models.RolePermission {
+	permissions := make([]models.RolePermission, 0, len(names))
+	for _, name := range names {
+		permissions = append(permissions, models.RolePermission{Permission: name})
+	}
+	return permissions
+}
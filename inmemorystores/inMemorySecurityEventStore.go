@@ -0,0 +1,66 @@
+package inmemorystores
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"um6p.ma/finalproject/models"
+)
+
+type InMemorySecurityEventStore struct {
+	mu     sync.RWMutex
+	events []models.SecurityEvent // Ordered by ID
+	nextID int
+}
+
+func NewInMemorySecurityEventStore() *InMemorySecurityEventStore {
+	return &InMemorySecurityEventStore{nextID: 1}
+}
+
+func (store *InMemorySecurityEventStore) RecordSecurityEvent(ctx context.Context, event models.SecurityEvent) (models.SecurityEvent, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return models.SecurityEvent{}, ctx.Err()
+	default:
+	}
+
+	event.ID = store.nextID
+	event.CreatedAt = time.Now()
+	store.nextID++
+	store.events = append(store.events, event)
+
+	return event, nil
+}
+
+// ListSecurityEvents returns the events matching a filter, most recent first
+func (store *InMemorySecurityEventStore) ListSecurityEvents(ctx context.Context, filter models.SecurityEventFilter) ([]models.SecurityEvent, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	events := make([]models.SecurityEvent, 0)
+	for i := len(store.events) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(events) >= filter.Limit {
+			break
+		}
+		event := store.events[i]
+		if filter.Type != "" && event.Type != filter.Type {
+			continue
+		}
+		if filter.UserID != 0 && (event.UserID == nil || *event.UserID != filter.UserID) {
+			continue
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
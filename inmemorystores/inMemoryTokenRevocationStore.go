@@ -0,0 +1,88 @@
+package inmemorystores
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"um6p.ma/finalproject/models"
+)
+
+// InMemoryTokenRevocationStore keeps revocations until the tokens they revoke have expired
+type InMemoryTokenRevocationStore struct {
+	mu     sync.RWMutex
+	tokens map[string]time.Time           // Expiry of the revocation of each JTI
+	users  map[int]models.TokenRevocation // Latest cutoff revoking the tokens of each user
+}
+
+func NewInMemoryTokenRevocationStore() *InMemoryTokenRevocationStore {
+	return &InMemoryTokenRevocationStore{
+		tokens: make(map[string]time.Time),
+		users:  make(map[int]models.TokenRevocation),
+	}
+}
+
+func (store *InMemoryTokenRevocationStore) RevokeToken(ctx context.Context, revocation models.TokenRevocation) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	store.purgeExpired(time.Now())
+
+	if revocation.JTI != "" {
+		if revocation.ExpiresAt.After(store.tokens[revocation.JTI]) {
+			store.tokens[revocation.JTI] = revocation.ExpiresAt
+		}
+	}
+	if revocation.IssuedBefore != nil {
+		existing, exists := store.users[revocation.UserID]
+		if !exists || revocation.IssuedBefore.After(*existing.IssuedBefore) {
+			if exists && existing.ExpiresAt.After(revocation.ExpiresAt) {
+				revocation.ExpiresAt = existing.ExpiresAt
+			}
+			revocation.CreatedAt = time.Now()
+			store.users[revocation.UserID] = revocation
+		}
+	}
+
+	return nil
+}
+
+func (store *InMemoryTokenRevocationStore) IsTokenRevoked(ctx context.Context, jti string, userID int, issuedAt time.Time) (bool, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	default:
+	}
+
+	now := time.Now()
+	if expiresAt, exists := store.tokens[jti]; exists && jti != "" && now.Before(expiresAt) {
+		return true, nil
+	}
+	if revocation, exists := store.users[userID]; exists && now.Before(revocation.ExpiresAt) {
+		return issuedAt.Before(*revocation.IssuedBefore), nil
+	}
+	return false, nil
+}
+
+// purgeExpired drops the revocations whose tokens have expired. The caller must hold the write lock.
+func (store *InMemoryTokenRevocationStore) purgeExpired(now time.Time) {
+	for jti, expiresAt := range store.tokens {
+		if !now.Before(expiresAt) {
+			delete(store.tokens, jti)
+		}
+	}
+	for userID, revocation := range store.users {
+		if !now.Before(revocation.ExpiresAt) {
+			delete(store.users, userID)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package inmemorystores
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/models"
+)
+
+type InMemoryTwoFactorStore struct {
+	mu     sync.Mutex
+	codes  map[int][]models.RecoveryCode // Recovery codes by user ID
+	nextID int
+	users  *InMemoryUserStore
+}
+
+// NewInMemoryTwoFactorStore creates a store recording the accepted TOTP steps in the users of users
+func NewInMemoryTwoFactorStore(users *InMemoryUserStore) *InMemoryTwoFactorStore {
+	return &InMemoryTwoFactorStore{
+		codes:  make(map[int][]models.RecoveryCode),
+		nextID: 1,
+		users:  users,
+	}
+}
+
+// AcceptTOTPStep records the time step of a code used by a user. It fails with
+// ErrInvalidTwoFactorCode unless the step is later than the last one accepted, so that each
+// code can only be used once.
+func (store *InMemoryTwoFactorStore) AcceptTOTPStep(ctx context.Context, userID int, step int64) error {
+	store.users.mu.Lock()
+	defer store.users.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	user, exists := store.users.users[userID]
+	if !exists {
+		return errorhandling.ErrUserNotFound
+	}
+	if step <= user.TOTPLastStep {
+		return errorhandling.ErrInvalidTwoFactorCode
+	}
+	user.TOTPLastStep = step
+	store.users.users[userID] = user
+
+	return nil
+}
+
+// ReplaceRecoveryCodes replaces the recovery codes of a user, given by their hashes
+func (store *InMemoryTwoFactorStore) ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	codes := make([]models.RecoveryCode, 0, len(codeHashes))
+	for _, hash := range codeHashes {
+		codes = append(codes, models.RecoveryCode{ID: store.nextID, UserID: userID, CodeHash: hash})
+		store.nextID++
+	}
+	if len(codes) == 0 {
+		delete(store.codes, userID)
+	} else {
+		store.codes[userID] = codes
+	}
+
+	return nil
+}
+
+// UseRecoveryCode marks a recovery code of a user as used. It fails with
+// ErrInvalidTwoFactorCode if the user has no such unused code.
+func (store *InMemoryTwoFactorStore) UseRecoveryCode(ctx context.Context, userID int, codeHash string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	for i, code := range store.codes[userID] {
+		if code.CodeHash == codeHash && code.UsedAt == nil {
+			now := time.Now()
+			store.codes[userID][i].UsedAt = &now
+			return nil
+		}
+	}
+	return errorhandling.ErrInvalidTwoFactorCode
+}
+
+// CountRecoveryCodes returns the number of unused recovery codes of a user
+func (store *InMemoryTwoFactorStore) CountRecoveryCodes(ctx context.Context, userID int) (int, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	default:
+	}
+
+	count := 0
+	for _, code := range store.codes[userID] {
+		if code.UsedAt == nil {
+			count++
+		}
+	}
+	return count, nil
+}
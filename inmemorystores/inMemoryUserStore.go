@@ -0,0 +1,153 @@
+package inmemorystores
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/models"
+)
+
+type InMemoryUserStore struct {
+	mu     sync.RWMutex
+	users  map[int]models.User
+	nextID int
+}
+
+func NewInMemoryUserStore() *InMemoryUserStore {
+	return &InMemoryUserStore{
+		users:  make(map[int]models.User),
+		nextID: 1,
+	}
+}
+
+func (store *InMemoryUserStore) CreateUser(ctx context.Context, user models.User) (models.User, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return models.User{}, ctx.Err()
+	default:
+	}
+
+	for _, existing := range store.users {
+		if strings.EqualFold(existing.Email, user.Email) {
+			return models.User{}, fmt.Errorf("%w: email %s", errorhandling.ErrDuplicateKey, user.Email)
+		}
+	}
+
+	user.ID = store.nextID
+	user.CreatedAt = time.Now()
+	store.nextID++
+	store.users[user.ID] = user
+
+	return user, nil
+}
+
+func (store *InMemoryUserStore) GetUser(ctx context.Context, id int) (models.User, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	select {
+	case <-ctx.Done():
+		return models.User{}, ctx.Err()
+	default:
+	}
+
+	user, exists := store.users[id]
+	if !exists {
+		return models.User{}, errorhandling.ErrUserNotFound
+	}
+
+	return user, nil
+}
+
+func (store *InMemoryUserStore) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	select {
+	case <-ctx.Done():
+		return models.User{}, ctx.Err()
+	default:
+	}
+
+	for _, user := range store.users {
+		if strings.EqualFold(user.Email, email) {
+			return user, nil
+		}
+	}
+
+	return models.User{}, errorhandling.ErrUserNotFound
+}
+
+func (store *InMemoryUserStore) ListUsers(ctx context.Context) ([]models.User, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	users := make([]models.User, 0, len(store.users))
+	for _, user := range store.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+
+	return users, nil
+}
+
+func (store *InMemoryUserStore) UpdateUser(ctx context.Context, id int, user models.User) (models.User, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return models.User{}, ctx.Err()
+	default:
+	}
+
+	existing, exists := store.users[id]
+	if !exists {
+		return models.User{}, errorhandling.ErrUserNotFound
+	}
+	for otherID, other := range store.users {
+		if otherID != id && strings.EqualFold(other.Email, user.Email) {
+			return models.User{}, fmt.Errorf("%w: email %s", errorhandling.ErrDuplicateKey, user.Email)
+		}
+	}
+
+	user.ID = id
+	user.CreatedAt = existing.CreatedAt
+	// Accepted TOTP steps only move forward, so that a stale copy of the user cannot let a code be replayed
+	user.TOTPLastStep = max(user.TOTPLastStep, existing.TOTPLastStep)
+	store.users[id] = user
+
+	return user, nil
+}
+
+func (store *InMemoryUserStore) DeleteUser(ctx context.Context, id int) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	if _, exists := store.users[id]; !exists {
+		return errorhandling.ErrUserNotFound
+	}
+	delete(store.users, id)
+
+	return nil
+}
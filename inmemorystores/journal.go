@@ -0,0 +1,373 @@
+package inmemorystores
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Journal operations
+const (
+	opPut    = "put"
+	opDelete = "delete"
+	opBatch  = "batch" // Entries written, and replayed, all or none
+)
+
+// journalEntry is one line of a journal file. Entries of a journal shared by several
+// collections name the collection they belong to, unless it is the journal's own.
+type journalEntry struct {
+	Op         string          `json:"op"`
+	Collection string          `json:"collection,omitempty"`
+	ID         int             `json:"id,omitempty"`
+	Data       json.RawMessage `json:"data,omitempty"`
+	Entries    []journalEntry  `json:"entries,omitempty"`
+}
+
+// newJournalEntry encodes a mutation of the record with the given ID
+func newJournalEntry(op string, id int, record interface{}) (journalEntry, error) {
+	entry := journalEntry{Op: op, ID: id}
+	if record != nil {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return journalEntry{}, err
+		}
+		entry.Data = data
+	}
+	return entry, nil
+}
+
+// journal is an append-only write-ahead log of the mutations of one collection, or of several
+// collections that change together, backed by a snapshot of each whole collection. Entries are fsynced before the mutation is applied in
+// memory, and recovery loads the last snapshot and replays the log over it. Puts carry the
+// full record and deletes are by ID, so replaying an entry twice is harmless.
+type journal struct {
+	mu           sync.Mutex
+	dir          string
+	name         string
+	snapshotPath string
+	logPath      string
+	file         *os.File
+	writer       *bufio.Writer
+	entries      int
+	dirty        bool // Buffered entries have not been flushed yet
+	options      options
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// openJournal opens (creating if needed) the journal of the named collection in dir
+func openJournal(dir, name string, o options) (*journal, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create journal directory: %w", err)
+	}
+
+	logPath := filepath.Join(dir, name+".journal")
+	file, err := os.OpenFile(logPath, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+
+	return &journal{
+		dir:          dir,
+		name:         name,
+		snapshotPath: filepath.Join(dir, name+".json"),
+		logPath:      logPath,
+		file:         file,
+		writer:       bufio.NewWriter(file),
+		options:      o,
+	}, nil
+}
+
+// recover passes the snapshot, if any, to loadSnapshot and then every logged entry to apply.
+// A torn entry at the end of the log, left by a crash during a write, is discarded.
+func (j *journal) recover(loadSnapshot func([]byte) error, apply func(journalEntry) error) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	data, err := os.ReadFile(j.snapshotPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	if err == nil {
+		if err := loadSnapshot(data); err != nil {
+			return fmt.Errorf("failed to load snapshot %s: %w", j.snapshotPath, err)
+		}
+	}
+
+	if _, err := j.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(j.file)
+	var offset int64
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if readErr != nil && !errors.Is(readErr, io.EOF) {
+			return fmt.Errorf("failed to read journal: %w", readErr)
+		}
+
+		var entry journalEntry
+		complete := len(line) > 0 && line[len(line)-1] == '\n'
+		if len(bytes.TrimSpace(line)) > 0 {
+			if err := json.Unmarshal(line, &entry); err != nil || !complete {
+				log.Printf("⚠️ Discarding torn entry at the end of %s", j.logPath)
+				if err := j.file.Truncate(offset); err != nil {
+					return fmt.Errorf("failed to truncate journal: %w", err)
+				}
+				break
+			}
+			if err := replay(entry, apply); err != nil {
+				return fmt.Errorf("failed to replay journal entry %d: %w", j.entries+1, err)
+			}
+			j.entries++
+		}
+		offset += int64(len(line))
+
+		if errors.Is(readErr, io.EOF) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// replay applies an entry, or each entry of a batch
+func replay(entry journalEntry, apply func(journalEntry) error) error {
+	if entry.Op != opBatch {
+		return apply(entry)
+	}
+	for _, batched := range entry.Entries {
+		if err := apply(batched); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// append writes an entry for the given record. Unless the journal is batched, the entry is
+// flushed to disk before append returns.
+func (j *journal) append(op string, id int, record interface{}) error {
+	entry, err := newJournalEntry(op, id, record)
+	if err != nil {
+		return err
+	}
+	return j.write(entry)
+}
+
+func (j *journal) write(entry journalEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := j.writer.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to append to journal: %w", err)
+	}
+
+	j.entries++
+	j.dirty = true
+	if j.options.flushInterval > 0 {
+		return nil
+	}
+	return j.flushLocked()
+}
+
+// flush writes the buffered entries, if any, and syncs them to disk
+func (j *journal) flush() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.flushLocked()
+}
+
+func (j *journal) flushLocked() error {
+	if !j.dirty {
+		return nil
+	}
+	if err := j.writer.Flush(); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync journal: %w", err)
+	}
+
+	j.dirty = false
+	return nil
+}
+
+// needsCompaction reports whether the log has grown past the compaction threshold
+func (j *journal) needsCompaction() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.options.compactAfter > 0 && j.entries >= j.options.compactAfter
+}
+
+// compact replaces the snapshot with the given collection and empties the log
+func (j *journal) compact(collection interface{}) error {
+	return j.compactAll(map[string]interface{}{j.name: collection})
+}
+
+// compactAll replaces the snapshot of each named collection and empties the log. Every
+// collection with entries in the log must be given.
+func (j *journal) compactAll(collections map[string]interface{}) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	// A crash between two snapshots leaves the log whole, and replaying it over old and new
+	// snapshots alike yields the latest records
+	for name, collection := range collections {
+		data, err := json.MarshalIndent(collection, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(j.snapshotPathOf(name), data, 0600); err != nil {
+			return fmt.Errorf("failed to write snapshot: %w", err)
+		}
+	}
+
+	// Buffered entries are part of the snapshot, so they are dropped rather than written.
+	// A crash before the truncation only means the old entries are replayed again.
+	j.writer.Reset(j.file)
+	j.dirty = false
+	if err := j.file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate journal: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return err
+	}
+
+	j.entries = 0
+	return nil
+}
+
+// readSnapshot returns the snapshot of the named collection, or nil if there is none
+func (j *journal) readSnapshot(name string) ([]byte, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	data, err := os.ReadFile(j.snapshotPathOf(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	return data, nil
+}
+
+func (j *journal) snapshotPathOf(name string) string {
+	return filepath.Join(j.dir, name+".json")
+}
+
+// start runs the batched flushes and the periodic snapshots configured in the options, in the
+// background until stopBackground is called
+func (j *journal) start(snapshot func() error) {
+	if j.options.flushInterval <= 0 && j.options.snapshotInterval <= 0 {
+		return
+	}
+
+	j.stop = make(chan struct{})
+	j.done = make(chan struct{})
+	go func() {
+		defer close(j.done)
+
+		var flushes, snapshots <-chan time.Time
+		if j.options.flushInterval > 0 {
+			ticker := time.NewTicker(j.options.flushInterval)
+			defer ticker.Stop()
+			flushes = ticker.C
+		}
+		if j.options.snapshotInterval > 0 {
+			ticker := time.NewTicker(j.options.snapshotInterval)
+			defer ticker.Stop()
+			snapshots = ticker.C
+		}
+
+		for {
+			select {
+			case <-j.stop:
+				return
+			case <-flushes:
+				if err := j.flush(); err != nil {
+					log.Printf("❌ Failed to flush %s: %v", j.logPath, err)
+				}
+			case <-snapshots:
+				if err := snapshot(); err != nil {
+					log.Printf("⚠️ Failed to write snapshot %s: %v", j.snapshotPath, err)
+				}
+			}
+		}
+	}()
+}
+
+// stopBackground stops the background flushes and snapshots and waits for them to finish.
+// It must not be called with the lock of the owning store held.
+func (j *journal) stopBackground() {
+	if j.stop == nil {
+		return
+	}
+	close(j.stop)
+	<-j.done
+	j.stop = nil
+}
+
+// close flushes the pending entries and releases the journal file
+func (j *journal) close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.flushLocked(); err != nil {
+		j.file.Close()
+		return err
+	}
+	return j.file.Close()
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it into place,
+// so readers only ever see the old or the new content
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	// Persist the rename itself
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
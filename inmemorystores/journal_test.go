@@ -0,0 +1,226 @@
+package inmemorystores
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"um6p.ma/finalproject/models"
+)
+
+// crash releases the journal of a book store without compacting it, as if the process died
+func crash(t *testing.T, store *InMemoryBookStore) {
+	t.Helper()
+	if err := store.journal.close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func openBooks(t *testing.T, dir string, opts ...Option) *InMemoryBookStore {
+	t.Helper()
+	store, err := NewInMemoryBookStore(append([]Option{WithJournal(dir)}, opts...)...)
+	if err != nil {
+		t.Fatalf("opening the books: %v", err)
+	}
+	return store
+}
+
+func assertBooks(t *testing.T, store *InMemoryBookStore, titles map[int]string) {
+	t.Helper()
+	books, err := store.GetAllBooks(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(books) != len(titles) {
+		t.Fatalf("got %d books, want %d", len(books), len(titles))
+	}
+	for _, book := range books {
+		if titles[book.ID] != book.Title {
+			t.Errorf("book %d: got %q, want %q", book.ID, book.Title, titles[book.ID])
+		}
+	}
+}
+
+func TestJournalReplaysMutationsAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	store := openBooks(t, dir)
+	for _, title := range []string{"Emma", "Persuasion", "Sanditon"} {
+		if _, err := store.CreateBook(ctx, models.Book{Title: title}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.UpdateBook(ctx, 1, models.Book{Title: "Emma, revised"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteBook(ctx, 3); err != nil {
+		t.Fatal(err)
+	}
+	crash(t, store)
+
+	recovered := openBooks(t, dir)
+	defer recovered.Close()
+	assertBooks(t, recovered, map[int]string{1: "Emma, revised", 2: "Persuasion"})
+
+	// IDs are not reused after recovery
+	book, err := recovered.CreateBook(ctx, models.Book{Title: "Lady Susan"})
+	if err != nil || book.ID != 4 {
+		t.Errorf("got ID %d (%v), want 4", book.ID, err)
+	}
+}
+
+func TestJournalDiscardsTornEntry(t *testing.T) {
+	dir := t.TempDir()
+	store := openBooks(t, dir)
+	if _, err := store.CreateBook(context.Background(), models.Book{Title: "Emma"}); err != nil {
+		t.Fatal(err)
+	}
+	crash(t, store)
+
+	// A write cut short by the crash
+	logPath := filepath.Join(dir, "books.journal")
+	file, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"op":"put","id":2,"data":{"Title":"Persu`)
+	file.Close()
+
+	recovered := openBooks(t, dir)
+	assertBooks(t, recovered, map[int]string{1: "Emma"})
+	if _, err := recovered.CreateBook(context.Background(), models.Book{Title: "Persuasion"}); err != nil {
+		t.Fatal(err)
+	}
+	crash(t, recovered)
+
+	// The torn entry was truncated, so the next entry is readable
+	again := openBooks(t, dir)
+	defer again.Close()
+	assertBooks(t, again, map[int]string{1: "Emma", 2: "Persuasion"})
+}
+
+func TestJournalCompactsIntoSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	store := openBooks(t, dir, WithCompactAfter(2))
+	for _, title := range []string{"Emma", "Persuasion", "Sanditon"} {
+		if _, err := store.CreateBook(ctx, models.Book{Title: title}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if store.journal.entries != 1 {
+		t.Errorf("got %d entries in the log, want 1 after compaction", store.journal.entries)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "books.json")); err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	crash(t, store)
+
+	recovered := openBooks(t, dir)
+	assertBooks(t, recovered, map[int]string{1: "Emma", 2: "Persuasion", 3: "Sanditon"})
+
+	// Closing compacts everything into the snapshot
+	if err := recovered.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "books.journal")); err != nil || info.Size() != 0 {
+		t.Errorf("log after Close: got %v, want an empty file", err)
+	}
+	reopened := openBooks(t, dir)
+	defer reopened.Close()
+	assertBooks(t, reopened, map[int]string{1: "Emma", 2: "Persuasion", 3: "Sanditon"})
+}
+
+// openOrders opens the books journaled in dir and the orders journaled with them
+func openOrders(t *testing.T, dir string, opts ...Option) (*InMemoryBookStore, *InMemoryOrderStore) {
+	t.Helper()
+	books := openBooks(t, dir, opts...)
+	orders, err := NewInMemoryOrderStore(books)
+	if err != nil {
+		t.Fatalf("opening the orders: %v", err)
+	}
+	return books, orders
+}
+
+func assertOrders(t *testing.T, orders *InMemoryOrderStore, want int) {
+	t.Helper()
+	all, err := orders.GetAllOrders(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != want {
+		t.Errorf("got %d orders, want %d", len(all), want)
+	}
+}
+
+func TestJournalReplaysOrdersWithTheirStock(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	books, orders := openOrders(t, dir)
+	for _, title := range []string{"Emma", "Persuasion"} {
+		if _, err := books.CreateBook(ctx, models.Book{Title: title, Price: 10, Stock: 3}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := orders.CreateOrder(ctx, orderOf(1, 3)); err != nil {
+		t.Fatal(err)
+	}
+	crash(t, books)
+
+	books, orders = openOrders(t, dir)
+	assertStock(t, books, 2, 0)
+	assertOrders(t, orders, 1)
+
+	// An order cut short by a crash is dropped along with its stock
+	if _, err := books.UpdateBook(ctx, 2, models.Book{Title: "Persuasion", Price: 10, Stock: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := orders.CreateOrder(ctx, orderOf(2, 2)); err != nil {
+		t.Fatal(err)
+	}
+	crash(t, books)
+	logPath := filepath.Join(dir, "books.journal")
+	info, err := os.Stat(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(logPath, info.Size()-10); err != nil {
+		t.Fatal(err)
+	}
+
+	books, orders = openOrders(t, dir)
+	defer books.Close()
+	assertStock(t, books, 2, 3)
+	assertOrders(t, orders, 1)
+}
+
+func TestJournalCompactsOrdersWithTheBooks(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	books, orders := openOrders(t, dir, WithCompactAfter(2))
+	if _, err := books.CreateBook(ctx, models.Book{Title: "Emma", Price: 10, Stock: 5}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := orders.CreateOrder(ctx, orderOf(1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "orders.json")); err != nil {
+		t.Fatalf("orders snapshot: %v", err)
+	}
+	crash(t, books)
+
+	books, orders = openOrders(t, dir)
+	assertStock(t, books, 2)
+	assertOrders(t, orders, 3)
+
+	if err := books.Close(); err != nil {
+		t.Fatal(err)
+	}
+	books, orders = openOrders(t, dir)
+	defer books.Close()
+	assertStock(t, books, 2)
+	assertOrders(t, orders, 3)
+}
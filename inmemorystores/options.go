@@ -0,0 +1,62 @@
+package inmemorystores
+
+import "time"
+
+// defaultCompactAfter is the number of journal entries after which a snapshot is written
+const defaultCompactAfter = 1000
+
+// Option configures the persistence of an in-memory store
+type Option func(*options)
+
+type options struct {
+	journalDir       string
+	compactAfter     int
+	snapshotInterval time.Duration
+	flushInterval    time.Duration
+}
+
+// WithJournal persists the store in dir: every mutation is appended to a journal, which is
+// compacted into a snapshot from time to time, and both are loaded back on startup
+func WithJournal(dir string) Option {
+	return func(o *options) {
+		o.journalDir = dir
+	}
+}
+
+// WithCompactAfter sets how many journal entries accumulate before they are compacted into
+// a snapshot. Zero disables compaction by entry count.
+func WithCompactAfter(entries int) Option {
+	return func(o *options) {
+		o.compactAfter = entries
+	}
+}
+
+// WithSnapshotInterval also writes a snapshot at the given interval. Zero disables it.
+func WithSnapshotInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.snapshotInterval = interval
+	}
+}
+
+// WithBatchedWrites buffers journal entries and flushes them to disk at the given interval
+// instead of on every mutation. Faster, but a crash loses up to one interval of changes.
+// Zero flushes on every mutation, which is the default.
+func WithBatchedWrites(interval time.Duration) Option {
+	return func(o *options) {
+		o.flushInterval = interval
+	}
+}
+
+// openStoreJournal applies the options and opens the journal of the named collection,
+// returning nil when no journal is configured
+func openStoreJournal(name string, opts []Option) (*journal, error) {
+	o := options{compactAfter: defaultCompactAfter}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if o.journalDir == "" {
+		return nil, nil
+	}
+	return openJournal(o.journalDir, name, o)
+}
@@ -0,0 +1,90 @@
+package inmemorystores
+
+import (
+	"fmt"
+
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/models"
+)
+
+// priceOrder prices the order items at the current prices of their books. A total submitted
+// with the order must match the computed one. The caller must hold the lock.
+func (store *InMemoryBookStore) priceOrder(order *models.Order) error {
+	if err := models.CheckItems(order.Items); err != nil {
+		return err
+	}
+	prices := make(map[int]float64, len(order.Items))
+	for _, item := range order.Items {
+		book, exists := store.books[item.BookID]
+		if !exists {
+			return errorhandling.ErrInvalidInput.WithDetails(fmt.Sprintf("Book with ID %d does not exist", item.BookID))
+		}
+		prices[book.ID] = book.Price
+	}
+
+	submitted := order.TotalPrice
+	if err := order.PriceItems(prices); err != nil {
+		return err
+	}
+	if submitted != 0 && models.RoundAmount(submitted) != order.TotalPrice {
+		return errorhandling.NewTotalPriceMismatchError(submitted, order.TotalPrice)
+	}
+	return nil
+}
+
+// reserveStock returns the books updated with the stock changes, without storing them. It
+// fails if a book would go out of stock, listing every short item, or if stock is taken from
+// a missing book. Stock returned to a book that no longer exists is dropped. The caller must
+// hold the lock.
+func (store *InMemoryBookStore) reserveStock(changes []models.StockChange) ([]models.Book, error) {
+	var shortages []errorhandling.StockShortage
+	updated := make([]models.Book, 0, len(changes))
+	for _, change := range changes {
+		book, exists := store.books[change.BookID]
+		if !exists && change.Delta > 0 {
+			continue
+		}
+		if !exists {
+			return nil, errorhandling.ErrInvalidInput.WithDetails(fmt.Sprintf("Book with ID %d does not exist", change.BookID))
+		}
+
+		if book.Stock+change.Delta < 0 {
+			shortages = append(shortages, errorhandling.StockShortage{
+				BookID:    change.BookID,
+				Requested: -change.Delta,
+				Available: book.Stock,
+			})
+			continue
+		}
+		book.Stock += change.Delta
+		updated = append(updated, book)
+	}
+	if len(shortages) > 0 {
+		return nil, errorhandling.NewInsufficientStockError(shortages)
+	}
+	return updated, nil
+}
+
+// recordWithStock journals a mutation of an order together with the books whose stock it
+// changes, in a single entry, so that a crash keeps both or neither. The caller must hold the
+// write lock of both stores, and the book store must have a journal.
+func (store *InMemoryBookStore) recordWithStock(order journalEntry, books []models.Book) error {
+	order.Collection = ordersCollection
+	entries := make([]journalEntry, 0, len(books)+1)
+	entries = append(entries, order)
+	for _, book := range books {
+		entry, err := newJournalEntry(opPut, book.ID, book)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+	}
+	return store.write(journalEntry{Op: opBatch, Entries: entries}, store.orders.snapshot)
+}
+
+// storeStock stores the books returned by reserveStock. The caller must hold the write lock.
+func (store *InMemoryBookStore) storeStock(books []models.Book) {
+	for _, book := range books {
+		store.books[book.ID] = book
+	}
+}
@@ -22,6 +22,13 @@ type CustomerStore interface {
 	UpdateCustomer(ctx context.Context, id int, customer models.Customer) (models.Customer, error)
 	DeleteCustomer(ctx context.Context, id int) error
 	GetAllCustomers(ctx context.Context) ([]models.Customer, error)
+	GetCustomerByEmail(ctx context.Context, email string) (models.Customer, error)
+	SearchCustomers(ctx context.Context, criteria models.CustomerSearchCriteria) ([]models.Customer, error)
+	GetCustomerByUserID(ctx context.Context, userID int) (models.Customer, error)
+	LinkCustomer(ctx context.Context, id int, userID int) (models.Customer, error)
+	AddCustomerAddress(ctx context.Context, customerID int, address models.CustomerAddress) (models.CustomerAddress, error)
+	UpdateCustomerAddress(ctx context.Context, customerID int, address models.CustomerAddress) (models.CustomerAddress, error)
+	DeleteCustomerAddress(ctx context.Context, customerID int, id int) error
 }
 
 type AuthorStore interface {
@@ -39,4 +46,93 @@ type OrderStore interface {
 	DeleteOrder(ctx context.Context, id int) error
 	GetAllOrders(ctx context.Context) ([]models.Order, error)
 	GetOrdersInTimeRange(ctx context.Context, start, end time.Time) ([]models.Order, error)
+	GetOrdersByCustomer(ctx context.Context, customerID int) ([]models.Order, error)
+	TransitionOrder(ctx context.Context, id int, change models.OrderStatusChange) (models.Order, error)
+}
+
+type ReportStore interface {
+	CreateReport(ctx context.Context, report models.SalesReport) (models.SalesReport, error)
+	GetLatestReports(ctx context.Context, limit int) ([]models.SalesReport, error)
+}
+
+type UserStore interface {
+	CreateUser(ctx context.Context, user models.User) (models.User, error)
+	GetUser(ctx context.Context, id int) (models.User, error)
+	GetUserByEmail(ctx context.Context, email string) (models.User, error)
+	ListUsers(ctx context.Context) ([]models.User, error)
+	UpdateUser(ctx context.Context, id int, user models.User) (models.User, error)
+	DeleteUser(ctx context.Context, id int) error
+}
+
+type InvitationStore interface {
+	CreateInvitation(ctx context.Context, invitation models.Invitation) (models.Invitation, error)
+	GetInvitationByHash(ctx context.Context, tokenHash string) (models.Invitation, error)
+	ListInvitations(ctx context.Context) ([]models.Invitation, error)
+	DeleteInvitation(ctx context.Context, id int) error
+	AcceptInvitation(ctx context.Context, id int, user models.User) (models.User, error)
+}
+
+type RefreshTokenStore interface {
+	CreateRefreshToken(ctx context.Context, token models.RefreshToken) (models.RefreshToken, error)
+	GetRefreshTokenByHash(ctx context.Context, tokenHash string) (models.RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, id int, next models.RefreshToken) (models.RefreshToken, error)
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) error
+	RevokeUserRefreshTokens(ctx context.Context, userID int, createdBefore time.Time) error
+}
+
+type TokenRevocationStore interface {
+	RevokeToken(ctx context.Context, revocation models.TokenRevocation) error
+	IsTokenRevoked(ctx context.Context, jti string, userID int, issuedAt time.Time) (bool, error)
+}
+
+type AccountTokenStore interface {
+	CreateAccountToken(ctx context.Context, token models.AccountToken) (models.AccountToken, error)
+	GetAccountToken(ctx context.Context, jti string) (models.AccountToken, error)
+	ConsumeAccountToken(ctx context.Context, jti string) (models.AccountToken, error)
+	RecordFailedAttempt(ctx context.Context, jti string, maxAttempts int) (models.AccountToken, error)
+}
+
+type TwoFactorStore interface {
+	AcceptTOTPStep(ctx context.Context, userID int, step int64) error
+	ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string) error
+	UseRecoveryCode(ctx context.Context, userID int, codeHash string) error
+	CountRecoveryCodes(ctx context.Context, userID int) (int, error)
+}
+
+type APIKeyStore interface {
+	ListAPIKeys(ctx context.Context) ([]models.APIKey, error)
+	GetAPIKey(ctx context.Context, id int) (models.APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (models.APIKey, error)
+	CreateAPIKey(ctx context.Context, key models.APIKey) (models.APIKey, error)
+	TouchAPIKey(ctx context.Context, id int, usedAt time.Time) error
+	RevokeAPIKey(ctx context.Context, id int) (models.APIKey, error)
+}
+
+type LoginThrottleStore interface {
+	GetLoginThrottle(ctx context.Context, identifier string) (models.LoginThrottle, error)
+	RecordLoginFailure(ctx context.Context, identifier string, at time.Time, window time.Duration) (models.LoginThrottle, error)
+	LockLogin(ctx context.Context, identifier string, until time.Time) error
+	ClearLoginThrottle(ctx context.Context, identifier string) error
+	ListLockedLogins(ctx context.Context, now time.Time) ([]models.LoginThrottle, error)
+}
+
+type SecurityEventStore interface {
+	RecordSecurityEvent(ctx context.Context, event models.SecurityEvent) (models.SecurityEvent, error)
+	ListSecurityEvents(ctx context.Context, filter models.SecurityEventFilter) ([]models.SecurityEvent, error)
+}
+
+type ImpersonationAuditStore interface {
+	RecordImpersonatedRequest(ctx context.Context, request models.ImpersonatedRequest) (models.ImpersonatedRequest, error)
+	ListImpersonatedRequests(ctx context.Context, filter models.ImpersonatedRequestFilter) ([]models.ImpersonatedRequest, error)
+}
+
+type RoleStore interface {
+	ListRoles(ctx context.Context) ([]models.Role, error)
+	GetRole(ctx context.Context, name string) (models.Role, error)
+	CreateRole(ctx context.Context, role models.Role) (models.Role, error)
+	UpdateRole(ctx context.Context, name string, role models.Role) (models.Role, error)
+	DeleteRole(ctx context.Context, name string) error
+	ListPermissionImplications(ctx context.Context) ([]models.PermissionImplication, error)
+	CreatePermissionImplication(ctx context.Context, implication models.PermissionImplication) (models.PermissionImplication, error)
+	DeletePermissionImplication(ctx context.Context, id int) error
 }
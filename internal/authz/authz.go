@@ -0,0 +1,519 @@
+// Package authz decides whether a subject may perform an action on a resource, following a
+// policy of rules read from a JSON file. Rules match actions such as "orders:read" and test
+// attributes of the subject, of the resource and of the environment of the request. The file
+// is watched, so that an edited policy applies without restarting the server.
+package authz
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Effects of the rules
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+// ScopeOwner makes a rule apply only to the resources owned by the subject, and restricts the
+// lists it allows to those resources
+const ScopeOwner = "owner"
+
+// BuiltInSource is the source of the policy shipped with the server, used when no file is set
+const BuiltInSource = "built-in"
+
+//go:embed defaultPolicy.json
+var defaultPolicy []byte
+
+// Policy is the set of rules deciding every request. A request is allowed when an allow rule
+// matches it and no deny rule does.
+type Policy struct {
+	Conceal []string `json:"conceal,omitempty"` // Resource types reported as not found to the callers denied access to them
+	Rules   []Rule   `json:"rules"`
+}
+
+// Rule allows or denies the actions it lists, when all its conditions hold
+type Rule struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Effect      string      `json:"effect"`
+	Actions     []string    `json:"actions"` // Such as "orders:read", "orders:*" or "*"
+	When        []Condition `json:"when,omitempty"`
+	Scope       string      `json:"scope,omitempty"` // ScopeOwner, or empty for every resource
+}
+
+// Condition tests an attribute, such as "subject.role", against a value or against another
+// attribute named by Ref. A condition on a missing attribute does not hold.
+type Condition struct {
+	Attr  string      `json:"attr"`
+	Op    string      `json:"op"` // One of the keys of operators
+	Value interface{} `json:"value,omitempty"`
+	Ref   string      `json:"ref,omitempty"`
+}
+
+// operators lists the operators of the conditions, and whether they take a list
+var operators = map[string]bool{
+	"eq": false, "ne": false, "lt": false, "lte": false, "gt": false, "gte": false,
+	"in": true, "not_in": true, "grants": false, "exists": false,
+}
+
+// Attributes describe a subject, a resource or an environment
+type Attributes map[string]interface{}
+
+// Request is what the policy decides on
+type Request struct {
+	Action      string     `json:"action"`
+	Subject     Attributes `json:"subject"`     // user_id, email, role, api_key_id, permissions
+	Resource    Attributes `json:"resource"`    // type and id, plus attributes such as owner_id
+	Environment Attributes `json:"environment"` // time, hour, weekday, ip
+}
+
+// Decision is the outcome of a request
+type Decision struct {
+	Allowed bool        `json:"allowed"`
+	Rule    string      `json:"rule,omitempty"`  // Rule deciding the request, empty when no rule allows it
+	Scope   string      `json:"scope,omitempty"` // Scope of the allowing rule
+	Conceal bool        `json:"conceal"`         // A denied request is answered as if the resource did not exist
+	Reason  string      `json:"reason"`
+	Trace   []RuleTrace `json:"trace,omitempty"` // Outcome of every rule, when explained
+}
+
+// RuleTrace tells whether a rule matched a request, and why not
+type RuleTrace struct {
+	Rule    string `json:"rule"`
+	Effect  string `json:"effect"`
+	Matched bool   `json:"matched"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// PermissionMatcher checks if granted permissions, which may hold wildcards, include the
+// required one. It also matches the actions of the rules.
+type PermissionMatcher func(granted []string, required string) bool
+
+// Engine evaluates requests against the current policy
+type Engine struct {
+	mu       sync.RWMutex
+	policy   Policy
+	source   string
+	modTime  time.Time
+	missing  bool // The file could not be found at the last check
+	loadedAt time.Time
+	matches  PermissionMatcher
+}
+
+// Load reads the policy from a file, or uses the built-in policy when the path is empty
+func Load(path string, matches PermissionMatcher) (*Engine, error) {
+	engine := &Engine{source: path, matches: matches}
+	if path == "" {
+		policy, err := Parse(defaultPolicy)
+		if err != nil {
+			return nil, fmt.Errorf("built-in policy: %w", err)
+		}
+		engine.source = BuiltInSource
+		engine.policy, engine.loadedAt = policy, time.Now()
+		return engine, nil
+	}
+
+	if _, err := engine.Reload(); err != nil {
+		return nil, err
+	}
+	return engine, nil
+}
+
+// Parse decodes and checks a policy. Unknown fields are refused, so that a misspelled field
+// does not silently change a rule.
+func Parse(data []byte) (Policy, error) {
+	var policy Policy
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&policy); err != nil {
+		return Policy{}, err
+	}
+	if len(policy.Rules) == 0 {
+		return Policy{}, errors.New("the policy has no rules")
+	}
+
+	names := make(map[string]bool, len(policy.Rules))
+	for i, rule := range policy.Rules {
+		if rule.Name == "" {
+			return Policy{}, fmt.Errorf("rule %d has no name", i+1)
+		}
+		if names[rule.Name] {
+			return Policy{}, fmt.Errorf("rule %q is defined twice", rule.Name)
+		}
+		names[rule.Name] = true
+		if err := rule.check(); err != nil {
+			return Policy{}, fmt.Errorf("rule %q: %w", rule.Name, err)
+		}
+	}
+	return policy, nil
+}
+
+// check reports the first mistake of a rule
+func (rule Rule) check() error {
+	if rule.Effect != EffectAllow && rule.Effect != EffectDeny {
+		return fmt.Errorf("effect must be %q or %q", EffectAllow, EffectDeny)
+	}
+	if len(rule.Actions) == 0 {
+		return errors.New("no actions")
+	}
+	if rule.Scope != "" && rule.Scope != ScopeOwner {
+		return fmt.Errorf("scope must be %q or empty", ScopeOwner)
+	}
+
+	for _, condition := range rule.When {
+		takesList, ok := operators[condition.Op]
+		switch {
+		case !ok:
+			return fmt.Errorf("unknown operator %q", condition.Op)
+		case !validAttribute(condition.Attr):
+			return fmt.Errorf("unknown attribute %q", condition.Attr)
+		case condition.Ref != "" && !validAttribute(condition.Ref):
+			return fmt.Errorf("unknown attribute %q", condition.Ref)
+		case condition.Op == "exists":
+			if condition.Value != nil || condition.Ref != "" {
+				return fmt.Errorf("%s takes no value", condition.Attr)
+			}
+		case (condition.Value == nil) == (condition.Ref == ""):
+			return fmt.Errorf("%s %s needs either a value or a ref", condition.Attr, condition.Op)
+		case condition.Op == "grants":
+			if _, ok := condition.Value.(string); !ok {
+				return fmt.Errorf("%s grants needs a permission", condition.Attr)
+			}
+		case takesList && condition.Ref == "":
+			if _, ok := condition.Value.([]interface{}); !ok {
+				return fmt.Errorf("%s %s needs a list", condition.Attr, condition.Op)
+			}
+		}
+	}
+	return nil
+}
+
+// validAttribute checks if an attribute name refers to the subject, the resource or the
+// environment
+func validAttribute(name string) bool {
+	for _, prefix := range []string{"subject.", "resource.", "env."} {
+		if rest, ok := strings.CutPrefix(name, prefix); ok && rest != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Reload reads the policy file again if it changed, and reports whether it did. A policy that
+// cannot be read or is invalid leaves the current one in place, and is reported once until
+// the file changes again. Likewise, a missing file is reported once until it comes back.
+func (e *Engine) Reload() (bool, error) {
+	if e.source == BuiltInSource {
+		return false, nil
+	}
+
+	info, err := os.Stat(e.source)
+	if err != nil {
+		e.mu.Lock()
+		reported := e.missing
+		// The file is read again once it comes back, whatever its modification time
+		e.missing, e.modTime = true, time.Time{}
+		e.mu.Unlock()
+		if reported {
+			return false, nil
+		}
+		return false, err
+	}
+	e.mu.Lock()
+	e.missing = false
+	unchanged := info.ModTime().Equal(e.modTime)
+	e.mu.Unlock()
+	if unchanged {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(e.source)
+	if err == nil {
+		var policy Policy
+		if policy, err = Parse(data); err == nil {
+			e.mu.Lock()
+			e.policy, e.modTime, e.loadedAt = policy, info.ModTime(), time.Now()
+			e.mu.Unlock()
+			return true, nil
+		}
+	}
+
+	e.mu.Lock()
+	e.modTime = info.ModTime()
+	e.mu.Unlock()
+	return false, fmt.Errorf("%s: %w", e.source, err)
+}
+
+// Watch reloads the policy file whenever it changes, until the context is done
+func (e *Engine) Watch(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := e.Reload()
+			if err != nil {
+				log.Printf("❌ Kept the previous authorization policy: %v", err)
+			} else if reloaded {
+				log.Printf("✅ Reloaded the authorization policy from %s", e.source)
+			}
+		}
+	}
+}
+
+// Policy returns the current policy, where it was read from and when
+func (e *Engine) Policy() (Policy, string, time.Time) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.policy, e.source, e.loadedAt
+}
+
+// Evaluate decides a request
+func (e *Engine) Evaluate(req Request) Decision {
+	return e.evaluate(req, false)
+}
+
+// Explain decides a request, tracing the outcome of every rule
+func (e *Engine) Explain(req Request) Decision {
+	return e.evaluate(req, true)
+}
+
+func (e *Engine) evaluate(req Request, explain bool) Decision {
+	e.mu.RLock()
+	policy := e.policy
+	e.mu.RUnlock()
+
+	decision := Decision{Reason: "No rule allows the request"}
+	for _, concealed := range policy.Conceal {
+		if concealed == req.Resource["type"] {
+			decision.Conceal = true
+		}
+	}
+
+	var allowedBy, deniedBy *Rule
+	for i := range policy.Rules {
+		rule := &policy.Rules[i]
+		reason := e.mismatch(*rule, req)
+		if explain {
+			decision.Trace = append(decision.Trace, RuleTrace{
+				Rule:    rule.Name,
+				Effect:  rule.Effect,
+				Matched: reason == "",
+				Reason:  reason,
+			})
+		}
+		if reason != "" {
+			continue
+		}
+
+		// Deny rules override allow rules, wherever they are. An allow rule reaching every
+		// resource wins over the rules scoped to owners, so that their order does not matter.
+		if rule.Effect == EffectDeny {
+			if deniedBy == nil {
+				deniedBy = rule
+			}
+		} else if allowedBy == nil || (allowedBy.Scope != "" && rule.Scope == "") {
+			allowedBy = rule
+		}
+	}
+
+	switch {
+	case deniedBy != nil:
+		decision.Rule = deniedBy.Name
+		decision.Reason = "Denied by rule " + deniedBy.Name
+		// Callers an allow rule lets reach the resource already know it exists
+		decision.Conceal = decision.Conceal && allowedBy == nil
+	case allowedBy != nil:
+		decision.Allowed = true
+		decision.Rule, decision.Scope = allowedBy.Name, allowedBy.Scope
+		decision.Reason = "Allowed by rule " + allowedBy.Name
+	}
+	return decision
+}
+
+// mismatch returns why a rule does not match a request, or an empty string when it does
+func (e *Engine) mismatch(rule Rule, req Request) string {
+	if !e.matches(rule.Actions, req.Action) {
+		return "action " + req.Action + " is not listed"
+	}
+
+	if rule.Scope == ScopeOwner {
+		userID, ok := req.Subject["user_id"]
+		if !ok {
+			return "the subject owns nothing"
+		}
+		// Rules scoped to owners only reach the resources of the subject, or lists of them
+		if _, named := req.Resource["id"]; named && !equal(req.Resource["owner_id"], userID) {
+			return "the subject does not own the resource"
+		}
+	}
+
+	for _, condition := range rule.When {
+		if !e.holds(condition, req) {
+			return "condition " + condition.String() + " does not hold"
+		}
+	}
+	return ""
+}
+
+// holds evaluates a condition
+func (e *Engine) holds(condition Condition, req Request) bool {
+	actual, ok := lookup(req, condition.Attr)
+	if condition.Op == "exists" || !ok {
+		return ok
+	}
+
+	expected := condition.Value
+	if condition.Ref != "" {
+		if expected, ok = lookup(req, condition.Ref); !ok {
+			return false
+		}
+	}
+
+	switch condition.Op {
+	case "eq":
+		return equal(actual, expected)
+	case "ne":
+		return !equal(actual, expected)
+	case "in", "not_in":
+		found := false
+		for _, item := range toList(expected) {
+			if equal(actual, item) {
+				found = true
+				break
+			}
+		}
+		return found == (condition.Op == "in")
+	case "grants":
+		permission, _ := expected.(string)
+		return e.matches(toStrings(actual), permission)
+	default:
+		order, comparable := compare(actual, expected)
+		if !comparable {
+			return false
+		}
+		switch condition.Op {
+		case "lt":
+			return order < 0
+		case "lte":
+			return order <= 0
+		case "gt":
+			return order > 0
+		default:
+			return order >= 0
+		}
+	}
+}
+
+// String describes a condition in traces
+func (condition Condition) String() string {
+	if condition.Op == "exists" {
+		return condition.Attr + " exists"
+	}
+	if condition.Ref != "" {
+		return fmt.Sprintf("%s %s %s", condition.Attr, condition.Op, condition.Ref)
+	}
+	value, _ := json.Marshal(condition.Value)
+	return fmt.Sprintf("%s %s %s", condition.Attr, condition.Op, value)
+}
+
+// lookup returns the value of an attribute of a request
+func lookup(req Request, name string) (interface{}, bool) {
+	var attributes Attributes
+	switch {
+	case strings.HasPrefix(name, "subject."):
+		attributes, name = req.Subject, strings.TrimPrefix(name, "subject.")
+	case strings.HasPrefix(name, "resource."):
+		attributes, name = req.Resource, strings.TrimPrefix(name, "resource.")
+	case strings.HasPrefix(name, "env."):
+		attributes, name = req.Environment, strings.TrimPrefix(name, "env.")
+	}
+	value, ok := attributes[name]
+	return value, ok
+}
+
+// equal compares two attribute values, numbers by value whatever their type. Lists and
+// objects, which cannot be compared with ==, are compared deeply.
+func equal(a, b interface{}) bool {
+	if order, ok := compare(a, b); ok {
+		return order == 0
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compare orders two numbers or two strings
+func compare(a, b interface{}) (int, bool) {
+	if x, ok := toNumber(a); ok {
+		if y, ok := toNumber(b); ok {
+			switch {
+			case x < y:
+				return -1, true
+			case x > y:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	if x, ok := a.(string); ok {
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), true
+		}
+	}
+	return 0, false
+}
+
+func toNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+func toList(value interface{}) []interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		return v
+	case []string:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = item
+		}
+		return list
+	}
+	return nil
+}
+
+func toStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case []string:
+		return v
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
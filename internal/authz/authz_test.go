@@ -0,0 +1,183 @@
+package authz_test
+
+import (
+	"os"
+	"testing"
+
+	"um6p.ma/finalproject/internal/authz"
+	internalhttp "um6p.ma/finalproject/internal/http"
+)
+
+// engine loads a policy written in JSON from a temporary file
+func engine(t *testing.T, policy string) *authz.Engine {
+	t.Helper()
+	path := t.TempDir() + "/policy.json"
+	writeFile(t, path, policy)
+	e, err := authz.Load(path, internalhttp.HasPermission)
+	if err != nil {
+		t.Fatalf("loading the policy: %v", err)
+	}
+	return e
+}
+
+func request(action string, subject, resource authz.Attributes) authz.Request {
+	if resource == nil {
+		resource = authz.Attributes{}
+	}
+	return authz.Request{Action: action, Subject: subject, Resource: resource, Environment: authz.Attributes{}}
+}
+
+func TestEvaluatePrefersUnscopedAllow(t *testing.T) {
+	e := engine(t, `{"rules": [
+		{"name": "owners", "effect": "allow", "actions": ["orders:*"], "scope": "owner"},
+		{"name": "staff", "effect": "allow", "actions": ["orders:read"],
+		 "when": [{"attr": "subject.permissions", "op": "grants", "value": "read:orders"}]}
+	]}`)
+
+	decision := e.Evaluate(request("orders:read",
+		authz.Attributes{"user_id": 1, "permissions": []string{"read:*"}},
+		authz.Attributes{"type": "order", "id": 7, "owner_id": 1}))
+	if !decision.Allowed || decision.Rule != "staff" || decision.Scope != "" {
+		t.Errorf("staff owning the order: got %+v, want the unscoped rule", decision)
+	}
+
+	decision = e.Evaluate(request("orders:read",
+		authz.Attributes{"user_id": 1, "permissions": []string{"read:books"}},
+		authz.Attributes{"type": "order", "id": 7, "owner_id": 1}))
+	if !decision.Allowed || decision.Scope != authz.ScopeOwner {
+		t.Errorf("owner: got %+v, want the rule scoped to owners", decision)
+	}
+
+	decision = e.Evaluate(request("orders:read",
+		authz.Attributes{"user_id": 2, "permissions": []string{"read:books"}},
+		authz.Attributes{"type": "order", "id": 7, "owner_id": 1}))
+	if decision.Allowed {
+		t.Errorf("other user: got %+v, want a denial", decision)
+	}
+}
+
+func TestEvaluateDenyOverridesAllow(t *testing.T) {
+	e := engine(t, `{"conceal": ["order"], "rules": [
+		{"name": "read", "effect": "allow", "actions": ["orders:read"],
+		 "when": [{"attr": "subject.permissions", "op": "grants", "value": "read:orders"}]},
+		{"name": "no-shipped", "effect": "deny", "actions": ["orders:read"],
+		 "when": [{"attr": "resource.status", "op": "eq", "value": "shipped"}]}
+	]}`)
+
+	tests := []struct {
+		name        string
+		permissions []string
+		status      string
+		allowed     bool
+		conceal     bool
+	}{
+		{"allowed", []string{"read:*"}, "pending", true, false},
+		{"denied after an allow", []string{"read:*"}, "shipped", false, false},
+		{"denied without an allow", []string{"read:books"}, "shipped", false, true},
+		{"not allowed", []string{"read:books"}, "pending", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := e.Evaluate(request("orders:read",
+				authz.Attributes{"permissions": tt.permissions},
+				authz.Attributes{"type": "order", "id": 3, "status": tt.status}))
+			// Conceal only matters for denied requests
+			if decision.Allowed != tt.allowed || !decision.Allowed && decision.Conceal != tt.conceal {
+				t.Errorf("got allowed %t conceal %t, want %t %t", decision.Allowed, decision.Conceal, tt.allowed, tt.conceal)
+			}
+		})
+	}
+}
+
+func TestEvaluateComparesListsWithoutPanicking(t *testing.T) {
+	e := engine(t, `{"rules": [
+		{"name": "tagged", "effect": "allow", "actions": ["books:read"],
+		 "when": [{"attr": "resource.tags", "op": "eq", "value": ["rare", "signed"]}]}
+	]}`)
+
+	tests := []struct {
+		name    string
+		tags    interface{}
+		allowed bool
+	}{
+		{"same list", []interface{}{"rare", "signed"}, true},
+		{"other list", []interface{}{"rare"}, false},
+		{"scalar", "rare", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := e.Evaluate(request("books:read", authz.Attributes{}, authz.Attributes{"type": "book", "tags": tt.tags}))
+			if decision.Allowed != tt.allowed {
+				t.Errorf("got allowed %t, want %t", decision.Allowed, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestBuiltInPolicy(t *testing.T) {
+	e, err := authz.Load("", internalhttp.HasPermission)
+	if err != nil {
+		t.Fatalf("loading the built-in policy: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		action  string
+		subject authz.Attributes
+		allowed bool
+		scope   string
+	}{
+		{"warehouse key updates books", "books:update", authz.Attributes{"api_key_id": 1, "permissions": []string{"read:books", "write:books"}}, true, ""},
+		{"warehouse key cannot delete books", "books:delete", authz.Attributes{"api_key_id": 1, "permissions": []string{"write:books"}}, false, ""},
+		{"point of sale key places orders", "orders:create", authz.Attributes{"api_key_id": 2, "permissions": []string{"write:orders", "read:customers"}}, true, ""},
+		{"key without customers cannot place orders", "orders:create", authz.Attributes{"api_key_id": 2, "permissions": []string{"write:orders"}}, false, ""},
+		{"user places own orders", "orders:create", authz.Attributes{"user_id": 5, "role": "user", "permissions": []string{"write:orders"}}, true, authz.ScopeOwner},
+		{"employee places orders for anyone", "orders:create", authz.Attributes{"user_id": 6, "role": "employee", "permissions": []string{"read:*", "write:orders"}}, true, ""},
+		{"admin transitions orders", "orders:transition", authz.Attributes{"user_id": 1, "role": "admin", "permissions": []string{"process:orders"}}, true, ""},
+		{"user lists own orders", "orders:list", authz.Attributes{"user_id": 5, "role": "user", "permissions": []string{"write:orders"}}, true, authz.ScopeOwner},
+		{"user cannot delete own orders", "orders:delete", authz.Attributes{"user_id": 5, "role": "user", "permissions": []string{"write:orders"}}, false, ""},
+		{"reports need the permission", "reports:read", authz.Attributes{"user_id": 6, "role": "manager", "permissions": []string{"read:*"}}, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := e.Evaluate(request(tt.action, tt.subject, nil))
+			if decision.Allowed != tt.allowed || decision.Scope != tt.scope {
+				t.Errorf("got %+v, want allowed %t with scope %q", decision, tt.allowed, tt.scope)
+			}
+		})
+	}
+}
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadReportsMissingFileOnce(t *testing.T) {
+	policy := `{"rules": [{"name": "all", "effect": "allow", "actions": ["*"]}]}`
+	e := engine(t, policy)
+	_, path, _ := e.Policy()
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Reload(); err == nil {
+		t.Fatal("first check of the missing file: got no error")
+	}
+	if _, err := e.Reload(); err != nil {
+		t.Fatalf("second check of the missing file: got %v, want no error", err)
+	}
+
+	writeFile(t, path, policy)
+	if reloaded, err := e.Reload(); err != nil || !reloaded {
+		t.Fatalf("file back: got %t, %v, want a reload", reloaded, err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Reload(); err == nil {
+		t.Fatal("file removed again: got no error")
+	}
+}
@@ -0,0 +1,95 @@
+package http
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/interfaces"
+)
+
+// apiKeyTouchInterval bounds how often the last use of a key is written, so that busy
+// integrations do not write on every request
+const apiKeyTouchInterval = time.Minute
+
+var apiKeys interfaces.APIKeyStore
+
+// SetAPIKeyStore sets the store from which RequireAuth looks up API keys
+func SetAPIKeyStore(store interfaces.APIKeyStore) {
+	apiKeys = store
+}
+
+// HashAPIKey returns the hash under which an API key is stored
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// apiKeyFromRequest returns the API key of a request, sent either in the X-API-Key header or
+// as "Authorization: ApiKey <key>"
+func apiKeyFromRequest(r *http.Request) (string, bool) {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key, true
+	}
+	if key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "ApiKey "); ok {
+		return key, true
+	}
+	return "", false
+}
+
+// authenticateAPIKey looks up an API key and returns the claims it authenticates with. API
+// keys have no user or role, only the permissions they were created with.
+func authenticateAPIKey(ctx context.Context, key string) (*Claims, error) {
+	if apiKeys == nil {
+		return nil, errorhandling.ErrInvalidAPIKey.WithDebug("API keys are not configured")
+	}
+
+	apiKey, err := apiKeys.GetAPIKeyByHash(ctx, HashAPIKey(key))
+	if err != nil {
+		if errorhandling.IsNotFoundError(err) {
+			return nil, errorhandling.ErrInvalidAPIKey
+		}
+		return nil, errorhandling.NewDatabaseError(err)
+	}
+	now := time.Now()
+	if apiKey.RevokedAt != nil {
+		return nil, errorhandling.ErrRevokedAPIKey
+	}
+	if apiKey.ExpiresAt != nil && now.After(*apiKey.ExpiresAt) {
+		return nil, errorhandling.ErrExpiredAPIKey
+	}
+
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= apiKeyTouchInterval {
+		if err := apiKeys.TouchAPIKey(ctx, apiKey.ID, now); err != nil {
+			return nil, errorhandling.NewDatabaseError(err)
+		}
+	}
+
+	return &Claims{
+		APIKeyID:    apiKey.ID,
+		Name:        apiKey.Name,
+		Permissions: apiKey.PermissionNames(),
+	}, nil
+}
+
+// RequireUser is middleware that refuses API keys, for endpoints acting on the account of the
+// authenticated user. It must follow RequireAuth.
+var RequireUser MiddlewareFunc = func(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := GetClaimsFromContext(r.Context())
+		if !ok {
+			errorhandling.HandleError(w, errorhandling.ErrMissingToken)
+			return
+		}
+		if claims.APIKeyID != 0 {
+			errorhandling.HandleError(w, errorhandling.ErrAPIKeyNotAllowed)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -2,118 +2,158 @@ package http
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"net/http"
-	"os"
 	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/interfaces"
 )
 
 // ContextKey is a type for context keys
 type ContextKey string
 
-// ExtractOwnerIDFunc is a function type for extracting resource owner IDs
-type ExtractOwnerIDFunc func(*http.Request) int
-
 // Context keys
 const (
 	ContextUserKey ContextKey = "user"
+	authResultKey  ContextKey = "auth"
 )
 
-// Claims represents JWT claims
+// Claims represents JWT claims. Requests authenticated by an API key get claims with the ID
+// and permissions of the key instead, and no user or role. Impersonation tokens carry the
+// claims of the impersonated user, along with the ID of the admin acting as them.
 type Claims struct {
-	UserID      int      `json:"user_id"`
-	Email       string   `json:"email"`
-	Name        string   `json:"name"`
-	Role        string   `json:"role"`
-	Permissions []string `json:"permissions"`
+	UserID int    `json:"user_id"`
+	Email  string `json:"email"`
+	Name   string `json:"name"`
+	Role   string `json:"role"`
 	jwt.StandardClaims
+
+	ImpersonatorID int  `json:"impersonator_id,omitempty"`
+	ReadOnly       bool `json:"read_only,omitempty"` // Impersonation tokens refusing writes
+
+	APIKeyID    int      `json:"-"`
+	Permissions []string `json:"-"` // Permissions of the API key
 }
 
 var (
-	jwtSecret = []byte(os.Getenv("JWT_SECRET"))
+	keyring     *Keyring
+	revocations interfaces.TokenRevocationStore
 )
 
-// GetJWTSecret returns the JWT secret key used for signing and validating tokens
-func GetJWTSecret() []byte {
-	return jwtSecret
+// SetKeyring sets the keys used by RequireAuth to verify tokens
+func SetKeyring(ring *Keyring) {
+	keyring = ring
 }
 
-// RequireAuth is middleware that validates JWT tokens
+// SetTokenRevocationStore sets the store consulted by RequireAuth for revoked tokens
+func SetTokenRevocationStore(store interfaces.TokenRevocationStore) {
+	revocations = store
+}
+
+// RequireAuth is middleware that validates JWT tokens, or API keys
 var RequireAuth MiddlewareFunc = func(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			errorhandling.HandleError(w, errorhandling.ErrMissingToken)
+		claims, err := authentication(r)
+		if err != nil {
+			errorhandling.HandleError(w, err)
 			return
 		}
 
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		if tokenString == authHeader {
-			errorhandling.HandleError(w, errorhandling.ErrInvalidToken.
-				WithDebug("Invalid token format"))
+		// Add claims to context
+		ctx := context.WithValue(r.Context(), ContextUserKey, claims)
+		if claims.ImpersonatorID != 0 {
+			serveImpersonated(next, w, r.WithContext(ctx), claims)
 			return
 		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
 
-		// Parse JWT Token
-		claims := &Claims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return jwtSecret, nil
-		})
+// authResult is the outcome of authenticating a request, kept in its context so that the
+// credentials are only checked once
+type authResult struct {
+	claims *Claims
+	err    error
+}
 
-		if err != nil {
-			if err == jwt.ErrSignatureInvalid {
-				errorhandling.HandleError(w, errorhandling.ErrInvalidToken)
-				return
-			}
-			errorhandling.HandleError(w, errorhandling.ErrExpiredToken)
-			return
-		}
+// withAuthentication authenticates a request and returns it with the outcome in its context
+func withAuthentication(r *http.Request) (*http.Request, *authResult) {
+	if result, ok := r.Context().Value(authResultKey).(*authResult); ok {
+		return r, result
+	}
+	claims, err := authenticate(r)
+	result := &authResult{claims: claims, err: err}
+	return r.WithContext(context.WithValue(r.Context(), authResultKey, result)), result
+}
 
-		if !token.Valid {
-			errorhandling.HandleError(w, errorhandling.ErrInvalidToken)
-			return
-		}
+// authentication returns the claims of a request, authenticating it unless it already was
+func authentication(r *http.Request) (*Claims, error) {
+	_, result := withAuthentication(r)
+	return result.claims, result.err
+}
 
-		// Add claims to context
-		ctx := context.WithValue(r.Context(), ContextUserKey, claims)
-		next.ServeHTTP(w, r.WithContext(ctx))
+// authenticate checks the API key or the JWT access token of a request
+func authenticate(r *http.Request) (*Claims, error) {
+	if key, ok := apiKeyFromRequest(r); ok {
+		return authenticateAPIKey(r.Context(), key)
+	}
+
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return nil, errorhandling.ErrMissingToken
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	if tokenString == authHeader {
+		return nil, errorhandling.ErrInvalidToken.WithDebug("Invalid token format")
+	}
+
+	// Parse JWT Token
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if keyring == nil {
+			return nil, errors.New("no JWT keys loaded")
+		}
+		return keyring.verificationKey(token)
 	})
-}
 
-// RequireOwnerOrAdmin creates middleware that ensures the user is either the resource owner or an admin
-func RequireOwnerOrAdmin(extractOwnerID ExtractOwnerIDFunc) MiddlewareFunc {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			claims, ok := GetClaimsFromContext(r.Context())
-			if !ok {
-				errorhandling.HandleError(w, errorhandling.ErrMissingToken)
-				return
-			}
+	if err != nil {
+		var validationErr *jwt.ValidationError
+		if errors.As(err, &validationErr) && validationErr.Errors&jwt.ValidationErrorExpired != 0 {
+			return nil, errorhandling.ErrExpiredToken
+		}
+		return nil, errorhandling.ErrInvalidToken.WithDebug(err.Error())
+	}
 
-			// Admins can access everything
-			if claims.Role == "admin" {
-				next.ServeHTTP(w, r)
-				return
-			}
+	if !token.Valid {
+		return nil, errorhandling.ErrInvalidToken
+	}
 
-			// Check if user is the resource owner
-			ownerID := extractOwnerID(r)
-			if ownerID == 0 || ownerID != claims.UserID {
-				errorhandling.HandleError(w, errorhandling.NewError(
-					http.StatusForbidden,
-					errorhandling.ErrCodeForbidden,
-					"Access denied: you are not the owner of this resource",
-				))
-				return
-			}
+	// Tokens with an audience, such as password reset tokens, are not access tokens
+	if claims.Audience != "" {
+		return nil, errorhandling.ErrInvalidToken.WithDebug("Token is not an access token")
+	}
 
-			next.ServeHTTP(w, r)
-		})
+	// Reject tokens revoked before their expiry
+	if revocations != nil {
+		revoked, err := revocations.IsTokenRevoked(r.Context(), claims.Id, claims.UserID, time.Unix(claims.IssuedAt, 0))
+		if err != nil {
+			return nil, errorhandling.NewDatabaseError(err)
+		}
+		// Impersonation tokens also end with the sessions of their admin
+		if !revoked && claims.ImpersonatorID != 0 {
+			revoked, err = revocations.IsTokenRevoked(r.Context(), claims.Id, claims.ImpersonatorID, time.Unix(claims.IssuedAt, 0))
+			if err != nil {
+				return nil, errorhandling.NewDatabaseError(err)
+			}
+		}
+		if revoked {
+			return nil, errorhandling.ErrTokenRevoked
+		}
 	}
+
+	return claims, nil
 }
@@ -0,0 +1,293 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/interfaces"
+	"um6p.ma/finalproject/internal/authz"
+)
+
+// ownerScopeKey holds the user whose resources a request is restricted to by the policy
+const ownerScopeKey ContextKey = "owner_scope"
+
+// ResourceLoader returns the attributes of a resource that the rules of the authorization
+// policy test, such as its owner_id. It returns a not found error for missing resources.
+type ResourceLoader func(ctx context.Context, id int) (authz.Attributes, error)
+
+// Authorizer guards routes with the authorization policy. It remembers the action and the
+// resource type of the routes it guards, to explain the decisions on them.
+type Authorizer struct {
+	engine    *authz.Engine
+	resources map[string]ResourceLoader // By resource type
+	routes    []authorizedRoute
+}
+
+// authorizedRoute is a route guarded by the policy, registered at startup
+type authorizedRoute struct {
+	method   string
+	segments []string
+	action   string
+	resource string
+}
+
+// Explanation describes how the policy decides the request of a subject to a route
+type Explanation struct {
+	Method   string         `json:"method"`
+	Path     string         `json:"path"`
+	Request  authz.Request  `json:"request"`
+	Decision authz.Decision `json:"decision"`
+}
+
+func NewAuthorizer(engine *authz.Engine, resources map[string]ResourceLoader) *Authorizer {
+	return &Authorizer{engine: engine, resources: resources}
+}
+
+// Wrap wraps the handler of a route with authentication and the authorization policy, which
+// decides the action on the resource type named by the route. Routes naming a resource do so
+// with an :id parameter.
+func (a *Authorizer) Wrap(method, path, action, resource string, handler httprouter.Handle) httprouter.Handle {
+	a.routes = append(a.routes, authorizedRoute{
+		method:   method,
+		segments: strings.Split(strings.Trim(path, "/"), "/"),
+		action:   action,
+		resource: resource,
+	})
+	return WrapWithMiddleware(handler, RequireAuth, a.require(action, resource))
+}
+
+// require creates middleware applying the policy. A denied request to a resource of a concealed
+// type is answered as if the resource did not exist, so that IDs reveal nothing. Rules scoped
+// to owners restrict the request to the resources of the caller, see OwnerScope.
+func (a *Authorizer) require(action, resource string) MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := GetClaimsFromContext(r.Context())
+			if !ok {
+				errorhandling.HandleError(w, errorhandling.ErrMissingToken)
+				return
+			}
+
+			param := httprouter.ParamsFromContext(r.Context()).ByName("id")
+			req, err := a.request(r.Context(), claims, action, resource, param, ClientIP(r))
+			if err != nil {
+				errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+				return
+			}
+
+			decision := a.engine.Evaluate(req)
+			if !decision.Allowed {
+				if id, named := req.Resource["id"]; named && decision.Conceal {
+					errorhandling.HandleError(w, errorhandling.NewNotFoundError(resourceName(resource), id))
+					return
+				}
+				errorhandling.HandleError(w, errorhandling.NewError(
+					http.StatusForbidden,
+					errorhandling.ErrCodeForbidden,
+					"Access denied by the authorization policy",
+				))
+				return
+			}
+
+			if decision.Scope == authz.ScopeOwner {
+				r = r.WithContext(context.WithValue(r.Context(), ownerScopeKey, claims.UserID))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Explain reports how the policy decides the request of a subject to a route
+func (a *Authorizer) Explain(ctx context.Context, claims *Claims, method, path, ip string) (Explanation, error) {
+	route, param, ok := a.match(method, path)
+	if !ok {
+		return Explanation{}, errorhandling.NewError(
+			http.StatusNotFound,
+			errorhandling.ErrCodeNotFound,
+			fmt.Sprintf("No route guarded by the authorization policy matches %s %s", method, path),
+		)
+	}
+
+	req, err := a.request(ctx, claims, route.action, route.resource, param, ip)
+	if err != nil {
+		return Explanation{}, err
+	}
+	return Explanation{
+		Method:   method,
+		Path:     path,
+		Request:  req,
+		Decision: a.engine.Explain(req),
+	}, nil
+}
+
+// match finds the guarded route of a request, along with its :id parameter
+func (a *Authorizer) match(method, path string) (authorizedRoute, string, bool) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for _, route := range a.routes {
+		if route.method != method || len(route.segments) != len(segments) {
+			continue
+		}
+
+		param, matched := "", true
+		for i, segment := range route.segments {
+			if strings.HasPrefix(segment, ":") && segments[i] != "" {
+				if segment == ":id" {
+					param = segments[i]
+				}
+				continue
+			}
+			if segment != segments[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return route, param, true
+		}
+	}
+	return authorizedRoute{}, "", false
+}
+
+// request gathers the attributes the policy decides on. Malformed IDs are left to the handler
+// to report, so the request is then decided as if it named no resource.
+func (a *Authorizer) request(ctx context.Context, claims *Claims, action, resource, param, ip string) (authz.Request, error) {
+	subject := authz.Attributes{}
+	if claims.UserID != 0 {
+		subject["user_id"] = claims.UserID
+		subject["email"] = claims.Email
+	}
+	if claims.Role != "" {
+		subject["role"] = claims.Role
+	}
+	if claims.APIKeyID != 0 {
+		subject["api_key_id"] = claims.APIKeyID
+	}
+	if claims.ImpersonatorID != 0 {
+		subject["impersonator_id"] = claims.ImpersonatorID
+	}
+	granted, err := grantedPermissions(ctx, claims)
+	if err != nil {
+		return authz.Request{}, err
+	}
+	subject["permissions"] = granted
+
+	attributes := authz.Attributes{"type": resource}
+	if id, err := strconv.Atoi(param); err == nil {
+		attributes["id"] = id
+		if load := a.resources[resource]; load != nil {
+			loaded, err := load(ctx, id)
+			switch {
+			case errorhandling.IsNotFoundError(err):
+				attributes["exists"] = false
+			case err != nil:
+				return authz.Request{}, err
+			default:
+				for name, value := range loaded {
+					attributes[name] = value
+				}
+				attributes["exists"] = true
+			}
+		}
+	}
+
+	now := time.Now()
+	return authz.Request{
+		Action:   action,
+		Subject:  subject,
+		Resource: attributes,
+		Environment: authz.Attributes{
+			"time":    now.Format(time.RFC3339),
+			"hour":    now.Hour(),
+			"weekday": strings.ToLower(now.Weekday().String()),
+			"ip":      ip,
+		},
+	}, nil
+}
+
+// ScopeToCaller restricts requests to the resources of the authenticated user, as the rules
+// scoped to owners do. It guards the routes acting on the account of the caller, such as /me.
+func ScopeToCaller(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if claims, ok := GetClaimsFromContext(r.Context()); ok && claims.UserID != 0 {
+			r = r.WithContext(context.WithValue(r.Context(), ownerScopeKey, claims.UserID))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// OwnerScope returns the user a request is restricted to by a rule scoped to owners or by
+// ScopeToCaller, or false when the request reaches the resources of everyone
+func OwnerScope(ctx context.Context) (int, bool) {
+	userID, ok := ctx.Value(ownerScopeKey).(int)
+	return userID, ok
+}
+
+// OrderResource loads the status and total price of orders, along with their customer and the
+// user linked to it as owner_id
+func OrderResource(orders interfaces.OrderStore, customers interfaces.CustomerStore) ResourceLoader {
+	return func(ctx context.Context, id int) (authz.Attributes, error) {
+		order, err := orders.GetOrder(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		attributes := authz.Attributes{
+			"status":      order.Status,
+			"total_price": order.TotalPrice,
+			"customer_id": order.CustomerID,
+		}
+
+		customer, err := customers.GetCustomer(ctx, order.CustomerID)
+		if err != nil && !errorhandling.IsNotFoundError(err) {
+			return nil, err
+		}
+		if err == nil && customer.UserID != nil {
+			attributes["owner_id"] = *customer.UserID
+		}
+		return attributes, nil
+	}
+}
+
+// CustomerResource loads the user linked to customers, as owner_id
+func CustomerResource(customers interfaces.CustomerStore) ResourceLoader {
+	return func(ctx context.Context, id int) (authz.Attributes, error) {
+		customer, err := customers.GetCustomer(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		attributes := authz.Attributes{}
+		if customer.UserID != nil {
+			attributes["owner_id"] = *customer.UserID
+		}
+		return attributes, nil
+	}
+}
+
+// BookResource loads the author, price and stock of books
+func BookResource(books interfaces.BookStore) ResourceLoader {
+	return func(ctx context.Context, id int) (authz.Attributes, error) {
+		book, err := books.GetBook(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return authz.Attributes{
+			"author_id": book.AuthorID,
+			"price":     book.Price,
+			"stock":     book.Stock,
+			"in_stock":  book.Stock > 0,
+		}, nil
+	}
+}
+
+// resourceName returns the name of a resource type in errors, such as "Order" for "order"
+func resourceName(resource string) string {
+	if resource == "" {
+		return "Resource"
+	}
+	return strings.ToUpper(resource[:1]) + resource[1:]
+}
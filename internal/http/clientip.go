@@ -0,0 +1,35 @@
+package http
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+var trustProxy bool
+
+// SetTrustProxy sets whether ClientIP believes the X-Forwarded-For header. Only enable it
+// behind a reverse proxy that sets the header, since clients can send anything.
+func SetTrustProxy(trusted bool) {
+	trustProxy = trusted
+}
+
+// ClientIP returns the IP address of the client that sent a request. Behind a trusted proxy,
+// it is the last address of X-Forwarded-For, the one the proxy appended.
+func ClientIP(r *http.Request) string {
+	if trustProxy {
+		forwarded := r.Header.Values("X-Forwarded-For")
+		if len(forwarded) > 0 {
+			addrs := strings.Split(forwarded[len(forwarded)-1], ",")
+			if ip := strings.TrimSpace(addrs[len(addrs)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
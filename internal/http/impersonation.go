@@ -0,0 +1,123 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/interfaces"
+	"um6p.ma/finalproject/models"
+)
+
+// Headers of the responses to impersonation tokens, so that clients can show a banner
+const (
+	ImpersonatorHeader = "X-Impersonator-ID"
+	BannerHeader       = "X-Impersonation-Banner"
+)
+
+var impersonationAudit interfaces.ImpersonationAuditStore
+
+// SetImpersonationAuditStore sets the store recording the requests made with impersonation tokens
+func SetImpersonationAuditStore(store interfaces.ImpersonationAuditStore) {
+	impersonationAudit = store
+}
+
+// RequireOwnCredentials is middleware that refuses impersonation tokens, for endpoints that
+// change how users sign in or that grant tokens. It must follow RequireAuth.
+var RequireOwnCredentials MiddlewareFunc = func(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := GetClaimsFromContext(r.Context())
+		if !ok {
+			errorhandling.HandleError(w, errorhandling.ErrMissingToken)
+			return
+		}
+		if claims.ImpersonatorID != 0 {
+			errorhandling.HandleError(w, errorhandling.ErrImpersonating)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serveImpersonated serves a request authenticated by an impersonation token. The response
+// carries the banners, writes are refused when the token is read-only, and the request is
+// recorded in the audit log with its status, refused or not.
+func serveImpersonated(next http.Handler, w http.ResponseWriter, r *http.Request, claims *Claims) {
+	banner := fmt.Sprintf("Admin %d is impersonating %s <%s>", claims.ImpersonatorID, claims.Name, claims.Email)
+	if claims.ReadOnly {
+		banner += "; changes are blocked"
+	}
+	w.Header().Set(ImpersonatorHeader, strconv.Itoa(claims.ImpersonatorID))
+	w.Header().Set(BannerHeader, banner)
+
+	recorder := &statusRecorder{ResponseWriter: w}
+	completed := false
+	defer func() {
+		status := recorder.status
+		switch {
+		case !completed:
+			status = http.StatusInternalServerError // The handler panicked
+		case status == 0:
+			status = http.StatusOK
+		}
+		recordImpersonatedRequest(r, claims, status)
+	}()
+
+	if claims.ReadOnly && !isSafeMethod(r.Method) {
+		errorhandling.HandleError(recorder, errorhandling.ErrImpersonationWrite)
+	} else {
+		next.ServeHTTP(recorder, r)
+	}
+	completed = true
+}
+
+// recordImpersonatedRequest adds a request to the audit log. Failures are logged, since the
+// response was already written.
+func recordImpersonatedRequest(r *http.Request, claims *Claims, status int) {
+	if impersonationAudit == nil {
+		return
+	}
+
+	// The request is recorded even when the client went away
+	ctx := context.WithoutCancel(r.Context())
+	if _, err := impersonationAudit.RecordImpersonatedRequest(ctx, models.ImpersonatedRequest{
+		ImpersonatorID: claims.ImpersonatorID,
+		UserID:         claims.UserID,
+		TokenID:        claims.Id,
+		Method:         r.Method,
+		Path:           r.URL.RequestURI(),
+		Status:         status,
+		IP:             ClientIP(r),
+	}); err != nil {
+		log.Printf("❌ Failed to record the request of admin %d impersonating user %d: %v", claims.ImpersonatorID, claims.UserID, err)
+	}
+}
+
+// isSafeMethod checks if a method only reads
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// statusRecorder remembers the status code written to a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(data []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.ResponseWriter.Write(data)
+}
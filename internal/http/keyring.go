@@ -0,0 +1,354 @@
+package http
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Signing algorithms supported by the keyring
+const (
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+const (
+	keyFileExt    = ".pem"
+	minRSAKeyBits = 2048
+	rsaKeyBits    = 3072
+)
+
+// signingKey is a private key of the keyring
+type signingKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private crypto.Signer
+	created time.Time
+}
+
+// Keyring holds the keys that sign and verify JWTs. They are read from a directory of PEM
+// private keys named <kid>.pem. Every key verifies tokens, while the most recently created
+// one signs new tokens.
+type Keyring struct {
+	mu      sync.RWMutex
+	dir     string
+	keys    map[string]signingKey
+	current string
+}
+
+// JSONWebKey is the public part of a key, as published in the JWKS document (RFC 7517)
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JSONWebKeySet is the JWKS document listing the public keys
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// LoadKeyring reads the keys of dir. It fails when no key material is configured, since
+// tokens could neither be issued nor verified.
+func LoadKeyring(dir string) (*Keyring, error) {
+	if dir == "" {
+		return nil, errors.New("no JWT signing keys configured: set JWT_KEYS_DIR to a directory of PEM private keys, created with \"go run ./cmd/keygen\"")
+	}
+
+	ring := &Keyring{dir: dir}
+	if err := ring.Reload(); err != nil {
+		return nil, err
+	}
+	return ring, nil
+}
+
+// Reload rereads the key directory, picking up keys added or removed since it was loaded
+func (ring *Keyring) Reload() error {
+	entries, err := os.ReadDir(ring.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read JWT keys: %w", err)
+	}
+
+	keys := make(map[string]signingKey)
+	current := ""
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != keyFileExt {
+			continue
+		}
+		key, err := readKey(filepath.Join(ring.dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		keys[key.kid] = key
+		if current == "" || newerKey(key, keys[current]) {
+			current = key.kid
+		}
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no JWT signing keys (*%s) in %s; create one with \"go run ./cmd/keygen\"", keyFileExt, ring.dir)
+	}
+
+	ring.mu.Lock()
+	ring.keys = keys
+	ring.current = current
+	ring.mu.Unlock()
+	return nil
+}
+
+// Sign signs the claims with the current key, recording its kid in the token header
+func (ring *Keyring) Sign(claims jwt.Claims) (string, error) {
+	ring.mu.RLock()
+	key := ring.keys[ring.current]
+	ring.mu.RUnlock()
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.private)
+}
+
+// Verify parses a token signed by the keyring into claims, checking its signature and expiry
+func (ring *Keyring) Verify(tokenString string, claims jwt.Claims) error {
+	token, err := jwt.ParseWithClaims(tokenString, claims, ring.verificationKey)
+	if err != nil {
+		return err
+	}
+	if !token.Valid {
+		return errors.New("invalid token")
+	}
+	return nil
+}
+
+// verificationKey is a jwt.Keyfunc returning the public key named by the kid of the token
+func (ring *Keyring) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	ring.mu.RLock()
+	key, ok := ring.keys[kid]
+	ring.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.private.Public(), nil
+}
+
+// JWKS returns the public keys, so that other services can verify the tokens
+func (ring *Keyring) JWKS() JSONWebKeySet {
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+
+	set := JSONWebKeySet{Keys: make([]JSONWebKey, 0, len(ring.keys))}
+	for _, key := range ring.keys {
+		jwk := JSONWebKey{Kid: key.kid, Use: "sig", Alg: key.method.Alg()}
+		switch public := key.private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
+
+// StartRotation generates a new signing key whenever the current one is older than every,
+// and deletes the keys that were replaced more than retention ago, once the tokens they
+// signed have expired. The directory is reloaded on each check, so that instances sharing
+// it also pick up the keys rotated by the others.
+func (ring *Keyring) StartRotation(ctx context.Context, every time.Duration, algorithm string, retention time.Duration) {
+	ticker := time.NewTicker(min(every, time.Minute))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := ring.rotate(every, algorithm, retention); err != nil {
+				log.Printf("⚠️ JWT key rotation failed: %v", err)
+			}
+		}
+	}
+}
+
+// rotate performs one rotation check
+func (ring *Keyring) rotate(every time.Duration, algorithm string, retention time.Duration) error {
+	if err := ring.Reload(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	ring.mu.RLock()
+	current := ring.keys[ring.current]
+	keys := make([]signingKey, 0, len(ring.keys))
+	for _, key := range ring.keys {
+		keys = append(keys, key)
+	}
+	ring.mu.RUnlock()
+
+	if now.Sub(current.created) >= every {
+		kid, err := GenerateKey(ring.dir, algorithm)
+		if err != nil {
+			return err
+		}
+		log.Printf("🔑 Rotated the JWT signing key to %s", kid)
+	}
+
+	// A key stopped signing when the next one was created
+	sort.Slice(keys, func(i, j int) bool { return newerKey(keys[j], keys[i]) })
+	for i := 0; i < len(keys)-1; i++ {
+		if now.Sub(keys[i+1].created) > retention {
+			if err := os.Remove(filepath.Join(ring.dir, keys[i].kid+keyFileExt)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			log.Printf("🔑 Retired the JWT signing key %s", keys[i].kid)
+		}
+	}
+
+	return ring.Reload()
+}
+
+// GenerateKey writes a new private key for the given algorithm to dir and returns its kid
+func GenerateKey(dir, algorithm string) (string, error) {
+	var private crypto.Signer
+	var err error
+	switch algorithm {
+	case AlgRS256:
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case AlgEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return "", fmt.Errorf("unsupported JWT signing algorithm %q (expected %q or %q)", algorithm, AlgRS256, AlgEdDSA)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return "", err
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	created := time.Now().UTC()
+	kid := created.Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+
+	data := pem.EncodeToMemory(&pem.Block{
+		Type:    "PRIVATE KEY",
+		Headers: map[string]string{"Created": created.Format(time.RFC3339)},
+		Bytes:   der,
+	})
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	// Written under a temporary name, so that a reload never sees a partial key
+	tmp, err := os.CreateTemp(dir, "."+kid+"-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, kid+keyFileExt)); err != nil {
+		return "", err
+	}
+	return kid, nil
+}
+
+// readKey parses a PEM private key. Its creation time is taken from the Created header
+// written by GenerateKey, or else from the modification time of the file.
+func readKey(path string) (signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return signingKey{}, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return signingKey{}, fmt.Errorf("%s: no PEM data found", path)
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		err = fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return signingKey{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	key := signingKey{kid: strings.TrimSuffix(filepath.Base(path), keyFileExt)}
+	switch private := parsed.(type) {
+	case *rsa.PrivateKey:
+		if private.N.BitLen() < minRSAKeyBits {
+			return signingKey{}, fmt.Errorf("%s: RSA keys must have at least %d bits", path, minRSAKeyBits)
+		}
+		key.method, key.private = jwt.SigningMethodRS256, private
+	case ed25519.PrivateKey:
+		key.method, key.private = jwt.SigningMethodEdDSA, private
+	default:
+		return signingKey{}, fmt.Errorf("%s: unsupported key type %T (expected RSA or Ed25519)", path, parsed)
+	}
+
+	if created, err := time.Parse(time.RFC3339, block.Headers["Created"]); err == nil {
+		key.created = created
+	} else {
+		info, err := os.Stat(path)
+		if err != nil {
+			return signingKey{}, err
+		}
+		key.created = info.ModTime()
+	}
+	return key, nil
+}
+
+// newerKey orders keys by creation time, then by kid
+func newerKey(a, b signingKey) bool {
+	if !a.created.Equal(b.created) {
+		return a.created.After(b.created)
+	}
+	return a.kid > b.kid
+}
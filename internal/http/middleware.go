@@ -12,26 +12,23 @@ func Wrap(handler httprouter.Handle, permission string) httprouter.Handle {
 	)
 }
 
-// WrapWithRole wraps a handler with authentication and single role middleware
-func WrapWithRole(handler httprouter.Handle, role string) httprouter.Handle {
-	return WrapWithMiddleware(handler,
-		RequireAuth,
-		RequireRoles(role),
-	)
+// WrapWithAuth wraps a handler with authentication middleware only. Such handlers act on the
+// account of the authenticated user, so API keys are refused and requests are scoped to the
+// caller.
+func WrapWithAuth(handler httprouter.Handle) httprouter.Handle {
+	return WrapWithMiddleware(handler, RequireAuth, RequireUser, ScopeToCaller)
 }
 
-// WrapWithRoles wraps a handler with authentication and multiple roles middleware
-func WrapWithRoles(handler httprouter.Handle, roles ...string) httprouter.Handle {
-	return WrapWithMiddleware(handler,
-		RequireAuth,
-		RequireRoles(roles...),
-	)
+// WrapWithOwnCredentials wraps a handler acting on the account of the authenticated user with
+// authentication middleware refusing API keys and impersonation tokens
+func WrapWithOwnCredentials(handler httprouter.Handle) httprouter.Handle {
+	return WrapWithMiddleware(handler, RequireAuth, RequireUser, RequireOwnCredentials, ScopeToCaller)
 }
 
-// WrapWithOwnerOrAdmin wraps a handler with authentication and owner/admin check middleware
-func WrapWithOwnerOrAdmin(handler httprouter.Handle, extractOwnerID ExtractOwnerIDFunc) httprouter.Handle {
+// WrapWithRole wraps a handler with authentication and single role middleware
+func WrapWithRole(handler httprouter.Handle, role string) httprouter.Handle {
 	return WrapWithMiddleware(handler,
 		RequireAuth,
-		RequireOwnerOrAdmin(extractOwnerID),
+		RequireRoles(role),
 	)
 }
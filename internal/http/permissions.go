@@ -0,0 +1,169 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"um6p.ma/finalproject/interfaces"
+	"um6p.ma/finalproject/models"
+)
+
+// permissionCacheTTL bounds how long another instance takes to see an edited role
+const permissionCacheTTL = 30 * time.Second
+
+var (
+	roles       interfaces.RoleStore
+	permissions = &permissionCache{}
+)
+
+// permissionCache holds the permissions of every role, expanded with the implication rules,
+// along with the rules themselves
+type permissionCache struct {
+	mu           sync.RWMutex
+	byRole       map[string][]string
+	implications []models.PermissionImplication
+	loadedAt     time.Time
+}
+
+// SetRoleStore sets the store from which RequirePermission resolves the permissions of roles
+func SetRoleStore(store interfaces.RoleStore) {
+	roles = store
+	InvalidatePermissions()
+}
+
+// InvalidatePermissions drops the cached permissions, after roles or implication rules changed
+func InvalidatePermissions() {
+	permissions.mu.Lock()
+	permissions.byRole = nil
+	permissions.implications = nil
+	permissions.mu.Unlock()
+}
+
+// ResolvePermissions returns the permissions granted to a role, including those implied by
+// the implication rules. An unknown role has no permissions.
+func ResolvePermissions(ctx context.Context, role string) ([]string, error) {
+	byRole, _, err := cachedPermissions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return byRole[role], nil
+}
+
+// ResolveGrantedPermissions returns the given permissions along with those implied by the
+// implication rules, for principals such as API keys that hold permissions without a role
+func ResolveGrantedPermissions(ctx context.Context, granted []string) ([]string, error) {
+	_, implications, err := cachedPermissions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ExpandPermissions(granted, implications), nil
+}
+
+// cachedPermissions returns the permissions of every role and the implication rules, reloading
+// them from the role store once they are older than permissionCacheTTL
+func cachedPermissions(ctx context.Context) (map[string][]string, []models.PermissionImplication, error) {
+	permissions.mu.RLock()
+	byRole, implications, loadedAt := permissions.byRole, permissions.implications, permissions.loadedAt
+	permissions.mu.RUnlock()
+
+	if byRole == nil || time.Since(loadedAt) > permissionCacheTTL {
+		var err error
+		if byRole, implications, err = loadPermissions(ctx); err != nil {
+			return nil, nil, err
+		}
+		permissions.mu.Lock()
+		permissions.byRole, permissions.implications, permissions.loadedAt = byRole, implications, time.Now()
+		permissions.mu.Unlock()
+	}
+	return byRole, implications, nil
+}
+
+// loadPermissions reads the roles and implication rules from the role store
+func loadPermissions(ctx context.Context) (map[string][]string, []models.PermissionImplication, error) {
+	if roles == nil {
+		return nil, nil, errors.New("no role store configured")
+	}
+	list, err := roles.ListRoles(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	implications, err := roles.ListPermissionImplications(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	byRole := make(map[string][]string, len(list))
+	for _, role := range list {
+		byRole[role.Name] = ExpandPermissions(role.PermissionNames(), implications)
+	}
+	return byRole, implications, nil
+}
+
+// ExpandPermissions adds to the granted permissions those implied by the rules, until no rule
+// grants anything new. A rule applies when its permission is satisfied by a granted one, so
+// "*" implies what every rule implies.
+func ExpandPermissions(granted []string, implications []models.PermissionImplication) []string {
+	expanded := make(map[string]bool, len(granted))
+	for _, permission := range granted {
+		expanded[permission] = true
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, rule := range implications {
+			if expanded[rule.Implies] {
+				continue
+			}
+			for permission := range expanded {
+				if MatchPermission(permission, rule.Permission) {
+					expanded[rule.Implies] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	result := make([]string, 0, len(expanded))
+	for permission := range expanded {
+		result = append(result, permission)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// HasPermission checks if the given permissions include the required one, directly or through
+// a wildcard
+func HasPermission(permissions []string, required string) bool {
+	for _, p := range permissions {
+		if MatchPermission(p, required) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchPermission checks if a granted permission pattern covers the required permission.
+// Permissions are colon-separated segments such as "read:books". "*" covers every permission
+// and a "*" segment covers any value of that segment, so "read:*" covers "read:books".
+func MatchPermission(granted, required string) bool {
+	if granted == "*" || granted == required {
+		return true
+	}
+
+	grantedParts := strings.Split(granted, ":")
+	requiredParts := strings.Split(required, ":")
+	if len(grantedParts) != len(requiredParts) {
+		return false
+	}
+	for i, part := range grantedParts {
+		if part != "*" && part != requiredParts[i] {
+			return false
+		}
+	}
+	return true
+}
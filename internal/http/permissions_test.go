@@ -0,0 +1,79 @@
+package http
+
+import (
+	"reflect"
+	"testing"
+
+	"um6p.ma/finalproject/models"
+)
+
+func TestMatchPermission(t *testing.T) {
+	tests := []struct {
+		granted, required string
+		match             bool
+	}{
+		{"read:books", "read:books", true},
+		{"read:books", "read:orders", false},
+		{"read:*", "read:books", true},
+		{"read:*", "write:books", false},
+		{"*:books", "delete:books", true},
+		{"*", "manage:users", true},
+		{"read:*", "read", false},
+		{"read", "read:books", false},
+		{"read:*", "read:books:archived", false},
+	}
+	for _, tt := range tests {
+		if got := MatchPermission(tt.granted, tt.required); got != tt.match {
+			t.Errorf("MatchPermission(%q, %q) = %t, want %t", tt.granted, tt.required, got, tt.match)
+		}
+	}
+}
+
+func TestExpandPermissions(t *testing.T) {
+	implications := []models.PermissionImplication{
+		{Permission: "manage:customers", Implies: "write:customers"},
+		{Permission: "write:customers", Implies: "read:customers"},
+		{Permission: "write:*", Implies: "read:books"},
+	}
+
+	tests := []struct {
+		name    string
+		granted []string
+		want    []string
+	}{
+		{"nothing implied", []string{"read:orders"}, []string{"read:orders"}},
+		{"chained implications", []string{"manage:customers"},
+			[]string{"manage:customers", "read:customers", "write:customers"}},
+		// The wildcard satisfies the rule for write:*, and through it the customer rules
+		{"granted wildcard", []string{"write:*"},
+			[]string{"read:books", "read:customers", "write:*"}},
+		{"everything", []string{"*"},
+			[]string{"*", "read:books", "read:customers", "write:customers"}},
+		{"duplicates", []string{"read:customers", "write:customers", "read:customers"},
+			[]string{"read:customers", "write:customers"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExpandPermissions(tt.granted, implications); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasPermission(t *testing.T) {
+	permissions := []string{"read:*", "write:orders"}
+	for required, want := range map[string]bool{
+		"read:customers": true,
+		"write:orders":   true,
+		"write:books":    false,
+		"manage:users":   false,
+	} {
+		if got := HasPermission(permissions, required); got != want {
+			t.Errorf("HasPermission(%v, %q) = %t, want %t", permissions, required, got, want)
+		}
+	}
+	if HasPermission(nil, "read:books") {
+		t.Error("no permissions: got a match")
+	}
+}
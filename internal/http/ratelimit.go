@@ -0,0 +1,178 @@
+package http
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"um6p.ma/finalproject/errorhandling"
+)
+
+// rateLimitSweepInterval is how often idle buckets are dropped
+const rateLimitSweepInterval = time.Minute
+
+// RateLimiter limits the request rate of each client with token buckets. Clients are
+// identified by their user or API key, and by their IP address when not authenticated.
+// Buckets are kept in memory, so every instance enforces its budgets on its own.
+type RateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket // Buckets by budget and client
+	lastSweep time.Time
+	disabled  bool
+}
+
+// RateBudget allows Requests requests per Period to each client, in bursts of up to Requests.
+// The routes limited by a budget share it.
+type RateBudget struct {
+	limiter  *RateLimiter
+	name     string
+	Requests int
+	Period   time.Duration
+}
+
+// bucket holds the requests a client has left in a budget
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+	capacity  float64
+	rate      float64 // Tokens per second
+}
+
+// NewRateLimiter creates a rate limiter. A disabled one lets every request through.
+func NewRateLimiter(enabled bool) *RateLimiter {
+	return &RateLimiter{
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+		disabled:  !enabled,
+	}
+}
+
+// Budget declares a named budget of requests per period
+func (l *RateLimiter) Budget(name string, requests int, period time.Duration) *RateBudget {
+	return &RateBudget{limiter: l, name: name, Requests: requests, Period: period}
+}
+
+// Limit wraps a handler so that each client can only call it within the budget. Requests
+// beyond it get 429 with a Retry-After header, and all responses describe the remaining budget
+// with the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers.
+func (b *RateBudget) Limit(handler httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if b.limiter.disabled {
+			handler(w, r, ps)
+			return
+		}
+
+		// Clients whose IP address ran out of requests cannot try API keys any more, so that
+		// guessing keys does not cost a query each
+		allowed := true
+		var remaining int
+		var reset, retryAfter time.Duration
+		if _, ok := apiKeyFromRequest(r); ok {
+			allowed, remaining, reset, retryAfter = b.limiter.take(b, ipClient(r), false)
+		}
+		if allowed {
+			// The credentials checked here are not checked again by RequireAuth
+			var result *authResult
+			r, result = withAuthentication(r)
+			allowed, remaining, reset, retryAfter = b.limiter.take(b, rateLimitClient(r, result), true)
+		}
+
+		w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", b.Requests, int(b.Period.Seconds())))
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(b.Requests))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(reset)))
+		if !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(retryAfter)))
+			errorhandling.HandleError(w, errorhandling.ErrRateLimited)
+			return
+		}
+
+		handler(w, r, ps)
+	}
+}
+
+// take spends a request of a client from a budget, or only checks that one is left when spend
+// is false. It returns whether the request is allowed, the requests left, the time until the
+// bucket is full again, and when refused, the time until the next request is allowed.
+func (l *RateLimiter) take(b *RateBudget, client string, spend bool) (allowed bool, remaining int, reset, retryAfter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastSweep) >= rateLimitSweepInterval {
+		l.sweep(now)
+	}
+
+	key := b.name + "|" + client
+	bkt, exists := l.buckets[key]
+	if !exists {
+		capacity := float64(b.Requests)
+		bkt = &bucket{
+			tokens:    capacity,
+			updatedAt: now,
+			capacity:  capacity,
+			rate:      capacity / b.Period.Seconds(),
+		}
+		l.buckets[key] = bkt
+	}
+	bkt.refill(now)
+
+	if bkt.tokens >= 1 {
+		if spend {
+			bkt.tokens--
+		}
+		allowed = true
+	} else {
+		retryAfter = seconds((1 - bkt.tokens) / bkt.rate)
+	}
+	return allowed, int(bkt.tokens), seconds((bkt.capacity - bkt.tokens) / bkt.rate), retryAfter
+}
+
+// sweep drops the buckets that refilled completely, since they hold nothing a new bucket
+// would not
+func (l *RateLimiter) sweep(now time.Time) {
+	for key, bkt := range l.buckets {
+		bkt.refill(now)
+		if bkt.tokens >= bkt.capacity {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// refill adds the tokens earned since the last update
+func (bkt *bucket) refill(now time.Time) {
+	elapsed := now.Sub(bkt.updatedAt).Seconds()
+	bkt.tokens = min(bkt.capacity, bkt.tokens+elapsed*bkt.rate)
+	bkt.updatedAt = now
+}
+
+// rateLimitClient identifies the client of a request for rate limiting
+func rateLimitClient(r *http.Request, result *authResult) string {
+	if result.err == nil {
+		if result.claims.APIKeyID != 0 {
+			return "key:" + strconv.Itoa(result.claims.APIKeyID)
+		}
+		return "user:" + strconv.Itoa(result.claims.UserID)
+	}
+	return ipClient(r)
+}
+
+// ipClient identifies the client of a request by its IP address
+func ipClient(r *http.Request) string {
+	return "ip:" + ClientIP(r)
+}
+
+// seconds converts a number of seconds to a duration
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// ceilSeconds rounds a duration up to whole seconds
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,98 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/interfaces"
+	"um6p.ma/finalproject/models"
+)
+
+// countingKeyStore knows a single API key and counts the lookups
+type countingKeyStore struct {
+	interfaces.APIKeyStore
+	key     models.APIKey
+	lookups int
+}
+
+func (store *countingKeyStore) GetAPIKeyByHash(_ context.Context, keyHash string) (models.APIKey, error) {
+	store.lookups++
+	if keyHash != store.key.KeyHash {
+		return models.APIKey{}, errorhandling.NewNotFoundError("API key", 0)
+	}
+	return store.key, nil
+}
+
+func (store *countingKeyStore) TouchAPIKey(context.Context, int, time.Time) error {
+	return nil
+}
+
+func limited(budget *RateBudget) httprouter.Handle {
+	return budget.Limit(func(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func call(handler httprouter.Handle, ip string, headers map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/books", nil)
+	r.RemoteAddr = ip + ":1234"
+	for name, value := range headers {
+		r.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	handler(w, r, nil)
+	return w
+}
+
+func TestRateLimitBucketsPerClient(t *testing.T) {
+	handler := limited(NewRateLimiter(true).Budget("test", 2, time.Minute))
+
+	for i, want := range []int{http.StatusNoContent, http.StatusNoContent, http.StatusTooManyRequests} {
+		if w := call(handler, "10.0.0.1", nil); w.Code != want {
+			t.Fatalf("request %d: got %d, want %d", i+1, w.Code, want)
+		}
+	}
+	w := call(handler, "10.0.0.1", nil)
+	if w.Header().Get("Retry-After") == "" || w.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("refused request: got headers %v", w.Header())
+	}
+	if w := call(handler, "10.0.0.2", nil); w.Code != http.StatusNoContent {
+		t.Errorf("other client: got %d, want %d", w.Code, http.StatusNoContent)
+	}
+}
+
+func TestRateLimitRefusesKeyGuessesBeforeLookup(t *testing.T) {
+	store := &countingKeyStore{key: models.APIKey{ID: 7, KeyHash: HashAPIKey("mbk_7_valid")}}
+	previous := apiKeys
+	SetAPIKeyStore(store)
+	t.Cleanup(func() { SetAPIKeyStore(previous) })
+
+	handler := limited(NewRateLimiter(true).Budget("test", 3, time.Minute))
+	guess := map[string]string{"X-API-Key": "mbk_7_guess"}
+	for i := 0; i < 3; i++ {
+		call(handler, "10.0.0.1", guess)
+	}
+	if store.lookups != 3 {
+		t.Fatalf("guesses within the budget: got %d lookups, want 3", store.lookups)
+	}
+
+	if w := call(handler, "10.0.0.1", guess); w.Code != http.StatusTooManyRequests {
+		t.Errorf("guess beyond the budget: got %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if store.lookups != 3 {
+		t.Errorf("guess beyond the budget: got %d lookups, want none more", store.lookups)
+	}
+
+	// Valid keys spend from their own bucket
+	if w := call(handler, "10.0.0.2", map[string]string{"X-API-Key": "mbk_7_valid"}); w.Code != http.StatusNoContent {
+		t.Errorf("valid key from another address: got %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if w := call(handler, "10.0.0.2", nil); w.Header().Get("RateLimit-Remaining") != "2" {
+		t.Errorf("address of the valid key: got %s requests left, want 2", w.Header().Get("RateLimit-Remaining"))
+	}
+}
@@ -0,0 +1,171 @@
+package http
+
+import (
+	"context"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+
+	"um6p.ma/finalproject/constants"
+)
+
+// redactedTypes caches whether the values of a type hold sensitive fields, directly or nested
+var redactedTypes sync.Map
+
+// Redact returns a copy of a response value with the sensitive fields the caller may not see
+// masked, following constants.SensitiveFieldPermissions. Fields are marked by a `sensitive` tag
+// naming their class. The value is returned unchanged when the caller sees every class in full.
+// Handlers respond through WriteJSON, which applies it.
+func Redact(ctx context.Context, value interface{}) interface{} {
+	if value == nil || !holdsSensitiveFields(reflect.TypeOf(value)) {
+		return value
+	}
+	masked := maskedClasses(ctx)
+	if len(masked) == 0 {
+		return value
+	}
+	return redactValue(reflect.ValueOf(value), masked).Interface()
+}
+
+// maskedClasses returns the classes of sensitive fields the caller may not see in full. Requests
+// restricted to the records of the caller see them in full, see OwnerScope. Every class is
+// masked when the permissions of the caller cannot be resolved.
+func maskedClasses(ctx context.Context) map[string]bool {
+	if _, scoped := OwnerScope(ctx); scoped {
+		return nil
+	}
+
+	var granted []string
+	if claims, ok := GetClaimsFromContext(ctx); ok {
+		permissions, err := grantedPermissions(ctx, claims)
+		if err != nil {
+			log.Printf("⚠️ Masking every sensitive field: %v", err)
+		}
+		granted = permissions
+	}
+
+	masked := make(map[string]bool)
+	for class, permission := range constants.SensitiveFieldPermissions {
+		if !HasPermission(granted, permission) {
+			masked[class] = true
+		}
+	}
+	return masked
+}
+
+// redactValue returns a copy of a value with the fields of the masked classes masked. Values
+// without sensitive fields are shared rather than copied.
+func redactValue(value reflect.Value, masked map[string]bool) reflect.Value {
+	if !holdsSensitiveFields(value.Type()) {
+		return value
+	}
+
+	switch value.Kind() {
+	case reflect.Pointer:
+		if value.IsNil() {
+			return value
+		}
+		copied := reflect.New(value.Type().Elem())
+		copied.Elem().Set(redactValue(value.Elem(), masked))
+		return copied
+	case reflect.Interface:
+		if value.IsNil() {
+			return value
+		}
+		copied := reflect.New(value.Type()).Elem()
+		copied.Set(redactValue(value.Elem(), masked))
+		return copied
+	case reflect.Slice:
+		if value.IsNil() {
+			return value
+		}
+		copied := reflect.MakeSlice(value.Type(), value.Len(), value.Len())
+		for i := 0; i < value.Len(); i++ {
+			copied.Index(i).Set(redactValue(value.Index(i), masked))
+		}
+		return copied
+	case reflect.Array:
+		copied := reflect.New(value.Type()).Elem()
+		for i := 0; i < value.Len(); i++ {
+			copied.Index(i).Set(redactValue(value.Index(i), masked))
+		}
+		return copied
+	case reflect.Map:
+		if value.IsNil() {
+			return value
+		}
+		copied := reflect.MakeMapWithSize(value.Type(), value.Len())
+		for iter := value.MapRange(); iter.Next(); {
+			copied.SetMapIndex(iter.Key(), redactValue(iter.Value(), masked))
+		}
+		return copied
+	case reflect.Struct:
+		copied := reflect.New(value.Type()).Elem()
+		copied.Set(value)
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			if class := field.Tag.Get("sensitive"); class != "" {
+				if masked[class] && field.Type.Kind() == reflect.String {
+					copied.Field(i).SetString(maskField(class, value.Field(i).String()))
+				}
+				continue
+			}
+			copied.Field(i).Set(redactValue(value.Field(i), masked))
+		}
+		return copied
+	}
+	return value
+}
+
+// holdsSensitiveFields checks if the values of a type may hold sensitive fields. Interfaces
+// may hold anything, so they are walked.
+func holdsSensitiveFields(t reflect.Type) bool {
+	if cached, ok := redactedTypes.Load(t); ok {
+		return cached.(bool)
+	}
+	holds := inspectType(t, make(map[reflect.Type]bool))
+	redactedTypes.Store(t, holds)
+	return holds
+}
+
+// inspectType checks if the values of a type may hold sensitive fields. Types being inspected
+// are skipped when met again, which ends the recursion of recursive types.
+func inspectType(t reflect.Type, visiting map[reflect.Type]bool) bool {
+	if visiting[t] {
+		return false
+	}
+	visiting[t] = true
+
+	switch t.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map:
+		return inspectType(t.Elem(), visiting)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.IsExported() && (field.Tag.Get("sensitive") != "" || inspectType(field.Type, visiting)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// maskField masks the value of a sensitive field of a class. Emails keep their first letter and
+// their domain, so that staff can still tell them apart.
+func maskField(class, value string) string {
+	if value == "" {
+		return ""
+	}
+	if class == constants.SensitiveEmail {
+		if local, domain, ok := strings.Cut(value, "@"); ok && local != "" {
+			return local[:1] + "***@" + domain
+		}
+	}
+	return "***"
+}
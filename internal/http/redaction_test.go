@@ -0,0 +1,91 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"um6p.ma/finalproject/interfaces"
+	"um6p.ma/finalproject/models"
+)
+
+// fixedRoleStore grants each role the listed permissions, without implication rules
+type fixedRoleStore struct {
+	interfaces.RoleStore
+	roles map[string][]string
+}
+
+func (store fixedRoleStore) ListRoles(context.Context) ([]models.Role, error) {
+	var roles []models.Role
+	for name, permissions := range store.roles {
+		role := models.Role{Name: name}
+		for _, permission := range permissions {
+			role.Permissions = append(role.Permissions, models.RolePermission{Permission: permission})
+		}
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
+func (fixedRoleStore) ListPermissionImplications(context.Context) ([]models.PermissionImplication, error) {
+	return nil, nil
+}
+
+func TestWriteJSONMasksSensitiveFields(t *testing.T) {
+	previous := roles
+	SetRoleStore(fixedRoleStore{roles: map[string][]string{
+		"manager": {"read:*", "read_sensitive:customers"},
+		"user":    {"read:customers"},
+	}})
+	t.Cleanup(func() { SetRoleStore(previous) })
+
+	customer := models.Customer{
+		ID:      3,
+		Name:    "Alice Doe",
+		Email:   "alice@mybiblio.com",
+		Address: models.Address{Street: "1 Main Street", City: "Ben Guerir", PostalCode: "43150"},
+	}
+
+	tests := []struct {
+		name   string
+		claims *Claims
+		own    bool
+		email  string
+		street string
+	}{
+		{"permission granted", &Claims{UserID: 1, Role: "manager"}, false, "alice@mybiblio.com", "1 Main Street"},
+		{"reading any customer", &Claims{UserID: 2, Role: "user"}, false, "a***@mybiblio.com", "***"},
+		{"own records", &Claims{UserID: 2, Role: "user"}, true, "alice@mybiblio.com", "1 Main Street"},
+		{"custom role", &Claims{UserID: 4, Role: "support"}, false, "a***@mybiblio.com", "***"},
+		{"API key", &Claims{APIKeyID: 5, Permissions: []string{"read:customers"}}, false, "a***@mybiblio.com", "***"},
+		{"API key granted the permission", &Claims{APIKeyID: 5, Permissions: []string{"read_sensitive:customers"}}, false, "alice@mybiblio.com", "1 Main Street"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/customers", nil)
+			r = r.WithContext(context.WithValue(r.Context(), ContextUserKey, tt.claims))
+			handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				WriteJSON(w, r, http.StatusOK, []models.Customer{customer})
+			}))
+			if tt.own {
+				handler = ScopeToCaller(handler)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			var got []models.Customer
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil || len(got) != 1 {
+				t.Fatalf("decoding %q: %v", w.Body.String(), err)
+			}
+			if got[0].Email != tt.email || got[0].Address.Street != tt.street || got[0].Address.City != "Ben Guerir" {
+				t.Errorf("got %s and %+v, want %s and street %q", got[0].Email, got[0].Address, tt.email, tt.street)
+			}
+		})
+	}
+
+	if customer.Email != "alice@mybiblio.com" {
+		t.Error("masking changed the original value")
+	}
+}
@@ -0,0 +1,15 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// WriteJSON writes value as the JSON response with the given status. Every handler responds
+// through it, so that the sensitive fields the caller may not see are masked whatever the
+// route, see Redact.
+func WriteJSON(w http.ResponseWriter, r *http.Request, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Redact(r.Context(), value))
+}
@@ -4,12 +4,9 @@ import (
 	"context"
 	"fmt"
 	"net/http"
-	"strconv"
 
 	"github.com/julienschmidt/httprouter"
-	"um6p.ma/finalproject/database"
 	"um6p.ma/finalproject/errorhandling"
-	"um6p.ma/finalproject/models"
 )
 
 // MiddlewareFunc is a type alias for HTTP middleware
@@ -26,6 +23,9 @@ func WrapWithMiddleware(handler httprouter.Handle, mw ...MiddlewareFunc) httprou
 			h = http.HandlerFunc(mw[i](h).ServeHTTP)
 		}
 
+		// The router passes the params to the handler only, so share them with the middleware
+		r = r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, ps))
+
 		defer func() {
 			if err := recover(); err != nil {
 				errorhandling.HandleError(w, errorhandling.NewError(
@@ -40,7 +40,9 @@ func WrapWithMiddleware(handler httprouter.Handle, mw ...MiddlewareFunc) httprou
 	}
 }
 
-// RequirePermission creates a middleware that checks for a specific permission
+// RequirePermission creates a middleware that checks for a specific permission. The permissions
+// of the role are resolved from the role store on each request, rather than read from the token.
+// API keys hold their own permissions, which the implication rules apply to as well.
 func RequirePermission(permission string) MiddlewareFunc {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -50,7 +52,13 @@ func RequirePermission(permission string) MiddlewareFunc {
 				return
 			}
 
-			if !HasPermission(claims.Permissions, permission) {
+			granted, err := grantedPermissions(r.Context(), claims)
+			if err != nil {
+				errorhandling.HandleError(w, errorhandling.NewDatabaseError(err))
+				return
+			}
+
+			if !HasPermission(granted, permission) {
 				errorhandling.HandleError(w, errorhandling.NewError(
 					http.StatusForbidden,
 					errorhandling.ErrCodeForbidden,
@@ -64,6 +72,14 @@ func RequirePermission(permission string) MiddlewareFunc {
 	}
 }
 
+// grantedPermissions returns the permissions of the role of the caller, or those of its API key
+func grantedPermissions(ctx context.Context, claims *Claims) ([]string, error) {
+	if claims.APIKeyID != 0 {
+		return ResolveGrantedPermissions(ctx, claims.Permissions)
+	}
+	return ResolvePermissions(ctx, claims.Role)
+}
+
 // RequireRoles creates a middleware that checks for specific roles
 func RequireRoles(roles ...string) MiddlewareFunc {
 	return func(next http.Handler) http.Handler {
@@ -88,37 +104,12 @@ func RequireRoles(roles ...string) MiddlewareFunc {
 	}
 }
 
-// ExtractOrderOwnerID extracts the owner ID from an order
-func ExtractOrderOwnerID(r *http.Request) int {
-	params := httprouter.ParamsFromContext(r.Context())
-	orderID, err := strconv.Atoi(params.ByName("id"))
-	if err != nil {
-		return 0
-	}
-
-	var order models.Order
-	if err := database.DB.First(&order, orderID).Error; err != nil {
-		return 0
-	}
-	return order.CustomerID
-}
-
 // GetClaimsFromContext extracts JWT claims from the request context
 func GetClaimsFromContext(ctx context.Context) (*Claims, bool) {
 	claims, ok := ctx.Value(ContextUserKey).(*Claims)
 	return claims, ok
 }
 
-// HasPermission checks if the given permissions include the required one
-func HasPermission(permissions []string, required string) bool {
-	for _, p := range permissions {
-		if p == required || p == "*" {
-			return true
-		}
-	}
-	return false
-}
-
 // HasRole checks if the given role matches any of the required roles
 func HasRole(userRole string, requiredRoles ...string) bool {
 	for _, role := range requiredRoles {
@@ -0,0 +1,172 @@
+// Package loginguard slows down password guessing. Every failed login of an email makes the
+// next attempt wait twice as long, and too many failures of an email or of an IP address lock
+// their logins for a while. Failures are counted in a LoginThrottleStore, so that instances
+// sharing a database also share the counts.
+package loginguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"um6p.ma/finalproject/constants"
+	"um6p.ma/finalproject/errorhandling"
+	"um6p.ma/finalproject/interfaces"
+	"um6p.ma/finalproject/models"
+)
+
+// Policy sets how failed logins are throttled. A zero maximum never locks.
+type Policy struct {
+	MaxFailures     int           // Failed logins of an email before it is locked
+	IPMaxFailures   int           // Failed logins from an IP address before it is locked
+	LockoutDuration time.Duration // How long a lock lasts, and how long failures are remembered
+	BackoffBase     time.Duration // Wait after the first failure of an email, doubled by every other one
+}
+
+// Guard throttles the logins of emails and IP addresses
+type Guard struct {
+	Throttles interfaces.LoginThrottleStore
+	Events    interfaces.SecurityEventStore
+	Policy    Policy
+}
+
+// Verdict tells whether a login may be attempted
+type Verdict struct {
+	Locked     bool          // The email or IP address is locked, rather than backing off
+	RetryAfter time.Duration // How long to wait before the next attempt, 0 when it is allowed
+}
+
+// Allowed reports whether the login may be attempted now
+func (v Verdict) Allowed() bool {
+	return v.RetryAfter <= 0
+}
+
+func New(throttles interfaces.LoginThrottleStore, events interfaces.SecurityEventStore, policy Policy) *Guard {
+	return &Guard{Throttles: throttles, Events: events, Policy: policy}
+}
+
+// EmailKey returns the identifier under which the failed logins of an email are counted
+func EmailKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// IPKey returns the identifier under which the failed logins from an IP address are counted
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Check tells whether a login of an email from an IP address may be attempted. It must be
+// called before the password is checked, so that refused attempts cost nothing.
+func (g *Guard) Check(ctx context.Context, email, ip string) (Verdict, error) {
+	now := time.Now()
+	var verdict Verdict
+
+	ipThrottle, err := g.Throttles.GetLoginThrottle(ctx, IPKey(ip))
+	if err != nil {
+		return Verdict{}, err
+	}
+	if wait := lockRemaining(ipThrottle, now); wait > 0 {
+		verdict = Verdict{Locked: true, RetryAfter: wait}
+	}
+
+	emailThrottle, err := g.Throttles.GetLoginThrottle(ctx, EmailKey(email))
+	if err != nil {
+		return Verdict{}, err
+	}
+	if wait := lockRemaining(emailThrottle, now); wait > 0 {
+		verdict = Verdict{Locked: true, RetryAfter: max(verdict.RetryAfter, wait)}
+	} else if !verdict.Locked && g.recent(emailThrottle, now) {
+		verdict.RetryAfter = emailThrottle.LastFailureAt.Add(g.backoff(emailThrottle.Failures)).Sub(now)
+	}
+
+	return verdict, nil
+}
+
+// Fail counts a failed login of an email from an IP address, and locks either of them once
+// it failed too many times. userID is the user owning the email, if any.
+func (g *Guard) Fail(ctx context.Context, email, ip string, userID *int) error {
+	if err := g.fail(ctx, EmailKey(email), g.Policy.MaxFailures, userID, ip); err != nil {
+		return err
+	}
+	return g.fail(ctx, IPKey(ip), g.Policy.IPMaxFailures, nil, ip)
+}
+
+// Succeed forgets the failed logins of an email. Those from the IP address are kept, since
+// an attacker may own one of the accounts it guesses.
+func (g *Guard) Succeed(ctx context.Context, email string) error {
+	err := g.Throttles.ClearLoginThrottle(ctx, EmailKey(email))
+	if err != nil && !errors.Is(err, errorhandling.ErrThrottleNotFound) {
+		return err
+	}
+	return nil
+}
+
+// Unlock lifts the lock and forgets the failed logins of an identifier, on behalf of an
+// admin. userID is the user owning the identifier, if any.
+func (g *Guard) Unlock(ctx context.Context, identifier string, actorID int, userID *int) error {
+	if err := g.Throttles.ClearLoginThrottle(ctx, identifier); err != nil {
+		return err
+	}
+
+	log.Printf("🔓 User %d unlocked the logins of %s", actorID, identifier)
+	_, err := g.Events.RecordSecurityEvent(ctx, models.SecurityEvent{
+		Type:       constants.SecurityEventLoginUnlocked,
+		UserID:     userID,
+		ActorID:    &actorID,
+		Identifier: identifier,
+	})
+	return err
+}
+
+// fail counts a failed login of an identifier, and locks it when it reaches maxFailures
+func (g *Guard) fail(ctx context.Context, identifier string, maxFailures int, userID *int, ip string) error {
+	now := time.Now()
+	throttle, err := g.Throttles.RecordLoginFailure(ctx, identifier, now, g.Policy.LockoutDuration)
+	if err != nil {
+		return err
+	}
+	if maxFailures <= 0 || throttle.Failures < maxFailures || lockRemaining(throttle, now) > 0 {
+		return nil
+	}
+
+	until := now.Add(g.Policy.LockoutDuration)
+	if err := g.Throttles.LockLogin(ctx, identifier, until); err != nil {
+		return err
+	}
+
+	log.Printf("🔒 Logins of %s locked until %s after %d failures", identifier, until.Format(time.RFC3339), throttle.Failures)
+	_, err = g.Events.RecordSecurityEvent(ctx, models.SecurityEvent{
+		Type:       constants.SecurityEventLoginLocked,
+		UserID:     userID,
+		Identifier: identifier,
+		IP:         ip,
+		Details:    fmt.Sprintf("%d failed logins; locked until %s", throttle.Failures, until.UTC().Format(time.RFC3339)),
+	})
+	return err
+}
+
+// recent reports whether the failures of a throttle are still remembered
+func (g *Guard) recent(throttle models.LoginThrottle, now time.Time) bool {
+	return throttle.Failures > 0 && throttle.LastFailureAt.After(now.Add(-g.Policy.LockoutDuration))
+}
+
+// backoff returns how long to wait after the given number of failures, which doubles with
+// every failure up to the lockout duration
+func (g *Guard) backoff(failures int) time.Duration {
+	wait := g.Policy.BackoffBase
+	for i := 1; i < failures && wait < g.Policy.LockoutDuration; i++ {
+		wait *= 2
+	}
+	return min(wait, g.Policy.LockoutDuration)
+}
+
+// lockRemaining returns how long a throttle stays locked
+func lockRemaining(throttle models.LoginThrottle, now time.Time) time.Duration {
+	if throttle.LockedUntil == nil {
+		return 0
+	}
+	return max(throttle.LockedUntil.Sub(now), 0)
+}
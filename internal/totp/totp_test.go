@@ -0,0 +1,96 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 key of the RFC 6238 test vectors, "12345678901234567890", in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// The RFC 6238 appendix B vectors, truncated from 8 digits to the 6 of authenticator apps
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestCodeMatchesRFC6238Vectors(t *testing.T) {
+	for _, vector := range rfcVectors {
+		code, err := Code(rfcSecret, Step(time.Unix(vector.unix, 0)))
+		if err != nil {
+			t.Fatalf("Code: %v", err)
+		}
+		if code != vector.code {
+			t.Errorf("at %d: got %s, want %s", vector.unix, code, vector.code)
+		}
+	}
+
+	// Secrets are typed in lowercase as often as not
+	if code, _ := Code(strings.ToLower(rfcSecret), Step(time.Unix(59, 0))); code != "287082" {
+		t.Errorf("lowercase secret: got %s, want 287082", code)
+	}
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("invalid secret: got no error")
+	}
+}
+
+func TestValidateAllowsSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+
+	tests := []struct {
+		name  string
+		at    time.Time
+		skew  int64
+		valid bool
+	}{
+		{"current step", now, 0, true},
+		{"previous step within the skew", now.Add(Period), 1, true},
+		{"next step within the skew", now.Add(-Period), 1, true},
+		{"previous step without skew", now.Add(Period), 0, false},
+		{"beyond the skew", now.Add(2 * Period), 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matched, ok := Validate(rfcSecret, "050471", tt.at, tt.skew)
+			if ok != tt.valid || ok && matched != step {
+				t.Errorf("got step %d, %t, want %d, %t", matched, ok, step, tt.valid)
+			}
+		})
+	}
+
+	if _, ok := Validate(rfcSecret, "050 471", now, 0); !ok {
+		t.Error("code typed with a space: got invalid")
+	}
+	if _, ok := Validate(rfcSecret, "50471", now, 0); ok {
+		t.Error("short code: got valid")
+	}
+}
+
+func TestGenerateSecretRoundTrips(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := encoding.DecodeString(secret)
+	if err != nil || len(key) != secretSize {
+		t.Fatalf("got %d bytes (%v), want %d", len(key), err, secretSize)
+	}
+
+	now := time.Now()
+	code, err := Code(secret, Step(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Validate(secret, code, now, 1); !ok {
+		t.Error("the current code of a generated secret is not valid")
+	}
+}
@@ -0,0 +1,66 @@
+package mailer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Outbox is a Mailer that writes each email to a .eml file of a directory instead of sending
+// it, so that development setups need no mail server
+type Outbox struct {
+	dir  string
+	from string
+}
+
+// NewOutbox creates an outbox writing the emails sent by from to dir
+func NewOutbox(dir, from string) (*Outbox, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Outbox{dir: dir, from: from}, nil
+}
+
+func (outbox *Outbox) Send(ctx context.Context, message Message) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	now := time.Now()
+	data, err := format(outbox.from, message, now)
+	if err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	name := now.UTC().Format("20060102T150405.000000000Z") + "-" + hex.EncodeToString(suffix) + ".eml"
+
+	// Written under a temporary name, so that readers never see a partial email
+	tmp, err := os.CreateTemp(outbox.dir, "."+name+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(outbox.dir, name)); err != nil {
+		return err
+	}
+
+	log.Printf("📧 Wrote email %q for %s to %s", message.Subject, message.To, filepath.Join(outbox.dir, name))
+	return nil
+}
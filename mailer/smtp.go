@@ -0,0 +1,102 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// smtpTimeout bounds an SMTP exchange when the context has no deadline
+const smtpTimeout = 30 * time.Second
+
+// SMTPMailer sends emails through an SMTP server. It upgrades the connection with STARTTLS
+// when the server offers it, and authenticates when credentials are configured.
+type SMTPMailer struct {
+	host     string
+	addr     string
+	username string
+	password string
+	from     string
+	rootCAs  *x509.CertPool // Certificates trusted by STARTTLS, the system ones when nil
+}
+
+// NewSMTPMailer creates a mailer sending through the SMTP server at host:port
+func NewSMTPMailer(host string, port int, username, password, from string) *SMTPMailer {
+	return &SMTPMailer{
+		host:     host,
+		addr:     net.JoinHostPort(host, strconv.Itoa(port)),
+		username: username,
+		password: password,
+		from:     from,
+	}
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, message Message) error {
+	data, err := format(m.from, message, time.Now())
+	if err != nil {
+		return err
+	}
+	sender, _ := mail.ParseAddress(m.from)
+	recipient, _ := mail.ParseAddress(message.To)
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, smtpTimeout)
+		defer cancel()
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", m.addr)
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	client, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: m.host, RootCAs: m.rootCAs}); err != nil {
+			return fmt.Errorf("STARTTLS: %w", err)
+		}
+	}
+	if m.username != "" {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return errors.New("SMTP server does not support authentication")
+		}
+		// PlainAuth refuses to send credentials without TLS, except to localhost
+		if err := client.Auth(smtp.PlainAuth("", m.username, m.password, m.host)); err != nil {
+			return fmt.Errorf("SMTP authentication: %w", err)
+		}
+	}
+
+	if err := client.Mail(sender.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(recipient.Address); err != nil {
+		return err
+	}
+	body, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := body.Write(data); err != nil {
+		body.Close()
+		return err
+	}
+	if err := body.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -0,0 +1,278 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"mime"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTPServer accepts a single SMTP session and records what the client sent
+type fakeSMTPServer struct {
+	listener net.Listener
+	tls      *tls.Config // STARTTLS is offered when set
+	auth     bool        // AUTH PLAIN is offered
+	done     chan struct{}
+
+	// Recorded by the session
+	from, to  string
+	data      string
+	plainAuth string // Decoded AUTH PLAIN credentials
+	secured   bool   // STARTTLS was used
+	err       error
+}
+
+func startFakeSMTPServer(t *testing.T, tlsConfig *tls.Config, auth bool) *fakeSMTPServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fakeSMTPServer{listener: listener, tls: tlsConfig, auth: auth, done: make(chan struct{})}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		defer close(server.done)
+		conn, err := listener.Accept()
+		if err != nil {
+			server.err = err
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		server.err = server.serve(conn)
+	}()
+	return server
+}
+
+// mailer returns a mailer sending to the fake server
+func (server *fakeSMTPServer) mailer(username, password string) *SMTPMailer {
+	addr := server.listener.Addr().(*net.TCPAddr)
+	return NewSMTPMailer("127.0.0.1", addr.Port, username, password, "MyBiblio <no-reply@mybiblio.com>")
+}
+
+// wait returns once the session is over
+func (server *fakeSMTPServer) wait(t *testing.T) {
+	t.Helper()
+	select {
+	case <-server.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the SMTP session did not end")
+	}
+	if server.err != nil && server.err != io.EOF {
+		t.Fatalf("fake SMTP server: %v", server.err)
+	}
+}
+
+func (server *fakeSMTPServer) serve(conn net.Conn) error {
+	text := textproto.NewConn(conn)
+	if err := text.PrintfLine("220 fake.test ESMTP"); err != nil {
+		return err
+	}
+
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return err
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			extensions := []string{"fake.test"}
+			if server.tls != nil && !server.secured {
+				extensions = append(extensions, "STARTTLS")
+			}
+			if server.auth {
+				extensions = append(extensions, "AUTH PLAIN")
+			}
+			for i, extension := range extensions {
+				separator := "-"
+				if i == len(extensions)-1 {
+					separator = " "
+				}
+				text.PrintfLine("250%s%s", separator, extension)
+			}
+		case "STARTTLS":
+			text.PrintfLine("220 Ready to start TLS")
+			secured := tls.Server(conn, server.tls)
+			if err := secured.Handshake(); err != nil {
+				return nil // The client refused the certificate
+			}
+			conn, server.secured = secured, true
+			text = textproto.NewConn(conn)
+		case "AUTH":
+			mechanism, initial, _ := strings.Cut(arg, " ")
+			credentials, err := base64.StdEncoding.DecodeString(initial)
+			if mechanism != "PLAIN" || err != nil {
+				text.PrintfLine("504 Unsupported authentication")
+				continue
+			}
+			server.plainAuth = string(credentials)
+			text.PrintfLine("235 Authenticated")
+		case "MAIL":
+			server.from = arg
+			text.PrintfLine("250 OK")
+		case "RCPT":
+			server.to = arg
+			text.PrintfLine("250 OK")
+		case "DATA":
+			text.PrintfLine("354 Go ahead")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return err
+			}
+			server.data = string(data)
+			text.PrintfLine("250 Queued")
+		case "QUIT":
+			text.PrintfLine("221 Bye")
+			return nil
+		default:
+			text.PrintfLine("502 Not implemented")
+		}
+	}
+}
+
+// selfSignedTLS creates a certificate for 127.0.0.1, returning the server configuration and
+// the pool trusting it
+func selfSignedTLS(t *testing.T) (*tls.Config, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "fake.test"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}, pool
+}
+
+var testMessage = Message{
+	To:      "Alice Doe <alice@mybiblio.com>",
+	Subject: "Vérifiez votre adresse",
+	Body:    "Hello Alice,\nopen the link below:\nhttps://mybiblio.com/email/verify?token=abc\n",
+}
+
+func TestSMTPMailerSendsEnvelopeAndHeaders(t *testing.T) {
+	server := startFakeSMTPServer(t, nil, false)
+	if err := server.mailer("", "").Send(context.Background(), testMessage); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	server.wait(t)
+
+	if server.from != "FROM:<no-reply@mybiblio.com>" {
+		t.Errorf("MAIL %s, want FROM:<no-reply@mybiblio.com>", server.from)
+	}
+	if server.to != "TO:<alice@mybiblio.com>" {
+		t.Errorf("RCPT %s, want TO:<alice@mybiblio.com>", server.to)
+	}
+	if server.secured || server.plainAuth != "" {
+		t.Errorf("got STARTTLS %t and AUTH %q, want neither", server.secured, server.plainAuth)
+	}
+
+	message, err := mail.ReadMessage(bufio.NewReader(strings.NewReader(server.data)))
+	if err != nil {
+		t.Fatalf("reading the email: %v", err)
+	}
+	headers := map[string]string{
+		"From":                      `"MyBiblio" <no-reply@mybiblio.com>`,
+		"To":                        `"Alice Doe" <alice@mybiblio.com>`,
+		"Content-Type":              "text/plain; charset=utf-8",
+		"Content-Transfer-Encoding": "quoted-printable",
+		"MIME-Version":              "1.0",
+	}
+	for name, want := range headers {
+		if got := message.Header.Get(name); got != want {
+			t.Errorf("header %s: got %q, want %q", name, got, want)
+		}
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(message.Header.Get("Subject"))
+	if err != nil || subject != testMessage.Subject {
+		t.Errorf("header Subject: got %q (%v), want %q", subject, err, testMessage.Subject)
+	}
+	if _, err := message.Header.Date(); err != nil {
+		t.Errorf("header Date: %v", err)
+	}
+	if id := message.Header.Get("Message-ID"); !strings.HasSuffix(id, "@mybiblio.com>") {
+		t.Errorf("header Message-ID: got %q", id)
+	}
+}
+
+func TestSMTPMailerUpgradesWithSTARTTLSAndAuthenticates(t *testing.T) {
+	serverTLS, roots := selfSignedTLS(t)
+	server := startFakeSMTPServer(t, serverTLS, true)
+	mailer := server.mailer("mailer", "s3cret")
+	mailer.rootCAs = roots
+
+	if err := mailer.Send(context.Background(), testMessage); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	server.wait(t)
+
+	if !server.secured {
+		t.Error("the connection was not upgraded with STARTTLS")
+	}
+	if server.plainAuth != "\x00mailer\x00s3cret" {
+		t.Errorf("AUTH PLAIN: got %q", server.plainAuth)
+	}
+	if server.to != "TO:<alice@mybiblio.com>" {
+		t.Errorf("RCPT %s, want TO:<alice@mybiblio.com>", server.to)
+	}
+}
+
+func TestSMTPMailerRefusesUntrustedCertificate(t *testing.T) {
+	serverTLS, _ := selfSignedTLS(t)
+	server := startFakeSMTPServer(t, serverTLS, true)
+
+	err := server.mailer("mailer", "s3cret").Send(context.Background(), testMessage)
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Fatalf("Send: got %v, want a STARTTLS error", err)
+	}
+	server.wait(t)
+	if server.plainAuth != "" || server.data != "" {
+		t.Error("credentials or data were sent over an untrusted connection")
+	}
+}
+
+func TestSMTPMailerNeedsAuthWhenConfigured(t *testing.T) {
+	server := startFakeSMTPServer(t, nil, false)
+
+	err := server.mailer("mailer", "s3cret").Send(context.Background(), testMessage)
+	if err == nil || !strings.Contains(err.Error(), "does not support authentication") {
+		t.Fatalf("Send: got %v, want an authentication error", err)
+	}
+	server.wait(t)
+	if server.data != "" {
+		t.Error("the email was sent without authenticating")
+	}
+}
@@ -10,33 +10,74 @@ import (
 	"syscall"
 	"time"
 
-	"um6p.ma/finalproject/database"
+	"um6p.ma/finalproject/app"
+	"um6p.ma/finalproject/config"
 	"um6p.ma/finalproject/handlers"
+	"um6p.ma/finalproject/internal/authz"
+	internalhttp "um6p.ma/finalproject/internal/http"
 )
 
 func main() {
 	fmt.Println("Starting the server...")
-	database.ConnectDatabase()
+	cfg := config.Load()
+
+	// Tokens can neither be issued nor verified without signing keys
+	keyring, err := internalhttp.LoadKeyring(cfg.JWTKeysDir)
+	if err != nil {
+		log.Fatalf("Failed to load the JWT signing keys: %v", err)
+	}
+
+	// Routes are authorized by the rules of the policy file, or by the built-in policy
+	policy, err := authz.Load(cfg.AuthzPolicyFile, internalhttp.HasPermission)
+	if err != nil {
+		log.Fatalf("Failed to load the authorization policy: %v", err)
+	}
+
+	// Build the stores for the configured backend
+	stores, err := app.NewStores(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize %s storage: %v", cfg.Storage, err)
+	}
+	log.Printf("Using %s storage", cfg.Storage)
 
 	// Create a context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	if err := app.EnsureAdmin(ctx, stores.Users, cfg); err != nil {
+		log.Fatalf("Failed to create the bootstrap admin: %v", err)
+	}
+
 	// Handle OS signals for graceful shutdown
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
 	// Start automated sales report generation in the background
-	go handlers.StartSalesReportGeneration(ctx)
+	go handlers.NewSalesReportHandler(stores).StartSalesReportGeneration(ctx)
+
+	// Rotate the JWT signing keys in the background
+	if cfg.JWTKeyRotation > 0 {
+		go keyring.StartRotation(ctx, cfg.JWTKeyRotation, cfg.JWTKeyAlgorithm, cfg.SignedTokenLifetime())
+	}
+
+	// Apply edits of the policy file without restarting
+	if cfg.AuthzPolicyFile != "" && cfg.AuthzReloadInterval > 0 {
+		go policy.Watch(ctx, cfg.AuthzReloadInterval)
+	}
+
+	mail, err := app.NewMailer(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize the %s mailer: %v", cfg.Mailer, err)
+	}
 
 	// Initialize the router
-	router := handlers.SetupRouter()
+	router := handlers.SetupRouter(stores, keyring, policy, mail, cfg)
 	server := &http.Server{
-		Addr:    ":8080",
+		Addr:    cfg.ServerAddr,
 		Handler: router,
 	}
 
-	fmt.Println("🚀 Server is running on http://localhost:8080")
+	fmt.Printf("🚀 Server is running on %s\n", cfg.ServerAddr)
 
 	// Start the server in a goroutine
 	go func() {
@@ -57,5 +98,10 @@ func main() {
 		log.Fatalf("Error during server shutdown: %v", err)
 	}
 
+	// Flush the stores once no more requests are being served
+	if err := stores.Close(); err != nil {
+		log.Printf("❌ Error closing the stores: %v", err)
+	}
+
 	fmt.Println("Server stopped successfully.")
 }
@@ -0,0 +1,295 @@
+package migrations
+
+import (
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//go:embed postgres/*.sql sqlite/*.sql
+var scripts embed.FS
+
+// fileNamePattern matches script names such as 0001_initial_schema.up.sql
+var fileNamePattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a numbered schema change with its up and down scripts
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string // SHA-256 of the up script
+}
+
+// MigrationStatus describes whether a migration has been applied to the database
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// schemaMigration is a row of the schema_migrations tracking table
+type schemaMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Migrator applies and reverts the migrations of one database
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+// New loads the migrations matching the dialect of db
+func New(db *gorm.DB) (*Migrator, error) {
+	migrations, err := load(db.Dialector.Name())
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// load reads and pairs the up/down scripts of a dialect, ordered by version
+func load(dialect string) ([]Migration, error) {
+	entries, err := fs.ReadDir(scripts, dialect)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for dialect %q: %w", dialect, err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := fileNamePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		content, err := scripts.ReadFile(path.Join(dialect, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, migration.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(content)
+			sum := sha256.Sum256(content)
+			migration.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d (%s) needs both an up and a down script", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Latest returns the highest known migration version
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Status reports every known migration and whether it has been applied
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if row, ok := applied[migration.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = row.AppliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Pending returns the migrations that have not been applied yet
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; !ok {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies every pending migration
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.To(ctx, m.Latest())
+}
+
+// Down reverts the most recently applied migration
+func (m *Migrator) Down(ctx context.Context) error {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return err
+	}
+
+	current := 0
+	for version := range applied {
+		if version > current {
+			current = version
+		}
+	}
+	if current == 0 {
+		return nil
+	}
+
+	target := 0
+	for _, migration := range m.migrations {
+		if migration.Version < current {
+			target = migration.Version
+		}
+	}
+	return m.To(ctx, target)
+}
+
+// To applies or reverts migrations until the database is at the given version
+func (m *Migrator) To(ctx context.Context, version int) error {
+	if version != 0 && m.find(version) == nil {
+		return fmt.Errorf("unknown migration version %d", version)
+	}
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Revert newer migrations first, latest to oldest
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; ok && migration.Version > version {
+			if err := m.revert(ctx, migration); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Then apply older pending migrations, oldest to latest
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; !ok && migration.Version <= version {
+			if err := m.apply(ctx, migration); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// apply runs an up script and records it, atomically
+func (m *Migrator) apply(ctx context.Context, migration Migration) error {
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(migration.Up).Error; err != nil {
+			return err
+		}
+		return tx.Create(&schemaMigration{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			Checksum:  migration.Checksum,
+			AppliedAt: time.Now(),
+		}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply migration %d (%s): %w", migration.Version, migration.Name, err)
+	}
+	return nil
+}
+
+// revert runs a down script and removes its record, atomically
+func (m *Migrator) revert(ctx context.Context, migration Migration) error {
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(migration.Down).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&schemaMigration{}, migration.Version).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to revert migration %d (%s): %w", migration.Version, migration.Name, err)
+	}
+	return nil
+}
+
+// applied returns the recorded migrations, verifying them against the known scripts
+func (m *Migrator) applied(ctx context.Context) (map[int]schemaMigration, error) {
+	db := m.db.WithContext(ctx)
+	if err := db.AutoMigrate(&schemaMigration{}); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	var rows []schemaMigration
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]schemaMigration, len(rows))
+	for _, row := range rows {
+		migration := m.find(row.Version)
+		if migration == nil {
+			return nil, fmt.Errorf("migration %d (%s) is applied but its scripts are missing", row.Version, row.Name)
+		}
+		if migration.Checksum != row.Checksum {
+			return nil, fmt.Errorf("checksum mismatch for migration %d (%s): the up script changed after it was applied",
+				row.Version, row.Name)
+		}
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// find returns the migration with the given version, or nil
+func (m *Migrator) find(version int) *Migration {
+	for i := range m.migrations {
+		if m.migrations[i].Version == version {
+			return &m.migrations[i]
+		}
+	}
+	return nil
+}
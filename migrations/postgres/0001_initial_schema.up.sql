@@ -0,0 +1,72 @@
+-- Baseline schema. IF NOT EXISTS lets databases created by the former AutoMigrate adopt it.
+CREATE TABLE IF NOT EXISTS authors (
+    id BIGSERIAL PRIMARY KEY,
+    first_name TEXT,
+    last_name TEXT,
+    bio TEXT
+);
+
+CREATE TABLE IF NOT EXISTS books (
+    id BIGSERIAL PRIMARY KEY,
+    title TEXT NOT NULL,
+    author_id BIGINT NOT NULL,
+    genres TEXT,
+    published_at TIMESTAMPTZ,
+    price DECIMAL,
+    stock BIGINT,
+    CONSTRAINT fk_books_author FOREIGN KEY (author_id) REFERENCES authors (id)
+);
+
+CREATE TABLE IF NOT EXISTS customers (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT,
+    email TEXT CONSTRAINT uni_customers_email UNIQUE,
+    street TEXT,
+    city TEXT,
+    state TEXT,
+    postal_code TEXT,
+    country TEXT,
+    created_at TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS orders (
+    id BIGSERIAL PRIMARY KEY,
+    customer_id BIGINT,
+    total_price DECIMAL,
+    created_at TIMESTAMPTZ,
+    status TEXT,
+    CONSTRAINT fk_orders_customer FOREIGN KEY (customer_id) REFERENCES customers (id)
+);
+
+CREATE TABLE IF NOT EXISTS order_items (
+    id BIGSERIAL PRIMARY KEY,
+    order_id BIGINT,
+    book_id BIGINT,
+    quantity BIGINT,
+    CONSTRAINT fk_orders_items FOREIGN KEY (order_id) REFERENCES orders (id),
+    CONSTRAINT fk_order_items_book FOREIGN KEY (book_id) REFERENCES books (id)
+);
+
+CREATE TABLE IF NOT EXISTS sales_reports (
+    id BIGSERIAL PRIMARY KEY,
+    timestamp TIMESTAMPTZ,
+    total_revenue DECIMAL,
+    total_orders BIGINT
+);
+
+CREATE TABLE IF NOT EXISTS book_sales (
+    id BIGSERIAL PRIMARY KEY,
+    report_id BIGINT,
+    book_id BIGINT,
+    quantity BIGINT,
+    CONSTRAINT fk_sales_reports_top_selling_books FOREIGN KEY (report_id) REFERENCES sales_reports (id),
+    CONSTRAINT fk_book_sales_book FOREIGN KEY (book_id) REFERENCES books (id)
+);
+
+CREATE TABLE IF NOT EXISTS users (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT,
+    email TEXT CONSTRAINT uni_users_email UNIQUE,
+    password TEXT,
+    role TEXT
+);
@@ -0,0 +1,3 @@
+ALTER TABLE order_items DROP COLUMN IF EXISTS currency;
+ALTER TABLE order_items DROP COLUMN IF EXISTS subtotal;
+ALTER TABLE order_items DROP COLUMN IF EXISTS unit_price;
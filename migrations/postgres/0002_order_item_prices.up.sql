@@ -0,0 +1,11 @@
+-- Snapshot the price of each order item, so that later price changes do not rewrite history.
+-- Existing items are priced at the current price of their book.
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS unit_price DECIMAL NOT NULL DEFAULT 0;
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS subtotal DECIMAL NOT NULL DEFAULT 0;
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'MAD';
+
+UPDATE order_items
+SET unit_price = books.price,
+    subtotal = ROUND(CAST(books.price * order_items.quantity AS NUMERIC), 2)
+FROM books
+WHERE books.id = order_items.book_id;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS token_revocations (
+    id BIGSERIAL PRIMARY KEY,
+    jti TEXT,
+    user_id BIGINT,
+    issued_before TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_by BIGINT,
+    created_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_token_revocations_jti ON token_revocations (jti);
+CREATE INDEX IF NOT EXISTS idx_token_revocations_user_id ON token_revocations (user_id);
+CREATE INDEX IF NOT EXISTS idx_token_revocations_expires_at ON token_revocations (expires_at);
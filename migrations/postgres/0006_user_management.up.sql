@@ -0,0 +1,15 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS invitations (
+    id BIGSERIAL PRIMARY KEY,
+    token_hash TEXT NOT NULL CONSTRAINT uni_invitations_token_hash UNIQUE,
+    email TEXT,
+    role TEXT NOT NULL,
+    invited_by BIGINT,
+    created_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ,
+    accepted_at TIMESTAMPTZ,
+    user_id BIGINT,
+    CONSTRAINT fk_invitations_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE SET NULL
+);
@@ -0,0 +1,60 @@
+CREATE TABLE IF NOT EXISTS roles (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL CONSTRAINT uni_roles_name UNIQUE,
+    description TEXT,
+    created_at TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    id BIGSERIAL PRIMARY KEY,
+    role_id BIGINT NOT NULL,
+    permission TEXT NOT NULL,
+    CONSTRAINT fk_roles_permissions FOREIGN KEY (role_id) REFERENCES roles (id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_role_permissions_role_permission ON role_permissions (role_id, permission);
+
+CREATE TABLE IF NOT EXISTS permission_implications (
+    id BIGSERIAL PRIMARY KEY,
+    permission TEXT NOT NULL,
+    implies TEXT NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_permission_implications_rule ON permission_implications (permission, implies);
+
+INSERT INTO roles (name, description, created_at) VALUES
+    ('admin', 'Full system access and management capabilities', NOW()),
+    ('manager', 'Manage books, authors, and generate reports', NOW()),
+    ('employee', 'Handle orders and customer service', NOW()),
+    ('user', 'Browse books and place orders', NOW())
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission)
+SELECT roles.id, seed.permission
+FROM (VALUES
+    ('admin', 'read:all'),
+    ('admin', 'write:all'),
+    ('admin', 'delete:all'),
+    ('admin', 'manage:users'),
+    ('admin', 'manage:roles'),
+    ('admin', 'generate:reports'),
+    ('manager', 'read:all'),
+    ('manager', 'write:books'),
+    ('manager', 'write:authors'),
+    ('manager', 'write:orders'),
+    ('manager', 'generate:reports'),
+    ('employee', 'read:all'),
+    ('employee', 'write:orders'),
+    ('employee', 'write:customers'),
+    ('user', 'read:books'),
+    ('user', 'read:authors'),
+    ('user', 'write:orders')
+) AS seed (role, permission)
+JOIN roles ON roles.name = seed.role
+ON CONFLICT (role_id, permission) DO NOTHING;
+
+INSERT INTO permission_implications (permission, implies) VALUES
+    ('read:all', 'read:*'),
+    ('write:all', 'write:*'),
+    ('delete:all', 'delete:*')
+ON CONFLICT (permission, implies) DO NOTHING;
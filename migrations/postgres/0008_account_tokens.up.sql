@@ -0,0 +1,15 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS account_tokens (
+    id BIGSERIAL PRIMARY KEY,
+    jti TEXT NOT NULL CONSTRAINT uni_account_tokens_jti UNIQUE,
+    user_id BIGINT NOT NULL,
+    purpose TEXT NOT NULL,
+    created_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    CONSTRAINT fk_account_tokens_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_account_tokens_user_id ON account_tokens (user_id);
+CREATE INDEX IF NOT EXISTS idx_account_tokens_expires_at ON account_tokens (expires_at);
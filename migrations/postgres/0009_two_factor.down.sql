@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS recovery_codes;
+ALTER TABLE account_tokens DROP COLUMN IF EXISTS attempts;
+ALTER TABLE roles DROP COLUMN IF EXISTS require_two_factor;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_last_step;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_enabled_at;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_secret;
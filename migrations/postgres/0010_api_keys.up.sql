@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL,
+    key_hash TEXT NOT NULL CONSTRAINT uni_api_keys_key_hash UNIQUE,
+    created_by BIGINT,
+    created_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS api_key_permissions (
+    id BIGSERIAL PRIMARY KEY,
+    api_key_id BIGINT NOT NULL,
+    permission TEXT NOT NULL,
+    CONSTRAINT fk_api_keys_permissions FOREIGN KEY (api_key_id) REFERENCES api_keys (id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_key_permissions_key_permission ON api_key_permissions (api_key_id, permission);
+
+INSERT INTO role_permissions (role_id, permission)
+SELECT id, 'manage:api_keys' FROM roles WHERE name = 'admin'
+ON CONFLICT (role_id, permission) DO NOTHING;
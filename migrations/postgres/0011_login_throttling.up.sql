@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS login_throttles (
+    id BIGSERIAL PRIMARY KEY,
+    identifier TEXT NOT NULL CONSTRAINT uni_login_throttles_identifier UNIQUE,
+    failures BIGINT NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMPTZ,
+    locked_until TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_throttles_locked_until ON login_throttles (locked_until);
+
+CREATE TABLE IF NOT EXISTS security_events (
+    id BIGSERIAL PRIMARY KEY,
+    type TEXT NOT NULL,
+    user_id BIGINT,
+    actor_id BIGINT,
+    identifier TEXT,
+    ip TEXT,
+    details TEXT,
+    created_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_security_events_type ON security_events (type);
+CREATE INDEX IF NOT EXISTS idx_security_events_user_id ON security_events (user_id);
+CREATE INDEX IF NOT EXISTS idx_security_events_created_at ON security_events (created_at);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS customer_addresses;
+ALTER TABLE customers DROP COLUMN IF EXISTS user_id;
@@ -0,0 +1,26 @@
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS user_id BIGINT
+    CONSTRAINT uni_customers_user_id UNIQUE
+    CONSTRAINT fk_customers_user REFERENCES users (id) ON DELETE SET NULL;
+
+-- Customers become the profile of the users who verified the same email address, unless
+-- several customers share it in different cases
+UPDATE customers SET user_id = (
+    SELECT MIN(users.id) FROM users
+    WHERE LOWER(users.email) = LOWER(customers.email) AND users.email_verified_at IS NOT NULL
+)
+WHERE user_id IS NULL
+    AND (SELECT COUNT(*) FROM customers AS others WHERE LOWER(others.email) = LOWER(customers.email)) = 1;
+
+CREATE TABLE IF NOT EXISTS customer_addresses (
+    id BIGSERIAL PRIMARY KEY,
+    customer_id BIGINT NOT NULL,
+    label TEXT,
+    street TEXT,
+    city TEXT,
+    state TEXT,
+    postal_code TEXT,
+    country TEXT,
+    CONSTRAINT fk_customers_addresses FOREIGN KEY (customer_id) REFERENCES customers (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_customer_addresses_customer_id ON customer_addresses (customer_id);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS impersonated_requests (
+    id BIGSERIAL PRIMARY KEY,
+    impersonator_id BIGINT NOT NULL,
+    user_id BIGINT NOT NULL,
+    token_id TEXT,
+    method TEXT NOT NULL,
+    path TEXT NOT NULL,
+    status BIGINT,
+    ip TEXT,
+    created_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_impersonated_requests_impersonator_id ON impersonated_requests (impersonator_id);
+CREATE INDEX IF NOT EXISTS idx_impersonated_requests_user_id ON impersonated_requests (user_id);
+CREATE INDEX IF NOT EXISTS idx_impersonated_requests_token_id ON impersonated_requests (token_id);
+CREATE INDEX IF NOT EXISTS idx_impersonated_requests_created_at ON impersonated_requests (created_at);
@@ -0,0 +1,12 @@
+INSERT INTO role_permissions (role_id, permission)
+SELECT roles.id, seed.permission
+FROM (VALUES
+    ('admin', 'manage:customers'),
+    ('admin', 'process:orders'),
+    ('manager', 'write:customers'),
+    ('manager', 'manage:customers'),
+    ('manager', 'process:orders'),
+    ('employee', 'process:orders')
+) AS seed (role, permission)
+JOIN roles ON roles.name = seed.role
+ON CONFLICT (role_id, permission) DO NOTHING;
@@ -0,0 +1,5 @@
+INSERT INTO role_permissions (role_id, permission)
+SELECT roles.id, 'read_sensitive:customers'
+FROM roles
+WHERE roles.name IN ('admin', 'manager')
+ON CONFLICT (role_id, permission) DO NOTHING;
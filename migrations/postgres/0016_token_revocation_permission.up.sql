@@ -0,0 +1,5 @@
+INSERT INTO role_permissions (role_id, permission)
+SELECT roles.id, 'revoke:tokens'
+FROM roles
+WHERE roles.name = 'admin'
+ON CONFLICT (role_id, permission) DO NOTHING;
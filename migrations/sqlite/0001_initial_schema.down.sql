@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS book_sales;
+DROP TABLE IF EXISTS sales_reports;
+DROP TABLE IF EXISTS order_items;
+DROP TABLE IF EXISTS orders;
+DROP TABLE IF EXISTS customers;
+DROP TABLE IF EXISTS books;
+DROP TABLE IF EXISTS authors;
@@ -0,0 +1,3 @@
+ALTER TABLE order_items DROP COLUMN currency;
+ALTER TABLE order_items DROP COLUMN subtotal;
+ALTER TABLE order_items DROP COLUMN unit_price;
@@ -0,0 +1,10 @@
+-- Snapshot the price of each order item, so that later price changes do not rewrite history.
+-- Existing items are priced at the current price of their book.
+ALTER TABLE order_items ADD COLUMN unit_price REAL NOT NULL DEFAULT 0;
+ALTER TABLE order_items ADD COLUMN subtotal REAL NOT NULL DEFAULT 0;
+ALTER TABLE order_items ADD COLUMN currency TEXT NOT NULL DEFAULT 'MAD';
+
+UPDATE order_items
+SET unit_price = COALESCE((SELECT price FROM books WHERE books.id = order_items.book_id), 0);
+UPDATE order_items
+SET subtotal = ROUND(unit_price * quantity, 2);
@@ -0,0 +1,14 @@
+-- Status history of each order. Existing orders start their history at their current status.
+CREATE TABLE IF NOT EXISTS order_status_changes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    order_id INTEGER REFERENCES orders (id),
+    from_status TEXT,
+    to_status TEXT,
+    changed_by INTEGER,
+    changed_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_status_changes_order_id ON order_status_changes (order_id);
+
+INSERT INTO order_status_changes (order_id, from_status, to_status, changed_by, changed_at)
+SELECT id, '', status, 0, created_at FROM orders;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS token_revocations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    jti TEXT,
+    user_id INTEGER,
+    issued_before DATETIME,
+    expires_at DATETIME NOT NULL,
+    revoked_by INTEGER,
+    created_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_token_revocations_jti ON token_revocations (jti);
+CREATE INDEX IF NOT EXISTS idx_token_revocations_user_id ON token_revocations (user_id);
+CREATE INDEX IF NOT EXISTS idx_token_revocations_expires_at ON token_revocations (expires_at);
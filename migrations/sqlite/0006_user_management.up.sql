@@ -0,0 +1,14 @@
+ALTER TABLE users ADD COLUMN disabled NUMERIC NOT NULL DEFAULT false;
+ALTER TABLE users ADD COLUMN created_at DATETIME;
+
+CREATE TABLE IF NOT EXISTS invitations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    token_hash TEXT NOT NULL UNIQUE,
+    email TEXT,
+    role TEXT NOT NULL,
+    invited_by INTEGER,
+    created_at DATETIME,
+    expires_at DATETIME,
+    accepted_at DATETIME,
+    user_id INTEGER REFERENCES users (id) ON DELETE SET NULL
+);
@@ -0,0 +1,56 @@
+CREATE TABLE IF NOT EXISTS roles (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE,
+    description TEXT,
+    created_at DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    role_id INTEGER NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+    permission TEXT NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_role_permissions_role_permission ON role_permissions (role_id, permission);
+
+CREATE TABLE IF NOT EXISTS permission_implications (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    permission TEXT NOT NULL,
+    implies TEXT NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_permission_implications_rule ON permission_implications (permission, implies);
+
+INSERT OR IGNORE INTO roles (name, description, created_at) VALUES
+    ('admin', 'Full system access and management capabilities', CURRENT_TIMESTAMP),
+    ('manager', 'Manage books, authors, and generate reports', CURRENT_TIMESTAMP),
+    ('employee', 'Handle orders and customer service', CURRENT_TIMESTAMP),
+    ('user', 'Browse books and place orders', CURRENT_TIMESTAMP);
+
+INSERT OR IGNORE INTO role_permissions (role_id, permission)
+SELECT roles.id, seed.permission
+FROM (
+    SELECT 'admin' AS role, 'read:all' AS permission
+    UNION ALL SELECT 'admin', 'write:all'
+    UNION ALL SELECT 'admin', 'delete:all'
+    UNION ALL SELECT 'admin', 'manage:users'
+    UNION ALL SELECT 'admin', 'manage:roles'
+    UNION ALL SELECT 'admin', 'generate:reports'
+    UNION ALL SELECT 'manager', 'read:all'
+    UNION ALL SELECT 'manager', 'write:books'
+    UNION ALL SELECT 'manager', 'write:authors'
+    UNION ALL SELECT 'manager', 'write:orders'
+    UNION ALL SELECT 'manager', 'generate:reports'
+    UNION ALL SELECT 'employee', 'read:all'
+    UNION ALL SELECT 'employee', 'write:orders'
+    UNION ALL SELECT 'employee', 'write:customers'
+    UNION ALL SELECT 'user', 'read:books'
+    UNION ALL SELECT 'user', 'read:authors'
+    UNION ALL SELECT 'user', 'write:orders'
+) AS seed
+JOIN roles ON roles.name = seed.role;
+
+INSERT OR IGNORE INTO permission_implications (permission, implies) VALUES
+    ('read:all', 'read:*'),
+    ('write:all', 'write:*'),
+    ('delete:all', 'delete:*');
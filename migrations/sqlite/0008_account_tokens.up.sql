@@ -0,0 +1,14 @@
+ALTER TABLE users ADD COLUMN email_verified_at DATETIME;
+
+CREATE TABLE IF NOT EXISTS account_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    jti TEXT NOT NULL UNIQUE,
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    purpose TEXT NOT NULL,
+    created_at DATETIME,
+    expires_at DATETIME NOT NULL,
+    used_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_account_tokens_user_id ON account_tokens (user_id);
+CREATE INDEX IF NOT EXISTS idx_account_tokens_expires_at ON account_tokens (expires_at);
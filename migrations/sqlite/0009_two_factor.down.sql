@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS recovery_codes;
+ALTER TABLE account_tokens DROP COLUMN attempts;
+ALTER TABLE roles DROP COLUMN require_two_factor;
+ALTER TABLE users DROP COLUMN totp_last_step;
+ALTER TABLE users DROP COLUMN totp_enabled_at;
+ALTER TABLE users DROP COLUMN totp_secret;
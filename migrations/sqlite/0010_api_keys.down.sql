@@ -0,0 +1,3 @@
+DELETE FROM role_permissions WHERE permission = 'manage:api_keys';
+DROP TABLE IF EXISTS api_key_permissions;
+DROP TABLE IF EXISTS api_keys;
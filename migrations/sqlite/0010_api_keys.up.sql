@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL,
+    key_hash TEXT NOT NULL UNIQUE,
+    created_by INTEGER,
+    created_at DATETIME,
+    expires_at DATETIME,
+    last_used_at DATETIME,
+    revoked_at DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS api_key_permissions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    api_key_id INTEGER NOT NULL REFERENCES api_keys (id) ON DELETE CASCADE,
+    permission TEXT NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_key_permissions_key_permission ON api_key_permissions (api_key_id, permission);
+
+INSERT OR IGNORE INTO role_permissions (role_id, permission)
+SELECT id, 'manage:api_keys' FROM roles WHERE name = 'admin';
@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS login_throttles (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    identifier TEXT NOT NULL UNIQUE,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at DATETIME,
+    locked_until DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_throttles_locked_until ON login_throttles (locked_until);
+
+CREATE TABLE IF NOT EXISTS security_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    type TEXT NOT NULL,
+    user_id INTEGER,
+    actor_id INTEGER,
+    identifier TEXT,
+    ip TEXT,
+    details TEXT,
+    created_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_security_events_type ON security_events (type);
+CREATE INDEX IF NOT EXISTS idx_security_events_user_id ON security_events (user_id);
+CREATE INDEX IF NOT EXISTS idx_security_events_created_at ON security_events (created_at);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS customer_addresses;
+DROP INDEX IF EXISTS uni_customers_user_id;
+ALTER TABLE customers DROP COLUMN user_id;
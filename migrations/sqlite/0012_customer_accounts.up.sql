@@ -0,0 +1,25 @@
+ALTER TABLE customers ADD COLUMN user_id INTEGER;
+
+CREATE UNIQUE INDEX IF NOT EXISTS uni_customers_user_id ON customers (user_id);
+
+-- Customers become the profile of the users who verified the same email address, unless
+-- several customers share it in different cases
+UPDATE customers SET user_id = (
+    SELECT MIN(users.id) FROM users
+    WHERE LOWER(users.email) = LOWER(customers.email) AND users.email_verified_at IS NOT NULL
+)
+WHERE user_id IS NULL
+    AND (SELECT COUNT(*) FROM customers AS others WHERE LOWER(others.email) = LOWER(customers.email)) = 1;
+
+CREATE TABLE IF NOT EXISTS customer_addresses (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    customer_id INTEGER NOT NULL REFERENCES customers (id) ON DELETE CASCADE,
+    label TEXT,
+    street TEXT,
+    city TEXT,
+    state TEXT,
+    postal_code TEXT,
+    country TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_customer_addresses_customer_id ON customer_addresses (customer_id);
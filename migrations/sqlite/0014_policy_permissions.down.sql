@@ -0,0 +1,4 @@
+DELETE FROM role_permissions WHERE permission IN ('manage:customers', 'process:orders');
+DELETE FROM role_permissions
+WHERE permission = 'write:customers'
+  AND role_id IN (SELECT id FROM roles WHERE name = 'manager');
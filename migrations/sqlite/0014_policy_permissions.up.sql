@@ -0,0 +1,11 @@
+INSERT OR IGNORE INTO role_permissions (role_id, permission)
+SELECT roles.id, seed.permission
+FROM (
+    SELECT 'admin' AS role, 'manage:customers' AS permission
+    UNION ALL SELECT 'admin', 'process:orders'
+    UNION ALL SELECT 'manager', 'write:customers'
+    UNION ALL SELECT 'manager', 'manage:customers'
+    UNION ALL SELECT 'manager', 'process:orders'
+    UNION ALL SELECT 'employee', 'process:orders'
+) AS seed
+JOIN roles ON roles.name = seed.role;
@@ -0,0 +1,4 @@
+INSERT OR IGNORE INTO role_permissions (role_id, permission)
+SELECT roles.id, 'revoke:tokens'
+FROM roles
+WHERE roles.name = 'admin';
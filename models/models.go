@@ -1,7 +1,13 @@
 package models
 
 import (
+	"fmt"
+	"math"
+	"sort"
 	"time"
+
+	"um6p.ma/finalproject/constants"
+	"um6p.ma/finalproject/errorhandling"
 )
 
 // Book Model
@@ -9,9 +15,9 @@ type Book struct {
 	ID          int       `gorm:"primaryKey;autoIncrement"`
 	Title       string    `gorm:"not null" validate:"required,min=1,max=200"`
 	AuthorID    int       `gorm:"not null" validate:"required"`
-	Author      Author    `gorm:"foreignKey:AuthorID"`
+	Author      Author    `gorm:"foreignKey:AuthorID" validate:"-"`
 	Genres      string    `validate:"required"`
-	PublishedAt time.Time `validate:"required,ltefield=now"`
+	PublishedAt time.Time `validate:"required,past_date"`
 	Price       float64   `validate:"required,gt=0"`
 	Stock       int       `validate:"required,gte=0"`
 }
@@ -26,19 +32,30 @@ type Author struct {
 
 // Customer Model
 type Customer struct {
-	ID        int     `gorm:"primaryKey;autoIncrement"`
-	Name      string  `validate:"required,min=2,max=100"`
-	Email     string  `gorm:"unique" validate:"required,email"`
-	Address   Address `gorm:"embedded" validate:"required"`
+	ID        int               `gorm:"primaryKey;autoIncrement"`
+	UserID    *int              `gorm:"unique" validate:"-"` // User account of the customer, if any; only set by linking
+	Name      string            `validate:"required,min=2,max=100"`
+	Email     string            `gorm:"unique" validate:"required,email" sensitive:"email"`
+	Address   Address           `gorm:"embedded" validate:"required"`
+	Addresses []CustomerAddress `gorm:"foreignKey:CustomerID" validate:"-"` // Address book, managed through the address methods only
 	CreatedAt time.Time
 }
 
-// Address Model
+// CustomerAddress Model, an entry of the address book of a customer
+type CustomerAddress struct {
+	ID         int     `gorm:"primaryKey;autoIncrement"`
+	CustomerID int     `gorm:"not null;index"`
+	Label      string  `validate:"max=50"` // Such as "Home" or "Office"
+	Address    Address `gorm:"embedded" validate:"required"`
+}
+
+// Address Model. The `sensitive` tags name the fields masked for the roles not allowed to see
+// them, see constants.SensitiveFieldPermissions.
 type Address struct {
-	Street     string `validate:"required,min=5,max=100"`
+	Street     string `validate:"required,min=5,max=100" sensitive:"address"`
 	City       string `validate:"required,min=2,max=50"`
 	State      string `validate:"required,min=2,max=50"`
-	PostalCode string `validate:"required,min=4,max=10"`
+	PostalCode string `validate:"required,min=4,max=10" sensitive:"address"`
 	Country    string `validate:"required,min=2,max=50"`
 }
 
@@ -46,20 +63,141 @@ type Address struct {
 type Order struct {
 	ID         int         `gorm:"primaryKey;autoIncrement"`
 	CustomerID int         `validate:"required"`
-	Customer   Customer    `gorm:"foreignKey:CustomerID"`
+	Customer   Customer    `gorm:"foreignKey:CustomerID" validate:"-"`
 	Items      []OrderItem `gorm:"foreignKey:OrderID" validate:"required,min=1,dive"`
-	TotalPrice float64     `validate:"required,gte=0"`
+	TotalPrice float64     `validate:"gte=0"` // Computed from the items; a submitted total must match
 	CreatedAt  time.Time
-	Status     string `validate:"required,oneof=pending processing shipped delivered cancelled"`
+	Status     string              `validate:"omitempty,oneof=pending processing shipped delivered cancelled"` // Changed through transitions only
+	History    []OrderStatusChange `gorm:"foreignKey:OrderID" validate:"-"`
+}
+
+// OrderStatusChange Model, one entry of the status history of an order
+type OrderStatusChange struct {
+	ID         int `gorm:"primaryKey;autoIncrement"`
+	OrderID    int
+	FromStatus string // Empty for the initial status
+	ToStatus   string
+	ChangedBy  int // ID of the user who made the change, 0 when unknown
+	ChangedAt  time.Time
 }
 
 // OrderItem Model
 type OrderItem struct {
 	ID       int  `gorm:"primaryKey;autoIncrement"`
-	OrderID  int  `validate:"required"`
+	OrderID  int  `validate:"-"` // Set by the store
 	BookID   int  `validate:"required"`
-	Book     Book `gorm:"foreignKey:BookID"`
+	Book     Book `gorm:"foreignKey:BookID" validate:"-"`
 	Quantity int  `validate:"required,gt=0"`
+
+	// Set by the server when the order is placed, so later price changes do not rewrite history
+	UnitPrice float64
+	Subtotal  float64
+	Currency  string `gorm:"size:3"`
+}
+
+// HoldsStock reports whether the order still holds the stock of its items, which deleting or
+// cancelling it returns. Shipped and delivered books have left the warehouse.
+func (order Order) HoldsStock() bool {
+	return order.Status == constants.OrderStatusPending || order.Status == constants.OrderStatusProcessing
+}
+
+// ApplyStatusChange moves the order from change.FromStatus to change.ToStatus and appends the
+// change to its history. It reports false, leaving the order untouched, when the order is not
+// in change.FromStatus or the state machine does not allow the move.
+func (order *Order) ApplyStatusChange(change OrderStatusChange) bool {
+	if order.Status != change.FromStatus || !constants.CanTransitionOrder(change.FromStatus, change.ToStatus) {
+		return false
+	}
+
+	change.ID = 0
+	change.OrderID = order.ID
+	order.Status = change.ToStatus
+	order.History = append(order.History, change)
+	return true
+}
+
+// Currency is the currency of book prices
+const Currency = "MAD"
+
+// PriceItems snapshots the unit price of every item from prices, keyed by book ID, and sets
+// the item subtotals and the order total from them. It fails for orders without items or
+// with items of no positive quantity.
+func (order *Order) PriceItems(prices map[int]float64) error {
+	if err := CheckItems(order.Items); err != nil {
+		return err
+	}
+
+	total := 0.0
+	for i := range order.Items {
+		item := &order.Items[i]
+		item.UnitPrice = prices[item.BookID]
+		item.Subtotal = RoundAmount(item.UnitPrice * float64(item.Quantity))
+		item.Currency = Currency
+		total += item.Subtotal
+	}
+	order.TotalPrice = RoundAmount(total)
+	return nil
+}
+
+// CheckItems checks that an order has items, each of a positive quantity
+func CheckItems(items []OrderItem) error {
+	if len(items) == 0 {
+		return errorhandling.ErrInvalidInput.WithDetails("An order needs at least one item")
+	}
+	return checkQuantities(items)
+}
+
+// checkQuantities checks that every item has a positive quantity. Stock changes are computed
+// from quantities, so others would add stock rather than take it.
+func checkQuantities(items []OrderItem) error {
+	for _, item := range items {
+		if item.Quantity <= 0 {
+			return errorhandling.ErrInvalidInput.WithDetails(fmt.Sprintf("Quantity of book %d must be greater than 0", item.BookID))
+		}
+	}
+	return nil
+}
+
+// RoundAmount rounds an amount to the cent
+func RoundAmount(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// StockChange is the change in stock of one book caused by an order
+type StockChange struct {
+	BookID int
+	Delta  int
+}
+
+// StockChanges returns the stock changes, ordered by book ID, of releasing one set of order
+// items and reserving another. Books whose stock does not change are left out. It fails for
+// items of no positive quantity.
+func StockChanges(released, reserved []OrderItem) ([]StockChange, error) {
+	if err := checkQuantities(released); err != nil {
+		return nil, err
+	}
+	if err := checkQuantities(reserved); err != nil {
+		return nil, err
+	}
+
+	deltas := make(map[int]int)
+	for _, item := range released {
+		deltas[item.BookID] += item.Quantity
+	}
+	for _, item := range reserved {
+		deltas[item.BookID] -= item.Quantity
+	}
+
+	changes := make([]StockChange, 0, len(deltas))
+	for bookID, delta := range deltas {
+		if delta != 0 {
+			changes = append(changes, StockChange{BookID: bookID, Delta: delta})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].BookID < changes[j].BookID
+	})
+	return changes, nil
 }
 
 // SalesReport Model
@@ -85,15 +223,218 @@ type SearchCriteria struct {
 	Titles   []string `validate:"dive,min=1"`
 	Authors  []string `validate:"dive,min=1"`
 	Genres   []string `validate:"dive,min=1"`
+	AuthorID int      `validate:"gte=0"`
 	MinPrice float64  `validate:"gte=0"`
 	MaxPrice float64  `validate:"gtefield=MinPrice"`
 }
 
+// CustomerSearchCriteria Model
+type CustomerSearchCriteria struct {
+	Name   string
+	Email  string
+	City   string
+	UserID int // Only the customer linked to the user, when not 0
+}
+
 // User Model
 type User struct {
+	ID        int    `gorm:"primaryKey;autoIncrement"`
+	Name      string `validate:"required,min=2,max=100"`
+	Email     string `gorm:"unique" validate:"required,email"`
+	Password  string `validate:"required,min=8,max=100"`
+	Role      string `validate:"required"` // Name of a Role
+	Disabled  bool   `gorm:"not null;default:false"`
+	CreatedAt time.Time
+
+	EmailVerifiedAt *time.Time
+
+	TOTPSecret    string     // Base32 TOTP secret, set from enrollment until two-factor authentication is disabled
+	TOTPEnabledAt *time.Time // Set once the user confirmed the secret with a code
+	TOTPLastStep  int64      `gorm:"not null;default:0"` // Last time step accepted, so that a code works once
+}
+
+// TwoFactorEnabled reports whether logging in requires a second factor
+func (user User) TwoFactorEnabled() bool {
+	return user.TOTPEnabledAt != nil
+}
+
+// Invitation Model. An admin invites someone to sign up with a given role, through a one-time
+// link. Only the SHA-256 hash of the invitation token is stored.
+type Invitation struct {
+	ID         int    `gorm:"primaryKey;autoIncrement"`
+	TokenHash  string `gorm:"not null;unique"`
+	Email      string // Optional; when set, only this address can accept the invitation
+	Role       string `gorm:"not null"`
+	InvitedBy  int
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	AcceptedAt *time.Time
+	UserID     *int // The user created by accepting the invitation
+}
+
+// RefreshToken Model. Only the SHA-256 hash of the opaque token is stored. Tokens issued from
+// one login share a family, so that a reused token can revoke every token of the session.
+type RefreshToken struct {
+	ID        int    `gorm:"primaryKey;autoIncrement"`
+	UserID    int    `gorm:"not null;index"`
+	TokenHash string `gorm:"not null;unique"`
+	FamilyID  string `gorm:"not null;index"`
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+}
+
+// TokenRevocation Model. It either revokes the access token with the given JTI, or every
+// access token issued to a user before IssuedBefore. Entries are only kept until ExpiresAt,
+// after which the tokens they revoke have expired anyway.
+type TokenRevocation struct {
+	ID           int    `gorm:"primaryKey;autoIncrement"`
+	JTI          string `gorm:"index"`
+	UserID       int    `gorm:"index"`
+	IssuedBefore *time.Time
+	ExpiresAt    time.Time `gorm:"not null;index"`
+	RevokedBy    int
+	CreatedAt    time.Time
+}
+
+// AccountToken Model. It records the signed tokens mailed to users, such as password reset
+// links, so that each can only be used once. Issuing a token supersedes the unused tokens of
+// the same user and purpose.
+type AccountToken struct {
+	ID        int    `gorm:"primaryKey;autoIncrement"`
+	JTI       string `gorm:"not null;unique"`
+	UserID    int    `gorm:"not null;index"`
+	Purpose   string `gorm:"not null"`
+	CreatedAt time.Time
+	ExpiresAt time.Time `gorm:"not null;index"`
+	UsedAt    *time.Time
+	Attempts  int `gorm:"not null;default:0"` // Failed attempts, for tokens that check a code
+}
+
+// RecoveryCode Model. Only the SHA-256 hash of each single-use code is stored.
+type RecoveryCode struct {
 	ID       int    `gorm:"primaryKey;autoIncrement"`
-	Name     string `validate:"required,min=2,max=100"`
-	Email    string `gorm:"unique" validate:"required,email"`
-	Password string `validate:"required,min=8,max=100"`
-	Role     string `validate:"required,oneof=admin user"`
+	UserID   int    `gorm:"not null;index"`
+	CodeHash string `gorm:"not null"`
+	UsedAt   *time.Time
+}
+
+// Role Model. Users reference their role by name. The permissions of a role are patterns such
+// as "read:books", "read:*" or "*", resolved on each request, so that edits apply at once.
+type Role struct {
+	ID          int    `gorm:"primaryKey;autoIncrement"`
+	Name        string `gorm:"not null;unique"`
+	Description string
+	Permissions []RolePermission `gorm:"foreignKey:RoleID"`
+	CreatedAt   time.Time
+
+	RequireTwoFactor bool `gorm:"not null;default:false"` // Users of the role must enroll in two-factor authentication
+}
+
+// RolePermission Model
+type RolePermission struct {
+	ID         int    `gorm:"primaryKey;autoIncrement"`
+	RoleID     int    `gorm:"not null;uniqueIndex:idx_role_permissions_role_permission"`
+	Permission string `gorm:"not null;uniqueIndex:idx_role_permissions_role_permission"`
+}
+
+// PermissionImplication Model. A role granted Permission is also granted Implies, so that
+// "read:all" can imply "read:*".
+type PermissionImplication struct {
+	ID         int    `gorm:"primaryKey;autoIncrement"`
+	Permission string `gorm:"not null;uniqueIndex:idx_permission_implications_rule"`
+	Implies    string `gorm:"not null;uniqueIndex:idx_permission_implications_rule"`
+}
+
+// PermissionNames returns the permissions granted to a role
+func (role Role) PermissionNames() []string {
+	names := make([]string, 0, len(role.Permissions))
+	for _, permission := range role.Permissions {
+		names = append(names, permission.Permission)
+	}
+	return names
+}
+
+// APIKey Model. API keys authenticate integrations without a user account, with an explicit
+// set of permissions. Only the SHA-256 hash of the key is stored, along with its prefix so
+// that admins can tell keys apart.
+type APIKey struct {
+	ID          int                `gorm:"primaryKey;autoIncrement"`
+	Name        string             `gorm:"not null"`
+	Prefix      string             `gorm:"not null"`
+	KeyHash     string             `gorm:"not null;unique"`
+	Permissions []APIKeyPermission `gorm:"foreignKey:APIKeyID"`
+	CreatedBy   int
+	CreatedAt   time.Time
+	ExpiresAt   *time.Time // Optional; keys without an expiry work until they are revoked
+	LastUsedAt  *time.Time
+	RevokedAt   *time.Time
+}
+
+// APIKeyPermission Model
+type APIKeyPermission struct {
+	ID         int    `gorm:"primaryKey;autoIncrement"`
+	APIKeyID   int    `gorm:"not null;uniqueIndex:idx_api_key_permissions_key_permission"`
+	Permission string `gorm:"not null;uniqueIndex:idx_api_key_permissions_key_permission"`
+}
+
+// PermissionNames returns the permissions granted to an API key
+func (key APIKey) PermissionNames() []string {
+	names := make([]string, 0, len(key.Permissions))
+	for _, permission := range key.Permissions {
+		names = append(names, permission.Permission)
+	}
+	return names
+}
+
+// LoginThrottle Model. Failed logins are counted per identifier, either "email:<address>" or
+// "ip:<address>", so that repeated guesses are slowed down and then locked out.
+type LoginThrottle struct {
+	ID            int    `gorm:"primaryKey;autoIncrement"`
+	Identifier    string `gorm:"not null;unique"`
+	Failures      int    `gorm:"not null;default:0"`
+	LastFailureAt time.Time
+	LockedUntil   *time.Time `gorm:"index"`
+}
+
+// SecurityEvent Model. Events such as lockouts are kept for admins to review, and outlive the
+// users they concern.
+type SecurityEvent struct {
+	ID         int    `gorm:"primaryKey;autoIncrement"`
+	Type       string `gorm:"not null;index"`
+	UserID     *int   `gorm:"index"` // The user concerned, if known
+	ActorID    *int   // The admin who caused the event, if any
+	Identifier string // The throttled identifier, for lockout events
+	IP         string
+	Details    string
+	CreatedAt  time.Time `gorm:"index"`
+}
+
+// SecurityEventFilter narrows down the listed security events
+type SecurityEventFilter struct {
+	Type   string
+	UserID int
+	Limit  int
+}
+
+// ImpersonatedRequest Model. Every request made with an impersonation token is kept in the
+// audit log, along with the admin behind it.
+type ImpersonatedRequest struct {
+	ID             int    `gorm:"primaryKey;autoIncrement"`
+	ImpersonatorID int    `gorm:"not null;index"` // The admin acting as the user
+	UserID         int    `gorm:"not null;index"` // The impersonated user
+	TokenID        string `gorm:"index"`          // JTI of the impersonation token
+	Method         string `gorm:"not null"`
+	Path           string `gorm:"not null"` // With the query string
+	Status         int
+	IP             string
+	CreatedAt      time.Time `gorm:"index"`
+}
+
+// ImpersonatedRequestFilter narrows down the listed impersonated requests
+type ImpersonatedRequestFilter struct {
+	ImpersonatorID int
+	UserID         int
+	TokenID        string
+	Limit          int
 }
@@ -2,6 +2,7 @@ package validation
 
 import (
 	"fmt"
+	"reflect"
 	"strings"
 	"time"
 	"unicode"
@@ -21,6 +22,8 @@ func init() {
 	validate.RegisterValidation("passwd", validatePassword)
 	validate.RegisterValidation("custom_email", validateEmail)
 	validate.RegisterValidation("custom_email", validateEmail)
+	validate.RegisterValidation("permission", validatePermission)
+	validate.RegisterValidation("role_name", validateRoleName)
 }
 
 // ValidationError represents a validation error
@@ -42,7 +45,7 @@ func Validate(s interface{}) []ValidationError {
 			element.Field = strings.ToLower(err.Field())
 			element.Tag = err.Tag()
 			element.Value = fmt.Sprintf("%v", err.Value())
-			element.Message = formatErrorMessage(element.Field, element.Tag, element.Value)
+			element.Message = formatErrorMessage(element.Field, element.Tag, err.Param(), err.Kind())
 			errors = append(errors, element)
 		}
 	}
@@ -50,19 +53,23 @@ func Validate(s interface{}) []ValidationError {
 	return errors
 }
 
-// formatErrorMessage creates a user-friendly error message based on the validation error
-func formatErrorMessage(field string, tag string, value string) string {
+// formatErrorMessage creates a user-friendly error message based on the validation error and
+// the parameter of its tag, such as the bound of min
+func formatErrorMessage(field string, tag string, param string, kind reflect.Kind) string {
 	switch tag {
 	case "required":
 		return fmt.Sprintf("%s is required", field)
 	case "min":
-		return fmt.Sprintf("%s must be at least %s characters long", field, value)
+		if kind == reflect.Slice {
+			return fmt.Sprintf("%s must have at least %s items", field, param)
+		}
+		return fmt.Sprintf("%s must be at least %s characters long", field, param)
 	case "max":
-		return fmt.Sprintf("%s must not exceed %s characters", field, value)
+		return fmt.Sprintf("%s must not exceed %s characters", field, param)
 	case "gt":
-		return fmt.Sprintf("%s must be greater than %s", field, value)
+		return fmt.Sprintf("%s must be greater than %s", field, param)
 	case "gte":
-		return fmt.Sprintf("%s must be greater than or equal to %s", field, value)
+		return fmt.Sprintf("%s must be greater than or equal to %s", field, param)
 	case "email":
 		return fmt.Sprintf("%s must be a valid email address", field)
 	case "custom_email":
@@ -70,13 +77,19 @@ func formatErrorMessage(field string, tag string, value string) string {
 	case "url":
 		return fmt.Sprintf("%s must be a valid URL", field)
 	case "ltefield":
-		return fmt.Sprintf("%s must be before %s", field, value)
+		return fmt.Sprintf("%s must be before %s", field, param)
+	case "past_date":
+		return fmt.Sprintf("%s must be in the past", field)
 	case "oneof":
-		return fmt.Sprintf("%s must be one of: %s", field, value)
+		return fmt.Sprintf("%s must be one of: %s", field, param)
 	case "valid_isbn":
 		return fmt.Sprintf("%s must be a valid ISBN-10 or ISBN-13", field)
 	case "valid_status":
 		return fmt.Sprintf("%s must be a valid order status", field)
+	case "permission":
+		return fmt.Sprintf("%s must be a permission such as read:books, read:* or *", field)
+	case "role_name":
+		return fmt.Sprintf("%s must start with a lowercase letter and contain only lowercase letters, digits, - and _", field)
 	default:
 		return fmt.Sprintf("%s failed %s validation", field, tag)
 	}
@@ -202,6 +215,51 @@ func validateEmail(fl validator.FieldLevel) bool {
 	return true
 }
 
+// validatePermission checks that a permission is made of colon-separated segments, each
+// either "*" or lowercase letters, digits, - and _
+func validatePermission(fl validator.FieldLevel) bool {
+	permission := fl.Field().String()
+	if len(permission) > 100 {
+		return false
+	}
+
+	for _, segment := range strings.Split(permission, ":") {
+		if segment == "*" {
+			continue
+		}
+		if segment == "" {
+			return false
+		}
+		for _, char := range segment {
+			if !isNameChar(char) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validateRoleName checks that a role name starts with a lowercase letter and contains only
+// lowercase letters, digits, - and _
+func validateRoleName(fl validator.FieldLevel) bool {
+	name := fl.Field().String()
+	if name == "" || len(name) > 50 || name[0] < 'a' || name[0] > 'z' {
+		return false
+	}
+
+	for _, char := range name {
+		if !isNameChar(char) {
+			return false
+		}
+	}
+	return true
+}
+
+// isNameChar reports whether a character is allowed in role and permission names
+func isNameChar(char rune) bool {
+	return (char >= 'a' && char <= 'z') || (char >= '0' && char <= '9') || char == '-' || char == '_'
+}
+
 // AddCustomError adds a custom validation error
 func AddCustomError(errors []ValidationError, field, tag, value, message string) []ValidationError {
 	return append(errors, ValidationError{